@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/paskal/datadog-parser/app/record"
+)
+
+// analyzeCommand runs the query over the whole log from --filepath or stdin
+type analyzeCommand struct {
+	Format string `long:"format" default:"table" choice:"table" choice:"csv" choice:"json" description:"output format"`
+	Args   struct {
+		Query string `positional-arg-name:"query" description:"filters and aggregation like 'status >= 500 | count by remotehost | top 10', the summary of the log if not set"`
+	} `positional-args:"yes"`
+}
+
+// summaryQuery is the totals printed by analyze command without a query, after the reports and alerts
+const summaryQuery = "count by section, status"
+
+// runAnalyze prints the result of the query over the log, and returns the exit code
+func runAnalyze(w io.Writer, opts opts, stdin io.Reader) int {
+	if opts.Analyze.Args.Query == "" {
+		return runSummary(w, opts, stdin)
+	}
+	query, err := record.ParseQuery(opts.Analyze.Args.Query)
+	if err != nil {
+		log.Printf("Bad query: %v", err)
+		return 2
+	}
+	loc, err := time.LoadLocation(opts.OutputTZ)
+	if err != nil {
+		log.Printf("Bad output timezone: %v", err)
+		return 2
+	}
+	in, code := openInput(opts, stdin)
+	if code != 0 {
+		return code
+	}
+	defer in.close()
+
+	analyzer := record.Analyzer{
+		LogReader:  in.reader,
+		Query:      query,
+		Sections:   in.sections,
+		Timestamps: in.timestamps,
+		Labels:     in.labels,
+		GeoIP:      in.geo,
+		Agents:     in.agents,
+	}
+	result, err := analyzer.Run()
+	if err != nil {
+		log.Printf("Error reading the log: %v", err)
+		return 3
+	}
+	if result.Rejected > 0 {
+		log.Printf("Skipped %d of %d lines which are not valid records", result.Rejected, result.Records+result.Rejected)
+	}
+	if err = writeRows(w, opts.Analyze.Format, result.Columns, result.Rows, loc); err != nil {
+		log.Printf("Error writing the result: %v", err)
+		return 3
+	}
+	return 0
+}
+
+// runSummary processes the whole log the way tail command does it with its default options, printing
+// the reports and alerts to stdout, followed by the hits per section and status written to w,
+// and returns the exit code
+func runSummary(w io.Writer, opts opts, stdin io.Reader) int {
+	t := opts.Tail.tailOptions
+	t.JSON = opts.Analyze.Format == "json"
+	rules, code := t.parseRules(opts)
+	if code != 0 {
+		return code
+	}
+	query, err := record.ParseQuery(summaryQuery)
+	if err != nil {
+		log.Printf("Bad query: %v", err)
+		return 2
+	}
+	in, code := openInput(opts, stdin)
+	if code != 0 {
+		return code
+	}
+	defer in.close()
+
+	// the totals are counted along with the processing, as the log from stdin can't be read twice
+	pr, pw := io.Pipe()
+	analyzer := record.Analyzer{
+		LogReader:  pr,
+		Query:      query,
+		Sections:   in.sections,
+		Timestamps: in.timestamps,
+		Labels:     in.labels,
+		GeoIP:      in.geo,
+		Agents:     in.agents,
+	}
+	type analyzed struct {
+		result *record.Result
+		err    error
+	}
+	done := make(chan analyzed, 1)
+	go func() {
+		result, err := analyzer.Run()
+		// the rest is drained for the processing not to block on the pipe
+		io.Copy(ioutil.Discard, pr) //nolint:errcheck
+		done <- analyzed{result, err}
+	}()
+
+	logProcessor := t.newProcessor(rules, in)
+	logProcessor.LogReader = io.TeeReader(in.reader, pw)
+	logProcessor.StopAtEnd = true
+	err = logProcessor.Start(context.Background())
+	pw.Close() //nolint:errcheck
+	totals := <-done
+	if err != nil {
+		log.Printf("Bad processing options: %v", err)
+		return 2
+	}
+	if totals.err != nil {
+		log.Printf("Error reading the log: %v", totals.err)
+		return 3
+	}
+	if err = writeRows(w, opts.Analyze.Format, totals.result.Columns, totals.result.Rows, rules.outputTZ); err != nil {
+		log.Printf("Error writing the result: %v", err)
+		return 3
+	}
+	return 0
+}
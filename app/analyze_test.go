@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunAnalyze(t *testing.T) {
+	log := `"remotehost","rfc931","authuser","date","request","status","bytes"
+"10.0.0.1","-","apache",1549573860,"GET /api/user HTTP/1.0",503,1000
+"10.0.0.2","-","apache",1549573861,"GET /api/user HTTP/1.0",500,200
+"10.0.0.2","-","apache",1549573920,"GET /api/help HTTP/1.0",502,100
+"10.0.0.3","-","apache",1549573921,"GET /report HTTP/1.0",500,5000
+`
+	analyze := func(opts opts, query, format string) string {
+		opts.InputTZ, opts.OutputTZ, opts.TimeFormat, opts.SectionDepth = "UTC", "UTC", "auto", 1
+		opts.Analyze.Format, opts.Analyze.Args.Query = format, query
+		var out strings.Builder
+		require.Equal(t, 0, runAnalyze(&out, opts, strings.NewReader(log)))
+		return out.String()
+	}
+
+	assert.Equal(t, `remotehost  count
+10.0.0.2    2
+10.0.0.1    1
+`, analyze(opts{}, `status >= 500 and section == "/api" | count by remotehost | top 10`, "table"))
+
+	path := filepath.Join(t.TempDir(), "access.csv")
+	require.NoError(t, ioutil.WriteFile(path, []byte(log), 0o600))
+	assert.Equal(t, `time,section,sum(bytes)
+2019-02-07T21:11:00Z,/api,1200
+2019-02-07T21:12:00Z,/report,5000
+2019-02-07T21:12:00Z,/api,100
+`, analyze(opts{FilePath: path}, `sum(bytes) by section every 1m`, "csv"))
+
+	var out strings.Builder
+	bad := opts{OutputTZ: "UTC"}
+	bad.Analyze.Args.Query = "status >="
+	assert.Equal(t, 2, runAnalyze(&out, bad, strings.NewReader(log)))
+	missing := opts{FilePath: path + ".missing", InputTZ: "UTC", OutputTZ: "UTC", TimeFormat: "auto", SectionDepth: 1}
+	missing.Analyze.Args.Query = "count"
+	assert.Equal(t, 3, runAnalyze(&out, missing, strings.NewReader(log)))
+	assert.Empty(t, out.String())
+}
+
+func TestRunAnalyzeSummary(t *testing.T) {
+	log := `"remotehost","rfc931","authuser","date","request","status","bytes"
+"10.0.0.1","-","apache",1549573860,"GET /api/user HTTP/1.0",503,1000
+"10.0.0.2","-","apache",1549573861,"GET /api/user HTTP/1.0",200,200
+"10.0.0.2","-","apache",1549573920,"GET /api/help HTTP/1.0",200,100
+"10.0.0.3","-","apache",1549573921,"GET /report HTTP/1.0",500,5000`
+	path := filepath.Join(t.TempDir(), "access.csv")
+	require.NoError(t, ioutil.WriteFile(path, []byte(log), 0o600))
+
+	// reports and alerts are printed to stdout the same way tail command does it
+	rescueStdout := os.Stdout
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	os.Stdout = w
+	o, _ := parseArgs(t, "--filepath", path, "--input_tz=UTC", "analyze")
+	var out strings.Builder
+	code := runAnalyze(&out, o, strings.NewReader(""))
+	w.Close()
+	os.Stdout = rescueStdout
+	stdout, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+
+	require.Equal(t, 0, code)
+	assert.Equal(t, "2019-02-07 21:11:01 +0000 UTC: 2 hits from 2 users with 1200 bytes transferred, top /api with 2 hits\n",
+		string(stdout))
+	assert.Equal(t, `section  status  count
+/api     200     2
+/api     503     1
+/report  500     1
+`, out.String())
+}
@@ -0,0 +1,161 @@
+// Package anomaly learns the baseline of a time series observed at regular intervals, like hits per second
+// sampled every minute, so that the current value could be compared with the expected one
+package anomaly
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Baseline is the expected value of a time series
+type Baseline interface {
+	// Observe the value of the series at time t
+	Observe(t time.Time, value float64)
+	// Expected returns the expected value at time t and its standard deviation,
+	// ok is false until enough values are observed
+	Expected(t time.Time) (mean, stdDev float64, ok bool)
+}
+
+// EWMA is the exponentially weighted moving average of the series and its variance
+type EWMA struct {
+	Alpha    float64 `json:"alpha"`   // weight of the new value
+	WarmUp   int     `json:"warm_up"` // number of values to observe before the baseline is ready
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	Count    int     `json:"count"`
+}
+
+// NewEWMA creates EWMA forgetting half of the weight of a value after halfLife more values,
+// which is ready after halfLife values
+func NewEWMA(halfLife int) *EWMA {
+	return &EWMA{Alpha: alpha(halfLife), WarmUp: halfLife}
+}
+
+// Observe the value, time is not used as EWMA has no seasonality
+func (e *EWMA) Observe(_ time.Time, value float64) {
+	if e.Count == 0 {
+		e.Mean = value
+	}
+	diff := value - e.Mean
+	e.Mean += e.Alpha * diff
+	e.Variance = (1 - e.Alpha) * (e.Variance + e.Alpha*diff*diff)
+	e.Count++
+}
+
+// Expected returns the moving average and standard deviation
+func (e *EWMA) Expected(_ time.Time) (mean, stdDev float64, ok bool) {
+	return e.Mean, math.Sqrt(e.Variance), e.Count >= e.WarmUp
+}
+
+// HoltWinters is the additive Holt-Winters model of the series with the level, trend and seasonal
+// components, one per interval of the season. Values are expected at every interval, and seasonal slot
+// is picked by the time of the value. The first season initialises the components, and the model
+// is ready once the forecast errors are observed for the half-life after it.
+type HoltWinters struct {
+	Interval time.Duration `json:"interval"`
+	Alpha    float64       `json:"alpha"` // level smoothing
+	Beta     float64       `json:"beta"`  // trend smoothing
+	Gamma    float64       `json:"gamma"` // seasonal smoothing
+	Level    float64       `json:"level"`
+	Trend    float64       `json:"trend"`
+	Seasonal []float64     `json:"seasonal"`
+	WarmUp   int           `json:"warm_up"`  // number of values to observe before the model is ready
+	Variance float64       `json:"variance"` // moving variance of forecast errors
+	Count    int           `json:"count"`
+}
+
+// NewHoltWinters creates Holt-Winters model of values observed every interval with the provided season,
+// like a day, which level forgets half of the weight of a value after halfLife more values
+func NewHoltWinters(interval, season time.Duration, halfLife int) *HoltWinters {
+	return &HoltWinters{
+		Interval: interval,
+		Alpha:    alpha(halfLife),
+		Beta:     alpha(halfLife) / 10,
+		Gamma:    0.3,
+		Seasonal: make([]float64, season/interval),
+		WarmUp:   int(season/interval) + halfLife,
+	}
+}
+
+// slot returns the seasonal component index for time t
+func (h *HoltWinters) slot(t time.Time) int {
+	n := int64(len(h.Seasonal))
+	return int((t.UnixNano()/int64(h.Interval)%n + n) % n)
+}
+
+// Observe the value at time t
+func (h *HoltWinters) Observe(t time.Time, value float64) {
+	slot := h.slot(t)
+	h.Count++
+	if n := len(h.Seasonal); h.Count <= n {
+		// level is the average of the first season, and seasonal components are deviations from it
+		h.Seasonal[slot] = value
+		if h.Count == n {
+			for _, v := range h.Seasonal {
+				h.Level += v / float64(n)
+			}
+			for i := range h.Seasonal {
+				h.Seasonal[i] -= h.Level
+			}
+		}
+		return
+	}
+	diff := value - (h.Level + h.Trend + h.Seasonal[slot])
+	h.Variance = (1-h.Alpha)*h.Variance + h.Alpha*diff*diff
+	level := h.Alpha*(value-h.Seasonal[slot]) + (1-h.Alpha)*(h.Level+h.Trend)
+	h.Trend = h.Beta*(level-h.Level) + (1-h.Beta)*h.Trend
+	h.Level = level
+	h.Seasonal[slot] = h.Gamma*(value-h.Level) + (1-h.Gamma)*h.Seasonal[slot]
+}
+
+// Expected returns the forecast for time t and standard deviation of forecast errors
+func (h *HoltWinters) Expected(t time.Time) (mean, stdDev float64, ok bool) {
+	return h.Level + h.Trend + h.Seasonal[h.slot(t)], math.Sqrt(h.Variance), h.Count >= h.WarmUp
+}
+
+// alpha returns smoothing factor forgetting half of the weight after halfLife values
+func alpha(halfLife int) float64 {
+	if halfLife < 1 {
+		halfLife = 1
+	}
+	return 1 - math.Pow(0.5, 1/float64(halfLife))
+}
+
+// saved is the baseline as it's persisted
+type saved struct {
+	Model       string       `json:"model"`
+	EWMA        *EWMA        `json:"ewma,omitempty"`
+	HoltWinters *HoltWinters `json:"holt_winters,omitempty"`
+}
+
+// Save the baseline to the writer as JSON
+func Save(w io.Writer, b Baseline) error {
+	var s saved
+	switch b := b.(type) {
+	case *EWMA:
+		s = saved{Model: "ewma", EWMA: b}
+	case *HoltWinters:
+		s = saved{Model: "holt_winters", HoltWinters: b}
+	default:
+		return fmt.Errorf("unsupported baseline %T", b)
+	}
+	return json.NewEncoder(w).Encode(s)
+}
+
+// Load the baseline saved to the reader by Save
+func Load(r io.Reader) (Baseline, error) {
+	var s saved
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, fmt.Errorf("can't decode baseline: %w", err)
+	}
+	switch {
+	case s.Model == "ewma" && s.EWMA != nil:
+		return s.EWMA, nil
+	case s.Model == "holt_winters" && s.HoltWinters != nil && len(s.HoltWinters.Seasonal) > 0 && s.HoltWinters.Interval > 0:
+		return s.HoltWinters, nil
+	}
+	return nil, fmt.Errorf("unsupported baseline model %q", s.Model)
+}
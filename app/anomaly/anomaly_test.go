@@ -0,0 +1,97 @@
+package anomaly
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEWMA(t *testing.T) {
+	e := NewEWMA(10)
+	rnd := rand.New(rand.NewSource(42))
+	start := time.Unix(1549573860, 0)
+	for i := 0; i < 9; i++ {
+		e.Observe(start, 10)
+	}
+	_, _, ok := e.Expected(start)
+	assert.False(t, ok, "warming up")
+
+	for i := 0; i < 1000; i++ {
+		e.Observe(start, 10+rnd.NormFloat64()*2)
+	}
+	mean, stdDev, ok := e.Expected(start)
+	require.True(t, ok)
+	assert.InDelta(t, 10, mean, 1.5)
+	assert.InDelta(t, 2, stdDev, 1)
+
+	// new level is learned after a few half-lives
+	for i := 0; i < 100; i++ {
+		e.Observe(start, 50)
+	}
+	mean, _, _ = e.Expected(start)
+	assert.InDelta(t, 50, mean, 0.1)
+}
+
+func TestHoltWinters(t *testing.T) {
+	h := NewHoltWinters(time.Minute, time.Hour, 10)
+	rnd := rand.New(rand.NewSource(42))
+	start := time.Unix(1549573860, 0).Truncate(time.Hour)
+	// value is 100 at the start of every hour and 0 in the middle of it
+	value := func(ts time.Time) float64 {
+		return 50 + 50*math.Cos(2*math.Pi*float64(ts.Minute())/60)
+	}
+	ts := start
+	for ; ts.Before(start.Add(time.Hour)); ts = ts.Add(time.Minute) {
+		h.Observe(ts, value(ts))
+	}
+	_, _, ok := h.Expected(ts)
+	assert.False(t, ok, "first season only sets seasonal components")
+
+	for ; ts.Before(start.Add(10 * time.Hour)); ts = ts.Add(time.Minute) {
+		h.Observe(ts, value(ts)+rnd.NormFloat64())
+	}
+	// every value of the next hour is expected before it's observed
+	for end := ts.Add(time.Hour); ts.Before(end); ts = ts.Add(time.Minute) {
+		mean, stdDev, ok := h.Expected(ts)
+		require.True(t, ok)
+		assert.InDelta(t, value(ts), mean, 5, "minute %d", ts.Minute())
+		assert.Less(t, stdDev, 5.0)
+		h.Observe(ts, value(ts)+rnd.NormFloat64())
+	}
+
+	// EWMA doesn't know about the season and expects the average
+	e := NewEWMA(10)
+	for ts = start; ts.Before(start.Add(10 * time.Hour)); ts = ts.Add(time.Minute) {
+		e.Observe(ts, value(ts))
+	}
+	_, stdDev, _ := e.Expected(ts)
+	assert.Greater(t, stdDev, 5.0)
+}
+
+func TestSaveLoad(t *testing.T) {
+	for _, b := range []Baseline{NewEWMA(10), NewHoltWinters(time.Minute, time.Hour, 10)} {
+		start := time.Unix(1549573860, 0)
+		for i := 0; i < 100; i++ {
+			b.Observe(start.Add(time.Duration(i)*time.Minute), float64(i%7))
+		}
+		var buf bytes.Buffer
+		require.NoError(t, Save(&buf, b))
+		loaded, err := Load(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, b, loaded)
+	}
+
+	assert.EqualError(t, Save(&bytes.Buffer{}, nil), "unsupported baseline <nil>")
+	_, err := Load(strings.NewReader(`{"model":"arima"}`))
+	assert.EqualError(t, err, `unsupported baseline model "arima"`)
+	_, err = Load(strings.NewReader(`{"model":"holt_winters","holt_winters":{"seasonal":[]}}`))
+	assert.EqualError(t, err, `unsupported baseline model "holt_winters"`)
+	_, err = Load(strings.NewReader(`garbage`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"log"
+	"time"
+
+	"github.com/paskal/datadog-parser/app/generate"
+)
+
+// generateCommand writes the synthetic log to try the processing on
+type generateCommand struct {
+	Rate       float64       `long:"rate" default:"10" description:"average number of requests per second"`
+	Duration   time.Duration `long:"duration" default:"10m" description:"time between the first and the last requests"`
+	Start      string        `long:"start" description:"date of the first request, like 2019-02-07T21:11:00Z, the log ends now if not set"`
+	Paths      []string      `long:"path" default:"/api/user" default:"/api/help" default:"/report" description:"comma-separated URL paths of requests, the first ones are requested more often"`
+	Clients    int           `long:"clients" default:"5" description:"number of clients, the first ones make more requests"`
+	ErrorRatio float64       `long:"error-ratio" default:"0.05" description:"share of requests failing with 5xx status"`
+	Latency    bool          `long:"latency" description:"add latency_ms column with request durations"`
+	Spike      string        `long:"spike" description:"offset:length:factor spike of the rate and durations of requests like 5m:2m:3, none if not set"`
+	Seed       int64         `long:"seed" description:"seed of the random values, the same one produces the same log, random if 0"`
+}
+
+// runGenerate writes the synthetic log, and returns the exit code
+func runGenerate(w io.Writer, opts opts, now time.Time) int {
+	g := opts.Generate
+	loc, err := time.LoadLocation(opts.OutputTZ)
+	if err != nil {
+		log.Printf("Bad output timezone: %v", err)
+		return 2
+	}
+	cfg := generate.Config{
+		Start:      now.Add(-g.Duration).Truncate(time.Second),
+		Duration:   g.Duration,
+		Rate:       g.Rate,
+		Paths:      splitList(g.Paths),
+		Clients:    g.Clients,
+		ErrorRatio: g.ErrorRatio,
+		Latency:    g.Latency,
+		Seed:       g.Seed,
+	}
+	if g.Start != "" {
+		if cfg.Start, err = parseDate(g.Start, loc); err != nil {
+			log.Printf("Bad start of the log: %v", err)
+			return 2
+		}
+	}
+	if g.Spike != "" {
+		if cfg.Spike, err = generate.ParseSpike(g.Spike); err != nil {
+			log.Printf("Bad spike: %v", err)
+			return 2
+		}
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = now.UnixNano()
+	}
+	generator, err := generate.New(cfg)
+	if err != nil {
+		log.Printf("Bad generate options: %v", err)
+		return 2
+	}
+	if err = generator.Write(w); err != nil {
+		log.Printf("Error writing the log: %v", err)
+		return 3
+	}
+	return 0
+}
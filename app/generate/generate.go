@@ -0,0 +1,183 @@
+// Package generate writes synthetic logs in the format of the processed ones, with Poisson-distributed
+// requests of a few clients, errors and an optional traffic spike
+package generate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxClients is the number of addresses in 10.0.0.0/16 network the clients are from
+const maxClients = 256 * 254
+
+// Config of the synthetic log
+type Config struct {
+	Start      time.Time
+	Duration   time.Duration
+	Rate       float64  // average number of requests per second
+	Paths      []string // requested URL paths, the first ones are requested more often
+	Clients    int      // number of clients, the first ones make more requests
+	ErrorRatio float64  // share of requests failing with 5xx status
+	Latency    bool     // add latency_ms column with request durations
+	Spike      Spike    // traffic spike, none if its length is zero
+	Seed       int64    // seed of the random values, the same one produces the same log
+}
+
+// Spike multiplies the rate of requests and their durations for a while
+type Spike struct {
+	Offset time.Duration // since the start of the log
+	Length time.Duration
+	Factor float64
+}
+
+// Generator writes the synthetic log
+type Generator struct {
+	cfg     Config
+	rnd     *rand.Rand
+	paths   []float64 // cumulative weights of the paths
+	clients []float64 // cumulative weights of the clients
+}
+
+// New checks the config and returns the generator for it
+func New(cfg Config) (*Generator, error) {
+	if cfg.Rate <= 0 || cfg.Duration <= 0 {
+		return nil, errors.New("rate and duration must be positive")
+	}
+	if cfg.Clients < 1 || cfg.Clients > maxClients {
+		return nil, fmt.Errorf("number of clients must be between 1 and %d", maxClients)
+	}
+	if cfg.ErrorRatio < 0 || cfg.ErrorRatio > 1 {
+		return nil, errors.New("error ratio must be between 0 and 1")
+	}
+	if len(cfg.Paths) == 0 {
+		return nil, errors.New("at least one path is required")
+	}
+	for _, p := range cfg.Paths {
+		if !strings.HasPrefix(p, "/") || strings.ContainsAny(p, " \"\n") {
+			return nil, fmt.Errorf("bad path %q, should start with / and have no spaces or quotes", p)
+		}
+	}
+	if cfg.Spike.Length < 0 || cfg.Spike.Offset < 0 || (cfg.Spike.Length > 0 && cfg.Spike.Factor <= 0) {
+		return nil, errors.New("spike offset and length must not be negative, and its factor must be positive")
+	}
+	return &Generator{
+		cfg:     cfg,
+		rnd:     rand.New(rand.NewSource(cfg.Seed)), //nolint:gosec // synthetic data doesn't need secure random
+		paths:   zipfWeights(len(cfg.Paths)),
+		clients: zipfWeights(cfg.Clients),
+	}, nil
+}
+
+// ParseSpike parses the spike like 5m:2m:3, which triples the rate for two minutes five minutes after the start
+func ParseSpike(s string) (Spike, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return Spike{}, fmt.Errorf("bad spike %q, should be like 5m:2m:3", s)
+	}
+	var spike Spike
+	var err error
+	if spike.Offset, err = time.ParseDuration(parts[0]); err != nil {
+		return Spike{}, fmt.Errorf("bad spike offset: %w", err)
+	}
+	if spike.Length, err = time.ParseDuration(parts[1]); err != nil {
+		return Spike{}, fmt.Errorf("bad spike length: %w", err)
+	}
+	if spike.Factor, err = strconv.ParseFloat(parts[2], 64); err != nil {
+		return Spike{}, fmt.Errorf("bad spike factor: %w", err)
+	}
+	return spike, nil
+}
+
+// Write the log with the header, requests are ordered by their dates
+func (g *Generator) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	header := `"remotehost","rfc931","authuser","date","request","status","bytes"`
+	if g.cfg.Latency {
+		header += `,"latency_ms"`
+	}
+	if _, err := bw.WriteString(header + "\n"); err != nil {
+		return err
+	}
+	for offset := g.next(0); offset < g.cfg.Duration; offset = g.next(offset) {
+		if err := g.writeRequest(bw, offset); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// next returns the offset of the request following the one at the provided offset
+func (g *Generator) next(offset time.Duration) time.Duration {
+	gap := g.rnd.ExpFloat64() / (g.cfg.Rate * g.factor(offset))
+	return offset + time.Duration(gap*float64(time.Second))
+}
+
+// factor returns the spike factor at the offset, 1 outside of the spike
+func (g *Generator) factor(offset time.Duration) float64 {
+	s := g.cfg.Spike
+	if s.Length > 0 && offset >= s.Offset && offset < s.Offset+s.Length {
+		return s.Factor
+	}
+	return 1
+}
+
+// writeRequest writes the random request at the offset
+func (g *Generator) writeRequest(w *bufio.Writer, offset time.Duration) error {
+	client := pick(g.rnd, g.clients)
+	method := "GET"
+	if g.rnd.Float64() < 0.25 {
+		method = "POST"
+	}
+	path := g.cfg.Paths[pick(g.rnd, g.paths)]
+
+	status, bytes, latency := 200, 1000+g.rnd.Intn(400), 50*math.Exp(g.rnd.NormFloat64()*0.5)
+	switch r := g.rnd.Float64(); {
+	case r < g.cfg.ErrorRatio:
+		status, bytes, latency = []int{500, 502, 503}[g.rnd.Intn(3)], 100+g.rnd.Intn(200), latency*4
+	case r < g.cfg.ErrorRatio+(1-g.cfg.ErrorRatio)*0.05:
+		status, bytes = 404, 100+g.rnd.Intn(200)
+	}
+
+	_, err := fmt.Fprintf(w, `"10.0.%d.%d","-","apache",%d,"%s %s HTTP/1.0",%d,%d`,
+		client/254, client%254+1, g.cfg.Start.Add(offset).Unix(), method, path, status, bytes)
+	if err != nil {
+		return err
+	}
+	if g.cfg.Latency {
+		_, err = fmt.Fprintf(w, ",%d", int(math.Round(latency*g.factor(offset))))
+		if err != nil {
+			return err
+		}
+	}
+	return w.WriteByte('\n')
+}
+
+// zipfWeights returns cumulative weights of n items, where the weight of the item is inversely
+// proportional to its position, so that the first items are picked more often
+func zipfWeights(n int) []float64 {
+	weights := make([]float64, n)
+	var sum float64
+	for i := range weights {
+		sum += 1 / float64(i+1)
+		weights[i] = sum
+	}
+	return weights
+}
+
+// pick the random item by cumulative weights
+func pick(rnd *rand.Rand, weights []float64) int {
+	r := rnd.Float64() * weights[len(weights)-1]
+	for i, w := range weights {
+		if r < w {
+			return i
+		}
+	}
+	return len(weights) - 1
+}
@@ -0,0 +1,101 @@
+package generate
+
+import (
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerator(t *testing.T) {
+	start := time.Date(2019, 2, 7, 21, 11, 0, 0, time.UTC)
+	cfg := Config{Start: start, Duration: 10 * time.Minute, Rate: 10, Paths: []string{"/api/user", "/report"},
+		Clients: 3, ErrorRatio: 0.1, Latency: true, Spike: Spike{Offset: 5 * time.Minute, Length: time.Minute, Factor: 5}, Seed: 42}
+	g, err := New(cfg)
+	require.NoError(t, err)
+	var out strings.Builder
+	require.NoError(t, g.Write(&out))
+
+	lines, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"remotehost", "rfc931", "authuser", "date", "request", "status", "bytes", "latency_ms"}, lines[0])
+
+	var spike, errors, latency, spikeLatency int
+	clients := map[string]int{}
+	prev := start.Unix()
+	for _, l := range lines[1:] {
+		date, err := strconv.ParseInt(l[3], 10, 64)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, date, prev, "requests are ordered by date")
+		prev = date
+		clients[l[0]]++
+		if l[5] >= "500" {
+			errors++
+		}
+		ms, err := strconv.Atoi(l[7])
+		require.NoError(t, err)
+		if date >= start.Add(5*time.Minute).Unix() && date < start.Add(6*time.Minute).Unix() {
+			spike++
+			spikeLatency += ms
+			continue
+		}
+		latency += ms
+	}
+	assert.Less(t, prev, start.Add(10*time.Minute).Unix())
+	// 9 minutes at 10 requests per second and a minute at 50
+	assert.InDelta(t, 5400, len(lines)-1-spike, 300)
+	assert.InDelta(t, 3000, spike, 200)
+	assert.InDelta(t, 0.1, float64(errors)/float64(len(lines)-1), 0.02)
+	assert.Greater(t, float64(spikeLatency)/float64(spike), 3*float64(latency)/float64(len(lines)-1-spike))
+	assert.Len(t, clients, 3)
+	assert.Greater(t, clients["10.0.0.1"], clients["10.0.0.3"], "the first clients make more requests")
+
+	// the same seed produces the same log
+	g, err = New(cfg)
+	require.NoError(t, err)
+	var again strings.Builder
+	require.NoError(t, g.Write(&again))
+	assert.Equal(t, out.String(), again.String())
+}
+
+func TestNewErrors(t *testing.T) {
+	valid := Config{Duration: time.Minute, Rate: 1, Paths: []string{"/"}, Clients: 1}
+	_, err := New(valid)
+	require.NoError(t, err)
+
+	for msg, change := range map[string]func(c *Config){
+		"rate and duration must be positive":                                func(c *Config) { c.Rate = 0 },
+		"number of clients must be between 1 and 65024":                     func(c *Config) { c.Clients = 70000 },
+		"error ratio must be between 0 and 1":                               func(c *Config) { c.ErrorRatio = -0.1 },
+		"at least one path is required":                                     func(c *Config) { c.Paths = nil },
+		`bad path "/a b", should start with / and have no spaces or quotes`: func(c *Config) { c.Paths = []string{"/a b"} },
+		"spike offset and length must not be negative, and its factor must be positive": func(c *Config) {
+			c.Spike = Spike{Length: time.Minute}
+		},
+	} {
+		c := valid
+		change(&c)
+		_, err = New(c)
+		assert.EqualError(t, err, msg)
+	}
+}
+
+func TestParseSpike(t *testing.T) {
+	s, err := ParseSpike("5m:2m:3.5")
+	require.NoError(t, err)
+	assert.Equal(t, Spike{Offset: 5 * time.Minute, Length: 2 * time.Minute, Factor: 3.5}, s)
+
+	for expr, msg := range map[string]string{
+		"5m":       `bad spike "5m", should be like 5m:2m:3`,
+		"x:2m:3":   `bad spike offset: time: invalid duration "x"`,
+		"5m:2:3":   `bad spike length: time: missing unit in duration "2"`,
+		"5m:2m:x3": `bad spike factor: strconv.ParseFloat: parsing "x3": invalid syntax`,
+	} {
+		_, err = ParseSpike(expr)
+		assert.EqualError(t, err, msg, expr)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunGenerate(t *testing.T) {
+	now := time.Date(2019, 2, 7, 21, 21, 0, 0, time.UTC)
+	generate := func(args ...string) (string, int) {
+		o, command := parseArgs(t, append([]string{"generate"}, args...)...)
+		require.Equal(t, "generate", command)
+		var out strings.Builder
+		code := runGenerate(&out, o, now)
+		return out.String(), code
+	}
+
+	out, code := generate("--seed=1", "--duration=10m")
+	require.Equal(t, 0, code)
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	assert.Equal(t, `"remotehost","rfc931","authuser","date","request","status","bytes"`, lines[0])
+	assert.Contains(t, lines[1], `,1549573860,`, "the log ends now by default")
+	assert.InDelta(t, 6000, len(lines)-1, 300)
+	same, _ := generate("--seed=1", "--duration=10m")
+	assert.Equal(t, out, same, "the same seed produces the same log")
+
+	out, code = generate("--seed=1", "--start=2019-02-07 22:00", "--output_tz=Europe/Berlin", "--duration=1s",
+		"--rate=1000", "--path=/a,/b", "--latency")
+	require.Equal(t, 0, code)
+	lines = strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	assert.Equal(t, `"remotehost","rfc931","authuser","date","request","status","bytes","latency_ms"`, lines[0])
+	assert.Regexp(t, `^"10\.0\.0\.\d","-","apache",1549573200,"(GET|POST) /[ab] HTTP/1.0",\d{3},\d+,\d+$`, lines[1])
+
+	for _, args := range [][]string{{"--rate=0"}, {"--spike=5m"}, {"--start=yesterday"}, {"--error-ratio=2"}, {"--path=api"}} {
+		out, code = generate(args...)
+		assert.Equal(t, 2, code, args)
+		assert.Empty(t, out, args)
+	}
+}
@@ -0,0 +1,90 @@
+// Package geoip enriches client addresses with the country and the autonomous system
+// using local MaxMind DB files like GeoLite2 Country and GeoLite2 ASN, without any network access
+package geoip
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+)
+
+// Unknown is the country or ASN of addresses not found in the databases
+const Unknown = "-"
+
+// Info is the location of the client address
+type Info struct {
+	Country string // ISO 3166-1 country code like "US"
+	ASN     string // autonomous system number like "AS15169"
+	Org     string // autonomous system organization like "Google LLC"
+}
+
+// DB looks up addresses in one or more MaxMind DB files, every file adds fields it has to the result,
+// so that separate country and ASN databases could be used together. It's safe for concurrent use.
+type DB struct {
+	readers []*Reader
+}
+
+// Open reads MaxMind DB files into memory
+func Open(paths ...string) (*DB, error) {
+	readers := make([]*Reader, 0, len(paths))
+	for _, path := range paths {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		r, err := NewReader(buf)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		readers = append(readers, r)
+	}
+	return New(readers...), nil
+}
+
+// New creates DB using provided readers
+func New(readers ...*Reader) *DB {
+	return &DB{readers: readers}
+}
+
+// Lookup returns the location of the client address, with Unknown country and ASN if it's not found
+// or the client is not an IP address
+func (db *DB) Lookup(host string) Info {
+	info := Info{Country: Unknown, ASN: Unknown}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return info
+	}
+	for _, r := range db.readers {
+		// errors mean the file is malformed for that address only, which is the same as no data for our purpose
+		data, err := r.Lookup(ip)
+		if err != nil {
+			continue
+		}
+		record, ok := data.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if country := countryCode(record); country != "" && info.Country == Unknown {
+			info.Country = country
+		}
+		if asn, ok := record["autonomous_system_number"].(uint64); ok && info.ASN == Unknown {
+			info.ASN = "AS" + strconv.FormatUint(asn, 10)
+			info.Org, _ = record["autonomous_system_organization"].(string)
+		}
+	}
+	return info
+}
+
+// countryCode returns the country of the GeoIP2/GeoLite2 Country or City record, falling back to
+// the registered country for addresses like anycast ones which have no location
+func countryCode(record map[string]interface{}) string {
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := record[key].(map[string]interface{}); ok {
+			if code, ok := country["iso_code"].(string); ok && code != "" {
+				return code
+			}
+		}
+	}
+	return ""
+}
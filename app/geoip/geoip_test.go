@@ -0,0 +1,44 @@
+package geoip
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	countries := writeTestDB(t, 6, 24, "GeoLite2-Country", []testNetwork{
+		{cidr: "81.2.69.0/24", data: map[string]interface{}{"country": map[string]interface{}{"iso_code": "GB"}}},
+		{cidr: "1.1.1.0/24", data: map[string]interface{}{"registered_country": map[string]interface{}{"iso_code": "AU"}}},
+	})
+	asns := writeTestDB(t, 6, 28, "GeoLite2-ASN", []testNetwork{
+		{cidr: "81.2.69.0/24", data: map[string]interface{}{
+			"autonomous_system_number":       uint32(20712),
+			"autonomous_system_organization": "Andrews & Arnold Ltd",
+		}},
+	})
+	countriesPath, asnsPath := filepath.Join(dir, "country.mmdb"), filepath.Join(dir, "asn.mmdb")
+	require.NoError(t, ioutil.WriteFile(countriesPath, countries, 0o600))
+	require.NoError(t, ioutil.WriteFile(asnsPath, asns, 0o600))
+
+	db, err := Open(countriesPath, asnsPath)
+	require.NoError(t, err)
+	assert.Equal(t, Info{Country: "GB", ASN: "AS20712", Org: "Andrews & Arnold Ltd"}, db.Lookup("81.2.69.1"))
+	assert.Equal(t, Info{Country: "AU", ASN: Unknown}, db.Lookup("1.1.1.1"))
+	assert.Equal(t, Info{Country: Unknown, ASN: Unknown}, db.Lookup("10.0.0.1"))
+	assert.Equal(t, Info{Country: Unknown, ASN: Unknown}, db.Lookup("example.com"))
+
+	_, err = Open(filepath.Join(dir, "missing.mmdb"))
+	assert.Error(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bad.mmdb"), []byte("bad"), 0o600))
+	_, err = Open(filepath.Join(dir, "bad.mmdb"))
+	assert.EqualError(t, err, filepath.Join(dir, "bad.mmdb")+": metadata not found, not a MaxMind DB file")
+}
@@ -0,0 +1,321 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+)
+
+// metadataMarker precedes the metadata at the end of MaxMind DB file
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// metadataMaxSize is the maximum size of the metadata section, as defined by the format
+const metadataMaxSize = 128 * 1024
+
+// dataSectionSeparator is the number of zero bytes between the search tree and the data section
+const dataSectionSeparator = 16
+
+// data section field types
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	_ // data cache container, not used in databases
+	_ // end marker, not used in databases
+	typeBoolean
+	typeFloat
+)
+
+// maxDepth limits the nesting of maps and arrays, so that malformed file can't exhaust the stack
+const maxDepth = 64
+
+// Reader looks up IP addresses in MaxMind DB file, as described in
+// https://maxmind.github.io/MaxMind-DB/. It's safe for concurrent use.
+type Reader struct {
+	tree         []byte // binary search tree, node_count nodes of record_size*2 bits each
+	data         []byte // data section
+	nodeCount    uint32
+	recordSize   int
+	ipVersion    int
+	databaseType string
+	ipv4Start    uint32 // node to start IPv4 lookups in IPv6 tree from
+}
+
+// NewReader parses MaxMind DB file contents
+func NewReader(buf []byte) (*Reader, error) {
+	searchFrom := 0
+	if len(buf) > metadataMaxSize {
+		searchFrom = len(buf) - metadataMaxSize
+	}
+	markerPos := bytes.LastIndex(buf[searchFrom:], metadataMarker)
+	if markerPos < 0 {
+		return nil, errors.New("metadata not found, not a MaxMind DB file")
+	}
+	markerPos += searchFrom
+	meta, _, err := (&decoder{buf: buf[markerPos+len(metadataMarker):]}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("bad metadata: %w", err)
+	}
+	metaMap, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("bad metadata: not a map")
+	}
+
+	r := &Reader{}
+	nodeCount, ok1 := metaMap["node_count"].(uint64)
+	recordSize, ok2 := metaMap["record_size"].(uint64)
+	ipVersion, ok3 := metaMap["ip_version"].(uint64)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, errors.New("bad metadata: node_count, record_size or ip_version is missing")
+	}
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", recordSize)
+	}
+	if ipVersion != 4 && ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", ipVersion)
+	}
+	r.nodeCount, r.recordSize, r.ipVersion = uint32(nodeCount), int(recordSize), int(ipVersion)
+	r.databaseType, _ = metaMap["database_type"].(string)
+
+	treeSize := uint64(r.nodeCount) * uint64(r.recordSize) / 4
+	if treeSize+dataSectionSeparator > uint64(markerPos) {
+		return nil, fmt.Errorf("search tree of %d nodes doesn't fit into the file", r.nodeCount)
+	}
+	r.tree = buf[:treeSize]
+	r.data = buf[treeSize+dataSectionSeparator : markerPos]
+
+	if r.ipVersion == 6 {
+		// IPv4 addresses are stored as ::a.b.c.d, so their lookup starts after 96 zero bits
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// DatabaseType returns the type of the database like "GeoLite2-Country"
+func (r *Reader) DatabaseType() string { return r.databaseType }
+
+// Lookup returns the data for the IP address decoded into maps, slices, strings, numbers and booleans,
+// or nil if there is no data for it
+func (r *Reader) Lookup(ip net.IP) (interface{}, error) {
+	node := uint32(0)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		node = r.ipv4Start
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+	for i := 0; i < 8*len(ip) && node < r.nodeCount; i++ {
+		node = r.record(node, int(ip[i/8]>>(7-i%8))&1)
+	}
+	if node <= r.nodeCount {
+		// node count itself means there is no data
+		return nil, nil
+	}
+	offset := node - r.nodeCount - dataSectionSeparator
+	value, _, err := (&decoder{buf: r.data}).decode(uint(offset), 0)
+	return value, err
+}
+
+// record returns left (bit 0) or right (bit 1) record of the node
+func (r *Reader) record(node uint32, bit int) uint32 {
+	b := r.tree[int(node)*r.recordSize/4:]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		if bit == 0 {
+			return uint32(b[3]&0xF0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0F)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	}
+	return binary.BigEndian.Uint32(b[bit*4:])
+}
+
+// decoder decodes data section fields, pointers are offsets in buf
+type decoder struct {
+	buf []byte
+}
+
+// decode the field at the offset, returns its value and the offset of the next field
+func (d *decoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, errors.New("data is nested too deep")
+	}
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == typePointer {
+		value, _, err := d.decode(size, depth+1)
+		return value, offset, err
+	}
+	if typ != typeMap && typ != typeArray && typ != typeBoolean && uint64(offset)+uint64(size) > uint64(len(d.buf)) {
+		return nil, 0, fmt.Errorf("field of type %d at %d is out of data section", typ, offset)
+	}
+	field, end := d.buf[offset:offset], offset
+	if typ != typeMap && typ != typeArray && typ != typeBoolean {
+		field, end = d.buf[offset:offset+size], offset+size
+	}
+	switch typ {
+	case typeString:
+		return string(field), end, nil
+	case typeBytes:
+		return append([]byte(nil), field...), end, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(field)), end, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(field))), end, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("unsigned integer of size %d", size)
+		}
+		var n uint64
+		for _, b := range field {
+			n = n<<8 | uint64(b)
+		}
+		return n, end, nil
+	case typeUint128:
+		// too big for any number type, which isn't used by GeoIP databases anyway
+		return append([]byte(nil), field...), end, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("int32 of size %d", size)
+		}
+		var n uint32
+		for _, b := range field {
+			n = n<<8 | uint32(b)
+		}
+		return int64(int32(n)), end, nil
+	case typeBoolean:
+		return size != 0, offset, nil
+	case typeMap:
+		// size is not used as a capacity hint, as it could be anything in a malformed file
+		m := make(map[string]interface{})
+		for i := uint(0); i < size; i++ {
+			var key, value interface{}
+			if key, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			if value, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		var a []interface{}
+		for i := uint(0); i < size; i++ {
+			var value interface{}
+			if value, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported field type %d", typ)
+}
+
+// control parses the control byte of the field at the offset, returns the type,
+// the size (pointer target for pointers) and the offset of the field payload
+func (d *decoder) control(offset uint) (typ int, size uint, next uint, err error) {
+	payload, ok := d.bytes(offset, 1)
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("field at %d is out of data section", offset)
+	}
+	ctrl := payload[0]
+	offset++
+	typ = int(ctrl >> 5)
+	if typ == typePointer {
+		return d.pointer(ctrl, offset)
+	}
+	if typ == typeExtended {
+		ext, ok := d.bytes(offset, 1)
+		if !ok {
+			return 0, 0, 0, fmt.Errorf("extended type at %d is out of data section", offset)
+		}
+		typ = 7 + int(ext[0])
+		if typ <= typeMap {
+			return 0, 0, 0, fmt.Errorf("bad extended type %d at %d", ext[0], offset)
+		}
+		offset++
+	}
+	size = uint(ctrl & 0x1f)
+	if size < 29 {
+		return typ, size, offset, nil
+	}
+	n := int(size) - 28
+	b, ok := d.bytes(offset, n)
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("size of the field at %d is out of data section", offset)
+	}
+	var extra uint
+	for _, c := range b {
+		extra = extra<<8 | uint(c)
+	}
+	switch n {
+	case 1:
+		size = 29 + extra
+	case 2:
+		size = 285 + extra
+	default:
+		size = 65821 + extra
+	}
+	return typ, size, offset + uint(n), nil
+}
+
+// pointer parses pointer with the control byte ctrl and the rest of it at the offset
+func (d *decoder) pointer(ctrl byte, offset uint) (typ int, target uint, next uint, err error) {
+	n := int(ctrl>>3)&0x3 + 1
+	b, ok := d.bytes(offset, n)
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("pointer at %d is out of data section", offset)
+	}
+	var p uint
+	if n < 4 {
+		p = uint(ctrl & 0x7)
+	}
+	for _, c := range b {
+		p = p<<8 | uint(c)
+	}
+	switch n {
+	case 2:
+		p += 2048
+	case 3:
+		p += 526336
+	}
+	return typePointer, p, offset + uint(n), nil
+}
+
+// bytes returns n bytes at the offset, or false if they are out of the buffer
+func (d *decoder) bytes(offset uint, n int) ([]byte, bool) {
+	if uint64(offset)+uint64(n) > uint64(len(d.buf)) {
+		return nil, false
+	}
+	return d.buf[offset : offset+uint(n)], true
+}
@@ -0,0 +1,28 @@
+//go:build go1.18
+// +build go1.18
+
+package geoip
+
+import (
+	"net"
+	"testing"
+)
+
+// FuzzReader checks that malformed database doesn't cause a panic
+func FuzzReader(f *testing.F) {
+	f.Add(writeTestDB(f, 6, 24, "Test", []testNetwork{
+		{cidr: "81.2.69.0/24", data: map[string]interface{}{"country": map[string]interface{}{"iso_code": "GB"}}},
+	}))
+	f.Add(writeTestDB(f, 4, 28, "Test", []testNetwork{
+		{cidr: "81.2.69.0/24", data: map[string]interface{}{"autonomous_system_number": uint32(1)}},
+	}))
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		r, err := NewReader(buf)
+		if err != nil {
+			return
+		}
+		for _, ip := range []string{"81.2.69.1", "10.0.0.1", "2001:db8::1"} {
+			_, _ = r.Lookup(net.ParseIP(ip))
+		}
+	})
+}
@@ -0,0 +1,338 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader(t *testing.T) {
+	networks := []testNetwork{
+		{cidr: "81.2.69.0/24", data: map[string]interface{}{"country": map[string]interface{}{"iso_code": "GB"}}},
+		{cidr: "81.2.69.160/27", data: map[string]interface{}{"country": map[string]interface{}{"iso_code": "IE"}}},
+		{cidr: "175.16.199.0/24", data: map[string]interface{}{"country": map[string]interface{}{"iso_code": "CN"}}},
+		{cidr: "2001:db8::/32", data: map[string]interface{}{"country": map[string]interface{}{"iso_code": "DE"}}},
+	}
+	for _, recordSize := range []int{24, 28, 32} {
+		for _, ipVersion := range []int{4, 6} {
+			buf := writeTestDB(t, ipVersion, recordSize, "Test-Country", networks)
+			r, err := NewReader(buf)
+			require.NoError(t, err, "record size %d, IPv%d", recordSize, ipVersion)
+			assert.Equal(t, "Test-Country", r.DatabaseType())
+
+			var testData = []struct{ ip, country string }{
+				{ip: "81.2.69.1", country: "GB"},
+				{ip: "81.2.69.161", country: "IE"},
+				{ip: "175.16.199.255", country: "CN"},
+				{ip: "10.0.0.1", country: ""},
+				{ip: "2001:db8:1::1", country: "DE"},
+				{ip: "2001:db9::1", country: ""},
+			}
+			if ipVersion == 4 {
+				testData[4].country = ""
+			}
+			for _, x := range testData {
+				data, err := r.Lookup(net.ParseIP(x.ip))
+				require.NoError(t, err)
+				if x.country == "" {
+					assert.Nil(t, data, "%s in record size %d, IPv%d", x.ip, recordSize, ipVersion)
+					continue
+				}
+				assert.Equal(t, map[string]interface{}{"country": map[string]interface{}{"iso_code": x.country}}, data,
+					"%s in record size %d, IPv%d", x.ip, recordSize, ipVersion)
+			}
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	value := map[string]interface{}{
+		"short":   "a",
+		"long":    strings.Repeat("b", 100),
+		"longer":  strings.Repeat("c", 1000),
+		"longest": strings.Repeat("d", 70000),
+		"uint16":  uint16(65535),
+		"uint32":  uint32(4294967295),
+		"uint64":  uint64(1) << 60,
+		"int32":   int32(-12345),
+		"double":  math.Pi,
+		"float":   float32(1.5),
+		"true":    true,
+		"false":   false,
+		"array":   []interface{}{"a", uint32(1), []interface{}{}},
+		"repeat":  []interface{}{"a", "a", "a"},
+	}
+	e := &testEncoder{strings: map[string]int{}}
+	e.encode(value)
+	decoded, next, err := (&decoder{buf: e.buf.Bytes()}).decode(0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint(e.buf.Len()), next)
+	assert.Equal(t, map[string]interface{}{
+		"short":   "a",
+		"long":    strings.Repeat("b", 100),
+		"longer":  strings.Repeat("c", 1000),
+		"longest": strings.Repeat("d", 70000),
+		"uint16":  uint64(65535),
+		"uint32":  uint64(4294967295),
+		"uint64":  uint64(1) << 60,
+		"int32":   int64(-12345),
+		"double":  math.Pi,
+		"float":   1.5,
+		"true":    true,
+		"false":   false,
+		"array":   []interface{}{"a", uint64(1), []interface{}(nil)},
+		"repeat":  []interface{}{"a", "a", "a"},
+	}, decoded)
+}
+
+func TestDecoderErrors(t *testing.T) {
+	var testData = []struct {
+		description string
+		buf         []byte
+		err         string
+	}{
+		{description: "empty", buf: nil, err: "field at 0 is out of data section"},
+		{description: "string too long", buf: []byte{0x45, 'a'}, err: "field of type 2 at 1 is out of data section"},
+		{description: "bad double", buf: []byte{0x61, 0}, err: "double of size 1"},
+		{description: "pointer out of data", buf: []byte{0x20}, err: "pointer at 1 is out of data section"},
+		{description: "pointer loop", buf: []byte{0x20, 0x00}, err: "data is nested too deep"},
+		{description: "map with number key", buf: []byte{0xe1, 0xc1, 1, 0x41, 'a'}, err: "map key is not a string"},
+		{description: "bad extended type", buf: []byte{0x00, 0x00}, err: "bad extended type 0 at 1"},
+		{description: "unsupported type", buf: []byte{0x00, 0x05}, err: "unsupported field type 12"},
+	}
+	for _, x := range testData {
+		_, _, err := (&decoder{buf: x.buf}).decode(0, 0)
+		assert.EqualError(t, err, x.err, x.description)
+	}
+}
+
+func TestNewReaderErrors(t *testing.T) {
+	_, err := NewReader([]byte("not a database"))
+	assert.EqualError(t, err, "metadata not found, not a MaxMind DB file")
+
+	e := &testEncoder{strings: map[string]int{}}
+	e.encode(map[string]interface{}{"node_count": uint32(1), "record_size": uint16(20), "ip_version": uint16(4)})
+	_, err = NewReader(append(metadataMarker, e.buf.Bytes()...))
+	assert.EqualError(t, err, "unsupported record size 20")
+
+	e = &testEncoder{strings: map[string]int{}}
+	e.encode(map[string]interface{}{"node_count": uint32(100), "record_size": uint16(24), "ip_version": uint16(4)})
+	_, err = NewReader(append(metadataMarker, e.buf.Bytes()...))
+	assert.EqualError(t, err, "search tree of 100 nodes doesn't fit into the file")
+
+	e = &testEncoder{strings: map[string]int{}}
+	e.encode(map[string]interface{}{"node_count": uint32(100)})
+	_, err = NewReader(append(metadataMarker, e.buf.Bytes()...))
+	assert.EqualError(t, err, "bad metadata: node_count, record_size or ip_version is missing")
+}
+
+func BenchmarkLookup(b *testing.B) {
+	buf := writeTestDB(b, 6, 28, "Test-Country", []testNetwork{
+		{cidr: "81.2.69.0/24", data: map[string]interface{}{"country": map[string]interface{}{"iso_code": "GB"}}},
+	})
+	r, err := NewReader(buf)
+	require.NoError(b, err)
+	ip := net.ParseIP("81.2.69.1")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = r.Lookup(ip)
+	}
+}
+
+// testNetwork is the network and its data written to the test database
+type testNetwork struct {
+	cidr string
+	data interface{}
+}
+
+// testRef is a search tree record: node index, data offset or nothing
+type testRef struct {
+	node   int
+	data   int
+	isData bool
+}
+
+// writeTestDB writes MaxMind DB with provided networks, more specific networks should go after less specific ones
+func writeTestDB(t testing.TB, ipVersion, recordSize int, databaseType string, networks []testNetwork) []byte {
+	nodes := [][2]testRef{{}} // node 0 is the root, node reference 0 means no data as root can't be a child
+	data := &testEncoder{strings: map[string]int{}}
+	for _, n := range networks {
+		_, network, err := net.ParseCIDR(n.cidr)
+		require.NoError(t, err)
+		ones, bits := network.Mask.Size()
+		ip := []byte(network.IP)
+		if bits == 32 {
+			ip = network.IP.To4()
+		}
+		if ipVersion == 6 && bits == 32 {
+			ip, ones = append(make([]byte, 12), ip...), ones+96
+		}
+		if ipVersion == 4 && bits == 128 {
+			continue
+		}
+		ref := testRef{data: data.buf.Len(), isData: true}
+		data.encode(n.data)
+
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip[i/8]>>(7-i%8)) & 1
+			if i == ones-1 {
+				nodes[node][bit] = ref
+				break
+			}
+			next := nodes[node][bit]
+			if next.isData || next.node == 0 {
+				// split the less specific network, so that the rest of it keeps its data
+				nodes = append(nodes, [2]testRef{next, next})
+				nodes[node][bit] = testRef{node: len(nodes) - 1}
+			}
+			node = nodes[node][bit].node
+		}
+	}
+
+	var buf bytes.Buffer
+	nodeCount := len(nodes)
+	value := func(r testRef) uint32 {
+		switch {
+		case r.isData:
+			return uint32(nodeCount + dataSectionSeparator + r.data)
+		case r.node == 0:
+			return uint32(nodeCount)
+		}
+		return uint32(r.node)
+	}
+	for _, n := range nodes {
+		left, right := value(n[0]), value(n[1])
+		switch recordSize {
+		case 24:
+			buf.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			buf.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left),
+				byte(left>>24)<<4 | byte(right>>24)&0x0F, byte(right >> 16), byte(right >> 8), byte(right)})
+		case 32:
+			require.NoError(t, binary.Write(&buf, binary.BigEndian, [2]uint32{left, right}))
+		}
+	}
+	buf.Write(make([]byte, dataSectionSeparator))
+	buf.Write(data.buf.Bytes())
+	buf.Write(metadataMarker)
+	meta := &testEncoder{strings: map[string]int{}}
+	meta.encode(map[string]interface{}{
+		"node_count":    uint32(nodeCount),
+		"record_size":   uint16(recordSize),
+		"ip_version":    uint16(ipVersion),
+		"database_type": databaseType,
+	})
+	buf.Write(meta.buf.Bytes())
+	return buf.Bytes()
+}
+
+// testEncoder writes data section fields, repeated strings are written as pointers to the first one
+type testEncoder struct {
+	buf     bytes.Buffer
+	strings map[string]int
+}
+
+func (e *testEncoder) encode(value interface{}) {
+	switch v := value.(type) {
+	case string:
+		if offset, ok := e.strings[v]; ok {
+			e.pointer(offset)
+			return
+		}
+		e.strings[v] = e.buf.Len()
+		e.control(typeString, len(v))
+		e.buf.WriteString(v)
+	case uint16:
+		e.uint(typeUint16, uint64(v))
+	case uint32:
+		e.uint(typeUint32, uint64(v))
+	case uint64:
+		e.uint(typeUint64, v)
+	case int32:
+		e.control(typeInt32, 4)
+		_ = binary.Write(&e.buf, binary.BigEndian, v)
+	case float64:
+		e.control(typeDouble, 8)
+		_ = binary.Write(&e.buf, binary.BigEndian, v)
+	case float32:
+		e.control(typeFloat, 4)
+		_ = binary.Write(&e.buf, binary.BigEndian, v)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		e.control(typeBoolean, size)
+	case []interface{}:
+		e.control(typeArray, len(v))
+		for _, item := range v {
+			e.encode(item)
+		}
+	case map[string]interface{}:
+		e.control(typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			e.encode(k)
+			e.encode(v[k])
+		}
+	default:
+		panic("unsupported type")
+	}
+}
+
+// uint writes unsigned integer in as few bytes as possible
+func (e *testEncoder) uint(typ int, v uint64) {
+	var b []byte
+	for ; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	e.control(typ, len(b))
+	e.buf.Write(b)
+}
+
+func (e *testEncoder) control(typ, size int) {
+	var ctrl byte
+	var ext []byte
+	switch {
+	case size < 29:
+		ctrl = byte(size)
+	case size < 285:
+		ctrl, ext = 29, []byte{byte(size - 29)}
+	case size < 65821:
+		ctrl, ext = 30, []byte{byte((size - 285) >> 8), byte(size - 285)}
+	default:
+		s := size - 65821
+		ctrl, ext = 31, []byte{byte(s >> 16), byte(s >> 8), byte(s)}
+	}
+	if typ <= typeMap {
+		e.buf.WriteByte(byte(typ)<<5 | ctrl)
+	} else {
+		e.buf.Write([]byte{ctrl, byte(typ - 7)})
+	}
+	e.buf.Write(ext)
+}
+
+func (e *testEncoder) pointer(offset int) {
+	switch {
+	case offset < 2048:
+		e.buf.Write([]byte{typePointer<<5 | byte(offset>>8), byte(offset)})
+	case offset < 526336:
+		p := offset - 2048
+		e.buf.Write([]byte{typePointer<<5 | 1<<3 | byte(p>>16), byte(p >> 8), byte(p)})
+	default:
+		p := offset - 526336
+		e.buf.Write([]byte{typePointer<<5 | 2<<3 | byte(p>>24), byte(p >> 16), byte(p >> 8), byte(p)})
+	}
+}
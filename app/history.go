@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/paskal/datadog-parser/app/history"
+)
+
+// historyCommand summarizes the alert history written with --alert_history
+type historyCommand struct {
+	From string `long:"from" description:"start of the range, like 2019-02-01 or 2019-02-01T10:00:00Z, a week before its end if not set"`
+	To   string `long:"to" description:"end of the range, exclusive, now if not set"`
+}
+
+// dateFormats are accepted formats of the range of history and query commands, dates without timezone are in --output_tz
+var dateFormats = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// runHistory prints stats of the alerts which were firing within the range, and returns the exit code
+func runHistory(w io.Writer, opts opts, now time.Time) int {
+	if opts.AlertHistoryPath == "" {
+		log.Print("Alert history file must be set with --alert_history")
+		return 2
+	}
+	loc, err := time.LoadLocation(opts.OutputTZ)
+	if err != nil {
+		log.Printf("Bad output timezone: %v", err)
+		return 2
+	}
+	to := now.In(loc).Truncate(time.Second)
+	if opts.History.To != "" {
+		if to, err = parseDate(opts.History.To, loc); err != nil {
+			log.Printf("Bad end of the range: %v", err)
+			return 2
+		}
+	}
+	from := to.Add(-7 * 24 * time.Hour)
+	if opts.History.From != "" {
+		if from, err = parseDate(opts.History.From, loc); err != nil {
+			log.Printf("Bad start of the range: %v", err)
+			return 2
+		}
+	}
+	if !from.Before(to) {
+		log.Print("Start of the range must be before its end")
+		return 2
+	}
+
+	f, err := os.Open(opts.AlertHistoryPath)
+	if err != nil {
+		log.Printf("Error opening alert history file: %v", err)
+		return 3
+	}
+	defer f.Close()
+	entries, err := history.Read(f)
+	if err != nil {
+		log.Printf("Error reading alert history file: %v", err)
+		return 3
+	}
+
+	stats := history.Summarize(entries, from, to)
+	// rules of the same description are told apart by their identifiers
+	described := map[string]int{}
+	for _, s := range stats {
+		described[s.Rule]++
+	}
+	fmt.Fprintf(w, "Alerts from %s to %s: %d fired\n", from, to, len(stats)) //nolint:errcheck
+	for _, s := range stats {
+		rule := s.Rule
+		if described[rule] > 1 {
+			rule = fmt.Sprintf("%s (%s)", rule, s.ID)
+		}
+		var open, mttr string
+		if s.Open > 0 {
+			open = fmt.Sprintf(" (%d never recovered)", s.Open)
+		}
+		if s.Count > s.Open {
+			mttr = fmt.Sprintf(", MTTR %s", s.MTTR.Truncate(time.Second))
+			if s.Unit != "" {
+				peak := "peak"
+				if s.Low {
+					peak = "lowest"
+				}
+				mttr += fmt.Sprintf(", %s %s %s", peak, strconv.FormatFloat(math.Round(s.Peak*100)/100, 'f', -1, 64), s.Unit)
+			}
+			if s.TotalUnit != "" {
+				mttr += fmt.Sprintf(", %d %s while firing", s.Total, s.TotalUnit)
+			}
+		}
+		fmt.Fprintf(w, "%s: %d times%s, RED for %s in total%s\n", rule, s.Count, open, s.TotalRed.Truncate(time.Second), mttr) //nolint:errcheck
+	}
+	return 0
+}
+
+// parseDate parses the date in one of dateFormats
+func parseDate(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range dateFormats {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q should be a date like 2019-02-01 or 2019-02-01T10:00:00Z", s)
+}
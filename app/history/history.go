@@ -0,0 +1,183 @@
+// Package history keeps the append-only log of alert state changes, and summarizes how often and for how long
+// alerts were firing over a period of time
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Entry is the alert state change in the history. RED entry is written when the alert fires, and GREEN one
+// when it recovers, with the start of the incident, the worst value the alert compared with its threshold
+// while it was firing, and hits or bytes it counted meanwhile, if the alert measures and counts them.
+type Entry struct {
+	ID        string            `json:"id,omitempty"` // stable identifier of the alert rule, like "latency:/api:p90>800ms@10m"
+	Rule      string            `json:"rule"`         // alert description, like "p90 latency of /api"
+	Labels    map[string]string `json:"labels,omitempty"`
+	State     string            `json:"state"`                // RED or GREEN
+	Start     time.Time         `json:"start"`                // time the alert fired at
+	End       *time.Time        `json:"end,omitempty"`        // time the alert recovered at, only set in GREEN entry
+	Peak      *float64          `json:"peak,omitempty"`       // worst value while firing, the highest one unless Low is set
+	Unit      string            `json:"unit,omitempty"`       // unit of the peak, like "hits/s" or "ms"
+	Low       bool              `json:"low,omitempty"`        // lower value is worse, like for the low traffic alert
+	Total     int               `json:"total,omitempty"`      // hits or bytes counted by the alert while firing
+	TotalUnit string            `json:"total_unit,omitempty"` // unit of the total, hits or bytes, not counted if empty
+}
+
+// Append writes the entry to the history as a JSON line, at once so that concurrent writers don't mix their lines.
+// Rule identifiers like "latency:/api:p90>800ms@10m" are written as is, without escaping of HTML characters.
+func Append(w io.Writer, e Entry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(e); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Read returns all entries of the history
+func Read(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if e.State != "RED" && e.State != "GREEN" {
+			return nil, fmt.Errorf("line %d: unknown state %q", line, e.State)
+		}
+		if e.State == "GREEN" && e.End == nil {
+			return nil, fmt.Errorf("line %d: GREEN entry without end", line)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Stats tells how often and for how long the alert was firing within the range
+type Stats struct {
+	ID        string        // identifier of the rule, its description for the entries written without one
+	Rule      string        // description of the rule in its latest entry
+	Count     int           // incidents overlapping the range
+	Open      int           // incidents of them which never recovered
+	TotalRed  time.Duration // time of the incidents within the range
+	MTTR      time.Duration // mean time to recovery of recovered incidents, zero if there were none
+	Peak      float64       // worst value of recovered incidents, the lowest one if Low is set
+	Unit      string        // unit of the peak, empty if the alert doesn't measure it
+	Low       bool
+	Total     int    // hits or bytes counted by recovered incidents
+	TotalUnit string // unit of the total, empty if the alert doesn't count it
+}
+
+// incident is the period of time the alert was firing
+type incident struct {
+	start, end time.Time
+	recovered  bool
+	entry      Entry // GREEN entry of the recovered incident
+}
+
+// Summarize returns stats of the rules which were firing between from and to, sorted by rule description
+// and identifier. Rules are told apart by the identifier, so that the ones of the same description are not
+// merged. Incidents which never recovered, as the program was stopped while they were firing, are counted
+// as open until the next incident of the rule or the last entry of the history, and they are not counted in MTTR.
+func Summarize(entries []Entry, from, to time.Time) []Stats {
+	var last time.Time
+	for _, e := range entries {
+		if e.Start.After(last) {
+			last = e.Start
+		}
+		if e.End != nil && e.End.After(last) {
+			last = *e.End
+		}
+	}
+
+	incidents := map[string][]incident{} // by rule identifier
+	names := map[string]string{}         // description of the rule by its identifier
+	open := map[string]time.Time{}       // start of the incident of the rule which is firing
+	for _, e := range entries {
+		id := e.ID
+		if id == "" {
+			id = e.Rule
+		}
+		names[id] = e.Rule
+		if e.State == "RED" {
+			if start, ok := open[id]; ok {
+				incidents[id] = append(incidents[id], incident{start: start, end: e.Start})
+			}
+			open[id] = e.Start
+			continue
+		}
+		delete(open, id)
+		incidents[id] = append(incidents[id], incident{start: e.Start, end: *e.End, recovered: true, entry: e})
+	}
+	for id, start := range open {
+		incidents[id] = append(incidents[id], incident{start: start, end: last})
+	}
+
+	var stats []Stats
+	for id, list := range incidents {
+		s := Stats{ID: id, Rule: names[id]}
+		var recovered int
+		var recovery time.Duration
+		for _, inc := range list {
+			if !inc.start.Before(to) || inc.end.Before(from) {
+				continue
+			}
+			start, end := inc.start, inc.end
+			if start.Before(from) {
+				start = from
+			}
+			if end.After(to) {
+				end = to
+			}
+			s.Count++
+			s.TotalRed += end.Sub(start)
+			if !inc.recovered {
+				s.Open++
+				continue
+			}
+			recovered++
+			recovery += inc.end.Sub(inc.start)
+			s.add(inc.entry)
+		}
+		if s.Count == 0 {
+			continue
+		}
+		if recovered > 0 {
+			s.MTTR = recovery / time.Duration(recovered)
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Rule != stats[j].Rule {
+			return stats[i].Rule < stats[j].Rule
+		}
+		return stats[i].ID < stats[j].ID
+	})
+	return stats
+}
+
+// add the peak and the total of the recovered incident
+func (s *Stats) add(e Entry) {
+	if e.Peak != nil {
+		if s.Unit == "" || (e.Low && *e.Peak < s.Peak) || (!e.Low && *e.Peak > s.Peak) {
+			s.Peak = *e.Peak
+		}
+		s.Unit, s.Low = e.Unit, e.Low
+	}
+	if e.TotalUnit != "" {
+		s.Total += e.Total
+		s.TotalUnit = e.TotalUnit
+	}
+}
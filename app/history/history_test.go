@@ -0,0 +1,83 @@
+package history
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendRead(t *testing.T) {
+	start, end := time.Date(2019, 2, 7, 21, 11, 0, 0, time.UTC), time.Date(2019, 2, 7, 21, 12, 1, 0, time.UTC)
+	peak, zero := 3.0, 0.0
+	entries := []Entry{
+		{ID: "hits", Rule: "hits", Labels: map[string]string{"alert": "hits"}, State: "RED", Start: start},
+		{ID: "hits", Rule: "hits", Labels: map[string]string{"alert": "hits"}, State: "GREEN", Start: start, End: &end,
+			Peak: &peak, Unit: "hits/s", Total: 180, TotalUnit: "hits"},
+		{Rule: "low hits", State: "GREEN", Start: start, End: &end, Peak: &zero, Unit: "hits/s", Low: true, TotalUnit: "hits"},
+	}
+	var buf bytes.Buffer
+	for _, e := range entries {
+		require.NoError(t, Append(&buf, e))
+	}
+	assert.Equal(t, `{"id":"hits","rule":"hits","labels":{"alert":"hits"},"state":"RED","start":"2019-02-07T21:11:00Z"}
+{"id":"hits","rule":"hits","labels":{"alert":"hits"},"state":"GREEN","start":"2019-02-07T21:11:00Z","end":"2019-02-07T21:12:01Z","peak":3,"unit":"hits/s","total":180,"total_unit":"hits"}
+{"rule":"low hits","state":"GREEN","start":"2019-02-07T21:11:00Z","end":"2019-02-07T21:12:01Z","peak":0,"unit":"hits/s","low":true,"total_unit":"hits"}
+`, buf.String())
+
+	read, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, entries, read)
+
+	_, err = Read(strings.NewReader("{\"rule\":\"hits\",\"state\":\"RED\"}\n\nnot json\n"))
+	assert.EqualError(t, err, "line 3: invalid character 'o' in literal null (expecting 'u')")
+	_, err = Read(strings.NewReader(`{"rule":"hits","state":"YELLOW"}`))
+	assert.EqualError(t, err, `line 1: unknown state "YELLOW"`)
+	_, err = Read(strings.NewReader(`{"rule":"hits","state":"GREEN"}`))
+	assert.EqualError(t, err, "line 1: GREEN entry without end")
+}
+
+func TestSummarize(t *testing.T) {
+	at := func(day, hour, minute int) time.Time { return time.Date(2019, 2, day, hour, minute, 0, 0, time.UTC) }
+	fired := func(rule string, start time.Time) Entry { return Entry{Rule: rule, State: "RED", Start: start} }
+	recovered := func(rule string, start, end time.Time, peak float64, unit string, total int) Entry {
+		e := Entry{Rule: rule, State: "GREEN", Start: start, End: &end, Peak: &peak, Unit: unit}
+		if unit == "hits/s" {
+			e.Total, e.TotalUnit = total, "hits"
+		}
+		return e
+	}
+	entries := []Entry{
+		// started before the range, only ten minutes of it are within
+		fired("hits", at(6, 23, 50)),
+		recovered("hits", at(6, 23, 50), at(7, 0, 10), 20, "hits/s", 1000),
+		fired("hits", at(7, 10, 0)),
+		recovered("hits", at(7, 10, 0), at(7, 10, 30), 30, "hits/s", 2000),
+		// the program was stopped while it was firing, and it fired again after the restart
+		fired("p90 latency of /api", at(7, 12, 0)),
+		fired("p90 latency of /api", at(7, 12, 5)),
+		recovered("p90 latency of /api", at(7, 12, 5), at(7, 12, 15), 900, "ms", 0),
+		// never recovered, lasts until the last entry of the history
+		fired("no data", at(7, 13, 0)),
+		fired("hits", at(7, 14, 0)),
+		recovered("hits", at(7, 14, 0), at(7, 14, 40), 25, "hits/s", 1500),
+		// rules of the same description are told apart by their identifiers
+		{ID: "latency:/api:p90>2s@1m", Rule: "p90 latency of /api", State: "RED", Start: at(7, 15, 0)},
+		// after the range
+		fired("low hits", at(8, 1, 0)),
+		recovered("low hits", at(8, 1, 0), at(8, 1, 5), 1, "hits/s", 10),
+	}
+	assert.Equal(t, []Stats{
+		{ID: "hits", Rule: "hits", Count: 3, TotalRed: 80 * time.Minute, MTTR: 30 * time.Minute,
+			Peak: 30, Unit: "hits/s", Total: 4500, TotalUnit: "hits"},
+		{ID: "no data", Rule: "no data", Count: 1, Open: 1, TotalRed: 11 * time.Hour},
+		{ID: "latency:/api:p90>2s@1m", Rule: "p90 latency of /api", Count: 1, Open: 1, TotalRed: 9 * time.Hour},
+		{ID: "p90 latency of /api", Rule: "p90 latency of /api", Count: 2, Open: 1, TotalRed: 15 * time.Minute, MTTR: 10 * time.Minute,
+			Peak: 900, Unit: "ms"},
+	}, Summarize(entries, at(7, 0, 0), at(8, 0, 0)))
+
+	assert.Empty(t, Summarize(entries, at(1, 0, 0), at(2, 0, 0)))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"rule":"hits","state":"RED","start":"2019-02-07T21:11:00Z"}
+{"rule":"hits","state":"GREEN","start":"2019-02-07T21:11:00Z","end":"2019-02-07T21:12:01Z","peak":3.0166,"unit":"hits/s","total":179,"total_unit":"hits"}
+{"rule":"no data","state":"RED","start":"2019-02-07T21:20:00Z"}
+{"rule":"hits","state":"RED","start":"2019-02-07T21:30:00Z"}
+{"rule":"hits","state":"GREEN","start":"2019-02-07T21:30:00Z","end":"2019-02-07T21:30:30Z","peak":5,"unit":"hits/s","total":100,"total_unit":"hits"}
+{"rule":"p50 latency of /api","state":"RED","start":"2019-02-07T21:40:00Z"}
+{"rule":"p50 latency of /api","state":"GREEN","start":"2019-02-07T21:40:00Z","end":"2019-02-07T21:41:00Z","peak":1250.5,"unit":"ms"}
+`), 0o600))
+	now := time.Date(2019, 2, 8, 10, 0, 0, 0, time.UTC)
+
+	var out strings.Builder
+	assert.Equal(t, 0, runHistory(&out, opts{AlertHistoryPath: path, OutputTZ: "UTC"}, now))
+	assert.Equal(t, `Alerts from 2019-02-01 10:00:00 +0000 UTC to 2019-02-08 10:00:00 +0000 UTC: 3 fired
+hits: 2 times, RED for 1m31s in total, MTTR 45s, peak 5 hits/s, 279 hits while firing
+no data: 1 times (1 never recovered), RED for 21m0s in total
+p50 latency of /api: 1 times, RED for 1m0s in total, MTTR 1m0s, peak 1250.5 ms
+`, out.String())
+
+	out.Reset()
+	assert.Equal(t, 0, runHistory(&out, opts{AlertHistoryPath: path, OutputTZ: "UTC",
+		History: historyCommand{From: "2019-02-07 21:25", To: "2019-02-08"}}, now))
+	assert.Equal(t, `Alerts from 2019-02-07 21:25:00 +0000 UTC to 2019-02-08 00:00:00 +0000 UTC: 3 fired
+hits: 1 times, RED for 30s in total, MTTR 30s, peak 5 hits/s, 100 hits while firing
+no data: 1 times (1 never recovered), RED for 16m0s in total
+p50 latency of /api: 1 times, RED for 1m0s in total, MTTR 1m0s, peak 1250.5 ms
+`, out.String())
+
+	assert.Equal(t, 2, runHistory(&out, opts{OutputTZ: "UTC"}, now))
+	assert.Equal(t, 2, runHistory(&out, opts{AlertHistoryPath: path, OutputTZ: "UTC", History: historyCommand{From: "yesterday"}}, now))
+	assert.Equal(t, 2, runHistory(&out, opts{AlertHistoryPath: path, OutputTZ: "UTC", History: historyCommand{From: "2019-02-09"}}, now))
+	assert.Equal(t, 3, runHistory(&out, opts{AlertHistoryPath: path + ".missing", OutputTZ: "UTC"}, now))
+}
+
+func TestRunHistorySameDescription(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"id":"latency:/api:p50>1s@2m","rule":"p50 latency of /api","state":"RED","start":"2019-02-07T21:40:00Z"}
+{"id":"latency:/api:p50>3s@10m","rule":"p50 latency of /api","state":"RED","start":"2019-02-07T21:40:30Z"}
+{"id":"latency:/api:p50>1s@2m","rule":"p50 latency of /api","state":"GREEN","start":"2019-02-07T21:40:00Z","end":"2019-02-07T21:41:00Z","peak":1250.5,"unit":"ms"}
+{"id":"latency:/api:p50>3s@10m","rule":"p50 latency of /api","state":"GREEN","start":"2019-02-07T21:40:30Z","end":"2019-02-07T21:45:30Z","peak":3100,"unit":"ms"}
+`), 0o600))
+
+	var out strings.Builder
+	assert.Equal(t, 0, runHistory(&out, opts{AlertHistoryPath: path, OutputTZ: "UTC"}, time.Date(2019, 2, 8, 10, 0, 0, 0, time.UTC)))
+	assert.Equal(t, `Alerts from 2019-02-01 10:00:00 +0000 UTC to 2019-02-08 10:00:00 +0000 UTC: 2 fired
+p50 latency of /api (latency:/api:p50>1s@2m): 1 times, RED for 1m0s in total, MTTR 1m0s, peak 1250.5 ms
+p50 latency of /api (latency:/api:p50>3s@10m): 1 times, RED for 5m0s in total, MTTR 5m0s, peak 3100 ms
+`, out.String())
+}
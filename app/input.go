@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/paskal/datadog-parser/app/geoip"
+	"github.com/paskal/datadog-parser/app/record"
+	"github.com/paskal/datadog-parser/app/useragent"
+)
+
+// input is the log along with the options of parsing its records
+type input struct {
+	reader     io.Reader
+	sections   *record.SectionRules
+	timestamps *record.TimeParser
+	labels     *record.Labels
+	geo        *geoip.DB
+	agents     *useragent.Parser
+	file       *os.File // log file, nil if stdin is used
+}
+
+// openInput opens the log file, stdin if it's not set, and loads files the records are enriched with.
+// Errors are logged, and the exit code is returned along with them.
+func openInput(opts opts, stdin io.Reader) (*input, int) {
+	in, code := loadInput(opts, stdin)
+	if code != 0 {
+		return nil, code
+	}
+	// the log is opened last, so that it's not left open if the other options are wrong
+	if opts.FilePath != "" {
+		var err error
+		if in.file, err = os.Open(opts.FilePath); err != nil {
+			log.Printf("Error opening csv file: %v", err)
+			return nil, 3
+		}
+		in.reader = in.file
+	}
+	return in, 0
+}
+
+// loadInput checks the parsing options and loads files the records are enriched with, without opening
+// the log file. Errors are logged, and the exit code is returned along with them.
+func loadInput(opts opts, stdin io.Reader) (*input, int) {
+	in := &input{reader: stdin, agents: useragent.Default}
+	var err error
+	if in.sections, err = record.NewSectionRules(opts.SectionDepth, opts.SectionNormalize, opts.SectionRules); err != nil {
+		log.Printf("Bad section options: %v", err)
+		return nil, 2
+	}
+	inputTZ, err := time.LoadLocation(opts.InputTZ)
+	if err != nil {
+		log.Printf("Bad input timezone: %v", err)
+		return nil, 2
+	}
+	if in.timestamps, err = record.NewTimeParser(opts.TimeFormat, inputTZ); err != nil {
+		log.Printf("Bad time format: %v", err)
+		return nil, 2
+	}
+
+	if opts.LabelsPath != "" {
+		f, err := os.Open(opts.LabelsPath)
+		if err != nil {
+			log.Printf("Error opening labels file: %v", err)
+			return nil, 3
+		}
+		in.labels, err = record.NewLabels(f)
+		f.Close()
+		if err != nil {
+			log.Printf("Bad labels file: %v", err)
+			return nil, 2
+		}
+	}
+
+	if len(opts.GeoIPDBs) > 0 {
+		if in.geo, err = geoip.Open(opts.GeoIPDBs...); err != nil {
+			log.Printf("Error opening GeoIP database: %v", err)
+			return nil, 3
+		}
+	}
+
+	if opts.BotPatternsPath != "" {
+		f, err := os.Open(opts.BotPatternsPath)
+		if err != nil {
+			log.Printf("Error opening bot patterns file: %v", err)
+			return nil, 3
+		}
+		in.agents, err = useragent.NewParser(f)
+		f.Close()
+		if err != nil {
+			log.Printf("Bad bot patterns file: %v", err)
+			return nil, 2
+		}
+	}
+
+	return in, 0
+}
+
+// close the log file if it was opened
+func (in *input) close() {
+	if in.file != nil {
+		in.file.Close() //nolint:errcheck
+	}
+}
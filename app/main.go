@@ -1,72 +1,105 @@
 package main
 
 import (
-	"context"
-	"encoding/csv"
 	"log"
 	"os"
-	"os/signal"
-	"syscall"
+	"reflect"
+	"strings"
 	"time"
+	_ "time/tzdata" // timezones database for the image without one
 
 	"github.com/jessevdk/go-flags"
-
-	"github.com/paskal/datadog-parser/app/record"
 )
 
+// opts are the options shared by the commands, the ones of the log processing belong to tail command
 type opts struct {
-	FilePath                string        `long:"filepath" env:"FILEPATH" default:"" description:"csv file path, stdin is used if not specified"`
-	AlertWindow             time.Duration `long:"alert_window" env:"ALERT_WINDOW" default:"2m" description:"alert windows"`
-	AlertThresholdPerSecond int           `long:"alert_threshold_per_sec" env:"ALERT_THRESHOLD_PER_SEC" default:"10" description:"threshold for alert, requests per second"`
+	FilePath         string   `long:"filepath" env:"FILEPATH" default:"" description:"csv file path, stdin is used if not specified"`
+	AlertHistoryPath string   `long:"alert_history" env:"ALERT_HISTORY" default:"" description:"file to append alert state changes to, summarized by history command"`
+	RollupsPath      string   `long:"rollups" env:"ROLLUPS" default:"" description:"directory to keep traffic rollups in, disabled if not set"`
+	RollupTiers      []string `long:"rollup_tier" env:"ROLLUP_TIERS" env-delim:"," default:"10s:1d" default:"1m:7d" default:"1h:90d" default:"1d:730d" description:"resolution:retention of rollups from the finest to the coarsest, could be repeated"`
+	LabelsPath       string   `long:"labels" env:"LABELS" default:"" description:"file with \"CIDR label\" lines to group clients by network"`
+	GeoIPDBs         []string `long:"geoip_db" env:"GEOIP_DBS" env-delim:"," description:"MaxMind DB file with countries or autonomous systems of clients, could be repeated"`
+	BotPatternsPath  string   `long:"bot_patterns" env:"BOT_PATTERNS" default:"" description:"file with user agent substrings of bots, one per line, in addition to the bundled ones"`
+	SectionDepth     int      `long:"section_depth" env:"SECTION_DEPTH" default:"1" description:"number of URL path segments in the section"`
+	SectionNormalize bool     `long:"section_normalize" env:"SECTION_NORMALIZE" description:"replace numeric IDs and UUIDs in the section with placeholders"`
+	SectionRules     []string `long:"section_rule" env:"SECTION_RULES" env-delim:"," description:"placeholder=regexp rule for section path segments, could be repeated"`
+	TimeFormat       string   `long:"time_format" env:"TIME_FORMAT" default:"auto" description:"date column format: auto, unix, unix_ms, unix_us, unix_ns, rfc3339 or strftime-style pattern"`
+	InputTZ          string   `long:"input_tz" env:"INPUT_TZ" default:"Local" description:"timezone of dates without one in the log"`
+	OutputTZ         string   `long:"output_tz" env:"OUTPUT_TZ" default:"UTC" description:"timezone of dates in the output"`
+
+	Tail     tailCommand     `command:"tail" description:"follow the log, printing reports and alerts, the default command" long-description:"Follows the log from --filepath or stdin, printing traffic reports and firing alerts till it's interrupted. It's the default command, so its options could be passed without the command name."`
+	Analyze  analyzeCommand  `command:"analyze" description:"run filters and aggregations over the whole log" long-description:"Runs the query over the whole log from --filepath or stdin and prints the result once the log ends. The query is filters and an optional aggregation separated by |, like 'status >= 500 | count, p99(latency) by section every 1m | top 10'. Without a query, the reports and alerts of tail command with its default options are printed, followed by the hits per section and status."`
+	Replay   replayCommand   `command:"replay" description:"write the log with the pauses between its records" long-description:"Writes the log from --filepath or stdin to stdout with the same pauses between the lines as between their dates, sped up with --speed, so that it could be piped to tail command. Dates are replaced with the time the lines are written with --retime."`
+	Validate validateCommand `command:"validate" description:"check the options and the log without processing it" long-description:"Checks the options of tail command, and reads the log from --filepath or stdin unless --config_only is set, printing the number of valid records, their time range and rejected lines by reason. Exits with code 1 if any line was rejected, and 2 on bad options."`
+	Generate generateCommand `command:"generate" description:"write a synthetic log" long-description:"Writes the synthetic log with the header to stdout: requests of a few clients to --path with --rate per second on average over --duration, with the share of 5xx errors set by --error-ratio and an optional traffic spike. The same --seed produces the same log."`
+	History  historyCommand  `command:"history" description:"summarize alert history over a date range"`
+	Query    queryCommand    `command:"query" description:"print traffic over a date range from the rollups"`
 }
 
 func main() {
 	var opts opts
-	if _, err := flags.Parse(&opts); err != nil {
+	parser := flags.NewParser(&opts, flags.Default)
+	if _, err := parser.ParseArgs(withDefaultCommand(parser, os.Args[1:])); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			os.Exit(0)
+		}
 		log.Printf("Unable to parse the args: %v", err)
 		os.Exit(2)
 	}
 
-	if opts.AlertWindow == 0 {
-		log.Print("Alert window must be non-zero")
-		os.Exit(2)
+	switch parser.Active.Name {
+	case "analyze":
+		os.Exit(runAnalyze(os.Stdout, opts, os.Stdin))
+	case "replay":
+		os.Exit(runReplay(os.Stdout, opts, os.Stdin))
+	case "validate":
+		os.Exit(runValidate(os.Stdout, opts, os.Stdin))
+	case "generate":
+		os.Exit(runGenerate(os.Stdout, opts, time.Now()))
+	case "history":
+		os.Exit(runHistory(os.Stdout, opts, time.Now()))
+	case "query":
+		os.Exit(runQuery(os.Stdout, opts, time.Now()))
 	}
-
-	if opts.AlertThresholdPerSecond == 0 {
-		log.Print("Alert threshold must be non-zero")
-		os.Exit(2)
+	if code := runTail(opts, os.Stdin); code != 0 {
+		os.Exit(code)
 	}
+}
 
-	var logReader *csv.Reader
-
-	// retrieve the recordReader either from file or from stdin
-	if opts.FilePath != "" {
-		f, err := os.Open(opts.FilePath)
-		if err != nil {
-			log.Printf("Error opening csv file: %v", err)
-			os.Exit(3)
+// withDefaultCommand prepends tail command to the arguments without a command, so that the log is processed
+// without one as it was before the commands were introduced. Help is printed for the whole application though.
+// Command could only be the first argument which is neither an option nor its value.
+func withDefaultCommand(parser *flags.Parser, args []string) []string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if arg == "-h" || arg == "--help" {
+			return args
+		}
+		if !strings.HasPrefix(arg, "-") {
+			if parser.Find(arg) != nil {
+				return args
+			}
+			break
+		}
+		if !strings.Contains(arg, "=") && takesValue(parser, strings.TrimLeft(arg, "-")) {
+			i++
 		}
-		defer f.Close()
-		logReader = csv.NewReader(f)
-	} else {
-		logReader = csv.NewReader(os.Stdin)
 	}
-	logReader.FieldsPerRecord = 7
-
-	// catch TERM signal and invoke graceful termination
-	// otherwise it's impossible to test main()
-	ctx, cancel := context.WithCancel(context.Background())
-	go func() {
-		stop := make(chan os.Signal, 1)
-		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-		<-stop
-		cancel()
-	}()
+	return append([]string{"tail"}, args...)
+}
 
-	logProcessor := record.Processor{
-		LogReader:               logReader,
-		AlertWindow:             opts.AlertWindow,
-		AlertThresholdPerSecond: opts.AlertThresholdPerSecond,
+// takesValue checks if the option with the long name is not a flag, so the next argument is its value
+func takesValue(parser *flags.Parser, name string) bool {
+	option := parser.FindOptionByLongName(name)
+	if option == nil {
+		for _, c := range parser.Commands() {
+			if option = c.FindOptionByLongName(name); option != nil {
+				break
+			}
+		}
 	}
-	logProcessor.Start(ctx)
+	return option != nil && option.Field().Type.Kind() != reflect.Bool && !option.OptionalArgument
 }
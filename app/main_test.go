@@ -7,7 +7,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jessevdk/go-flags"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const sampleCsvOutput = `2019-02-07 21:11:09 +0000 UTC: 81 hits from 5 users with 99752 bytes transferred, top /api with 11 hits
@@ -19,7 +21,7 @@ const sampleCsvOutput = `2019-02-07 21:11:09 +0000 UTC: 81 hits from 5 users wit
 2019-02-07 21:12:09 +0000 UTC: 171 hits from 5 users with 210066 bytes transferred, top /api and /report with 11 hits
 2019-02-07 21:12:19 +0000 UTC: 181 hits from 5 users with 222160 bytes transferred, top /api with 11 hits
 2019-02-07 21:12:29 +0000 UTC: 182 hits from 5 users with 223896 bytes transferred, top /api and /report with 11 hits
-2019-02-07 21:12:36 +0000 UTC: Alert RED, ~10.01 hits per second which is higher than 10 (1201 total) in the last 2m0s
+2019-02-07 21:12:36 +0000 UTC: Alert RED, ~10.01 hits per second which is higher than 10 (1201 total) in the last 2m0s, top clients 10.0.0.1 (409 hits), 10.0.0.2 (267 hits), 10.0.0.5 (252 hits), by bytes 10.0.0.1 (501836 bytes), 10.0.0.2 (328560 bytes), 10.0.0.5 (310142 bytes), top users apache (1201 hits)
 2019-02-07 21:12:39 +0000 UTC: 191 hits from 5 users with 235645 bytes transferred, top /api with 11 hits
 2019-02-07 21:12:49 +0000 UTC: 178 hits from 5 users with 219456 bytes transferred, top /api and /report with 11 hits
 2019-02-07 21:12:59 +0000 UTC: 190 hits from 5 users with 233590 bytes transferred, top /api and /report with 11 hits
@@ -30,7 +32,7 @@ const sampleCsvOutput = `2019-02-07 21:11:09 +0000 UTC: 81 hits from 5 users wit
 2019-02-07 21:13:49 +0000 UTC: 32 hits from 5 users with 39501 bytes transferred, top /api with 11 hits
 2019-02-07 21:13:59 +0000 UTC: 33 hits from 5 users with 40646 bytes transferred, top /api with 10 hits
 2019-02-07 21:14:04 +0000 UTC: Alert GREEN, ~9.97 hits per second which is lower than 10 (1197 total) in the last 2m0s
-2019-02-07 21:14:04 +0000 UTC: Alert RED, ~10.01 hits per second which is higher than 10 (1201 total) in the last 2m0s
+2019-02-07 21:14:04 +0000 UTC: Alert RED, ~10.01 hits per second which is higher than 10 (1201 total) in the last 2m0s, top clients 10.0.0.1 (388 hits), 10.0.0.2 (279 hits), 10.0.0.5 (278 hits), by bytes 10.0.0.1 (477508 bytes), 10.0.0.2 (343760 bytes), 10.0.0.5 (342495 bytes), top users apache (1201 hits)
 2019-02-07 21:14:05 +0000 UTC: Alert GREEN, ~9.89 hits per second which is lower than 10 (1187 total) in the last 2m0s
 2019-02-07 21:14:09 +0000 UTC: 30 hits from 5 users with 37185 bytes transferred, top /api with 10 hits
 2019-02-07 21:14:18 +0000 UTC: 32 hits from 5 users with 38818 bytes transferred, top /api and /report with 10 hits
@@ -44,7 +46,7 @@ const sampleCsvOutput = `2019-02-07 21:11:09 +0000 UTC: 81 hits from 5 users wit
 2019-02-07 21:15:39 +0000 UTC: 257 hits from 5 users with 316992 bytes transferred, top /api and /report with 11 hits
 2019-02-07 21:15:49 +0000 UTC: 287 hits from 5 users with 353279 bytes transferred, top /api with 11 hits
 2019-02-07 21:15:59 +0000 UTC: 283 hits from 5 users with 348392 bytes transferred, top /api with 11 hits
-2019-02-07 21:16:03 +0000 UTC: Alert RED, ~10.01 hits per second which is higher than 10 (1201 total) in the last 2m0s
+2019-02-07 21:16:03 +0000 UTC: Alert RED, ~10.01 hits per second which is higher than 10 (1201 total) in the last 2m0s, top clients 10.0.0.1 (411 hits), 10.0.0.2 (280 hits), 10.0.0.5 (250 hits), by bytes 10.0.0.1 (505325 bytes), 10.0.0.2 (344178 bytes), 10.0.0.5 (307023 bytes), top users apache (1201 hits)
 2019-02-07 21:16:09 +0000 UTC: 283 hits from 5 users with 347894 bytes transferred, top /api and /report with 11 hits
 2019-02-07 21:16:19 +0000 UTC: 284 hits from 5 users with 348956 bytes transferred, top /api with 11 hits
 2019-02-07 21:16:29 +0000 UTC: 280 hits from 5 users with 344621 bytes transferred, top /api with 11 hits
@@ -109,6 +111,48 @@ func TestInput(t *testing.T) {
 	}
 }
 
+func TestWithDefaultCommand(t *testing.T) {
+	o, command := parseArgs(t, "--filepath=access.csv", "--alert_window=3m")
+	assert.Equal(t, "tail", command)
+	assert.Equal(t, "access.csv", o.FilePath)
+	assert.Equal(t, 3*time.Minute, o.Tail.AlertWindow)
+
+	o, command = parseArgs(t, "--filepath", "access.csv", "analyze", "--format=csv", "count by status")
+	assert.Equal(t, "analyze", command)
+	assert.Equal(t, "access.csv", o.FilePath)
+	assert.Equal(t, "count by status", o.Analyze.Args.Query)
+
+	// the value of the option is not taken for the command
+	o, command = parseArgs(t, "--filepath", "analyze", "--section_normalize", "--alert_window", "3m")
+	assert.Equal(t, "tail", command)
+	assert.Equal(t, "analyze", o.FilePath)
+	assert.True(t, o.SectionNormalize)
+	assert.Equal(t, 3*time.Minute, o.Tail.AlertWindow)
+
+	o, command = parseArgs(t, "--section_normalize", "analyze", "replay")
+	assert.Equal(t, "analyze", command)
+	assert.Equal(t, "replay", o.Analyze.Args.Query)
+
+	o, command = parseArgs(t, "validate", "--slo=99.9%", "--config_only")
+	assert.Equal(t, "validate", command)
+	assert.Equal(t, []string{"99.9%"}, o.Validate.SLOs)
+	assert.True(t, o.Validate.ConfigOnly)
+
+	parser := flags.NewParser(&opts{}, flags.None)
+	assert.Equal(t, []string{"--help"}, withDefaultCommand(parser, []string{"--help"}))
+	assert.Equal(t, []string{"tail", "--", "replay"}, withDefaultCommand(parser, []string{"--", "replay"}))
+}
+
+// parseArgs returns the options parsed from the command line arguments the same way main does it,
+// along with the name of the command
+func parseArgs(t *testing.T, args ...string) (opts, string) {
+	var o opts
+	parser := flags.NewParser(&o, flags.None)
+	_, err := parser.ParseArgs(withDefaultCommand(parser, args))
+	require.NoError(t, err)
+	return o, parser.Active.Name
+}
+
 func testMain(t *testing.T, inputFile, expectedOutput string) {
 	// prepare stdout capture
 	rescueStdout := os.Stdout
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// writeRows writes the rows in the format, which is table, csv or json. Values could be strings, numbers,
+// bools, times in the location and durations, which are written in milliseconds in JSON. Nil is a missing value.
+func writeRows(w io.Writer, format string, header []string, rows [][]interface{}, loc *time.Location) error {
+	switch format {
+	case "csv":
+		return writeCSV(w, header, rows, loc)
+	case "json":
+		return writeJSONLines(w, header, rows, loc)
+	}
+	return writeTable(w, header, rows, loc)
+}
+
+// formatCell returns the value as a string, with times in the layout
+func formatCell(v interface{}, layout string, loc *time.Location) string {
+	switch v := v.(type) {
+	case nil:
+		return "-"
+	case time.Time:
+		return v.In(loc).Format(layout)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func formatRow(row []interface{}, layout string, loc *time.Location) []string {
+	record := make([]string, len(row))
+	for i, v := range row {
+		record[i] = formatCell(v, layout, loc)
+	}
+	return record
+}
+
+func writeTable(w io.Writer, header []string, rows [][]interface{}, loc *time.Location) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t")) //nolint:errcheck
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(formatRow(row, "2006-01-02 15:04:05", loc), "\t")) //nolint:errcheck
+	}
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, header []string, rows [][]interface{}, loc *time.Location) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := cw.Write(formatRow(row, time.RFC3339, loc)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeJSONLines writes a JSON object per row, with keys in the order of the header
+func writeJSONLines(w io.Writer, header []string, rows [][]interface{}, loc *time.Location) error {
+	for _, row := range rows {
+		var b strings.Builder
+		for i, v := range row {
+			switch t := v.(type) {
+			case time.Time:
+				v = t.In(loc).Format(time.RFC3339)
+			case time.Duration:
+				v = float64(t.Round(time.Microsecond)) / float64(time.Millisecond)
+			}
+			key, _ := json.Marshal(header[i])
+			value, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.Write(key)
+			b.WriteByte(':')
+			b.Write(value)
+		}
+		if _, err := fmt.Fprintf(w, "{%s}\n", b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteRows(t *testing.T) {
+	header := []string{"time", "section", "bot", "count", "avg(bytes)", "p99(latency)"}
+	start := time.Date(2019, 2, 7, 21, 11, 0, 0, time.UTC)
+	rows := [][]interface{}{
+		{start, "/api", false, 3, 1230.26, 812345 * time.Microsecond},
+		{start.Add(time.Minute), "/report, v2", true, 1, 100.0, nil},
+	}
+	loc := time.FixedZone("UTC+1", 3600)
+	write := func(format string) string {
+		var out strings.Builder
+		require.NoError(t, writeRows(&out, format, header, rows, loc))
+		return out.String()
+	}
+
+	assert.Equal(t, `time                 section      bot    count  avg(bytes)  p99(latency)
+2019-02-07 22:11:00  /api         false  3      1230.26     812.345ms
+2019-02-07 22:12:00  /report, v2  true   1      100         -
+`, write("table"))
+	assert.Equal(t, `time,section,bot,count,avg(bytes),p99(latency)
+2019-02-07T22:11:00+01:00,/api,false,3,1230.26,812.345ms
+2019-02-07T22:12:00+01:00,"/report, v2",true,1,100,-
+`, write("csv"))
+	assert.Equal(t, `{"time":"2019-02-07T22:11:00+01:00","section":"/api","bot":false,"count":3,"avg(bytes)":1230.26,"p99(latency)":812.345}
+{"time":"2019-02-07T22:12:00+01:00","section":"/report, v2","bot":true,"count":1,"avg(bytes)":100,"p99(latency)":null}
+`, write("json"))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paskal/datadog-parser/app/rollup"
+)
+
+// queryCommand prints traffic from the rollups written with --rollups
+type queryCommand struct {
+	From    string        `long:"from" description:"start of the range, like 2019-02-01 or 2019-02-01T10:00:00Z, a day before its end if not set"`
+	To      string        `long:"to" description:"end of the range, exclusive, now if not set"`
+	Step    time.Duration `long:"step" description:"length of the time buckets, like 5m, the whole range is one bucket if not set"`
+	GroupBy []string      `long:"group-by" description:"comma-separated dimensions to group by: section, status, status_class or label"`
+	Metrics []string      `long:"metric" default:"hits" description:"comma-separated metrics: hits, bytes or users, unique users can't be grouped or filtered"`
+	Section string        `long:"section" description:"count only requests to sections with the prefix"`
+	Status  string        `long:"status" description:"count only requests with the status class, like 5xx"`
+	Label   string        `long:"label" description:"count only requests of clients with the network label, - for clients without one"`
+	Format  string        `long:"format" default:"table" choice:"table" choice:"csv" choice:"json" description:"output format"`
+}
+
+// runQuery prints the result of the query over the rollups, and returns the exit code
+func runQuery(w io.Writer, opts opts, now time.Time) int {
+	if opts.RollupsPath == "" {
+		log.Print("Rollups directory must be set with --rollups")
+		return 2
+	}
+	tiers, err := rollup.ParseTiers(opts.RollupTiers)
+	if err != nil {
+		log.Printf("Bad rollup tiers: %v", err)
+		return 2
+	}
+	loc, err := time.LoadLocation(opts.OutputTZ)
+	if err != nil {
+		log.Printf("Bad output timezone: %v", err)
+		return 2
+	}
+	q := rollup.Query{
+		GroupBy: splitList(opts.Query.GroupBy),
+		Metrics: splitList(opts.Query.Metrics),
+		Step:    opts.Query.Step,
+		Section: opts.Query.Section,
+		Label:   opts.Query.Label,
+	}
+	if opts.Query.Status != "" {
+		class, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(opts.Query.Status), "xx"))
+		if err != nil || class < 1 || class > 5 {
+			log.Printf("Bad status class %q, should be like 5xx", opts.Query.Status)
+			return 2
+		}
+		q.StatusClass = class
+	}
+	// the range ends with the last complete rollup of the finest tier by default
+	q.To = now.In(loc).Truncate(tiers[0].Resolution)
+	if opts.Query.To != "" {
+		if q.To, err = parseDate(opts.Query.To, loc); err != nil {
+			log.Printf("Bad end of the range: %v", err)
+			return 2
+		}
+	}
+	q.From = q.To.Add(-24 * time.Hour)
+	if opts.Query.From != "" {
+		if q.From, err = parseDate(opts.Query.From, loc); err != nil {
+			log.Printf("Bad start of the range: %v", err)
+			return 2
+		}
+	}
+
+	store, err := rollup.OpenReadOnly(opts.RollupsPath, tiers)
+	if err != nil {
+		log.Printf("Error opening rollups: %v", err)
+		return 3
+	}
+	defer store.Close() //nolint:errcheck
+	rows, err := store.Query(q)
+	if err != nil {
+		log.Printf("Bad query: %v", err)
+		return 2
+	}
+
+	header := append(append([]string{"time"}, q.GroupBy...), q.Metrics...)
+	records := make([][]interface{}, len(rows))
+	for i, r := range rows {
+		record := []interface{}{r.Time}
+		for _, g := range r.Group {
+			record = append(record, g)
+		}
+		for _, v := range r.Values {
+			record = append(record, v)
+		}
+		records[i] = record
+	}
+	if err = writeRows(w, opts.Query.Format, header, records, loc); err != nil {
+		log.Printf("Error writing the result: %v", err)
+		return 3
+	}
+	return 0
+}
+
+// splitList splits comma-separated values of the repeated option
+func splitList(values []string) []string {
+	var result []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/paskal/datadog-parser/app/rollup"
+)
+
+func TestRunQuery(t *testing.T) {
+	dir := t.TempDir()
+	tierExprs := []string{"10s:1h", "1m:1d"}
+	tiers, err := rollup.ParseTiers(tierExprs)
+	require.NoError(t, err)
+	store, err := rollup.Open(dir, tiers)
+	require.NoError(t, err)
+	start := time.Date(2019, 2, 7, 21, 10, 0, 0, time.UTC)
+	for i := 0; i < 12; i++ {
+		r := rollup.New(start.Add(time.Duration(i) * 10 * time.Second))
+		r.Add(rollup.Group{Section: "/api", Status: 200}, 100, "10.0.0.1")
+		r.Add(rollup.Group{Section: "/report", Status: 500 + i%2, Label: "office"}, 1000, "10.0.0.2")
+		require.NoError(t, store.Write(r))
+	}
+	require.NoError(t, store.Close())
+	now := time.Date(2019, 2, 7, 21, 15, 3, 0, time.UTC)
+
+	run := func(q queryCommand) string {
+		var out strings.Builder
+		require.Equal(t, 0, runQuery(&out, opts{RollupsPath: dir, RollupTiers: tierExprs, OutputTZ: "UTC", Query: q}, now))
+		return out.String()
+	}
+
+	assert.Equal(t, `time                 hits
+2019-02-06 21:15:00  24
+`, run(queryCommand{Metrics: []string{"hits"}, Format: "table"}))
+
+	assert.Equal(t, `time                 section  status_class  hits  bytes
+2019-02-07 21:10:00  /api     2xx           12    1200
+2019-02-07 21:10:00  /report  5xx           12    12000
+`, run(queryCommand{From: "2019-02-07 21:10", To: "2019-02-07 21:20", GroupBy: []string{"section,status_class"},
+		Metrics: []string{"hits,bytes"}, Format: "table"}))
+
+	assert.Equal(t, `time,status,label,hits
+2019-02-07T21:10:00Z,500,office,3
+2019-02-07T21:10:00Z,501,office,3
+2019-02-07T21:11:00Z,500,office,3
+2019-02-07T21:11:00Z,501,office,3
+`, run(queryCommand{From: "2019-02-07 21:10", To: "2019-02-07 21:20", Step: time.Minute,
+		GroupBy: []string{"status", "label"}, Metrics: []string{"hits"}, Section: "/rep", Status: "5xx", Label: "office", Format: "csv"}))
+
+	assert.Equal(t, `{"time":"2019-02-07T21:00:00Z","hits":24,"users":2}
+`, run(queryCommand{From: "2019-02-07T21:00:00Z", To: "2019-02-07T22:00:00Z", Metrics: []string{"hits", "users"}, Format: "json"}))
+
+	var out strings.Builder
+	assert.Equal(t, 2, runQuery(&out, opts{RollupTiers: tierExprs, OutputTZ: "UTC"}, now))
+	assert.Equal(t, 2, runQuery(&out, opts{RollupsPath: dir, RollupTiers: tierExprs, OutputTZ: "UTC",
+		Query: queryCommand{Metrics: []string{"hits"}, Status: "9xx"}}, now))
+	assert.Equal(t, 2, runQuery(&out, opts{RollupsPath: dir, RollupTiers: tierExprs, OutputTZ: "UTC",
+		Query: queryCommand{Metrics: []string{"users"}, GroupBy: []string{"section"}}}, now))
+	assert.Equal(t, 3, runQuery(&out, opts{RollupsPath: filepath.Join(dir, "missing"), RollupTiers: tierExprs, OutputTZ: "UTC",
+		Query: queryCommand{Metrics: []string{"hits"}}}, now))
+	assert.Empty(t, out.String())
+}
@@ -0,0 +1,252 @@
+package record
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/paskal/datadog-parser/app/sketch"
+)
+
+// alertRule is checked after every record and by the wall clock while no records arrive, printing the change of its state
+type alertRule interface {
+	check(l *Processor, currentTime time.Time)
+}
+
+// rateAlert fires when the rate of hits or bytes counted by the alert in the window is higher than the threshold
+type rateAlert struct {
+	name      string              // name in JSON output
+	unit      string              // unit of the rate, hits or bytes
+	threshold int                 // per second
+	total     func(w *window) int // total of the window counted by the alert
+	rate      float64             // rate on the last check
+	firing    bool
+}
+
+func (a *rateAlert) measure() measurement {
+	return measurement{value: a.rate, unit: a.unit + "/s", totalUnit: a.unit}
+}
+
+// alertRules returns alerts enabled for the processor
+func (l *Processor) alertRules() []alertRule {
+	// learned baseline replaces the static threshold of hits
+	alerts := []alertRule{&anomalyAlert{baseline: l.Anomaly}}
+	if l.Anomaly == nil {
+		alerts[0] = &rateAlert{
+			name:      "hits",
+			unit:      "hits",
+			threshold: l.AlertThresholdPerSecond,
+			total:     func(w *window) int { return w.alertHits },
+		}
+	}
+	if l.AlertBandwidthPerSecond > 0 {
+		alerts = append(alerts, &rateAlert{
+			name:      "bandwidth",
+			unit:      "bytes",
+			threshold: l.AlertBandwidthPerSecond,
+			total:     func(w *window) int { return w.alertBytes },
+		})
+	}
+	for _, a := range l.latency {
+		alerts = append(alerts, a)
+	}
+	for _, t := range l.slos {
+		alerts = append(alerts, burnRateAlerts(t)...)
+	}
+	for _, a := range l.lowTraffic {
+		alerts = append(alerts, a)
+	}
+	if l.NoDataAlert > 0 {
+		alerts = append(alerts, &noDataAlert{after: l.NoDataAlert})
+	}
+	return alerts
+}
+
+// check recalculates the alert state, printing the change of it
+func (a *rateAlert) check(l *Processor, currentTime time.Time) {
+	total := a.total(l.history)
+	rate := float64(total) / l.AlertWindow.Seconds()
+	a.rate = rate
+
+	if rate > float64(a.threshold) {
+		if a.firing {
+			return
+		}
+		a.firing = true
+		top := alertBreakdowns(l.history.total())
+		l.notify(alertEvent{rule: a, id: ruleID(a.name, "", ""), name: a.name, time: currentTime, state: "RED", labels: alertLabels(a.name, ""),
+			text: fmt.Sprintf("Alert RED, ~%.2f %s per second which is higher than %d (%d total) in the last %s, %s",
+				rate, a.unit, a.threshold, total, l.AlertWindow, formatBreakdowns(top)),
+			json: &jsonAlert{PerSecond: rate, Threshold: a.threshold, Total: total, Window: l.AlertWindow.String(),
+				TopClients: jsonCounts(top[0].top), TopClientsByBytes: jsonCounts(top[1].top), TopUsers: jsonCounts(top[2].top)},
+		})
+		return
+	}
+
+	if a.firing {
+		a.firing = false
+		l.notify(alertEvent{rule: a, id: ruleID(a.name, "", ""), name: a.name, time: currentTime, state: "GREEN", labels: alertLabels(a.name, ""),
+			text: fmt.Sprintf("Alert GREEN, ~%.2f %s per second which is lower than %d (%d total) in the last %s",
+				rate, a.unit, a.threshold, total, l.AlertWindow),
+			json: &jsonAlert{PerSecond: rate, Threshold: a.threshold, Total: total, Window: l.AlertWindow.String()},
+		})
+	}
+}
+
+// alertBreakdowns returns top clients by hits and bytes and top users among the records counted by the alert
+func alertBreakdowns(stats historyRecord) []breakdown {
+	return []breakdown{
+		{name: "clients", title: "top clients", unit: "hits", top: stats.alertClients.top(alertTopClients)},
+		{name: "clients_by_bytes", title: "by bytes", unit: "bytes", top: stats.alertClientBytes.top(alertTopClients)},
+		{name: "users", title: "top users", unit: "hits", top: stats.alertAuthUsers.top(alertTopClients)},
+	}
+}
+
+// LatencyRule fires the alert when the percentile of request durations of the section
+// in the window is higher than the threshold
+type LatencyRule struct {
+	Section    string  // all records are counted if empty
+	Percentile float64 // between 0 and 100
+	Threshold  time.Duration
+	Window     time.Duration // the alert window is used if not set
+}
+
+var latencyRuleRe = regexp.MustCompile(`^(?:(.+):)?p(\d+(?:\.\d+)?)>([^@]+)(?:@(.+))?$`)
+
+// NewLatencyRules creates latency alert rules from "[section:]pNN>duration[@window]" expressions,
+// like "/api:p99>800ms" or "/api:p99>800ms@10m"
+func NewLatencyRules(exprs []string) ([]LatencyRule, error) {
+	rules := make([]LatencyRule, 0, len(exprs))
+	for _, expr := range exprs {
+		m := latencyRuleRe.FindStringSubmatch(expr)
+		if m == nil {
+			return nil, fmt.Errorf("latency alert %q should be in [section:]pNN>duration[@window] format, like /api:p99>800ms@10m", expr)
+		}
+		percentile, err := strconv.ParseFloat(m[2], 64)
+		if err != nil || percentile <= 0 || percentile >= 100 {
+			return nil, fmt.Errorf("latency alert %q should have percentile between 0 and 100", expr)
+		}
+		threshold, err := time.ParseDuration(m[3])
+		if err != nil || threshold <= 0 {
+			return nil, fmt.Errorf("latency alert %q should have positive duration threshold", expr)
+		}
+		var window time.Duration
+		if m[4] != "" {
+			if window, err = time.ParseDuration(m[4]); err != nil || window <= 0 {
+				return nil, fmt.Errorf("latency alert %q should have positive window", expr)
+			}
+		}
+		rules = append(rules, LatencyRule{Section: m[1], Percentile: percentile, Threshold: threshold, Window: window})
+	}
+	return rules, nil
+}
+
+// name returns the rule description like "p99 latency of /api"
+func (r LatencyRule) name() string {
+	if r.Section == "" {
+		return fmt.Sprintf("p%g latency", r.Percentile)
+	}
+	return fmt.Sprintf("p%g latency of %s", r.Percentile, r.Section)
+}
+
+// latencyAlert fires when the percentile of request durations counted by the alert in the rule window is higher
+// than the threshold. Durations are kept in a ring of sketches per bucket of the window, the same way the alert
+// window keeps its history. Merging them is expensive, so the alert is checked once per bucket rather than
+// on every record.
+type latencyAlert struct {
+	LatencyRule
+	resolution time.Duration
+	buckets    []*sketch.DDSketch // durations per bucket in microseconds, bucket with the key k is at index k mod len(buckets)
+	head       int64              // key of the newest bucket
+	started    bool               // false until the first record or check
+	checked    int64              // key of the newest bucket when the alert was checked
+	latencies  *sketch.DDSketch   // durations in the window, in microseconds
+	latency    time.Duration      // percentile on the last check
+	firing     bool
+}
+
+func newLatencyAlert(rule LatencyRule, resolution time.Duration) *latencyAlert {
+	a := &latencyAlert{LatencyRule: rule, resolution: resolution, checked: math.MinInt64,
+		buckets: make([]*sketch.DDSketch, int64(rule.Window/resolution)+1), latencies: newDistributionSketch()}
+	for i := range a.buckets {
+		a.buckets[i] = newDistributionSketch()
+	}
+	return a
+}
+
+func (a *latencyAlert) measure() measurement {
+	return measurement{value: jsonMilliseconds(a.latency), unit: "ms"}
+}
+
+// add the duration of the record if it's counted by the alert and belongs to the section of the rule
+func (a *latencyAlert) add(r *record) {
+	if !r.timed || r.filtered || (a.Section != "" && a.Section != r.section) {
+		return
+	}
+	key := bucketKey(r.date, a.resolution)
+	a.advance(key)
+	if key <= a.head-int64(len(a.buckets)) {
+		return
+	}
+	a.buckets[floorMod(key, int64(len(a.buckets)))].Add(float64(r.latency) / float64(time.Microsecond))
+}
+
+// advance moves the head to the key, resetting buckets which fall out of the window
+func (a *latencyAlert) advance(key int64) {
+	if a.started && key <= a.head {
+		return
+	}
+	n := int64(len(a.buckets))
+	from := a.head + 1
+	if !a.started || key-a.head > n {
+		from = key - n + 1
+	}
+	for k := from; k <= key; k++ {
+		a.buckets[floorMod(k, n)].Reset()
+	}
+	a.head, a.started = key, true
+}
+
+// check recalculates the alert state once the window moves, printing the change of it
+func (a *latencyAlert) check(l *Processor, currentTime time.Time) {
+	a.advance(bucketKey(currentTime, a.resolution))
+	if a.checked == a.head {
+		return
+	}
+	a.checked = a.head
+	a.latencies.Reset()
+	for _, sk := range a.buckets {
+		a.latencies.Merge(sk)
+	}
+	latency := microseconds(a.latencies.Quantile(a.Percentile / 100))
+	a.latency = latency
+
+	if latency > a.Threshold {
+		if a.firing {
+			return
+		}
+		a.firing = true
+		l.printLatencyAlert(a, "RED", latency, currentTime)
+		return
+	}
+	if a.firing {
+		a.firing = false
+		l.printLatencyAlert(a, "GREEN", latency, currentTime)
+	}
+}
+
+func (l *Processor) printLatencyAlert(a *latencyAlert, state string, latency time.Duration, currentTime time.Time) {
+	comparison := "higher"
+	if state == "GREEN" {
+		comparison = "lower"
+	}
+	l.notify(alertEvent{rule: a, id: ruleID("latency", a.Section, fmt.Sprintf("p%g>%s@%s", a.Percentile, a.Threshold, a.Window)),
+		name: a.name(), time: currentTime, state: state, labels: alertLabels("latency", a.Section),
+		text: fmt.Sprintf("Alert %s, %s ~%s is %s than %s in the last %s",
+			state, a.name(), formatLatency(latency), comparison, a.Threshold, a.Window),
+		json: &jsonLatencyAlert{Section: a.Section, Percentile: a.Percentile, Latency: jsonMilliseconds(latency),
+			Threshold: jsonMilliseconds(a.Threshold), Window: a.Window.String()},
+	})
+}
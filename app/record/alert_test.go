@@ -0,0 +1,99 @@
+package record
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLatencyRules(t *testing.T) {
+	rules, err := NewLatencyRules([]string{"/api:p99>800ms", "p99.9>2s@10m"})
+	require.NoError(t, err)
+	assert.Equal(t, []LatencyRule{{Section: "/api", Percentile: 99, Threshold: 800 * time.Millisecond},
+		{Percentile: 99.9, Threshold: 2 * time.Second, Window: 10 * time.Minute}}, rules)
+	assert.Equal(t, "p99 latency of /api", rules[0].name())
+	assert.Equal(t, "p99.9 latency", rules[1].name())
+
+	_, err = NewLatencyRules([]string{"/api>800ms"})
+	assert.EqualError(t, err, `latency alert "/api>800ms" should be in [section:]pNN>duration[@window] format, like /api:p99>800ms@10m`)
+	_, err = NewLatencyRules([]string{"p100>1s"})
+	assert.EqualError(t, err, `latency alert "p100>1s" should have percentile between 0 and 100`)
+	_, err = NewLatencyRules([]string{"p99>800"})
+	assert.EqualError(t, err, `latency alert "p99>800" should have positive duration threshold`)
+	_, err = NewLatencyRules([]string{"p99>800ms@0s"})
+	assert.EqualError(t, err, `latency alert "p99>800ms@0s" should have positive window`)
+}
+
+func TestLatencyAlert(t *testing.T) {
+	log := `remotehost,rfc931,authuser,date,request,status,bytes,latency_ms
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234,120
+"10.0.0.2","-","apache",1549573861,"GET /api/user HTTP/1.0",200,1234,950
+"10.0.0.3","-","apache",1549573861,"GET /report HTTP/1.0",200,1234,3000
+"10.0.0.2","-","apache",1549573862,"GET /api/user HTTP/1.0",200,1234,900
+"10.0.0.2","-","apache",1549573863,"GET /api/user HTTP/1.0",200,1234,-
+"10.0.0.2","-","apache",1549573990,"GET /api/user HTTP/1.0",200,1234,100
+"10.0.0.2","-","apache",1549573991,"GET /api/user HTTP/1.0",200,1234,100
+`
+	rules, err := NewLatencyRules([]string{"/api:p99>800ms"})
+	require.NoError(t, err)
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Minute * 2,
+		AlertThresholdPerSecond: 10,
+		LatencySections:         1,
+		LatencyAlerts:           rules,
+	}
+	// p99 of 120ms and 950ms at 21:11:01 is the lower one, so the alert fires only with the third value
+	assert.Equal(t, "2019-02-07 21:11:02 +0000 UTC: Alert RED, p99 latency of /api ~900.2ms is higher than 800ms in the last 2m0s\n"+
+		"2019-02-07 21:11:03 +0000 UTC: 5 hits from 2 users with 6170 bytes transferred, top /api with 4 hits\n"+
+		"2019-02-07 21:11:03 +0000 UTC: latencies all p50=900.2ms p90=955.9ms p99=955.9ms max=3s, /api p50=900.2ms p90=900.2ms p99=900.2ms max=950ms\n"+
+		"2019-02-07 21:13:10 +0000 UTC: Alert GREEN, p99 latency of /api ~100ms is lower than 800ms in the last 2m0s\n",
+		runProcessor(&logProcessor))
+}
+
+func TestLatencyAlertJSON(t *testing.T) {
+	log := `remotehost,rfc931,authuser,date,request,status,bytes,duration_us
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234,2500000
+"10.0.0.2","-","apache",1549573861,"GET /api/user HTTP/1.0",200,1234,100
+`
+	rules, err := NewLatencyRules([]string{"p50>1s"})
+	require.NoError(t, err)
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Second,
+		AlertThresholdPerSecond: 10,
+		LatencyAlerts:           rules,
+		JSON:                    true,
+	}
+	assert.Equal(t, `{"time":"2019-02-07T21:11:00Z","type":"alert","alert":"latency","state":"RED","percentile":50,"latency_ms":2500,"threshold_ms":1000,"window":"1s"}
+{"time":"2019-02-07T21:11:01Z","type":"alert","alert":"latency","state":"GREEN","percentile":50,"latency_ms":0.1,"threshold_ms":1000,"window":"1s"}
+`, runProcessor(&logProcessor))
+}
+
+func TestLatencyAlertWindow(t *testing.T) {
+	log := `remotehost,rfc931,authuser,date,request,status,bytes,latency_ms
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234,2000
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234,2000
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234,2000
+"10.0.0.2","-","apache",1549573861,"GET /api/user HTTP/1.0",200,1234,10
+"10.0.0.2","-","apache",1549573862,"GET /api/user HTTP/1.0",200,1234,10
+"10.0.0.2","-","apache",1549573990,"GET /api/user HTTP/1.0",200,1234,10
+`
+	rules, err := NewLatencyRules([]string{"p50>1s@2m"})
+	require.NoError(t, err)
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Second,
+		AlertThresholdPerSecond: 10,
+		LatencyAlerts:           rules,
+	}
+	// slow requests are kept for the rule window rather than the alert window
+	assert.Equal(t, "2019-02-07 21:11:00 +0000 UTC: Alert RED, p50 latency ~2s is higher than 1s in the last 2m0s\n"+
+		"2019-02-07 21:11:02 +0000 UTC: 2 hits from 1 users with 2468 bytes transferred, top /api with 2 hits\n"+
+		"2019-02-07 21:11:02 +0000 UTC: latencies all p50=10ms p90=10ms p99=10ms max=10ms\n"+
+		"2019-02-07 21:13:10 +0000 UTC: Alert GREEN, p50 latency ~10ms is lower than 1s in the last 2m0s\n",
+		runProcessor(&logProcessor))
+}
@@ -0,0 +1,294 @@
+package record
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paskal/datadog-parser/app/geoip"
+	"github.com/paskal/datadog-parser/app/sketch"
+	"github.com/paskal/datadog-parser/app/useragent"
+)
+
+// Analyzer runs the query over the whole log at once, unlike Processor which follows the log as it's written
+type Analyzer struct {
+	LogReader  io.Reader
+	Query      *Query
+	Sections   *SectionRules     // first path segment is used as the section if not set
+	Timestamps *TimeParser       // timestamps format is detected automatically if not set
+	Labels     *Labels           // client networks labels, label field is empty if not set
+	GeoIP      *geoip.DB         // country and ASN database, country and asn fields are empty if not set
+	Agents     *useragent.Parser // user agents parser, bundled bot patterns are used if not set
+}
+
+// Result of the query, with rows sorted by time and by the first aggregation in descending order
+type Result struct {
+	Columns []string
+	// Rows have values of the columns: time.Time of the bucket, fields as string, int, bool or time.Duration,
+	// and aggregations as int, float64 or time.Duration, nil if there were no values to aggregate
+	Rows     [][]interface{}
+	Records  int            // valid records in the log
+	Rejected int            // lines which are not valid records
+	Reasons  map[string]int // rejected lines per reason
+	From, To time.Time      // dates of the first and the last valid records, zero if there are none
+}
+
+// group is the aggregated state of the records with the same fields in the same time bucket
+type group struct {
+	bucket time.Time
+	key    string // fields joined for sorting
+	fields []interface{}
+	funcs  []funcState
+}
+
+// funcState is the state of the aggregation function
+type funcState struct {
+	n        int // number of aggregated values
+	sum      float64
+	min, max float64
+	sketch   *sketch.DDSketch // percentile only
+}
+
+// Run reads the log till the end and returns the result of the query
+func (a *Analyzer) Run() (*Result, error) {
+	opts := parseOpts{sections: a.Sections, times: a.Timestamps, labels: a.Labels, agents: a.Agents}
+	if opts.sections == nil {
+		opts.sections = defaultSectionRules
+	}
+	if opts.times == nil {
+		opts.times = defaultTimeParser
+	}
+	if opts.agents == nil {
+		opts.agents = useragent.Default
+	}
+	if a.GeoIP != nil {
+		opts.geo = a.GeoIP
+	}
+	p := newLineParser(opts)
+	reader := csv.NewReader(a.LogReader)
+	reader.FieldsPerRecord = -1
+
+	result := &Result{Columns: a.Query.columns()}
+	groups := map[string]*group{}
+	var rejected rejectStats
+	for {
+		raw, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rejected.add(reasonCSV)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// lines after the header are parsed with its layout
+		if len(raw) > 0 && raw[0] == "remotehost" {
+			p.opts.columns = newColumns(raw)
+			continue
+		}
+		r, reason := parseRecord(raw, p.opts)
+		if reason != reasonNone {
+			rejected.add(reason)
+			continue
+		}
+		p.enrich(r)
+		result.Records++
+		if result.From.IsZero() || r.date.Before(result.From) {
+			result.From = r.date
+		}
+		if r.date.After(result.To) {
+			result.To = r.date
+		}
+		if a.Query.match(r) {
+			a.Query.agg.add(groups, r)
+		}
+	}
+
+	result.Rejected, result.Reasons = rejected.malformed(), rejected.byReason()
+
+	// the total is shown even if no records matched it
+	if len(groups) == 0 && len(a.Query.agg.groupBy) == 0 && a.Query.agg.every == 0 {
+		groups[""] = a.Query.agg.newGroup(time.Time{}, "", nil)
+	}
+	sorted := make([]*group, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	first := a.Query.agg.funcs[0]
+	sort.Slice(sorted, func(i, j int) bool {
+		gi, gj := sorted[i], sorted[j]
+		if !gi.bucket.Equal(gj.bucket) {
+			return gi.bucket.Before(gj.bucket)
+		}
+		vi, vj := first.sortValue(gi.funcs[0]), first.sortValue(gj.funcs[0])
+		if vi != vj {
+			return vi > vj
+		}
+		return gi.key < gj.key
+	})
+	inBucket := 0
+	for i, g := range sorted {
+		if i > 0 && !g.bucket.Equal(sorted[i-1].bucket) {
+			inBucket = 0
+		}
+		inBucket++
+		if a.Query.top > 0 && inBucket > a.Query.top {
+			continue
+		}
+		result.Rows = append(result.Rows, a.Query.agg.row(g))
+	}
+	return result, nil
+}
+
+// add the record to its group, creating it if needed
+func (agg queryAggregation) add(groups map[string]*group, r *record) {
+	var bucket time.Time
+	if agg.every > 0 {
+		bucket = r.date.Truncate(agg.every)
+	}
+	var key strings.Builder
+	key.WriteString(strconv.FormatInt(bucket.Unix(), 10))
+	fields := make([]interface{}, len(agg.groupBy))
+	for i, f := range agg.groupBy {
+		v, ok := f.get(r)
+		fields[i] = fieldValue(f.kind, v, ok)
+		key.WriteByte(0)
+		key.WriteString(fieldString(fields[i]))
+	}
+	g, ok := groups[key.String()]
+	if !ok {
+		g = agg.newGroup(bucket, key.String(), fields)
+		groups[g.key] = g
+	}
+	for i, f := range agg.funcs {
+		s := &g.funcs[i]
+		if f.name == "count" {
+			s.n++
+			continue
+		}
+		v, ok := f.field.get(r)
+		if !ok {
+			continue
+		}
+		if s.n == 0 || v.n < s.min {
+			s.min = v.n
+		}
+		if s.n == 0 || v.n > s.max {
+			s.max = v.n
+		}
+		s.n++
+		s.sum += v.n
+		if s.sketch != nil {
+			s.sketch.Add(v.n)
+		}
+	}
+}
+
+func (agg queryAggregation) newGroup(bucket time.Time, key string, fields []interface{}) *group {
+	g := &group{bucket: bucket, key: key, fields: fields, funcs: make([]funcState, len(agg.funcs))}
+	for i, f := range agg.funcs {
+		if f.name == "percentile" {
+			g.funcs[i].sketch = newDistributionSketch()
+		}
+	}
+	return g
+}
+
+// row returns values of the result columns for the group
+func (agg queryAggregation) row(g *group) []interface{} {
+	var row []interface{}
+	if agg.every > 0 {
+		row = append(row, g.bucket)
+	}
+	row = append(row, g.fields...)
+	for i, f := range agg.funcs {
+		row = append(row, f.result(g.funcs[i]))
+	}
+	return row
+}
+
+// result returns the value of the function: count as int, numbers as int except for the average,
+// and durations rounded to three significant digits or so
+func (f queryFunc) result(s funcState) interface{} {
+	if f.name == "count" {
+		return s.n
+	}
+	if s.n == 0 {
+		return nil
+	}
+	v := f.value(s)
+	if f.field.kind == kindDuration {
+		return roundLatency(microseconds(v))
+	}
+	if f.name == "avg" {
+		return math.Round(v*100) / 100
+	}
+	return int(math.Round(v))
+}
+
+// value returns the value of the function in units of the field
+func (f queryFunc) value(s funcState) float64 {
+	switch f.name {
+	case "sum":
+		return s.sum
+	case "avg":
+		return s.sum / float64(s.n)
+	case "min":
+		return s.min
+	case "max":
+		return s.max
+	case "percentile":
+		return s.sketch.Quantile(f.quantile)
+	}
+	return float64(s.n)
+}
+
+// sortValue returns the value of the function to sort rows by, groups without values go last
+func (f queryFunc) sortValue(s funcState) float64 {
+	if f.name != "count" && s.n == 0 {
+		return math.Inf(-1)
+	}
+	return f.value(s)
+}
+
+// fieldValue returns the value of the field in the result, "-" if the record doesn't have it or it's empty
+func fieldValue(kind queryKind, v queryValue, ok bool) interface{} {
+	if !ok {
+		return "-"
+	}
+	switch kind {
+	case kindNumber:
+		return int(v.n)
+	case kindDuration:
+		return microseconds(v.n)
+	case kindBool:
+		return v.n == 1
+	}
+	if v.s == "" {
+		return "-"
+	}
+	return v.s
+}
+
+// fieldString returns the value of the field as a string for the group key, numbers and durations
+// are zero-padded so that the groups are sorted by their value
+func fieldString(v interface{}) string {
+	switch v := v.(type) {
+	case int:
+		return fmt.Sprintf("%020d", v)
+	case time.Duration:
+		return fmt.Sprintf("%020d", v)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return v.(string)
+}
@@ -0,0 +1,67 @@
+package record
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzer(t *testing.T) {
+	log := `"remotehost","rfc931","authuser","date","request","status","bytes","latency_ms"
+"10.0.0.1","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1000,100
+"10.0.0.2","-","apache",1549573861,"GET /api/user HTTP/1.0",503,200,900
+"10.0.0.2","-","apache",1549573862,"POST /api/user HTTP/1.0",500,300,-
+"10.0.0.3","-","apache",1549573920,"GET /report HTTP/1.0",200,5000,300
+"10.0.0.2","-","apache",1549573921,"GET /api/help HTTP/1.0",502,100,700
+not a record
+"10.0.0.1","-","apache",1549573922,"GET /report HTTP/1.0",200,3000,200
+`
+	run := func(query string) *Result {
+		q, err := ParseQuery(query)
+		require.NoError(t, err, query)
+		result, err := (&Analyzer{LogReader: strings.NewReader(log), Query: q}).Run()
+		require.NoError(t, err, query)
+		assert.Equal(t, 6, result.Records)
+		assert.Equal(t, 1, result.Rejected)
+		return result
+	}
+
+	result := run(`status >= 500 and section == "/api" | count by remotehost | top 10`)
+	assert.Equal(t, []string{"remotehost", "count"}, result.Columns)
+	assert.Equal(t, [][]interface{}{{"10.0.0.2", 3}}, result.Rows)
+	assert.Equal(t, map[string]int{"wrong number of fields": 1}, result.Reasons)
+	assert.Equal(t, int64(1549573860), result.From.Unix())
+	assert.Equal(t, int64(1549573922), result.To.Unix())
+
+	assert.Equal(t, [][]interface{}{
+		{"/api", 4, 1600, 400.0, 900 * time.Millisecond, 100 * time.Millisecond},
+		{"/report", 2, 8000, 4000.0, 300 * time.Millisecond, 200 * time.Millisecond},
+	}, run(`count, sum(bytes), avg(bytes), max(latency), min(latency) by section`).Rows)
+
+	// untimed record is counted, but not in the latency median, and records are bucketed by the minute
+	start := time.Unix(1549573860, 0)
+	assert.Equal(t, [][]interface{}{
+		{start, "/api", 3, 100 * time.Millisecond},
+		{start.Add(time.Minute), "/report", 2, 200900 * time.Microsecond},
+		{start.Add(time.Minute), "/api", 1, 700 * time.Millisecond},
+	}, run(`count, p50(latency) by section every 1m`).Rows)
+
+	// top rows of every bucket
+	assert.Equal(t, [][]interface{}{
+		{start, 500, 300},
+		{start.Add(time.Minute), 200, 5000},
+	}, run(`status != 200 or section == "/report" | max(bytes) by status every 1m | top 1`).Rows)
+
+	// groups without values are sorted last
+	assert.Equal(t, [][]interface{}{
+		{503, false, 900 * time.Millisecond},
+		{502, false, 700 * time.Millisecond},
+		{500, false, nil},
+	}, run(`status >= 500 | avg(latency) by status, bot`).Rows)
+
+	assert.Equal(t, [][]interface{}{{0}}, run(`section == "/missing"`).Rows)
+	assert.Equal(t, [][]interface{}{{6}}, run(``).Rows)
+}
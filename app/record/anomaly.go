@@ -0,0 +1,126 @@
+package record
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/paskal/datadog-parser/app/anomaly"
+)
+
+// anomalyInterval is how often the rate of hits is fed to the baseline
+const anomalyInterval = time.Minute
+
+// BaselineSaver persists the learned baseline
+type BaselineSaver func(b anomaly.Baseline) error
+
+// anomalyAlert fires when the rate of hits counted by the alert in the window deviates from the one expected
+// by the learned baseline by more than AnomalySigma standard deviations. Rate is fed to the baseline once per
+// anomalyInterval, and minutes without records are fed as zero rate once the window is empty. Fed rate is clipped
+// to AnomalySigma standard deviations from the expected one, so a spike doesn't make the baseline expect spikes,
+// while the lasting change of traffic still becomes the new normal after a while.
+type anomalyAlert struct {
+	baseline anomaly.Baseline
+	observed int64     // key of the last interval fed to the baseline
+	first    time.Time // time of the first check, the window is not full until AlertWindow after it
+	started  bool      // false until the first check
+	saved    time.Time // wall clock time the baseline was saved at
+	sigmas   float64   // absolute deviation from the expected rate on the last check, in standard deviations
+	firing   bool
+}
+
+func (a *anomalyAlert) check(l *Processor, currentTime time.Time) {
+	rate := float64(l.history.alertHits) / l.AlertWindow.Seconds()
+	interval := floorDiv(currentTime.Unix(), int64(anomalyInterval/time.Second))
+	if !a.started {
+		a.observed, a.first, a.started = interval, currentTime, true
+	}
+	if interval > a.observed {
+		a.observe(l, interval, rate)
+		a.save(l)
+	}
+
+	mean, stdDev, ok := a.expected(l, currentTime)
+	if !ok || currentTime.Sub(a.first) < l.AlertWindow {
+		return
+	}
+	deviation := (rate - mean) / stdDev
+	a.sigmas = math.Abs(deviation)
+
+	if math.Abs(deviation) > l.AnomalySigma {
+		if !a.firing {
+			a.firing = true
+			l.printAnomalyAlert(a, "RED", rate, mean, stdDev, deviation, currentTime)
+		}
+		return
+	}
+	if a.firing {
+		a.firing = false
+		l.printAnomalyAlert(a, "GREEN", rate, mean, stdDev, deviation, currentTime)
+	}
+}
+
+func (a *anomalyAlert) measure() measurement {
+	return measurement{value: a.sigmas, unit: "sigma", totalUnit: "hits"}
+}
+
+// observe feeds the baseline with intervals since the last observed one up to the provided one, excluding it
+func (a *anomalyAlert) observe(l *Processor, interval int64, rate float64) {
+	from := a.observed
+	// there is no need to go over the same seasonal slots more than once a day
+	if maxIntervals := int64(24 * time.Hour / anomalyInterval); interval-from > maxIntervals {
+		from = interval - maxIntervals
+	}
+	windowIntervals := int64(l.AlertWindow / anomalyInterval)
+	for i := from; i < interval; i++ {
+		r := rate
+		if interval-i > windowIntervals+1 {
+			r = 0 // the interval is older than the window, which means there were no records in it
+		}
+		t := time.Unix(i*int64(anomalyInterval/time.Second), 0)
+		if mean, stdDev, ok := a.expected(l, t); ok {
+			r = math.Max(mean-l.AnomalySigma*stdDev, math.Min(mean+l.AnomalySigma*stdDev, r))
+		}
+		a.baseline.Observe(t, r)
+	}
+	a.observed = interval
+}
+
+// save the baseline if it wasn't saved for anomalyInterval, so that not much is lost if the process is killed
+func (a *anomalyAlert) save(l *Processor) {
+	if l.AnomalySave == nil || l.now().Sub(a.saved) < anomalyInterval {
+		return
+	}
+	if err := l.AnomalySave(a.baseline); err != nil {
+		log.Printf("Error saving anomaly baseline: %v", err)
+		return
+	}
+	a.saved = l.now()
+}
+
+// expected returns the expected rate at time t and its standard deviation, which is never lower
+// than sqrt(mean/window) the rate of a random stream of hits deviates by
+func (a *anomalyAlert) expected(l *Processor, t time.Time) (mean, stdDev float64, ok bool) {
+	mean, stdDev, ok = a.baseline.Expected(t)
+	window := l.AlertWindow.Seconds()
+	return mean, math.Max(stdDev, math.Sqrt(math.Max(mean, 1/window)/window)), ok
+}
+
+func (l *Processor) printAnomalyAlert(a *anomalyAlert, state string, rate, mean, stdDev, deviation float64, currentTime time.Time) {
+	text := fmt.Sprintf("Alert GREEN, ~%.2f hits per second is within %g standard deviations of expected ~%.2f (±%.2f) in the last %s",
+		rate, l.AnomalySigma, mean, stdDev, l.AlertWindow)
+	if state == "RED" {
+		direction := "above"
+		if deviation < 0 {
+			direction = "below"
+		}
+		text = fmt.Sprintf("Alert RED, ~%.2f hits per second is %.1f standard deviations %s expected ~%.2f (±%.2f) in the last %s",
+			rate, math.Abs(deviation), direction, mean, stdDev, l.AlertWindow)
+	}
+	l.notify(alertEvent{rule: a, id: ruleID("anomaly", "", ""), name: "hits anomaly", time: currentTime, state: state,
+		labels: alertLabels("anomaly", ""), text: text,
+		json: &jsonAnomalyAlert{PerSecond: rate, Expected: mean, StdDev: stdDev, Deviation: deviation, Sigma: l.AnomalySigma,
+			Window: l.AlertWindow.String()},
+	})
+}
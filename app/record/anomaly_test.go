@@ -0,0 +1,106 @@
+package record
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/paskal/datadog-parser/app/anomaly"
+)
+
+func TestAnomalyAlert(t *testing.T) {
+	// two hits per second for 15 minutes, then 20 per second for half a minute, then two again
+	var log strings.Builder
+	start := int64(1549573860)
+	for second := int64(0); second < 20*60; second++ {
+		hits := 2
+		if second >= 15*60 && second < 15*60+30 {
+			hits = 20
+		}
+		for i := 0; i < hits; i++ {
+			fmt.Fprintf(&log, "\"10.0.0.2\",\"-\",\"apache\",%d,\"GET /api/user HTTP/1.0\",200,1234\n", start+second)
+		}
+	}
+	logProcessor := Processor{
+		LogReader:        strings.NewReader(log.String()),
+		AlertWindow:      time.Minute,
+		Anomaly:          anomaly.NewEWMA(10),
+		AnomalySigma:     3,
+		BucketResolution: time.Second,
+	}
+	alerts := alertLines(runProcessor(&logProcessor))
+	// rate fed to the baseline during the spike is clipped, so the alert recovers once the spike leaves the window
+	assert.Equal(t, []string{
+		"2019-02-07 21:26:01 +0000 UTC: Alert RED, ~2.57 hits per second is 3.0 standard deviations above expected ~2.02 (±0.18) in the last 1m0s",
+		"2019-02-07 21:27:29 +0000 UTC: Alert GREEN, ~2.32 hits per second is within 3 standard deviations of expected ~2.05 (±0.18) in the last 1m0s",
+	}, alerts)
+}
+
+func TestAnomalyAlertJSON(t *testing.T) {
+	// a hit per second matching the loaded baseline for two minutes, then a burst of hits
+	var log strings.Builder
+	for second := int64(0); second <= 120; second++ {
+		hits := 1
+		if second == 120 {
+			hits = 120
+		}
+		for i := 0; i < hits; i++ {
+			fmt.Fprintf(&log, "\"10.0.0.2\",\"-\",\"apache\",%d,\"GET /api/user HTTP/1.0\",200,1234\n", 1549573860+second)
+		}
+	}
+	logProcessor := Processor{
+		LogReader:    strings.NewReader(log.String()),
+		AlertWindow:  time.Minute,
+		Anomaly:      &anomaly.EWMA{Alpha: 0.1, WarmUp: 10, Mean: 1, Count: 10},
+		AnomalySigma: 3,
+		JSON:         true,
+	}
+	alerts := alertLines(runProcessor(&logProcessor))
+	// alert fires as soon as enough hits of the burst are counted
+	assert.Equal(t, []string{`{"time":"2019-02-07T21:13:00Z","type":"alert","alert":"anomaly","state":"RED","per_second":1.4,` +
+		`"expected":1.0031666666666668,"std_dev":0.12930369076110879,"deviation":3.0690023695185227,"sigma":3,"window":"1m0s"}`}, alerts)
+}
+
+func TestAnomalySave(t *testing.T) {
+	// a hit per second for five minutes
+	var log strings.Builder
+	for second := int64(0); second < 5*60; second++ {
+		fmt.Fprintf(&log, "\"10.0.0.2\",\"-\",\"apache\",%d,\"GET /api/user HTTP/1.0\",200,1234\n", 1549573860+second)
+	}
+	var saved []int
+	save := func(b anomaly.Baseline) error {
+		saved = append(saved, b.(*anomaly.EWMA).Count)
+		return nil
+	}
+	wallClock := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	logProcessor := Processor{
+		LogReader:    strings.NewReader(log.String()),
+		AlertWindow:  time.Minute,
+		Anomaly:      anomaly.NewEWMA(10),
+		AnomalySigma: 3,
+		AnomalySave:  save,
+		now:          func() time.Time { return wallClock },
+	}
+	runProcessor(&logProcessor)
+	// log is read faster than the wall clock goes, so the baseline is saved once after the first minute is fed
+	assert.Equal(t, []int{1}, saved)
+
+	// and after every minute fed if the wall clock goes faster
+	saved = nil
+	logProcessor = Processor{
+		LogReader:    strings.NewReader(log.String()),
+		AlertWindow:  time.Minute,
+		Anomaly:      anomaly.NewEWMA(10),
+		AnomalySigma: 3,
+		AnomalySave:  save,
+		now: func() time.Time {
+			wallClock = wallClock.Add(anomalyInterval)
+			return wallClock
+		},
+	}
+	runProcessor(&logProcessor)
+	assert.Equal(t, []int{1, 2, 3, 4}, saved)
+}
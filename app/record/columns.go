@@ -0,0 +1,75 @@
+package record
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+// maxColumns limits the number of columns in the log
+const maxColumns = 32
+
+// columns is the layout of the log lines described by the header. First fieldsCount columns
+// are always the same, and optional ones after them are recognised by their names.
+type columns struct {
+	count       int           // number of columns in every line
+	userAgent   int           // position of the user agent column, zero if there is none
+	latency     int           // position of the request duration column, zero if there is none
+	latencyUnit time.Duration // unit of the request duration, milliseconds or microseconds
+}
+
+// defaultColumns is the layout of the log without the header
+var defaultColumns = &columns{count: fieldsCount}
+
+// names of the optional columns, compared case-insensitively
+var userAgentColumns = map[string]bool{"useragent": true, "user_agent": true, "user-agent": true, "http_user_agent": true, "agent": true}
+
+// latencyColumns are names of the request duration column without the unit suffix
+var latencyColumns = map[string]bool{"latency": true, "duration": true, "response_time": true, "elapsed": true, "time_taken": true}
+
+// latencySuffixes are unit suffixes of the request duration column, milliseconds are used if there is none
+var latencySuffixes = []struct {
+	suffix string
+	unit   time.Duration
+}{
+	{"_ms", time.Millisecond}, {"_msec", time.Millisecond}, {"_millis", time.Millisecond},
+	{"_us", time.Microsecond}, {"_µs", time.Microsecond}, {"_usec", time.Microsecond}, {"_micros", time.Microsecond},
+}
+
+// isHeader returns true if the line is the header, which starts with the remotehost column
+func isHeader(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("remotehost,")) || bytes.HasPrefix(line, []byte(`"remotehost",`))
+}
+
+// newColumns returns the layout described by the header fields, unknown columns are skipped
+func newColumns(header []string) *columns {
+	if len(header) <= fieldsCount || len(header) > maxColumns {
+		return defaultColumns
+	}
+	c := &columns{count: len(header)}
+	for i := fieldsCount; i < len(header); i++ {
+		name := strings.ToLower(strings.TrimSpace(header[i]))
+		if userAgentColumns[name] {
+			c.userAgent = i
+		}
+		if unit := latencyUnit(name); unit > 0 {
+			c.latency, c.latencyUnit = i, unit
+		}
+	}
+	return c
+}
+
+// latencyUnit returns the unit of the request duration column with the lowercase name, zero if it's not the one
+func latencyUnit(name string) time.Duration {
+	unit := time.Millisecond
+	for _, s := range latencySuffixes {
+		if strings.HasSuffix(name, s.suffix) {
+			name, unit = strings.TrimSuffix(name, s.suffix), s.unit
+			break
+		}
+	}
+	if !latencyColumns[name] {
+		return 0
+	}
+	return unit
+}
@@ -0,0 +1,98 @@
+package record
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/paskal/datadog-parser/app/useragent"
+)
+
+func TestNewColumns(t *testing.T) {
+	base := []string{"remotehost", "rfc931", "authuser", "date", "request", "status", "bytes"}
+	assert.Equal(t, defaultColumns, newColumns(base))
+	assert.Equal(t, defaultColumns, newColumns(base[:3]))
+	assert.Equal(t, &columns{count: 8, userAgent: 7}, newColumns(append(base[:7:7], "useragent")))
+	assert.Equal(t, &columns{count: 9, userAgent: 8}, newColumns(append(base[:7:7], "referer", " User-Agent")))
+	assert.Equal(t, &columns{count: 8}, newColumns(append(base[:7:7], "referer")))
+	assert.Equal(t, defaultColumns, newColumns(make([]string, maxColumns+1)))
+	assert.Equal(t, &columns{count: 9, userAgent: 7, latency: 8, latencyUnit: time.Microsecond},
+		newColumns(append(base[:7:7], "user_agent", "duration_us")))
+}
+
+func TestLatencyUnit(t *testing.T) {
+	assert.Equal(t, time.Millisecond, latencyUnit("latency"))
+	assert.Equal(t, time.Millisecond, latencyUnit("response_time_ms"))
+	assert.Equal(t, time.Microsecond, latencyUnit("duration_µs"))
+	assert.Equal(t, time.Microsecond, latencyUnit("time_taken_micros"))
+	assert.Equal(t, time.Duration(0), latencyUnit("request_time"), "nginx logs it in seconds")
+	assert.Equal(t, time.Duration(0), latencyUnit("referer_ms"))
+}
+
+func TestIsHeader(t *testing.T) {
+	assert.True(t, isHeader([]byte(`"remotehost","rfc931","authuser","date","request","status","bytes"`+"\n")))
+	assert.True(t, isHeader([]byte("remotehost,rfc931,authuser,date,request,status,bytes\n")))
+	assert.False(t, isHeader([]byte(`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234`+"\n")))
+	assert.False(t, isHeader([]byte("remotehost\n")))
+}
+
+func TestParserUserAgent(t *testing.T) {
+	opts := defaultParseOpts
+	opts.agents = useragent.Default
+	opts.columns = &columns{count: 9, userAgent: 8}
+	p := newLineParser(opts)
+	var r record
+	line := `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234,"-","Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"` + "\n"
+	require.Equal(t, reasonNone, p.parse([]byte(line), &r))
+	assert.Equal(t, useragent.Agent{Browser: "Googlebot", OS: useragent.Other, Device: useragent.Bot, Bot: true}, r.agent)
+
+	// slow path gets the same result
+	slow, reason := parseRecord([]string{"10.0.0.2", "-", "apache", "1549573860", "GET /api/user HTTP/1.0", "200", "1234", "-",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"}, opts)
+	require.Equal(t, reasonNone, reason)
+	assert.Equal(t, r.userAgent, slow.userAgent)
+
+	assert.Equal(t, reasonFields, p.parse([]byte(`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234`+"\n"), &r))
+	assert.Equal(t, reasonHeader, p.parse([]byte(`"remotehost","rfc931"`+"\n"), &r))
+}
+
+func TestParserLatency(t *testing.T) {
+	opts := defaultParseOpts
+	opts.columns = &columns{count: 8, latency: 7, latencyUnit: time.Millisecond}
+	p := newLineParser(opts)
+	prefix := `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234,`
+	tbl := []struct {
+		value   string
+		latency time.Duration
+		timed   bool
+		reason  rejectReason
+	}{
+		{"812", 812 * time.Millisecond, true, reasonNone},
+		{"0.25", 250 * time.Microsecond, true, reasonNone},
+		{`"12.5"`, 12500 * time.Microsecond, true, reasonNone},
+		{"-", 0, false, reasonNone},
+		{"", 0, false, reasonNone},
+		{"-5", 0, false, reasonLatency},
+		{"1.2.3", 0, false, reasonLatency},
+		{"fast", 0, false, reasonLatency},
+		{"99999999999999999", 0, false, reasonLatency},
+	}
+	for _, tt := range tbl {
+		var r record
+		require.Equal(t, tt.reason, p.parse([]byte(prefix+tt.value+"\n"), &r), tt.value)
+		if tt.reason != reasonNone {
+			continue
+		}
+		assert.Equal(t, tt.latency, r.latency, tt.value)
+		assert.Equal(t, tt.timed, r.timed, tt.value)
+
+		// slow path gets the same result
+		slow, reason := parseRecord([]string{"10.0.0.2", "-", "apache", "1549573860", "GET /api/user HTTP/1.0", "200", "1234",
+			strings.Trim(tt.value, `"`)}, opts)
+		require.Equal(t, reasonNone, reason, tt.value)
+		assert.Equal(t, tt.latency, slow.latency, tt.value)
+	}
+}
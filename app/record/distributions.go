@@ -0,0 +1,159 @@
+package record
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/paskal/datadog-parser/app/sketch"
+)
+
+// distributionMaxBins limits memory of a single distribution sketch, with 1% accuracy it covers
+// sizes up to 10^17 bytes and durations up to thousands of years in microseconds
+const distributionMaxBins = 2048
+
+// reportQuantiles are quantiles of distributions shown in the report
+var reportQuantiles = []struct {
+	name string
+	q    float64
+}{{"p50", 0.5}, {"p90", 0.9}, {"p99", 0.99}}
+
+// distributions are distributions of values like response sizes of all records and per section
+type distributions struct {
+	all      *sketch.DDSketch // nil if values are not collected
+	sections map[string]*sketch.DDSketch
+	limit    int // maximum number of sections, values of the rest are only counted in all, unlimited if zero
+}
+
+// newDistributions creates distributions, which are not collected if enabled is false
+func newDistributions(enabled bool, limit int) distributions {
+	if !enabled {
+		return distributions{}
+	}
+	return distributions{all: newDistributionSketch(), sections: map[string]*sketch.DDSketch{}, limit: limit}
+}
+
+func newDistributionSketch() *sketch.DDSketch {
+	return sketch.NewDDSketch(sketch.DefaultAccuracy, distributionMaxBins)
+}
+
+func (d *distributions) add(section string, value float64) {
+	if d.all == nil {
+		return
+	}
+	d.all.Add(value)
+	if sk := d.section(section); sk != nil {
+		sk.Add(value)
+	}
+}
+
+// section returns sketch of the section, creating it if there is room for it
+func (d *distributions) section(name string) *sketch.DDSketch {
+	sk, ok := d.sections[name]
+	if !ok && (d.limit == 0 || len(d.sections) < d.limit) {
+		sk = newDistributionSketch()
+		d.sections[name] = sk
+	}
+	return sk
+}
+
+func (d *distributions) merge(other distributions) {
+	if d.all == nil {
+		return
+	}
+	d.all.Merge(other.all)
+	for name, o := range other.sections {
+		if sk := d.section(name); sk != nil {
+			sk.Merge(o)
+		}
+	}
+}
+
+func (d *distributions) reset() {
+	if d.all == nil {
+		return
+	}
+	d.all.Reset()
+	for name := range d.sections {
+		delete(d.sections, name)
+	}
+}
+
+// summary is the distribution of values of the section
+type summary struct {
+	section   string // empty for all records
+	quantiles []float64
+	max       float64
+}
+
+// summary returns distribution of all records followed by the provided sections,
+// sections without collected values are skipped
+func (d *distributions) summary(sections []hitCount) []summary {
+	if d.all == nil || d.all.Count() == 0 {
+		return nil
+	}
+	result := []summary{summarize("", d.all)}
+	for _, c := range sections {
+		if sk, ok := d.sections[c.key]; ok && sk.Count() > 0 {
+			result = append(result, summarize(c.key, sk))
+		}
+	}
+	return result
+}
+
+func summarize(section string, sk *sketch.DDSketch) summary {
+	s := summary{section: section, quantiles: make([]float64, len(reportQuantiles)), max: sk.Max()}
+	for i, q := range reportQuantiles {
+		s.quantiles[i] = sk.Quantile(q.q)
+	}
+	return s
+}
+
+// formatSizes returns response sizes for the text output, like "all p50=100 p90=200 p99=300 max=400 bytes, /api p50=..."
+func formatSizes(summaries []summary) string {
+	return formatSummaries(summaries, func(v float64) string { return fmt.Sprintf("%.0f", v) }, " bytes")
+}
+
+// formatLatencies returns latencies in microseconds for the text output, like "all p50=1.2ms p90=20ms p99=300ms max=1.5s, /api p50=..."
+func formatLatencies(summaries []summary) string {
+	return formatSummaries(summaries, func(v float64) string { return formatLatency(microseconds(v)) }, "")
+}
+
+func formatSummaries(summaries []summary, format func(v float64) string, unit string) string {
+	parts := make([]string, len(summaries))
+	for i, s := range summaries {
+		var b strings.Builder
+		if s.section == "" {
+			b.WriteString("all")
+		} else {
+			b.WriteString(s.section)
+		}
+		for j, q := range reportQuantiles {
+			fmt.Fprintf(&b, " %s=%s", q.name, format(s.quantiles[j]))
+		}
+		fmt.Fprintf(&b, " max=%s%s", format(s.max), unit)
+		parts[i] = b.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// microseconds converts the value of latency distribution to the duration
+func microseconds(v float64) time.Duration {
+	return time.Duration(v * float64(time.Microsecond))
+}
+
+// formatLatency rounds the duration to three significant digits or so, like 812.3ms or 1.23s
+func formatLatency(d time.Duration) string {
+	return roundLatency(d).String()
+}
+
+// roundLatency rounds the duration to three significant digits or so
+func roundLatency(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(100 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
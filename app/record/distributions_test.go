@@ -0,0 +1,58 @@
+package record
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDistributions(t *testing.T) {
+	disabled := newDistributions(false, 0)
+	disabled.add("/api", 100)
+	disabled.merge(newDistributions(false, 0))
+	disabled.reset()
+	assert.Nil(t, disabled.summary([]hitCount{{key: "/api", count: 1}}), "sizes are not collected")
+
+	s := newDistributions(true, 2)
+	for _, size := range []int{0, 100, 100, 100} {
+		s.add("/api", float64(size))
+	}
+	s.add("/report", 5000)
+	s.add("/static", 20) // over the limit of sections, only counted in all
+
+	other := newDistributions(true, 2)
+	other.add("/report", 5000)
+	other.add("/user", 7)
+	s.merge(other)
+	assert.Len(t, s.sections, 2)
+
+	// estimates are within 1% of the true value, clamped to the exact minimum and maximum
+	summaries := s.summary([]hitCount{{key: "/api", count: 4}, {key: "/report", count: 2}, {key: "/static", count: 1}})
+	require.Len(t, summaries, 3)
+	assert.InDelta(t, 100, summaries[1].quantiles[0], 1)
+	assert.Equal(t, "all p50=100 p90=4965 p99=4965 max=5000 bytes, /api p50=100 p90=100 p99=100 max=100 bytes, "+
+		"/report p50=5000 p90=5000 p99=5000 max=5000 bytes", formatSizes(summaries))
+	assert.Equal(t, []jsonDistribution{{P50: 100, P90: 4965, P99: 4965, Max: 5000}, {Section: "/api", P50: 100, P90: 100, P99: 100, Max: 100},
+		{Section: "/report", P50: 5000, P90: 5000, P99: 5000, Max: 5000}}, jsonSizes(summaries))
+
+	s.reset()
+	assert.Nil(t, s.summary(nil))
+	assert.Empty(t, s.sections)
+}
+
+func TestFormatLatencies(t *testing.T) {
+	d := newDistributions(true, 0)
+	for _, us := range []float64{450, 1234, 812345, 1530000} {
+		d.add("/api", us)
+	}
+	summaries := d.summary([]hitCount{{key: "/api", count: 4}})
+	assert.Equal(t, "all p50=1.2ms p90=814.6ms p99=814.6ms max=1.53s, /api p50=1.2ms p90=814.6ms p99=814.6ms max=1.53s",
+		formatLatencies(summaries))
+	assert.Equal(t, []jsonDistribution{{P50: 1.224, P90: 814.56, P99: 814.56, Max: 1530},
+		{Section: "/api", P50: 1.224, P90: 814.56, P99: 814.56, Max: 1530}}, jsonLatencies(summaries))
+
+	assert.Equal(t, "450µs", formatLatency(450*time.Microsecond))
+	assert.Equal(t, "1.23s", formatLatency(1234567*time.Microsecond))
+}
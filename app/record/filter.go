@@ -0,0 +1,93 @@
+package record
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// filterDimensions are record properties alert filter could be applied to
+var filterDimensions = map[string]func(r *record) string{
+	"label":   func(r *record) string { return r.label },
+	"country": func(r *record) string { return r.country },
+	"asn":     func(r *record) string { return r.asn },
+	"bot":     func(r *record) string { return strconv.FormatBool(r.agent.Bot) },
+	"browser": func(r *record) string { return r.agent.Browser },
+	"os":      func(r *record) string { return r.agent.OS },
+	"device":  func(r *record) string { return r.agent.Device },
+}
+
+// AlertFilter selects records which are counted by the alert. Record matches it if for every
+// dimension it has one of the values required for that dimension, and none of the excluded ones.
+type AlertFilter struct {
+	conditions []condition
+}
+
+// condition is a set of allowed or excluded values of a single dimension
+type condition struct {
+	dimension string
+	value     func(r *record) string
+	values    map[string]bool
+	exclude   bool
+}
+
+// NewAlertFilter creates filter from "dimension=value" and "dimension!=value" expressions,
+// nil filter matching every record is returned if there are no expressions
+func NewAlertFilter(exprs []string) (*AlertFilter, error) {
+	if len(exprs) == 0 {
+		return nil, nil
+	}
+	f := &AlertFilter{}
+	for _, expr := range exprs {
+		eq := strings.Index(expr, "=")
+		if eq < 1 || eq == len(expr)-1 {
+			return nil, fmt.Errorf("alert filter %q should be in dimension=value or dimension!=value format", expr)
+		}
+		dimension, value, exclude := expr[:eq], expr[eq+1:], false
+		if strings.HasSuffix(dimension, "!") {
+			dimension, exclude = dimension[:len(dimension)-1], true
+		}
+		getter, ok := filterDimensions[dimension]
+		if !ok {
+			return nil, fmt.Errorf("alert filter %q has unknown dimension %q, supported: %s",
+				expr, dimension, strings.Join(filterDimensionNames(), ", "))
+		}
+		f.condition(dimension, getter, exclude).values[value] = true
+	}
+	return f, nil
+}
+
+// condition returns the condition for dimension, adding it if it doesn't exist yet
+func (f *AlertFilter) condition(dimension string, value func(r *record) string, exclude bool) *condition {
+	for i := range f.conditions {
+		if c := &f.conditions[i]; c.dimension == dimension && c.exclude == exclude {
+			return c
+		}
+	}
+	f.conditions = append(f.conditions, condition{dimension: dimension, value: value, values: map[string]bool{}, exclude: exclude})
+	return &f.conditions[len(f.conditions)-1]
+}
+
+// match returns true if the record should be counted by the alert, nil filter matches every record
+func (f *AlertFilter) match(r *record) bool {
+	if f == nil {
+		return true
+	}
+	for _, c := range f.conditions {
+		if c.values[c.value(r)] == c.exclude {
+			return false
+		}
+	}
+	return true
+}
+
+// filterDimensionNames returns names of supported dimensions in alphabetical order
+func filterDimensionNames() []string {
+	names := make([]string, 0, len(filterDimensions))
+	for name := range filterDimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
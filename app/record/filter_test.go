@@ -0,0 +1,42 @@
+package record
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertFilter(t *testing.T) {
+	var testData = []struct {
+		description string
+		exprs       []string
+		label       string
+		match       bool
+	}{
+		{description: "no filter", label: "office", match: true},
+		{description: "equal", exprs: []string{"label=office"}, label: "office", match: true},
+		{description: "not equal", exprs: []string{"label=office"}, label: "k8s-pods", match: false},
+		{description: "one of", exprs: []string{"label=office", "label=k8s-pods"}, label: "k8s-pods", match: true},
+		{description: "excluded", exprs: []string{"label!=office"}, label: "office", match: false},
+		{description: "not excluded", exprs: []string{"label!=office"}, label: "k8s-pods", match: true},
+		{description: "both", exprs: []string{"label=office", "label!=office"}, label: "office", match: false},
+	}
+	for _, x := range testData {
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			f, err := NewAlertFilter(x.exprs)
+			require.NoError(t, err)
+			assert.Equal(t, x.match, f.match(&record{label: x.label}))
+		})
+	}
+}
+
+func TestNewAlertFilterErrors(t *testing.T) {
+	_, err := NewAlertFilter([]string{"office"})
+	assert.EqualError(t, err, `alert filter "office" should be in dimension=value or dimension!=value format`)
+	_, err = NewAlertFilter([]string{"label="})
+	assert.EqualError(t, err, `alert filter "label=" should be in dimension=value or dimension!=value format`)
+	_, err = NewAlertFilter([]string{"host=office"})
+	assert.EqualError(t, err, `alert filter "host=office" has unknown dimension "host", supported: asn, bot, browser, country, device, label, os`)
+}
@@ -0,0 +1,123 @@
+package record
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paskal/datadog-parser/app/sketch"
+)
+
+// hitCounter counts hits per key, like section or client
+type hitCounter interface {
+	add(key string, weight int)
+	merge(other hitCounter)
+	// top returns up to n keys with most hits sorted by count, all of them if n is not positive
+	top(n int) []hitCount
+	reset()
+}
+
+// hitCount is the number of hits of the key, the true count is between count-err and count
+type hitCount struct {
+	key   string
+	count int
+	err   int
+}
+
+// newHitCounter creates exact counter if capacity is zero, and heavy hitters one
+// keeping at most capacity keys otherwise
+func newHitCounter(capacity int) hitCounter {
+	if capacity == 0 {
+		return exactHits{}
+	}
+	return heavyHitters{sketch.NewTopK(capacity)}
+}
+
+// exactHits keeps every key in the map
+type exactHits map[string]int
+
+func (e exactHits) add(key string, weight int) { e[key] += weight }
+
+func (e exactHits) merge(other hitCounter) {
+	for k, v := range other.(exactHits) {
+		e[k] += v
+	}
+}
+
+func (e exactHits) top(n int) []hitCount {
+	counts := make([]hitCount, 0, len(e))
+	for k, v := range e {
+		counts = append(counts, hitCount{key: k, count: v})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].count != counts[j].count {
+			return counts[i].count > counts[j].count
+		}
+		return counts[i].key < counts[j].key
+	})
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+func (e exactHits) reset() {
+	for k := range e {
+		delete(e, k)
+	}
+}
+
+// heavyHitters keeps only the most frequent keys in bounded memory
+type heavyHitters struct {
+	topK *sketch.TopK
+}
+
+func (h heavyHitters) add(key string, weight int) {
+	if weight > 0 {
+		h.topK.Add(key, uint64(weight))
+	}
+}
+
+func (h heavyHitters) merge(other hitCounter) { h.topK.Merge(other.(heavyHitters).topK) }
+
+func (h heavyHitters) top(n int) []hitCount {
+	top := h.topK.Top(n)
+	counts := make([]hitCount, len(top))
+	for i, c := range top {
+		counts[i] = hitCount{key: c.Key, count: int(c.Count), err: int(c.Err)}
+	}
+	return counts
+}
+
+func (h heavyHitters) reset() { h.topK.Reset() }
+
+// leaders returns keys with the highest count, sorted
+func leaders(counts []hitCount) []hitCount {
+	for i := range counts {
+		if counts[i].count != counts[0].count {
+			return counts[:i]
+		}
+	}
+	return counts
+}
+
+// formatCount returns the count with the unit, or the range of possible values if it's approximate
+func formatCount(c hitCount, unit string) string {
+	if c.err == 0 {
+		return strconv.Itoa(c.count) + " " + unit
+	}
+	return fmt.Sprintf("%d-%d %s", c.count-c.err, c.count, unit)
+}
+
+// formatTop returns keys with their counts, like "10.0.0.1 (12 hits), 10.0.0.2 (9 hits)", or "none"
+func formatTop(counts []hitCount, unit string) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	top := make([]string, len(counts))
+	for i, c := range counts {
+		top[i] = c.key + " (" + formatCount(c, unit) + ")"
+	}
+	return strings.Join(top, ", ")
+}
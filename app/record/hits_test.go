@@ -0,0 +1,46 @@
+package record
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHitCounter(t *testing.T) {
+	for _, capacity := range []int{0, 10} {
+		counter := newHitCounter(capacity)
+		counter.add("/api", 2)
+		counter.add("/report", 1)
+		other := newHitCounter(capacity)
+		other.add("/report", 1)
+		other.add("/user", 1)
+		counter.merge(other)
+		assert.Equal(t, []hitCount{{key: "/api", count: 2}, {key: "/report", count: 2}, {key: "/user", count: 1}},
+			counter.top(0), "capacity %d", capacity)
+		assert.Equal(t, []hitCount{{key: "/api", count: 2}}, counter.top(1), "capacity %d", capacity)
+		assert.Equal(t, []hitCount{{key: "/api", count: 2}, {key: "/report", count: 2}},
+			leaders(counter.top(0)), "capacity %d", capacity)
+		counter.reset()
+		assert.Empty(t, counter.top(0), "capacity %d", capacity)
+		assert.Empty(t, leaders(counter.top(0)), "capacity %d", capacity)
+	}
+}
+
+func TestHeavyHittersCapacity(t *testing.T) {
+	counter := newHitCounter(2)
+	counter.add("/api", 5)
+	counter.add("/report", 3)
+	counter.add("/user", 1)
+	top := counter.top(0)
+	assert.Len(t, top, 2)
+	assert.Equal(t, hitCount{key: "/api", count: 5}, top[0])
+	assert.Equal(t, hitCount{key: "/user", count: 4, err: 3}, top[1])
+}
+
+func TestFormatCount(t *testing.T) {
+	assert.Equal(t, "12 hits", formatCount(hitCount{key: "/api", count: 12}, "hits"))
+	assert.Equal(t, "9-12 hits", formatCount(hitCount{key: "/api", count: 12, err: 3}, "hits"))
+	assert.Equal(t, "none", formatTop(nil, "hits"))
+	assert.Equal(t, "10.0.0.1 (1200 bytes), 10.0.0.2 (100-300 bytes)",
+		formatTop([]hitCount{{key: "10.0.0.1", count: 1200}, {key: "10.0.0.2", count: 300, err: 200}}, "bytes"))
+}
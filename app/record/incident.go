@@ -0,0 +1,93 @@
+package record
+
+import (
+	"log"
+	"time"
+
+	"github.com/paskal/datadog-parser/app/history"
+)
+
+// measurement is the value the alert rule compared with its threshold on the last check
+type measurement struct {
+	value     float64
+	unit      string // unit of the value, like "hits/s" or "ms"
+	low       bool   // lower value is worse, like for the low traffic alert
+	totalUnit string // records counted while the alert is firing, "hits" or "bytes", nothing is counted if empty
+}
+
+// measuredRule is the alert rule which tells how bad the breach is, so that the worst measurement while
+// the alert is firing is written to the alert history
+type measuredRule interface {
+	measure() measurement
+}
+
+// incident keeps the worst measurement of the alert and counts its records while it's firing, to be written
+// to the alert history when it recovers
+type incident struct {
+	start    time.Time
+	section  string      // records of the section are counted, all of them if empty
+	peak     measurement // worst measurement while firing
+	measured bool        // false if the rule doesn't tell its measurement
+	total    int         // hits or bytes while firing, in peak.totalUnit
+}
+
+// add the record if it's counted by the alert and belongs to the section of the incident
+func (i *incident) add(r *record) {
+	if i.peak.totalUnit == "" || r.filtered || (i.section != "" && i.section != r.section) {
+		return
+	}
+	if i.peak.totalUnit == "bytes" {
+		i.total += r.bytes
+		return
+	}
+	i.total++
+}
+
+// measure keeps the measurement if it's worse than the peak
+func (i *incident) measure(m measurement) {
+	if !i.measured || (m.low && m.value < i.peak.value) || (!m.low && m.value > i.peak.value) {
+		i.peak, i.measured = m, true
+	}
+}
+
+// measureIncidents updates the peaks of firing alerts after they are checked
+func (l *Processor) measureIncidents() {
+	for rule, i := range l.incidents {
+		if m, ok := rule.(measuredRule); ok {
+			i.measure(m.measure())
+		}
+	}
+}
+
+// recordHistory appends the alert state change to the alert history. Alert recovery is written along with
+// the start of the incident, the worst measurement of the alert and the records it counted while firing,
+// and the one which wasn't seen firing is skipped.
+func (l *Processor) recordHistory(e alertEvent) {
+	now := e.time.In(l.OutputTZ)
+	entry := history.Entry{ID: e.id, Rule: e.name, Labels: e.labels, State: e.state, Start: now}
+	if e.state == "RED" {
+		if l.incidents == nil {
+			l.incidents = map[alertRule]*incident{}
+		}
+		i := &incident{start: now, section: e.labels["section"]}
+		if m, ok := e.rule.(measuredRule); ok {
+			i.measure(m.measure())
+		}
+		l.incidents[e.rule] = i
+	} else {
+		i, ok := l.incidents[e.rule]
+		if !ok {
+			return
+		}
+		delete(l.incidents, e.rule)
+		entry.Start, entry.End = i.start, &now
+		if i.measured {
+			peak := i.peak.value
+			entry.Peak, entry.Unit, entry.Low = &peak, i.peak.unit, i.peak.low
+			entry.Total, entry.TotalUnit = i.total, i.peak.totalUnit
+		}
+	}
+	if err := history.Append(l.AlertHistory, entry); err != nil {
+		log.Printf("Error writing alert history: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package record
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertHistory(t *testing.T) {
+	latencyRules, err := NewLatencyRules([]string{"/api:p50>1s"})
+	require.NoError(t, err)
+	var alertHistory bytes.Buffer
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(routedLog()),
+		AlertWindow:             time.Second,
+		AlertThresholdPerSecond: 2,
+		LatencyAlerts:           latencyRules,
+		AlertHistory:            &alertHistory,
+	}
+	runProcessor(&logProcessor)
+	// latency alert fires on the first record with its own peak and no total, and the hits one on the third, counting
+	// hits while it's firing
+	assert.Equal(t, []string{
+		`{"id":"latency:/api:p50>1s@1s","rule":"p50 latency of /api","labels":{"alert":"latency","section":"/api"},"state":"RED","start":"2019-02-07T21:11:00Z"}`,
+		`{"id":"hits","rule":"hits","labels":{"alert":"hits"},"state":"RED","start":"2019-02-07T21:11:00Z"}`,
+		`{"id":"hits","rule":"hits","labels":{"alert":"hits"},"state":"GREEN","start":"2019-02-07T21:11:00Z","end":"2019-02-07T21:12:01Z","peak":6,"unit":"hits/s","total":179,"total_unit":"hits"}`,
+		`{"id":"latency:/api:p50>1s@1s","rule":"p50 latency of /api","labels":{"alert":"latency","section":"/api"},"state":"GREEN","start":"2019-02-07T21:11:00Z","end":"2019-02-07T21:12:01Z","peak":2000,"unit":"ms"}`,
+	}, strings.Split(strings.TrimSpace(alertHistory.String()), "\n"))
+}
+
+func TestIncidentMeasure(t *testing.T) {
+	bandwidth := incident{section: "/api"}
+	bandwidth.measure(measurement{value: 100, unit: "bytes/s", totalUnit: "bytes"})
+	bandwidth.measure(measurement{value: 300, unit: "bytes/s", totalUnit: "bytes"})
+	bandwidth.measure(measurement{value: 200, unit: "bytes/s", totalUnit: "bytes"})
+	bandwidth.add(&record{section: "/api", bytes: 1000})
+	bandwidth.add(&record{section: "/api", bytes: 500, filtered: true})
+	bandwidth.add(&record{section: "/static", bytes: 700})
+	bandwidth.add(&record{section: "/api", bytes: 24})
+	assert.Equal(t, 300.0, bandwidth.peak.value)
+	assert.Equal(t, 1024, bandwidth.total)
+
+	low := incident{}
+	low.measure(measurement{value: 0.5, unit: "hits/s", low: true, totalUnit: "hits"})
+	low.measure(measurement{value: 0, unit: "hits/s", low: true, totalUnit: "hits"})
+	low.measure(measurement{value: 0.2, unit: "hits/s", low: true, totalUnit: "hits"})
+	low.add(&record{section: "/api"})
+	low.add(&record{section: "/static"})
+	assert.Equal(t, 0.0, low.peak.value)
+	assert.Equal(t, 2, low.total)
+
+	latency := incident{}
+	latency.measure(measurement{value: 1200, unit: "ms"})
+	latency.add(&record{section: "/api"})
+	assert.Equal(t, 1200.0, latency.peak.value)
+	assert.Zero(t, latency.total)
+}
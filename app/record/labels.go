@@ -0,0 +1,114 @@
+package record
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// prefix lengths unmatched addresses are grouped by
+const (
+	fallbackPrefixV4 = 24
+	fallbackPrefixV6 = 64
+)
+
+// Labels map client networks to names like "office" or "k8s-pods", the label of the address
+// is the one of the longest prefix containing it
+type Labels struct {
+	v4, v6 prefixTable
+}
+
+// prefixTable keeps labels of the prefixes of the same address family
+type prefixTable struct {
+	lengths  []int             // distinct prefix lengths, longest first
+	prefixes map[string]string // label per masked address followed by the prefix length
+}
+
+// NewLabels reads labels from "CIDR label" lines like "10.1.0.0/16 office",
+// blank lines and lines starting with # are ignored
+func NewLabels(r io.Reader) (*Labels, error) {
+	l := &Labels{
+		v4: prefixTable{prefixes: make(map[string]string)},
+		v6: prefixTable{prefixes: make(map[string]string)},
+	}
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: %q should be in \"CIDR label\" format", lineNum, line)
+		}
+		_, network, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		l.add(network, fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// add label of the network, later one wins for the same network
+func (l *Labels) add(network *net.IPNet, label string) {
+	ones, bits := network.Mask.Size()
+	table, ip := &l.v6, network.IP.To16()
+	if bits == 8*net.IPv4len {
+		table, ip = &l.v4, network.IP.To4()
+	}
+	known := false
+	for _, length := range table.lengths {
+		if length == ones {
+			known = true
+			break
+		}
+	}
+	if !known {
+		table.lengths = append(table.lengths, ones)
+		sort.Sort(sort.Reverse(sort.IntSlice(table.lengths)))
+	}
+	table.prefixes[string(prefixKey(nil, ip, ones))] = label
+}
+
+// label of the client address, which is the network prefix like "10.0.0.0/24" if it matches no labelled network.
+// Client which is not an IP address is labelled with itself.
+func (l *Labels) label(host string) string {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	table, fallback := &l.v6, fallbackPrefixV6
+	if ip4 := ip.To4(); ip4 != nil {
+		table, fallback, ip = &l.v4, fallbackPrefixV4, ip4
+	}
+	var buf [net.IPv6len + 1]byte
+	for _, length := range table.lengths {
+		if label, ok := table.prefixes[string(prefixKey(buf[:0], ip, length))]; ok {
+			return label
+		}
+	}
+	return ip.Mask(net.CIDRMask(fallback, 8*len(ip))).String() + "/" + strconv.Itoa(fallback)
+}
+
+// prefixKey appends the address masked to the prefix length and the length itself to dst
+func prefixKey(dst []byte, ip net.IP, length int) []byte {
+	for i, b := range ip {
+		switch bits := length - 8*i; {
+		case bits >= 8:
+		case bits <= 0:
+			b = 0
+		default:
+			b &= ^byte(0xff >> bits)
+		}
+		dst = append(dst, b)
+	}
+	return append(dst, byte(length))
+}
@@ -0,0 +1,77 @@
+package record
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testLabels = `# networks
+10.0.0.0/8       internal
+10.1.0.0/16      office
+10.1.2.0/24      office-wifi
+
+2001:db8::/32    partner-x
+2001:db8:1::/48  k8s-pods
+`
+
+func TestLabels(t *testing.T) {
+	labels, err := NewLabels(strings.NewReader(testLabels))
+	require.NoError(t, err)
+	var testData = []struct{ host, label string }{
+		{host: "10.2.3.4", label: "internal"},
+		{host: "10.1.3.4", label: "office"},
+		{host: "10.1.2.3", label: "office-wifi"},
+		{host: "192.168.1.15", label: "192.168.1.0/24"},
+		{host: "2001:db8:2::1", label: "partner-x"},
+		{host: "2001:db8:1:5::1", label: "k8s-pods"},
+		{host: "2001:db9:1:2:3::1", label: "2001:db9:1:2::/64"},
+		{host: "::ffff:10.1.2.3", label: "office-wifi"},
+		{host: "example.com", label: "example.com"},
+	}
+	for _, x := range testData {
+		assert.Equal(t, x.label, labels.label(x.host), x.host)
+	}
+	assert.Equal(t, []int{24, 16, 8}, labels.v4.lengths)
+	assert.Equal(t, []int{48, 32}, labels.v6.lengths)
+}
+
+func TestLabelsOverride(t *testing.T) {
+	labels, err := NewLabels(strings.NewReader("10.1.0.0/16 office\n10.1.255.255/16 hq\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "hq", labels.label("10.1.2.3"))
+	assert.Equal(t, []int{16}, labels.v4.lengths)
+}
+
+func TestNewLabelsErrors(t *testing.T) {
+	_, err := NewLabels(strings.NewReader("10.0.0.0/8 internal\n10.1.0.0/16\n"))
+	assert.EqualError(t, err, `line 2: "10.1.0.0/16" should be in "CIDR label" format`)
+	_, err = NewLabels(strings.NewReader("10.0.0.0/33 internal\n"))
+	assert.EqualError(t, err, "line 1: invalid CIDR address: 10.0.0.0/33")
+}
+
+func TestParserLabels(t *testing.T) {
+	labels, err := NewLabels(strings.NewReader(testLabels))
+	require.NoError(t, err)
+	filter, err := NewAlertFilter([]string{"label!=office"})
+	require.NoError(t, err)
+	p := newLineParser(parseOpts{sections: defaultSectionRules, times: defaultTimeParser, labels: labels, filter: filter})
+	var r record
+	require.Equal(t, reasonNone, p.parse([]byte(`"10.1.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234`+"\n"), &r))
+	assert.Equal(t, "office", r.label)
+	assert.True(t, r.filtered)
+	require.Equal(t, reasonNone, p.parse([]byte(`10.3.0.2,-,apache,1549573860,"GET /api/user HTTP/1.0",200,1234`+"\n"), &r))
+	assert.Equal(t, "internal", r.label)
+	assert.False(t, r.filtered)
+}
+
+func BenchmarkLabels(b *testing.B) {
+	labels, err := NewLabels(strings.NewReader(testLabels))
+	require.NoError(b, err)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		labels.label("10.1.2.3")
+	}
+}
@@ -0,0 +1,73 @@
+package record
+
+import "time"
+
+// alertEvent is the change of the alert state, which is printed unless it's silenced
+type alertEvent struct {
+	rule   alertRule // the alert which state changed
+	id     string    // stable identifier of the alert in the history, like "latency:/api:p90>800ms@10m"
+	name   string    // description of the alert in the history, like "p90 latency of /api"
+	time   time.Time
+	state  string            // RED or GREEN
+	labels map[string]string // alert name, section and severity if the alert has them, matched by silences
+	text   string            // line of the text output after the time
+	json   jsonAlertEvent    // alert in JSON output, its state is filled by notify
+}
+
+// jsonAlertEvent is the alert in JSON output with jsonAlertState embedded
+type jsonAlertEvent interface {
+	alertState() *jsonAlertState
+}
+
+// alertLabels returns labels of the alert with the provided name and section, which could be empty
+func alertLabels(name, section string) map[string]string {
+	labels := map[string]string{"alert": name}
+	if section != "" {
+		labels["section"] = section
+	}
+	return labels
+}
+
+// ruleID returns the stable identifier of the alert in the history: its name followed by the section
+// and the rule in the syntax of its option if it has them, so that the rules of the same description differ
+func ruleID(name, section, rule string) string {
+	id := name
+	if section != "" {
+		id += ":" + section
+	}
+	if rule != "" {
+		id += ":" + rule
+	}
+	return id
+}
+
+// silenced returns true if the alert with the labels is silenced at the time
+func (l *Processor) silenced(labels map[string]string, t time.Time) bool {
+	return l.Silences != nil && l.Silences.Silenced(labels, t)
+}
+
+// notify prints the alert state change, or routes it to be notified in the group with others if Routing is set.
+// Silenced changes are only printed in JSON output, marked as such, and so are the inhibited ones.
+func (l *Processor) notify(e alertEvent) {
+	if l.AlertHistory != nil {
+		l.recordHistory(e)
+	}
+	silenced := l.silenced(e.labels, e.time)
+	inhibited := l.router != nil && l.router.inhibited(e.rule, e.labels)
+	if l.JSON {
+		*e.json.alertState() = jsonAlertState{Time: e.time.In(l.OutputTZ), Type: "alert", Alert: e.labels["alert"],
+			State: e.state, Silenced: silenced, Inhibited: inhibited}
+		printJSON(e.json)
+	}
+	if l.router != nil {
+		// recovery of the silenced alert is notified if the alert was notified as firing
+		if !silenced || e.state != "RED" {
+			l.router.route(e)
+		}
+		return
+	}
+	if silenced || l.JSON {
+		return
+	}
+	printFunction("%s: %s\n", e.time.In(l.OutputTZ), e.text) //nolint:errcheck
+}
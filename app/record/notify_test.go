@@ -0,0 +1,56 @@
+package record
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/paskal/datadog-parser/app/silence"
+)
+
+func TestSilencedAlerts(t *testing.T) {
+	// hits and latency alerts fire and recover during the deploy, and only the hits one is silenced
+	log := `remotehost,rfc931,authuser,date,request,status,bytes,latency
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234,2000
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234,2000
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234,2000
+"10.0.0.3","-","apache",1549573871,"GET /api/user HTTP/1.0",200,1234,10
+`
+	rules, err := NewLatencyRules([]string{"/api:p50>1s"})
+	require.NoError(t, err)
+	silences := silence.NewStore(time.UTC)
+	start, end := time.Unix(1549573800, 0), time.Unix(1549574400, 0)
+	_, err = silences.Add(silence.Silence{Matchers: map[string]string{"alert": "hits"}, Start: &start, End: &end})
+	require.NoError(t, err)
+
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Second,
+		AlertThresholdPerSecond: 2,
+		LatencyAlerts:           rules,
+		Silences:                silences,
+	}
+	assert.Equal(t, []string{
+		"2019-02-07 21:11:00 +0000 UTC: Alert RED, p50 latency of /api ~2s is higher than 1s in the last 1s",
+		"2019-02-07 21:11:11 +0000 UTC: Alert GREEN, p50 latency of /api ~10ms is lower than 1s in the last 1s",
+	}, alertLines(runProcessor(&logProcessor)))
+
+	// silenced changes are still in JSON output
+	logProcessor = Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Second,
+		AlertThresholdPerSecond: 2,
+		LatencyAlerts:           rules,
+		Silences:                silences,
+		JSON:                    true,
+	}
+	assert.Equal(t, []string{
+		`{"time":"2019-02-07T21:11:00Z","type":"alert","alert":"latency","state":"RED","section":"/api","percentile":50,"latency_ms":2000,"threshold_ms":1000,"window":"1s"}`,
+		`{"time":"2019-02-07T21:11:00Z","type":"alert","alert":"hits","state":"RED","silenced":true,"per_second":3,"threshold":2,"total":3,"window":"1s","top_clients":[{"key":"10.0.0.2","count":3}],"top_clients_by_bytes":[{"key":"10.0.0.2","count":3702}],"top_users":[{"key":"apache","count":3}]}`,
+		`{"time":"2019-02-07T21:11:11Z","type":"alert","alert":"hits","state":"GREEN","silenced":true,"per_second":1,"threshold":2,"total":1,"window":"1s"}`,
+		`{"time":"2019-02-07T21:11:11Z","type":"alert","alert":"latency","state":"GREEN","section":"/api","percentile":50,"latency_ms":10,"threshold_ms":1000,"window":"1s"}`,
+	}, alertLines(runProcessor(&logProcessor)))
+}
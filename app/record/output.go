@@ -0,0 +1,258 @@
+package record
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// breakdown is the top of one of the report dimensions, like clients or countries
+type breakdown struct {
+	name  string // key in JSON output
+	title string // prefix in text output
+	unit  string
+	top   []hitCount
+}
+
+// breakdowns returns tops of all dimensions collected in the stats
+func (l *Processor) breakdowns(stats historyRecord) []breakdown {
+	n := l.TopTalkers
+	b := []breakdown{
+		{name: "clients", title: "top clients", unit: "hits", top: stats.clients.top(n)},
+		{name: "clients_by_bytes", title: "by bytes", unit: "bytes", top: stats.clientBytes.top(n)},
+		{name: "users", title: "top users", unit: "hits", top: stats.authUsers.top(n)},
+	}
+	if l.Labels != nil {
+		b = append(b, breakdown{name: "networks", title: "top networks", unit: "hits", top: stats.networks.top(n)})
+	}
+	if l.GeoIP != nil {
+		b = append(b,
+			breakdown{name: "countries", title: "top countries", unit: "hits", top: stats.countries.top(n)},
+			breakdown{name: "asns", title: "top ASNs", unit: "hits", top: stats.asns.top(n)},
+		)
+	}
+	if stats.agentHits > 0 {
+		b = append(b,
+			breakdown{name: "browsers", title: "top browsers", unit: "hits", top: stats.browsers.top(n)},
+			breakdown{name: "os", title: "top OS", unit: "hits", top: stats.systems.top(n)},
+			breakdown{name: "devices", title: "top devices", unit: "hits", top: stats.devices.top(n)},
+			breakdown{name: "bots", title: "top bots", unit: "hits", top: stats.bots.top(n)},
+		)
+	}
+	return b
+}
+
+// formatBreakdowns returns breakdowns for the text output, like "top clients 10.0.0.1 (2 hits), by bytes ..."
+func formatBreakdowns(breakdowns []breakdown) string {
+	parts := make([]string, len(breakdowns))
+	for i, b := range breakdowns {
+		parts[i] = b.title + " " + formatTop(b.top, b.unit)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// jsonCount is the number of hits or bytes of the key, the true count is between count-error and count
+type jsonCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+	Error int    `json:"error,omitempty"`
+}
+
+// jsonReport is the report in JSON output
+type jsonReport struct {
+	Time        time.Time              `json:"time"`
+	Type        string                 `json:"type"`
+	Hits        int                    `json:"hits"`
+	Users       int                    `json:"users"`
+	UsersError  float64                `json:"users_error,omitempty"` // relative standard error of the estimate
+	Bytes       int                    `json:"bytes"`
+	TopSections []jsonCount            `json:"top_sections"`
+	Agents      *jsonAgents            `json:"agents,omitempty"`
+	Top         map[string][]jsonCount `json:"top,omitempty"`
+	Sizes       []jsonDistribution     `json:"sizes,omitempty"`        // all records first, then top sections
+	Latencies   []jsonDistribution     `json:"latencies_ms,omitempty"` // all records first, then top sections
+	SLOs        []jsonSLO              `json:"slos,omitempty"`
+}
+
+// jsonSLO is the state of the objective over its period
+type jsonSLO struct {
+	Section         string  `json:"section,omitempty"`
+	Objective       float64 `json:"objective"` // percent
+	Requests        int     `json:"requests"`
+	Errors          int     `json:"errors"`
+	BudgetRemaining float64 `json:"budget_remaining"` // share of the error budget, negative once it's exceeded
+}
+
+// jsonDistribution is the distribution of values of the section, or of all records if the section is empty
+type jsonDistribution struct {
+	Section string  `json:"section,omitempty"`
+	P50     float64 `json:"p50"`
+	P90     float64 `json:"p90"`
+	P99     float64 `json:"p99"`
+	Max     float64 `json:"max"`
+}
+
+// jsonAgents is the number of hits with known user agent and how many of them are from bots
+type jsonAgents struct {
+	Hits int `json:"hits"`
+	Bots int `json:"bots"`
+}
+
+// jsonAlertState is the alert state change in JSON output, which is embedded into alerts with their details
+type jsonAlertState struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Alert     string    `json:"alert"`
+	State     string    `json:"state"`
+	Silenced  bool      `json:"silenced,omitempty"`  // not sent to notifiers
+	Inhibited bool      `json:"inhibited,omitempty"` // source alert of the inhibit rule was firing
+}
+
+// alertState returns the state embedded into the alert
+func (s *jsonAlertState) alertState() *jsonAlertState { return s }
+
+// jsonAlert is the alert state change in JSON output
+type jsonAlert struct {
+	jsonAlertState
+	PerSecond         float64     `json:"per_second"`
+	Threshold         int         `json:"threshold"`
+	Total             int         `json:"total"`
+	Window            string      `json:"window"`
+	TopClients        []jsonCount `json:"top_clients,omitempty"`
+	TopClientsByBytes []jsonCount `json:"top_clients_by_bytes,omitempty"`
+	TopUsers          []jsonCount `json:"top_users,omitempty"`
+}
+
+// jsonLatencyAlert is the latency alert state change in JSON output
+type jsonLatencyAlert struct {
+	jsonAlertState
+	Section    string  `json:"section,omitempty"`
+	Percentile float64 `json:"percentile"`
+	Latency    float64 `json:"latency_ms"`
+	Threshold  float64 `json:"threshold_ms"`
+	Window     string  `json:"window"`
+}
+
+// jsonBurnRateAlert is the SLO burn rate alert state change in JSON output
+type jsonBurnRateAlert struct {
+	jsonAlertState
+	Section       string  `json:"section,omitempty"`
+	Objective     float64 `json:"objective"`
+	Severity      string  `json:"severity"`
+	LongWindow    string  `json:"long_window"`
+	LongBurnRate  float64 `json:"long_burn_rate"`
+	ShortWindow   string  `json:"short_window"`
+	ShortBurnRate float64 `json:"short_burn_rate"`
+	Threshold     float64 `json:"threshold"`
+}
+
+// jsonAnomalyAlert is the anomaly alert state change in JSON output
+type jsonAnomalyAlert struct {
+	jsonAlertState
+	PerSecond float64 `json:"per_second"`
+	Expected  float64 `json:"expected"`
+	StdDev    float64 `json:"std_dev"`
+	Deviation float64 `json:"deviation"` // in standard deviations, negative if the rate is lower than expected
+	Sigma     float64 `json:"sigma"`
+	Window    string  `json:"window"`
+}
+
+// jsonLowTrafficAlert is the low traffic alert state change in JSON output
+type jsonLowTrafficAlert struct {
+	jsonAlertState
+	Section   string  `json:"section,omitempty"`
+	PerSecond float64 `json:"per_second"`
+	Threshold float64 `json:"threshold"`
+	Total     int     `json:"total"`
+	Window    string  `json:"window"`
+}
+
+// jsonNoDataAlert is the no data alert state change in JSON output
+type jsonNoDataAlert struct {
+	jsonAlertState
+	Silence     string     `json:"silence"` // time without records
+	Threshold   string     `json:"threshold"`
+	FirstRecord *time.Time `json:"first_record,omitempty"` // date of the first record resolving the alert fired since the start
+}
+
+// jsonNotification is the group of alerts notified together in JSON output
+type jsonNotification struct {
+	Time     time.Time        `json:"time"`
+	Type     string           `json:"type"`
+	Group    string           `json:"group"`
+	Repeat   bool             `json:"repeat,omitempty"` // nothing changed since the previous notification of the group
+	Firing   int              `json:"firing"`
+	Resolved int              `json:"resolved"`
+	Alerts   []jsonAlertEvent `json:"alerts"` // firing alerts followed by the resolved ones
+}
+
+// jsonRejects is the rejected lines warning in JSON output
+type jsonRejects struct {
+	Time     time.Time      `json:"time"`
+	Type     string         `json:"type"`
+	Rejected int            `json:"rejected"`
+	Lines    int            `json:"lines"`
+	Reasons  map[string]int `json:"reasons"`
+}
+
+// jsonCounts converts counts to JSON output
+func jsonCounts(counts []hitCount) []jsonCount {
+	result := make([]jsonCount, len(counts))
+	for i, c := range counts {
+		result[i] = jsonCount{Key: c.key, Count: c.count, Error: c.err}
+	}
+	return result
+}
+
+// jsonDistributions converts distributions to JSON output, converting every value with the provided function
+func jsonDistributions(summaries []summary, convert func(v float64) float64) []jsonDistribution {
+	if len(summaries) == 0 {
+		return nil
+	}
+	result := make([]jsonDistribution, len(summaries))
+	for i, s := range summaries {
+		result[i] = jsonDistribution{Section: s.section, P50: convert(s.quantiles[0]), P90: convert(s.quantiles[1]),
+			P99: convert(s.quantiles[2]), Max: convert(s.max)}
+	}
+	return result
+}
+
+// jsonSizes converts response size distributions to JSON output in whole bytes
+func jsonSizes(summaries []summary) []jsonDistribution {
+	return jsonDistributions(summaries, math.Round)
+}
+
+// jsonLatencies converts latency distributions to JSON output in milliseconds, rounded to microseconds
+func jsonLatencies(summaries []summary) []jsonDistribution {
+	return jsonDistributions(summaries, func(v float64) float64 { return jsonMilliseconds(microseconds(v)) })
+}
+
+// jsonMilliseconds converts the duration to milliseconds, rounded to microseconds
+func jsonMilliseconds(d time.Duration) float64 {
+	return float64(d.Round(time.Microsecond)) / float64(time.Millisecond)
+}
+
+// jsonSLOs converts objectives state to JSON output
+func jsonSLOs(statuses []sloStatus) []jsonSLO {
+	if len(statuses) == 0 {
+		return nil
+	}
+	result := make([]jsonSLO, len(statuses))
+	for i, s := range statuses {
+		result[i] = jsonSLO{Section: s.slo.Section, Objective: s.slo.Objective, Requests: s.requests, Errors: s.errs,
+			BudgetRemaining: s.budgetRemaining}
+	}
+	return result
+}
+
+// printJSON prints the value as a single line of JSON
+func printJSON(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding the output: %v", err)
+		return
+	}
+	printFunction("%s\n", data) //nolint:errcheck
+}
@@ -0,0 +1,50 @@
+package record
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/paskal/datadog-parser/app/geoip"
+)
+
+func TestJSONOutput(t *testing.T) {
+	log := `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+garbage
+"10.0.0.3","-","apache",1549573871,"GET /api/user HTTP/1.0",200,1234
+`
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Second,
+		AlertThresholdPerSecond: 2,
+		TopTalkers:              1,
+		GeoIP:                   geoip.New(),
+		JSON:                    true,
+	}
+	assert.Equal(t, `{"time":"2019-02-07T21:11:00Z","type":"alert","alert":"hits","state":"RED","per_second":3,"threshold":2,"total":3,"window":"1s","top_clients":[{"key":"10.0.0.2","count":3}],"top_clients_by_bytes":[{"key":"10.0.0.2","count":3702}],"top_users":[{"key":"apache","count":3}]}
+{"time":"2019-02-07T21:11:00Z","type":"report","hits":3,"users":1,"bytes":3702,"top_sections":[{"key":"/api","count":1}],"top":{"asns":[{"key":"-","count":3}],"clients":[{"key":"10.0.0.2","count":3}],"clients_by_bytes":[{"key":"10.0.0.2","count":3702}],"countries":[{"key":"-","count":3}],"users":[{"key":"apache","count":3}]}}
+{"time":"2019-02-07T21:11:00Z","type":"rejects","rejected":1,"lines":4,"reasons":{"wrong number of fields":1}}
+{"time":"2019-02-07T21:11:11Z","type":"alert","alert":"hits","state":"GREEN","per_second":1,"threshold":2,"total":1,"window":"1s"}
+`, runProcessor(&logProcessor))
+}
+
+func TestBreakdownsText(t *testing.T) {
+	log := `"10.0.0.2","-","-",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","-",1549573871,"GET /api/user HTTP/1.0",200,1234
+`
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Minute * 2,
+		AlertThresholdPerSecond: 10,
+		TopTalkers:              3,
+		GeoIP:                   geoip.New(),
+	}
+	assert.Equal(t, "2019-02-07 21:11:00 +0000 UTC: 1 hits from 1 users with 1234 bytes transferred, top /api with 1 hits\n"+
+		"2019-02-07 21:11:00 +0000 UTC: top clients 10.0.0.2 (1 hits), by bytes 10.0.0.2 (1234 bytes), top users none, "+
+		"top countries - (1 hits), top ASNs - (1 hits)\n",
+		runProcessor(&logProcessor))
+}
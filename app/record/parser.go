@@ -0,0 +1,355 @@
+package record
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"time"
+
+	"github.com/paskal/datadog-parser/app/geoip"
+	"github.com/paskal/datadog-parser/app/useragent"
+)
+
+const (
+	fieldsCount   = 7
+	internerLimit = 64 * 1024
+)
+
+// lineParser is a fast path parser of 7-column CSV lines which doesn't allocate
+// for repeated values, falling back to encoding/csv for lines with escaped quotes
+// or carriage returns. It's not thread-safe, every worker should have its own.
+type lineParser struct {
+	opts     parseOpts
+	fields   [maxColumns][]byte
+	section  []byte // buffer for the section being built
+	strings  interner
+	labels   map[string]string          // labels cache per client
+	geo      map[string]geoip.Info      // locations cache per client
+	agents   map[string]useragent.Agent // parsed user agents cache
+	fallback *csvDecoder
+}
+
+// parseOpts are log lines parsing options, shared by all workers
+type parseOpts struct {
+	sections *SectionRules
+	times    *TimeParser
+	labels   *Labels           // client networks labels, nil if not set
+	geo      locator           // country and ASN database, nil if not set
+	agents   *useragent.Parser // user agents parser, used if the log has user agent column
+	filter   *AlertFilter      // records counted by the alert, all of them if nil
+	columns  *columns          // layout of the log lines, defaultColumns if nil
+}
+
+// layout returns the layout of the log lines
+func (o parseOpts) layout() *columns {
+	if o.columns == nil {
+		return defaultColumns
+	}
+	return o.columns
+}
+
+// locator returns the location of the client, implemented by geoip.DB
+type locator interface {
+	Lookup(host string) geoip.Info
+}
+
+// defaultParseOpts use the first path segment as the section and detect the time format
+var defaultParseOpts = parseOpts{sections: defaultSectionRules, times: defaultTimeParser}
+
+func newLineParser(opts parseOpts) *lineParser {
+	return &lineParser{
+		opts:     opts,
+		strings:  interner{values: make(map[string]string)},
+		labels:   make(map[string]string),
+		geo:      make(map[string]geoip.Info),
+		agents:   make(map[string]useragent.Agent),
+		fallback: newCSVDecoder(),
+	}
+}
+
+// parse line ending with a newline into r, returns the reason if line is not a valid record
+func (p *lineParser) parse(line []byte, r *record) rejectReason {
+	reason := p.parseLine(line, r)
+	if reason == reasonNone {
+		p.enrich(r)
+	}
+	return reason
+}
+
+// enrich parsed record with the properties derived from its fields
+func (p *lineParser) enrich(r *record) {
+	if p.opts.labels != nil {
+		r.label = p.label(r.remotehost)
+	}
+	if p.opts.geo != nil {
+		info := p.locate(r.remotehost)
+		r.country, r.asn = info.Country, info.ASN
+	}
+	if p.opts.agents != nil && p.opts.layout().userAgent > 0 {
+		r.agent = p.agent(r.userAgent)
+	}
+	r.filtered = !p.opts.filter.match(r)
+}
+
+// label of the client, cached as the same clients repeat a lot
+func (p *lineParser) label(host string) string {
+	if label, ok := p.labels[host]; ok {
+		return label
+	}
+	if len(p.labels) >= internerLimit {
+		for k := range p.labels {
+			delete(p.labels, k)
+		}
+	}
+	label := p.opts.labels.label(host)
+	p.labels[host] = label
+	return label
+}
+
+// locate the client, cached as lookups are expensive and the same clients repeat a lot
+func (p *lineParser) locate(host string) geoip.Info {
+	if info, ok := p.geo[host]; ok {
+		return info
+	}
+	if len(p.geo) >= internerLimit {
+		for k := range p.geo {
+			delete(p.geo, k)
+		}
+	}
+	info := p.opts.geo.Lookup(host)
+	p.geo[host] = info
+	return info
+}
+
+// agent returns the parsed user agent, cached as parsing is expensive and the same agents repeat a lot
+func (p *lineParser) agent(ua string) useragent.Agent {
+	if agent, ok := p.agents[ua]; ok {
+		return agent
+	}
+	if len(p.agents) >= internerLimit {
+		for k := range p.agents {
+			delete(p.agents, k)
+		}
+	}
+	agent := p.opts.agents.Parse(ua)
+	p.agents[ua] = agent
+	return agent
+}
+
+// parseLine parses fields of the line, using encoding/csv for lines fast path can't handle
+func (p *lineParser) parseLine(line []byte, r *record) rejectReason {
+	if len(line) <= 1 || bytes.IndexByte(line, '\r') >= 0 {
+		return p.parseSlow(line, r)
+	}
+	line = line[:len(line)-1] // drop trailing newline
+	var n int
+	for pos := 0; pos <= len(line); n++ {
+		field, next, ok := splitField(line, pos)
+		if !ok {
+			return p.parseSlow(append(line, '\n'), r)
+		}
+		if n < maxColumns {
+			p.fields[n] = field
+		}
+		pos = next
+	}
+	if bytes.Equal(p.fields[0], []byte("remotehost")) {
+		return reasonHeader
+	}
+	layout := p.opts.layout()
+	if n != layout.count {
+		return reasonFields
+	}
+
+	bytesTransferred, ok := parseInt(p.fields[6])
+	if !ok {
+		return reasonBytes
+	}
+	status, ok := parseInt(p.fields[5])
+	if !ok {
+		return reasonStatus
+	}
+	date, ok := p.opts.times.parse(p.fields[3])
+	if !ok {
+		return reasonTimestamp
+	}
+	if p.section, ok = p.opts.sections.section(p.section[:0], p.fields[4]); !ok {
+		return reasonRequest
+	}
+	// request and user agent are unique too often to be worth interning, they would only flush the interner
+	*r = record{
+		remotehost: p.strings.get(p.fields[0]),
+		rfc931:     p.strings.get(p.fields[1]),
+		authuser:   p.strings.get(p.fields[2]),
+		date:       date,
+		request:    string(p.fields[4]),
+		section:    p.strings.get(p.section),
+		status:     int(status),
+		bytes:      int(bytesTransferred),
+	}
+	if layout.userAgent > 0 {
+		r.userAgent = string(p.fields[layout.userAgent])
+	}
+	if layout.latency > 0 {
+		if r.latency, r.timed, ok = parseLatency(p.fields[layout.latency], layout.latencyUnit); !ok {
+			return reasonLatency
+		}
+	}
+	return reasonNone
+}
+
+// parseSlow parses line using encoding/csv
+func (p *lineParser) parseSlow(line []byte, r *record) rejectReason {
+	raw, err := p.fallback.decode(line)
+	if err != nil {
+		return reasonCSV
+	}
+	parsed, reason := parseRecord(raw, p.opts)
+	if parsed != nil {
+		*r = *parsed
+	}
+	return reason
+}
+
+// splitField returns the field starting at pos and the position of the next field,
+// which is past the end of the line for the last field.
+// Returns false if the field can't be handled without encoding/csv.
+func splitField(line []byte, pos int) (field []byte, next int, ok bool) {
+	if pos < len(line) && line[pos] == '"' {
+		end := bytes.IndexByte(line[pos+1:], '"')
+		if end < 0 {
+			return nil, 0, false
+		}
+		end += pos + 1
+		switch {
+		case end+1 == len(line):
+			return line[pos+1 : end], end + 2, true
+		case line[end+1] == ',':
+			return line[pos+1 : end], end + 2, true
+		}
+		// escaped quote or garbage after the closing quote
+		return nil, 0, false
+	}
+	end := bytes.IndexByte(line[pos:], ',')
+	if end < 0 {
+		end = len(line) - pos
+	}
+	field = line[pos : pos+end]
+	if bytes.IndexByte(field, '"') >= 0 {
+		return nil, 0, false
+	}
+	return field, pos + end + 1, true
+}
+
+// parseLatency parses non-negative decimal number of units like "12.5", without allocations.
+// Empty value and "-" mean the duration is unknown, in which case timed is false.
+func parseLatency(b []byte, unit time.Duration) (latency time.Duration, timed, ok bool) {
+	if len(b) == 0 || (len(b) == 1 && b[0] == '-') {
+		return 0, false, true
+	}
+	whole, frac := b, []byte(nil)
+	if dot := bytes.IndexByte(b, '.'); dot >= 0 {
+		whole, frac = b[:dot], b[dot+1:]
+	}
+	if len(whole) == 0 || whole[0] < '0' || whole[0] > '9' {
+		return 0, false, false
+	}
+	n, ok := parseInt(whole)
+	if !ok || n > int64(math.MaxInt64/unit) {
+		return 0, false, false
+	}
+	latency = time.Duration(n) * unit
+	scale := unit
+	for _, c := range frac {
+		if c < '0' || c > '9' {
+			return 0, false, false
+		}
+		scale /= 10
+		latency += time.Duration(c-'0') * scale
+	}
+	return latency, true, true
+}
+
+// parseInt is strconv.ParseInt(s, 10, 64) for a byte slice, without allocations
+func parseInt(b []byte) (int64, bool) {
+	neg := false
+	if len(b) > 0 && (b[0] == '+' || b[0] == '-') {
+		neg = b[0] == '-'
+		b = b[1:]
+	}
+	if len(b) == 0 {
+		return 0, false
+	}
+	var n uint64
+	const cutoff = 1 << 63
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		if n > cutoff/10 {
+			return 0, false
+		}
+		n = n*10 + uint64(c-'0')
+		if n > cutoff {
+			return 0, false
+		}
+	}
+	if !neg && n == cutoff {
+		return 0, false
+	}
+	if neg {
+		return -int64(n), true
+	}
+	return int64(n), true
+}
+
+// interner deduplicates strings, so that repeated values like hosts, users and sections
+// are allocated once; it's flushed once the limit is reached to keep memory bounded,
+// so fields with unbounded number of values shouldn't go through it
+type interner struct {
+	values map[string]string
+}
+
+// get returns the string equal to b
+func (in *interner) get(b []byte) string {
+	if s, ok := in.values[string(b)]; ok {
+		return s
+	}
+	if len(in.values) >= internerLimit {
+		for k := range in.values {
+			delete(in.values, k)
+		}
+	}
+	s := string(b)
+	in.values[s] = s
+	return s
+}
+
+// csvDecoder decodes single CSV lines, reusing the same csv.Reader for all of them
+type csvDecoder struct {
+	src    bytes.Reader
+	reader *csv.Reader
+}
+
+func newCSVDecoder() *csvDecoder {
+	d := &csvDecoder{}
+	d.resetReader()
+	return d
+}
+
+// decode the line which must end with a newline, returned slice is valid until the next call
+func (d *csvDecoder) decode(line []byte) ([]string, error) {
+	d.src.Reset(line)
+	raw, err := d.reader.Read()
+	if err != nil {
+		// on parse error the rest of the line might be left buffered in the reader
+		d.resetReader()
+	}
+	return raw, err
+}
+
+func (d *csvDecoder) resetReader() {
+	d.reader = csv.NewReader(&d.src)
+	d.reader.FieldsPerRecord = -1 // field count is validated by parseRecord
+	d.reader.ReuseRecord = true
+}
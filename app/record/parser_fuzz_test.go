@@ -0,0 +1,36 @@
+//go:build go1.18
+// +build go1.18
+
+package record
+
+import (
+	"testing"
+)
+
+// FuzzLineParser checks that the fast path parser is equivalent to encoding/csv based one
+// on lines the pipeline could produce, i.e. ones with newlines only inside quoted fields
+func FuzzLineParser(f *testing.F) {
+	for _, line := range parserTestLines {
+		f.Add(line)
+	}
+	p := newLineParser(defaultParseOpts)
+	f.Fuzz(func(t *testing.T, line string) {
+		quotes := 0
+		for _, c := range line {
+			if c == '"' {
+				quotes++
+			}
+			if c == '\n' && quotes%2 == 0 {
+				return // pipeline would split such line in two
+			}
+		}
+		expected, expectedReason := referenceParse(line)
+		actual, actualReason := fastParse(p, line)
+		if expectedReason != actualReason {
+			t.Fatalf("parsers reject reason mismatch on %q: %s != %s", line, expectedReason, actualReason)
+		}
+		if (expected == nil) != (actual == nil) || (expected != nil && *expected != *actual) {
+			t.Fatalf("parsers mismatch on %q: %+v != %+v", line, expected, actual)
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package record
+
+import (
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/paskal/datadog-parser/app/geoip"
+)
+
+// parserTestLines are used both for equivalence tests and as fuzzing corpus
+var parserTestLines = []string{
+	`"remotehost","rfc931","authuser","date","request","status","bytes"`,
+	`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234`,
+	`10.0.0.2,-,apache,1549573860,GET /api/user HTTP/1.0,200,1234`,
+	`"10.0.0.2","-","apache",1549573860,"GET /api HTTP/1.0",200,1234`,
+	`"10.0.0.2","-","apache",1549573860,"GET / HTTP/1.0",200,1234`,
+	`"10.0.0.2","-","apache",1549573860,"GET api HTTP/1.0",200,1234`,
+	`"10.0.0.2","-","apache",1549573860,"GET",200,1234`,
+	`"10.0.0.2","-","apache",1549573860,"GET  /api/user",200,1234`,
+	`"10.0.0.2","-","apache",-1549573860,"GET x/api/user",+200,-1234`,
+	`"10.0.0.2","-","apache",9223372036854775808,"GET /api/user HTTP/1.0",200,1234`,
+	`"10.0.0.2","-","apache",-9223372036854775808,"GET /api/user HTTP/1.0",200,1234`,
+	`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,not_a_number`,
+	`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234,extra_field`,
+	`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234,"extra""field"`,
+	`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200`,
+	`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,`,
+	`"10.0.0.2","-","ap""ache",1549573860,"GET /api/user HTTP/1.0",200,1234`,
+	`"10.0.0.2","-","apache",1549573860,"GET /api/user?a=1,2 HTTP/1.0",200,1234`,
+	`"10.0.0.2","-","apa` + "\n" + `che",1549573860,"GET /api/user HTTP/1.0",200,1234`,
+	`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234` + "\r",
+	`"10.0.0.2","-",ap"ache,1549573860,"GET /api/user HTTP/1.0",200,1234`,
+	`"10.0.0.2","-","apache"x,1549573860,"GET /api/user HTTP/1.0",200,1234`,
+	`"10.0.0.2","-","apache,1549573860,"GET /api/user HTTP/1.0",200,1234`,
+	``,
+	`,,,,,,`,
+}
+
+// referenceParse parses line the way it was done before the fast path parser
+func referenceParse(line string) (*record, rejectReason) {
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.FieldsPerRecord = -1
+	raw, err := reader.Read()
+	if err != nil {
+		return nil, reasonCSV
+	}
+	return parseRecord(raw, defaultParseOpts)
+}
+
+// fastParse parses line using lineParser
+func fastParse(p *lineParser, line string) (*record, rejectReason) {
+	r := record{}
+	if reason := p.parse([]byte(line+"\n"), &r); reason != reasonNone {
+		return nil, reason
+	}
+	return &r, reasonNone
+}
+
+func TestLineParser(t *testing.T) {
+	p := newLineParser(defaultParseOpts)
+	for i, line := range parserTestLines {
+		line := line
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			expected, expectedReason := referenceParse(line)
+			actual, actualReason := fastParse(p, line)
+			assert.Equal(t, expected, actual)
+			assert.Equal(t, expectedReason, actualReason)
+		})
+	}
+
+	r, _ := fastParse(p, parserTestLines[1])
+	require.NotNil(t, r)
+	assert.Equal(t, "/api", r.section)
+	r, _ = fastParse(p, `"10.0.0.2","-","ap""ache",1549573860,"GET /api/user HTTP/1.0",200,1234`)
+	require.NotNil(t, r)
+	assert.Equal(t, `ap"ache`, r.authuser, "parsed using fallback")
+}
+
+func TestParseInt(t *testing.T) {
+	for _, s := range []string{"0", "1", "-1", "+1", "", "-", "+", "1a", "007",
+		"9223372036854775807", "9223372036854775808", "-9223372036854775808", "-9223372036854775809",
+		"18446744073709551616", "99999999999999999999"} {
+		expected, err := strconv.ParseInt(s, 10, 64)
+		actual, ok := parseInt([]byte(s))
+		assert.Equal(t, err == nil, ok, s)
+		if ok {
+			assert.Equal(t, expected, actual, s)
+		}
+	}
+}
+
+func TestInterner(t *testing.T) {
+	in := interner{values: make(map[string]string)}
+	a := in.get([]byte("10.0.0.1"))
+	assert.Equal(t, "10.0.0.1", a)
+	assert.Equal(t, 1, len(in.values))
+	in.get([]byte("10.0.0.1"))
+	assert.Equal(t, 1, len(in.values))
+	for i := 0; i < internerLimit; i++ {
+		in.get([]byte(strconv.Itoa(i)))
+	}
+	assert.Equal(t, 1, len(in.values), "interner is flushed when full")
+}
+
+func TestLineParserInternedFields(t *testing.T) {
+	p := newLineParser(defaultParseOpts)
+	r, _ := fastParse(p, `"10.0.0.2","-","apache",1549573860,"GET /api/user/42 HTTP/1.0",200,1234`)
+	require.NotNil(t, r)
+	assert.Equal(t, "GET /api/user/42 HTTP/1.0", r.request)
+	// requests are too diverse to be interned, unlike the rest of string fields
+	assert.Equal(t, map[string]string{"10.0.0.2": "10.0.0.2", "-": "-", "apache": "apache", "/api": "/api"}, p.strings.values)
+}
+
+// testLocator locates clients using the map, counting lookups
+type testLocator struct {
+	infos   map[string]geoip.Info
+	lookups int
+}
+
+func (l *testLocator) Lookup(host string) geoip.Info {
+	l.lookups++
+	if info, ok := l.infos[host]; ok {
+		return info
+	}
+	return geoip.Info{Country: geoip.Unknown, ASN: geoip.Unknown}
+}
+
+func TestParserGeoIP(t *testing.T) {
+	geo := &testLocator{infos: map[string]geoip.Info{"81.2.69.1": {Country: "GB", ASN: "AS20712"}}}
+	filter, err := NewAlertFilter([]string{"country!=GB"})
+	require.NoError(t, err)
+	p := newLineParser(parseOpts{sections: defaultSectionRules, times: defaultTimeParser, geo: geo, filter: filter})
+	var r record
+	for i := 0; i < 2; i++ {
+		require.Equal(t, reasonNone, p.parse([]byte(`81.2.69.1,-,apache,1549573860,"GET /api/user HTTP/1.0",200,1234`+"\n"), &r))
+		assert.Equal(t, "GB", r.country)
+		assert.Equal(t, "AS20712", r.asn)
+		assert.True(t, r.filtered)
+	}
+	assert.Equal(t, 1, geo.lookups, "lookups are cached")
+	require.Equal(t, reasonNone, p.parse([]byte(`10.0.0.1,-,apache,1549573860,"GET /api/user HTTP/1.0",200,1234`+"\n"), &r))
+	assert.Equal(t, geoip.Unknown, r.country)
+	assert.Equal(t, geoip.Unknown, r.asn)
+	assert.False(t, r.filtered)
+}
+
+func BenchmarkLineParser(b *testing.B) {
+	line := []byte(`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234` + "\n")
+	b.Run("fast", func(b *testing.B) {
+		p := newLineParser(defaultParseOpts)
+		r := record{}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p.parse(line, &r)
+		}
+	})
+	b.Run("csv", func(b *testing.B) {
+		p := newLineParser(defaultParseOpts)
+		r := record{}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p.parseSlow(line, &r)
+		}
+	})
+}
@@ -0,0 +1,290 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize = 1024
+	readBufferSize   = 64 * 1024
+	// 500ms seems to be a decent compromise between missing not too much data and not burning the CPU away
+	eofSleep = 500 * time.Millisecond
+	// pause before retrying the failed read doubles from eofSleep up to this one while the reads keep failing
+	maxErrorSleep = 30 * time.Second
+)
+
+// batch is a set of consecutive raw log lines which are parsed by a single worker
+// and handed over to the aggregator as a whole, in the order they were read
+type batch struct {
+	data     []byte         // raw lines, one after another, each ending with a newline
+	ends     []int          // end offset of every line in data
+	lineNums []int          // number of the log line every line starts at
+	records  []record       // parsed records, one per line
+	reasons  []rejectReason // reasonNone for parsed lines, reject reason for the rest
+	columns  *columns       // layout of the lines, described by the latest header
+	done     chan struct{}
+}
+
+// add copies line to the batch
+func (b *batch) add(line []byte, lineNum int) {
+	b.data = append(b.data, line...)
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		b.data = append(b.data, '\n')
+	}
+	b.ends = append(b.ends, len(b.data))
+	b.lineNums = append(b.lineNums, lineNum)
+	b.records = append(b.records, record{})
+	b.reasons = append(b.reasons, reasonNone)
+}
+
+// addError adds read error instead of the line, so that it's accounted along with rejected lines
+func (b *batch) addError(err error, lineNum int) {
+	b.add([]byte(err.Error()), lineNum)
+	b.reasons[len(b.reasons)-1] = reasonRead
+}
+
+// line returns raw line number i of the batch
+func (b *batch) line(i int) []byte {
+	start := 0
+	if i > 0 {
+		start = b.ends[i-1]
+	}
+	return b.data[start:b.ends[i]]
+}
+
+// reset prepares batch for reuse
+func (b *batch) reset() {
+	b.data, b.ends, b.lineNums = b.data[:0], b.ends[:0], b.lineNums[:0]
+	b.records, b.reasons = b.records[:0], b.reasons[:0]
+}
+
+// parse every line of the batch and signal the aggregator once done
+func (b *batch) parse(p *lineParser) {
+	p.opts.columns = b.columns
+	for i := range b.ends {
+		if b.reasons[i] == reasonNone {
+			b.reasons[i] = p.parse(b.line(i), &b.records[i])
+		}
+	}
+	close(b.done)
+}
+
+// pipeline splits the log into batches of lines, parses them in parallel using a pool of workers
+// and hands them over in the original order through the ordered channel.
+// Amount of batches in flight is bounded by the capacity of ordered channel.
+type pipeline struct {
+	reader    io.Reader
+	batchSize int
+	workers   int
+	opts      parseOpts
+	stopAtEOF bool // the log is read till its end only, instead of waiting for lines to be appended
+
+	jobs    chan *batch // batches waiting for a worker
+	ordered chan *batch // batches in the order they were read, closed at the end of the log if stopAtEOF is set
+	ended   bool        // ordered channel was closed, set by next
+	pool    sync.Pool
+	sleep   func(ctx context.Context, d time.Duration) bool // pause after the read error, overwritten in tests
+}
+
+func newPipeline(reader io.Reader, workers, batchSize int, opts parseOpts) *pipeline {
+	p := &pipeline{
+		reader:    reader,
+		batchSize: batchSize,
+		workers:   workers,
+		opts:      opts,
+		jobs:      make(chan *batch, workers),
+		ordered:   make(chan *batch, workers*2),
+		sleep:     sleepContext,
+	}
+	p.pool.New = func() interface{} { return &batch{} }
+	return p
+}
+
+// start reader and parser workers, all of them terminate when context is cancelled
+func (p *pipeline) start(ctx context.Context) {
+	for i := 0; i < p.workers; i++ {
+		go p.parseBatches(ctx)
+	}
+	go p.readLines(ctx)
+}
+
+// next returns the next parsed batch, or nil if context was cancelled, the log ended or tick came before
+// the next batch was read; batch should be returned with release after use
+func (p *pipeline) next(ctx context.Context, tick <-chan time.Time) *batch {
+	select {
+	case b, ok := <-p.ordered:
+		if !ok {
+			p.ended = true
+			return nil
+		}
+		select {
+		case <-b.done:
+			return b
+		case <-ctx.Done():
+			return nil
+		}
+	case <-tick:
+		return nil
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// release batch for reuse
+func (p *pipeline) release(b *batch) {
+	b.reset()
+	p.pool.Put(b)
+}
+
+// parseBatches parses batches from jobs channel until context is cancelled
+func (p *pipeline) parseBatches(ctx context.Context) {
+	parser := newLineParser(p.opts)
+	for {
+		select {
+		case b := <-p.jobs:
+			b.parse(parser)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readLines splits the log into lines and sends them to workers in batches,
+// sleeps on EOF so that lines could be appended to the log file, unless stopAtEOF is set.
+// Wouldn't be terminated using context while blocked on read,
+// but would reliably terminate in tests with properly constructed Reader.
+func (p *pipeline) readLines(ctx context.Context) {
+	r := bufio.NewReaderSize(p.reader, readBufferSize)
+	layout := defaultColumns
+	headers := newCSVDecoder()
+	b := p.newBatch(layout)
+	var line []byte              // current line, could span multiple reads
+	var quotes int               // number of quotes in the current line, odd number means newline is quoted
+	var idle bool                // true if nothing was read since the last EOF
+	var lineNum = 1              // number of the log line the current line starts at
+	var errorSleep time.Duration // pause after the last read error, zero if the last read succeeded
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		chunk, err := r.ReadSlice('\n')
+		if len(chunk) > 0 {
+			idle = false
+		}
+		line = append(line, chunk...)
+		quotes += bytes.Count(chunk, []byte{'"'})
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == nil && quotes%2 == 1 {
+			// newline is inside the quoted field, record continues on the next line
+			continue
+		}
+		// unterminated line at EOF is likely still being written, so it's flushed only
+		// when nothing is appended to it for the whole sleep period, or if nothing would be appended at all
+		end := err == io.EOF && p.stopAtEOF
+		if err == nil || ((idle || end) && len(line) > 0) {
+			// blank lines are skipped the same way encoding/csv does it
+			if len(bytes.TrimRight(line, "\r\n")) > 0 {
+				// lines after the header are parsed with its layout, so batch can't span the header changing it
+				if next := headerLayout(headers, line); next != nil && *next != *layout {
+					if len(b.ends) > 0 {
+						if !p.send(ctx, b) {
+							return
+						}
+						b = p.newBatch(next)
+					}
+					layout, b.columns = next, next
+				}
+				b.add(line, lineNum)
+			}
+			lineNum += bytes.Count(line, []byte{'\n'})
+			line, quotes = line[:0], 0
+		}
+		if err != nil && err != io.EOF {
+			// persistent error is reported once, not on every retry
+			if errorSleep == 0 {
+				b.addError(err, lineNum)
+			}
+		} else {
+			errorSleep = 0
+		}
+		if err == nil && len(b.ends) < p.batchSize {
+			continue
+		}
+		if len(b.ends) > 0 {
+			if !p.send(ctx, b) {
+				return
+			}
+			b = p.newBatch(layout)
+		}
+		if end {
+			close(p.ordered)
+			return
+		}
+		if err == io.EOF {
+			idle = true
+			time.Sleep(eofSleep)
+		} else if err != nil {
+			// errors other than EOF are reported in the batch and then retried with growing pauses
+			idle = true
+			errorSleep = nextErrorSleep(errorSleep)
+			if !p.sleep(ctx, errorSleep) {
+				return
+			}
+		}
+	}
+}
+
+// nextErrorSleep returns the pause after the read error which follows the pause after the previous one
+func nextErrorSleep(previous time.Duration) time.Duration {
+	if previous == 0 {
+		return eofSleep
+	}
+	if previous*2 > maxErrorSleep {
+		return maxErrorSleep
+	}
+	return previous * 2
+}
+
+// newBatch returns empty batch for the lines with provided layout
+func (p *pipeline) newBatch(layout *columns) *batch {
+	b := p.pool.Get().(*batch)
+	b.columns = layout
+	return b
+}
+
+// headerLayout returns the layout described by the line if it's the header, nil otherwise
+func headerLayout(decoder *csvDecoder, line []byte) *columns {
+	if !isHeader(line) {
+		return nil
+	}
+	raw, err := decoder.decode(line)
+	if err != nil {
+		return defaultColumns
+	}
+	return newColumns(raw)
+}
+
+// send batch to the workers, keeping track of the order
+func (p *pipeline) send(ctx context.Context, b *batch) bool {
+	b.done = make(chan struct{})
+	select {
+	case p.ordered <- b:
+	case <-ctx.Done():
+		return false
+	}
+	select {
+	case p.jobs <- b:
+	case <-ctx.Done():
+		return false
+	}
+	return true
+}
@@ -0,0 +1,167 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/paskal/datadog-parser/app/useragent"
+)
+
+func TestPipelineOrder(t *testing.T) {
+	var log strings.Builder
+	const lines = 10000
+	for i := 0; i < lines; i++ {
+		log.WriteString(`"10.0.0.1","-","apache",` + strconv.Itoa(1549573860+i) + `,"GET /api/user HTTP/1.0",200,1234` + "\n")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := newPipeline(strings.NewReader(log.String()), 8, 13, defaultParseOpts)
+	p.start(ctx)
+
+	var got int
+	for got < lines {
+		b := p.next(ctx, nil)
+		require.NotNil(t, b)
+		for i := range b.records {
+			require.Equal(t, reasonNone, b.reasons[i])
+			require.Equal(t, int64(1549573860+got), b.records[i].date.Unix())
+			got++
+		}
+		p.release(b)
+	}
+}
+
+func TestPipelineLines(t *testing.T) {
+	log := `"remotehost","rfc931","authuser","date","request","status","bytes"
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+
+"10.0.0.3","-","multi
+line",1549573861,"GET /report HTTP/1.0",200,1234
+"10.0.0.4","-","apache",1549573862,"GET /api/help HTTP/1.0",200,1234`
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := newPipeline(strings.NewReader(log), 2, 100, defaultParseOpts)
+	p.start(ctx)
+
+	// unterminated last line is flushed after the EOF sleep
+	b := p.next(ctx, nil)
+	require.NotNil(t, b)
+	assert.Equal(t, []rejectReason{reasonHeader, reasonNone, reasonNone}, b.reasons, "blank line is skipped")
+	assert.Equal(t, []int{1, 2, 4}, b.lineNums)
+	assert.Equal(t, "multi\nline", b.records[2].authuser)
+	assert.Equal(t, "/report", b.records[2].section)
+	p.release(b)
+
+	b = p.next(ctx, nil)
+	require.NotNil(t, b)
+	assert.Equal(t, []rejectReason{reasonNone}, b.reasons)
+	assert.Equal(t, []int{6}, b.lineNums)
+	assert.Equal(t, "10.0.0.4", b.records[0].remotehost)
+	p.release(b)
+}
+
+// appendReader is a log file which is written to while being read
+type appendReader struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (a *appendReader) Read(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return a.buf.Read(p)
+}
+
+func (a *appendReader) write(s string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.buf.WriteString(s)
+}
+
+func TestPipelineHeaderLayout(t *testing.T) {
+	log := `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"remotehost","rfc931","authuser","date","request","status","bytes","useragent"
+"10.0.0.2","-","apache",1549573861,"GET /api/user HTTP/1.0",200,1234,"curl/8.4.0"
+"10.0.0.2","-","apache",1549573862,"GET /api/user HTTP/1.0",200,1234
+`
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := defaultParseOpts
+	opts.agents = useragent.Default
+	p := newPipeline(strings.NewReader(log), 2, 100, opts)
+	p.start(ctx)
+
+	// batch is sent before the header changing the layout
+	b := p.next(ctx, nil)
+	require.NotNil(t, b)
+	assert.Equal(t, []rejectReason{reasonNone}, b.reasons)
+	assert.Equal(t, defaultColumns, b.columns)
+	p.release(b)
+
+	b = p.next(ctx, nil)
+	require.NotNil(t, b)
+	assert.Equal(t, []rejectReason{reasonHeader, reasonNone, reasonFields}, b.reasons)
+	assert.Equal(t, &columns{count: 8, userAgent: 7}, b.columns)
+	assert.Equal(t, "curl/8.4.0", b.records[1].userAgent)
+	assert.True(t, b.records[1].agent.Bot)
+	p.release(b)
+}
+
+func TestPipelineTail(t *testing.T) {
+	r := &appendReader{}
+	r.write(`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234` + "\n" + `"10.0.0.3","-","apa`)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := newPipeline(r, 1, 100, defaultParseOpts)
+	p.start(ctx)
+
+	b := p.next(ctx, nil)
+	require.NotNil(t, b)
+	assert.Equal(t, 1, len(b.records))
+	p.release(b)
+
+	// the rest of the line is appended before the end of the sleep, so the line is not split
+	time.Sleep(eofSleep / 2)
+	r.write(`che",1549573861,"GET /report HTTP/1.0",200,1234` + "\n")
+	b = p.next(ctx, nil)
+	require.NotNil(t, b)
+	assert.Equal(t, []rejectReason{reasonNone}, b.reasons)
+	assert.Equal(t, "apache", b.records[0].authuser)
+	p.release(b)
+
+	cancel()
+	assert.Nil(t, p.next(ctx, nil))
+}
+
+// BenchmarkParseBatch measures single worker parsing speed
+func BenchmarkParseBatch(b *testing.B) {
+	bt := &batch{}
+	for i := 0; i < defaultBatchSize; i++ {
+		bt.add([]byte(`"10.0.0.`+strconv.Itoa(i%250)+`","-","apache",`+strconv.Itoa(1549573860+i/100)+
+			`,"GET /section`+strconv.Itoa(i%50)+`/user HTTP/1.0",200,1234`+"\n"), i+1)
+	}
+	parser := newLineParser(defaultParseOpts)
+	b.ReportAllocs()
+	b.ResetTimer()
+	began := time.Now()
+	for i := 0; i < b.N; i++ {
+		for j := range bt.reasons {
+			bt.reasons[j] = reasonNone
+		}
+		bt.done = make(chan struct{})
+		bt.parse(parser)
+	}
+	b.ReportMetric(float64(b.N*defaultBatchSize)/time.Since(began).Seconds(), "lines/s")
+}
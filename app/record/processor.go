@@ -2,221 +2,394 @@ package record
 
 import (
 	"context"
+	"encoding/csv"
 	"fmt"
 	"io"
-	"sort"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/paskal/datadog-parser/app/anomaly"
+	"github.com/paskal/datadog-parser/app/geoip"
+	"github.com/paskal/datadog-parser/app/rollup"
+	"github.com/paskal/datadog-parser/app/silence"
+	"github.com/paskal/datadog-parser/app/sketch"
+	"github.com/paskal/datadog-parser/app/useragent"
 )
 
-const reportInterval = time.Second * 10
+const (
+	reportInterval  = time.Second * 10
+	alertTopClients = 3 // number of top clients and users shown in the alert
+	// idleInterval is how often alerts are checked by the wall clock while no records arrive
+	idleInterval = time.Second
+)
 
 var printFunction = fmt.Printf // overwritten in tests
 
-// Reader is a subset or csv.Reader functions used by the application
-type Reader interface {
-	Read() ([]string, error)
-}
-
-// Processor goes through records in provided channel and prints alerts and stats on them
+// Processor goes through records from provided CSV log and prints alerts and stats on them
 type Processor struct {
-	LogReader               Reader
+	LogReader               io.Reader
 	AlertWindow             time.Duration
 	AlertThresholdPerSecond int
+	AlertBandwidthPerSecond int               // bytes per second to fire the bandwidth alert on, disabled if not set
+	Workers                 int               // number of parser goroutines, number of CPUs if not set
+	BatchSize               int               // number of lines parsed by a worker at once, 1024 if not set
+	Rejects                 io.Writer         // rejected lines are written there as CSV with line number and reason, if set
+	RejectsWarnRatio        float64           // share of rejected lines since the previous report to print a warning on
+	Sections                *SectionRules     // first path segment is used as the section if not set
+	Timestamps              *TimeParser       // timestamps format is detected automatically if not set
+	OutputTZ                *time.Location    // timezone of the output, UTC if not set
+	BucketResolution        time.Duration     // precision of the sliding window, one second if not set
+	UsersPrecision          uint8             // HyperLogLog precision for unique users estimation, exact count if not set
+	HeavyHitters            int               // number of keys tracked per bucket for every breakdown, exact count if not set
+	TopTalkers              int               // number of top clients and users printed with every report, none if not set
+	SizeSections            int               // number of top sections with response size percentiles in every report, none if not set
+	LatencySections         int               // number of top sections with latency percentiles in every report, only overall ones if not set
+	LatencyAlerts           []LatencyRule     // latency percentile alerts
+	SLOs                    []SLO             // objectives with burn rate alerts and error budget in every report
+	LowTrafficAlerts        []LowTrafficRule  // alerts on the rate of hits lower than the threshold
+	NoDataAlert             time.Duration     // time without records to fire the alert on, disabled if not set
+	Silences                *silence.Store    // silenced alert state changes are only printed in JSON output, if set
+	Routing                 *Routing          // alert state changes are grouped into notifications, printed as is if not set
+	AlertHistory            io.Writer         // alert state changes are appended there as JSON lines, if set
+	Rollups                 *rollup.Store     // traffic is summarized there per interval of its first tier, if set
+	Anomaly                 anomaly.Baseline  // learned baseline of hits per second replacing AlertThresholdPerSecond, if set
+	AnomalySigma            float64           // standard deviations from the baseline to fire the anomaly alert on
+	AnomalySave             BaselineSaver     // persists the baseline at most once per anomalyInterval of the wall clock, if set
+	Labels                  *Labels           // client networks labels, clients are not grouped if not set
+	AlertFilter             *AlertFilter      // records counted by the alert, all of them if not set
+	GeoIP                   *geoip.DB         // country and ASN database, clients are not located if not set
+	Agents                  *useragent.Parser // user agents parser, bundled bot patterns are used if not set
+	JSON                    bool              // print reports and alerts as JSON lines
+	StopAtEnd               bool              // stop at the end of the log instead of waiting for lines appended to it
 
-	alertState     bool
-	lastReport     time.Time
-	records        chan []string
-	history        map[int64]historyRecord
-	historicalHits int
+	alerts           []alertRule
+	slos             []*sloTracker
+	router           *router
+	incidents        map[alertRule]*incident  // firing alerts, tracked only if AlertHistory is set
+	rollups          map[int64]*rollup.Rollup // rollups which are not written yet, by unix time of their start
+	lowTraffic       []*lowTrafficAlert
+	latency          []*latencyAlert
+	lastReport       time.Time
+	lastEntry        time.Time        // date of the newest record
+	lastArrival      time.Time        // wall clock time the last records arrived at
+	started          time.Time        // wall clock time the processing started at
+	now              func() time.Time // wall clock, overwritten in tests
+	history          *window
+	rejected         rejectStats // all lines since start
+	intervalRejected rejectStats // lines since the previous report
+	rejectsWriter    *csv.Writer
 }
 
-// Start processes new record from provided Reader
-// should be called once, is not thread-safe
-func (l *Processor) Start(ctx context.Context) {
-	l.records = make(chan []string)
-	l.history = make(map[int64]historyRecord)
-
-	go l.readLogRecords(ctx)
+// Start processes new records from provided LogReader till the context is cancelled, or till the end
+// of the log if StopAtEnd is set; should be called once, is not thread-safe. Returns error right away
+// if the alert windows can't be kept with the bucket resolution or unique users precision.
+func (l *Processor) Start(ctx context.Context) error {
+	opts, err := l.prepare()
+	if err != nil {
+		return err
+	}
+	p := newPipeline(l.LogReader, l.Workers, l.BatchSize, opts)
+	p.stopAtEOF = l.StopAtEnd
+	p.start(ctx)
 
+	// absence of records can't be noticed by records, so alerts are checked by the wall clock as well
+	idle := time.NewTicker(idleInterval)
+	defer idle.Stop()
 	for {
-		select {
-		case record := <-l.records:
-			l.processRecord(record)
-		case <-ctx.Done():
-			return
+		b := p.next(ctx, idle.C)
+		if b == nil {
+			if ctx.Err() != nil || p.ended {
+				if l.Rollups != nil {
+					l.writeRollups(l.lastEntry.Add(l.Rollups.Resolution()))
+				}
+				if l.lastEntry.IsZero() {
+					// no report was printed to warn about rejected lines along with it
+					l.printRejectsWarning(l.now())
+				}
+				return nil
+			}
+			// log time goes on along with the wall clock only while the log is followed
+			if !l.StopAtEnd {
+				l.idle()
+			}
+			continue
+		}
+		l.lastArrival = l.now()
+		for i := range b.records {
+			if b.reasons[i] != reasonNone {
+				l.reject(b.line(i), b.lineNums[i], b.reasons[i])
+				continue
+			}
+			l.aggregate(&b.records[i])
+			l.rejected.add(reasonNone)
+			l.intervalRejected.add(reasonNone)
 		}
+		l.flushRejects()
+		p.release(b)
 	}
 }
 
-// processRecord processes new record
-func (l *Processor) processRecord(rawRecord []string) {
-	r := parseRecord(rawRecord)
-	if r == nil {
-		return
+// prepare sets the defaults, checks the windows could be kept in memory and creates the state of the processing,
+// returning options of the log parser
+func (l *Processor) prepare() (parseOpts, error) {
+	if l.Workers <= 0 {
+		l.Workers = runtime.NumCPU()
+	}
+	if l.BatchSize <= 0 {
+		l.BatchSize = defaultBatchSize
+	}
+	if l.Sections == nil {
+		l.Sections = defaultSectionRules
+	}
+	if l.Timestamps == nil {
+		l.Timestamps = defaultTimeParser
+	}
+	if l.OutputTZ == nil {
+		l.OutputTZ = time.UTC
+	}
+	if l.BucketResolution <= 0 {
+		l.BucketResolution = time.Second
+	}
+	if err := CheckResolution(l.AlertWindow, l.BucketResolution); err != nil {
+		return parseOpts{}, err
+	}
+	if l.UsersPrecision != 0 {
+		if err := sketch.CheckPrecision(l.UsersPrecision); err != nil {
+			return parseOpts{}, err
+		}
+		if err := CheckUsersPrecision(l.AlertWindow, l.BucketResolution, l.UsersPrecision); err != nil {
+			return parseOpts{}, err
+		}
+	}
+	for _, rule := range l.LatencyAlerts {
+		if err := CheckResolution(rule.Window, l.BucketResolution); err != nil {
+			return parseOpts{}, fmt.Errorf("%s alert: %w", rule.name(), err)
+		}
+	}
+	l.history = newWindow(l.AlertWindow, l.BucketResolution, historyOpts{usersPrecision: l.UsersPrecision,
+		heavyHitters: l.HeavyHitters, sizes: l.SizeSections > 0})
+	for _, slo := range l.SLOs {
+		l.slos = append(l.slos, newSLOTracker(slo))
+	}
+	for _, rule := range l.LowTrafficAlerts {
+		l.lowTraffic = append(l.lowTraffic, newLowTrafficAlert(rule))
+	}
+	for _, rule := range l.LatencyAlerts {
+		if rule.Window == 0 {
+			rule.Window = l.AlertWindow
+		}
+		l.latency = append(l.latency, newLatencyAlert(rule, l.BucketResolution))
+	}
+	if l.now == nil {
+		l.now = time.Now
+	}
+	l.started = l.now()
+	l.alerts = l.alertRules()
+	if l.Routing != nil {
+		l.router = newRouter(*l.Routing)
+	}
+	if l.Rollups != nil {
+		l.rollups = map[int64]*rollup.Rollup{}
+	}
+	if l.Rejects != nil {
+		l.rejectsWriter = csv.NewWriter(l.Rejects)
 	}
 
-	l.historicalHits++
-	ts := r.date.Unix()
-	history, ok := l.history[ts]
-	if !ok {
-		history = newHistoryRecord()
+	if l.Agents == nil {
+		l.Agents = useragent.Default
 	}
-	history.add(r)
-	l.history[ts] = history
+	opts := parseOpts{sections: l.Sections, times: l.Timestamps, labels: l.Labels, agents: l.Agents, filter: l.AlertFilter}
+	if l.GeoIP != nil {
+		opts.geo = l.GeoIP
+	}
+	return opts, nil
+}
 
+// aggregate adds parsed record to the history, printing stats and alerts if needed
+func (l *Processor) aggregate(r *record) {
 	if l.lastReport.Equal(time.Time{}) {
 		l.lastReport = r.date
 	}
 
 	if r.date.Sub(l.lastReport) >= reportInterval {
 		// we need to print the report on entries before the last one, as new log entry might be hours away from previous one
-		l.printReport(l.findPreLastReport(r.date))
+		preLast := l.findPreLastReport(r.date)
+		l.printReport(preLast)
+		l.printRejectsWarning(preLast)
 		l.lastReport = r.date
 	}
-	l.cleanHistory(r.date)
+	if r.date.After(l.lastEntry) {
+		l.lastEntry = r.date
+	}
+	l.history.add(r)
+	for _, t := range l.slos {
+		t.add(r)
+	}
+	for _, a := range l.lowTraffic {
+		a.add(r)
+	}
+	for _, a := range l.latency {
+		a.add(r)
+	}
+	for _, i := range l.incidents {
+		i.add(r)
+	}
+	if l.Rollups != nil {
+		l.addRollup(r)
+	}
 	l.recalculateAlerts(r.date)
 }
 
-// findPreLastReport finds the date of the last report before the provided time
-func (l *Processor) findPreLastReport(lastEntry time.Time) time.Time {
-	preLastEntryTime := time.Time{}
-	for k := range l.history {
-		t := time.Unix(k, 0)
-		if t.Before(lastEntry) && !t.Equal(lastEntry) && preLastEntryTime.Before(t) {
-			preLastEntryTime = t
-		}
+// idle checks alerts when no records arrived for a while. Time of the log is assumed to go on
+// along with the wall clock since the last records arrived, and the alert window moves with it.
+func (l *Processor) idle() {
+	if l.lastEntry.IsZero() {
+		l.idleBeforeRecords()
+		return
+	}
+	elapsed := l.now().Sub(l.lastArrival)
+	if elapsed < idleInterval {
+		return
+	}
+	currentTime := l.lastEntry.Add(elapsed)
+	if key := l.history.key(currentTime); key > l.history.head {
+		l.history.advance(key)
+	}
+	if l.Rollups != nil {
+		l.writeRollups(currentTime.Add(-l.Rollups.Resolution()))
 	}
-	return preLastEntryTime
+	l.recalculateAlerts(currentTime)
 }
 
-// printReport for the reportInterval
-func (l *Processor) printReport(lastEntry time.Time) {
-	stats := newHistoryRecord()
-	for k, v := range l.history {
-		// we check if the entry is before the last one to prevent the very last log entry from being counted
-		if !time.Unix(k, 0).After(lastEntry) && lastEntry.Sub(time.Unix(k, 0)) <= reportInterval {
-			stats.append(v)
+// idleBeforeRecords checks the no data alert when no records arrived since the start. There is no log time
+// to go on from yet, so the absence is measured by the wall clock and other alerts are not checked.
+// Rejected lines are warned about by the wall clock as well, as there are no reports to do it along with.
+func (l *Processor) idleBeforeRecords() {
+	currentTime := l.now()
+	if currentTime.Sub(l.started) < idleInterval {
+		return
+	}
+	l.printRejectsWarning(currentTime)
+	for _, a := range l.alerts {
+		if a, ok := a.(*noDataAlert); ok {
+			a.check(l, currentTime)
 		}
 	}
-	var topSection string
-	var topHits int
-
-	// sort sections so that they appear in the output in the same order reliably
-	sections := []string{}
-	for k := range stats.sections {
-		sections = append(sections, k)
+	if l.router != nil {
+		l.router.flush(l, currentTime)
 	}
-	sort.Strings(sections)
+}
 
-	for _, k := range sections {
-		if topHits == stats.sections[k] {
-			topSection += " and " + k
-		}
-		if topHits < stats.sections[k] {
-			topHits = stats.sections[k]
-			topSection = k
-		}
+// findPreLastReport finds the date of the last report before the provided time
+func (l *Processor) findPreLastReport(lastEntry time.Time) time.Time {
+	key, ok := l.history.latestBefore(l.history.key(lastEntry))
+	if !ok {
+		return time.Time{}
 	}
-
-	printFunction("%s: %d hits from %d users with %d bytes transferred, top %s with %d hits\n", //nolint:errcheck
-		lastEntry.In(time.UTC),
-		stats.hits,
-		len(stats.uniqueUsers),
-		stats.bytesTransferred,
-		topSection,
-		topHits,
-	)
+	return l.history.start(key)
 }
 
-// cleanHistory drops history older than AlertWindow from specified date
-func (l *Processor) cleanHistory(d time.Time) {
-	for k := range l.history {
-		if d.Sub(time.Unix(k, 0)) > l.AlertWindow {
-			l.historicalHits -= l.history[k].hits
-			delete(l.history, k)
-		}
+// printReport for the reportInterval
+func (l *Processor) printReport(lastEntry time.Time) {
+	// entry itself is included, so the report covers reportInterval before it and the entry's bucket
+	last := l.history.key(lastEntry)
+	stats := l.history.collect(last-int64(reportInterval/l.BucketResolution), last)
+	// sections with the same number of hits are shown together, in alphabetical order
+	top := leaders(stats.sections.top(0))
+	var breakdowns []breakdown
+	if l.TopTalkers > 0 {
+		breakdowns = l.breakdowns(stats)
+	}
+	sizes := stats.sizes.summary(stats.sections.top(l.SizeSections))
+	slos := make([]sloStatus, len(l.slos))
+	for i, t := range l.slos {
+		slos[i] = t.status(lastEntry)
+	}
+	var latencies []summary
+	if l.LatencySections > 0 {
+		latencies = stats.latencies.summary(stats.sections.top(l.LatencySections))
+	} else {
+		latencies = stats.latencies.summary(nil)
 	}
-}
 
-// recalculateAlerts recalculates alert state
-func (l *Processor) recalculateAlerts(currentTime time.Time) {
-	hitsPerSecond := float64(l.historicalHits) / l.AlertWindow.Seconds()
-
-	if hitsPerSecond > float64(l.AlertThresholdPerSecond) {
-		if !l.alertState {
-			printFunction("%s: Alert RED, ~%.2f hits per second which is higher than %d (%d total) in the last %s\n", //nolint:errcheck
-				currentTime.In(time.UTC),
-				hitsPerSecond,
-				l.AlertThresholdPerSecond,
-				l.historicalHits,
-				l.AlertWindow,
-			)
-			l.alertState = true
+	if l.JSON {
+		report := jsonReport{
+			Time:        lastEntry.In(l.OutputTZ),
+			Type:        "report",
+			Hits:        stats.hits,
+			Users:       stats.uniqueUsers.count(),
+			UsersError:  stats.uniqueUsers.stdError(),
+			Bytes:       stats.bytesTransferred,
+			TopSections: jsonCounts(top),
+		}
+		if stats.agentHits > 0 {
+			report.Agents = &jsonAgents{Hits: stats.agentHits, Bots: stats.botHits}
+		}
+		if len(breakdowns) > 0 {
+			report.Top = make(map[string][]jsonCount, len(breakdowns))
+			for _, b := range breakdowns {
+				report.Top[b.name] = jsonCounts(b.top)
+			}
 		}
+		report.Sizes = jsonSizes(sizes)
+		report.Latencies = jsonLatencies(latencies)
+		report.SLOs = jsonSLOs(slos)
+		printJSON(report)
 		return
 	}
 
-	if l.alertState {
-		printFunction("%s: Alert GREEN, ~%.2f hits per second which is lower than %d (%d total) in the last %s\n", //nolint:errcheck
-			currentTime.In(time.UTC),
-			hitsPerSecond,
-			l.AlertThresholdPerSecond,
-			l.historicalHits,
-			l.AlertWindow,
-		)
-		l.alertState = false
+	topSections := make([]string, len(top))
+	for i, c := range top {
+		topSections[i] = c.key
 	}
-}
-
-// readLogRecords sends new log reader to provided channel,
-// wouldn't be terminated using context unless there is new log entry,
-// but would reliably terminate in tests with properly constructed Reader
-func (l *Processor) readLogRecords(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-		}
-		record, err := l.LogReader.Read()
-		// ignore all errors, sleep on EOF so that lines could be appended to the log file
-		if err == io.EOF {
-			// 500ms seems to be a decent compromise between missing not too much data and not burning the CPU away
-			time.Sleep(500 * time.Millisecond)
-			continue
-		}
-		l.records <- record
+	topHits := "0 hits"
+	if len(top) > 0 {
+		topHits = formatCount(top[0], "hits")
 	}
-}
 
-type historyRecord struct { // key is unix timestamp
-	bytesTransferred int                 // used for stats
-	hits             int                 // used for alerting
-	sections         map[string]int      // hit stats per section
-	uniqueUsers      map[string]struct{} // unique user counter
-}
+	users := strconv.Itoa(stats.uniqueUsers.count()) + " users"
+	if stdErr := stats.uniqueUsers.stdError(); stdErr > 0 {
+		users = fmt.Sprintf("~%s (±%.2f%%)", users, stdErr*100)
+	}
 
-func newHistoryRecord() historyRecord {
-	return historyRecord{
-		sections:    make(map[string]int),
-		uniqueUsers: make(map[string]struct{}),
+	var bots string
+	if stats.agentHits > 0 {
+		bots = fmt.Sprintf(", %d human and %d bot hits (%.2f%% bots)", stats.agentHits-stats.botHits, stats.botHits,
+			float64(stats.botHits)/float64(stats.agentHits)*100)
 	}
-}
 
-func (h *historyRecord) add(r *record) {
-	h.bytesTransferred += r.bytes
-	h.sections[r.section]++
-	h.uniqueUsers[r.remotehost] = struct{}{}
-	h.hits++
+	printFunction("%s: %d hits from %s with %d bytes transferred, top %s with %s%s\n", //nolint:errcheck
+		lastEntry.In(l.OutputTZ),
+		stats.hits,
+		users,
+		stats.bytesTransferred,
+		strings.Join(topSections, " and "),
+		topHits,
+		bots,
+	)
+	if len(breakdowns) > 0 {
+		printFunction("%s: %s\n", lastEntry.In(l.OutputTZ), formatBreakdowns(breakdowns)) //nolint:errcheck
+	}
+	if len(sizes) > 0 {
+		printFunction("%s: response sizes %s\n", lastEntry.In(l.OutputTZ), formatSizes(sizes)) //nolint:errcheck
+	}
+	if len(latencies) > 0 {
+		printFunction("%s: latencies %s\n", lastEntry.In(l.OutputTZ), formatLatencies(latencies)) //nolint:errcheck
+	}
+	if len(slos) > 0 {
+		printFunction("%s: %s\n", lastEntry.In(l.OutputTZ), formatSLOs(slos)) //nolint:errcheck
+	}
 }
 
-func (h *historyRecord) append(new historyRecord) {
-	h.bytesTransferred += new.bytesTransferred
-	h.hits += new.hits
-	for s := range new.sections {
-		h.sections[s]++
+// recalculateAlerts recalculates state of all alerts
+func (l *Processor) recalculateAlerts(currentTime time.Time) {
+	for _, a := range l.alerts {
+		a.check(l, currentTime)
 	}
-	for u := range new.uniqueUsers {
-		h.uniqueUsers[u] = struct{}{}
+	l.measureIncidents()
+	if l.router != nil {
+		l.router.flush(l, currentTime)
 	}
 }
@@ -2,14 +2,15 @@ package record
 
 import (
 	"context"
-	"encoding/csv"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const sampleCsvOutput = `2019-02-07 21:11:09 +0000 UTC: 81 hits from 5 users with 99752 bytes transferred, top /api with 11 hits
@@ -21,7 +22,7 @@ const sampleCsvOutput = `2019-02-07 21:11:09 +0000 UTC: 81 hits from 5 users wit
 2019-02-07 21:12:09 +0000 UTC: 171 hits from 5 users with 210066 bytes transferred, top /api and /report with 11 hits
 2019-02-07 21:12:19 +0000 UTC: 181 hits from 5 users with 222160 bytes transferred, top /api with 11 hits
 2019-02-07 21:12:29 +0000 UTC: 182 hits from 5 users with 223896 bytes transferred, top /api and /report with 11 hits
-2019-02-07 21:12:36 +0000 UTC: Alert RED, ~10.01 hits per second which is higher than 10 (1201 total) in the last 2m0s
+2019-02-07 21:12:36 +0000 UTC: Alert RED, ~10.01 hits per second which is higher than 10 (1201 total) in the last 2m0s, top clients 10.0.0.1 (409 hits), 10.0.0.2 (267 hits), 10.0.0.5 (252 hits), by bytes 10.0.0.1 (501836 bytes), 10.0.0.2 (328560 bytes), 10.0.0.5 (310142 bytes), top users apache (1201 hits)
 2019-02-07 21:12:39 +0000 UTC: 191 hits from 5 users with 235645 bytes transferred, top /api with 11 hits
 2019-02-07 21:12:49 +0000 UTC: 178 hits from 5 users with 219456 bytes transferred, top /api and /report with 11 hits
 2019-02-07 21:12:59 +0000 UTC: 190 hits from 5 users with 233590 bytes transferred, top /api and /report with 11 hits
@@ -32,7 +33,7 @@ const sampleCsvOutput = `2019-02-07 21:11:09 +0000 UTC: 81 hits from 5 users wit
 2019-02-07 21:13:49 +0000 UTC: 32 hits from 5 users with 39501 bytes transferred, top /api with 11 hits
 2019-02-07 21:13:59 +0000 UTC: 33 hits from 5 users with 40646 bytes transferred, top /api with 10 hits
 2019-02-07 21:14:04 +0000 UTC: Alert GREEN, ~9.97 hits per second which is lower than 10 (1197 total) in the last 2m0s
-2019-02-07 21:14:04 +0000 UTC: Alert RED, ~10.01 hits per second which is higher than 10 (1201 total) in the last 2m0s
+2019-02-07 21:14:04 +0000 UTC: Alert RED, ~10.01 hits per second which is higher than 10 (1201 total) in the last 2m0s, top clients 10.0.0.1 (388 hits), 10.0.0.2 (279 hits), 10.0.0.5 (278 hits), by bytes 10.0.0.1 (477508 bytes), 10.0.0.2 (343760 bytes), 10.0.0.5 (342495 bytes), top users apache (1201 hits)
 2019-02-07 21:14:05 +0000 UTC: Alert GREEN, ~9.89 hits per second which is lower than 10 (1187 total) in the last 2m0s
 2019-02-07 21:14:09 +0000 UTC: 30 hits from 5 users with 37185 bytes transferred, top /api with 10 hits
 2019-02-07 21:14:18 +0000 UTC: 32 hits from 5 users with 38818 bytes transferred, top /api and /report with 10 hits
@@ -46,7 +47,7 @@ const sampleCsvOutput = `2019-02-07 21:11:09 +0000 UTC: 81 hits from 5 users wit
 2019-02-07 21:15:39 +0000 UTC: 257 hits from 5 users with 316992 bytes transferred, top /api and /report with 11 hits
 2019-02-07 21:15:49 +0000 UTC: 287 hits from 5 users with 353279 bytes transferred, top /api with 11 hits
 2019-02-07 21:15:59 +0000 UTC: 283 hits from 5 users with 348392 bytes transferred, top /api with 11 hits
-2019-02-07 21:16:03 +0000 UTC: Alert RED, ~10.01 hits per second which is higher than 10 (1201 total) in the last 2m0s
+2019-02-07 21:16:03 +0000 UTC: Alert RED, ~10.01 hits per second which is higher than 10 (1201 total) in the last 2m0s, top clients 10.0.0.1 (411 hits), 10.0.0.2 (280 hits), 10.0.0.5 (250 hits), by bytes 10.0.0.1 (505325 bytes), 10.0.0.2 (344178 bytes), 10.0.0.5 (307023 bytes), top users apache (1201 hits)
 2019-02-07 21:16:09 +0000 UTC: 283 hits from 5 users with 347894 bytes transferred, top /api and /report with 11 hits
 2019-02-07 21:16:19 +0000 UTC: 284 hits from 5 users with 348956 bytes transferred, top /api with 11 hits
 2019-02-07 21:16:29 +0000 UTC: 280 hits from 5 users with 344621 bytes transferred, top /api with 11 hits
@@ -68,27 +69,389 @@ const sampleCsvOutput = `2019-02-07 21:11:09 +0000 UTC: 81 hits from 5 users wit
 `
 
 func TestSampleCSV(t *testing.T) {
-	f, err := os.Open("../../sample.csv")
+	var testData = []struct {
+		description        string
+		workers, batchSize int
+	}{
+		{description: "defaults"},
+		{description: "single worker, single line batches", workers: 1, batchSize: 1},
+		{description: "many workers, small batches", workers: 8, batchSize: 7},
+	}
+
+	for _, x := range testData {
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			f, err := os.Open("../../sample.csv")
+			assert.NoError(t, err)
+			defer f.Close()
+			logProcessor := Processor{
+				LogReader:               f,
+				AlertWindow:             time.Minute * 2,
+				AlertThresholdPerSecond: 10,
+				Workers:                 x.workers,
+				BatchSize:               x.batchSize,
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+
+			output := new(strings.Builder)
+			printfToVariable := func(format string, a ...interface{}) (n int, err error) {
+				return fmt.Fprintf(output, format, a...)
+			}
+			printFunction = printfToVariable
+
+			done := make(chan struct{})
+			go func() {
+				assert.NoError(t, logProcessor.Start(ctx))
+				close(done)
+			}()
+
+			// hack to wait for log to be processed
+			time.Sleep(time.Second)
+			cancel()
+			<-done
+
+			assert.Equal(t, sampleCsvOutput, output.String())
+		})
+	}
+}
+
+func TestOutputTZ(t *testing.T) {
+	log := `"10.0.0.2","-","apache",2019-02-07T21:11:00.5Z,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",2019-02-07T22:11:11+01:00,"GET /api/user HTTP/1.0",200,1234
+`
+	tz, err := time.LoadLocation("Asia/Tokyo")
 	assert.NoError(t, err)
-	defer f.Close()
 	logProcessor := Processor{
-		LogReader:               csv.NewReader(f),
+		LogReader:               strings.NewReader(log),
 		AlertWindow:             time.Minute * 2,
 		AlertThresholdPerSecond: 10,
+		OutputTZ:                tz,
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	assert.Equal(t, "2019-02-08 06:11:00 +0900 JST: 1 hits from 1 users with 1234 bytes transferred, top /api with 1 hits\n",
+		runProcessor(&logProcessor))
+}
 
+func TestStopAtEnd(t *testing.T) {
+	// unterminated last line is processed right away, printing the report, as nothing would be appended to it
+	log := `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.3","-","apache",1549573861,"GET /report HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573871,"GET /api/user HTTP/1.0",200,1234`
 	output := new(strings.Builder)
-	printfToVariable := func(format string, a ...interface{}) (n int, err error) {
+	printFunction = func(format string, a ...interface{}) (n int, err error) {
 		return fmt.Fprintf(output, format, a...)
 	}
-	printFunction = printfToVariable
+	defer func() { printFunction = fmt.Printf }()
+
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Minute * 2,
+		AlertThresholdPerSecond: 10,
+		StopAtEnd:               true,
+	}
+	require.NoError(t, logProcessor.Start(context.Background()))
+	assert.Equal(t, "2019-02-07 21:11:01 +0000 UTC: 2 hits from 2 users with 2468 bytes transferred, top /api and /report with 1 hits\n",
+		output.String())
+}
 
-	go logProcessor.Start(ctx)
+func TestStartChecks(t *testing.T) {
+	latencyRules, err := NewLatencyRules([]string{"/api:p99>800ms@240h"})
+	require.NoError(t, err)
+	for _, x := range []struct {
+		description string
+		processor   Processor
+		err         string
+	}{
+		{"window with too many buckets", Processor{AlertWindow: time.Hour, BucketResolution: time.Microsecond},
+			"window of 1h0m0s with 1µs resolution needs more than 100000 buckets"},
+		{"bad users precision", Processor{AlertWindow: time.Minute, UsersPrecision: 20},
+			"HyperLogLog precision should be between 4 and 18, got 20"},
+		{"users counters taking too much memory", Processor{AlertWindow: 24 * time.Hour, UsersPrecision: 18},
+			"window of 24h0m0s with 1s resolution and unique users precision 18 could take 21600 MB, more than 1024 MB"},
+		{"latency alert window with too many buckets", Processor{AlertWindow: time.Minute, LatencyAlerts: latencyRules},
+			"p99 latency of /api alert: window of 240h0m0s with 1s resolution needs more than 100000 buckets"},
+	} {
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			x.processor.LogReader = strings.NewReader("")
+			assert.EqualError(t, x.processor.Start(context.Background()), x.err)
+		})
+	}
+}
 
+func TestEstimatedUsers(t *testing.T) {
+	log := `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.3","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573861,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573871,"GET /api/user HTTP/1.0",200,1234
+`
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Minute * 2,
+		AlertThresholdPerSecond: 10,
+		UsersPrecision:          14,
+	}
+	assert.Equal(t, "2019-02-07 21:11:01 +0000 UTC: 3 hits from ~2 users (±0.81%) with 3702 bytes transferred, top /api with 2 hits\n",
+		runProcessor(&logProcessor))
+}
+
+func TestHeavyHitters(t *testing.T) {
+	log := `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.3","-","apache",1549573861,"GET /report HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573862,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573871,"GET /api/user HTTP/1.0",200,1234
+`
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Minute * 2,
+		AlertThresholdPerSecond: 10,
+		HeavyHitters:            1,
+	}
+	// only one section is kept in the report, so /api count includes the /report bucket it replaced
+	assert.Equal(t, "2019-02-07 21:11:02 +0000 UTC: 3 hits from 2 users with 3702 bytes transferred, top /api with 1-3 hits\n",
+		runProcessor(&logProcessor))
+}
+
+func TestTopTalkers(t *testing.T) {
+	log := `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.3","-","-",1549573860,"GET /report HTTP/1.0",200,5000
+"10.0.0.2","-","apache",1549573861,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.4","-","frank",1549573861,"GET /api/user HTTP/1.0",200,10
+"10.0.0.2","-","apache",1549573871,"GET /api/user HTTP/1.0",200,1234
+`
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Minute * 2,
+		AlertThresholdPerSecond: 10,
+		TopTalkers:              2,
+	}
+	assert.Equal(t, "2019-02-07 21:11:01 +0000 UTC: 4 hits from 3 users with 7478 bytes transferred, top /api with 2 hits\n"+
+		"2019-02-07 21:11:01 +0000 UTC: top clients 10.0.0.2 (2 hits), 10.0.0.3 (1 hits), "+
+		"by bytes 10.0.0.3 (5000 bytes), 10.0.0.2 (2468 bytes), top users apache (2 hits), frank (1 hits)\n",
+		runProcessor(&logProcessor))
+}
+
+func TestAlertTopClients(t *testing.T) {
+	log := `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.3","-","frank",1549573860,"GET /api/user HTTP/1.0",200,5000
+"10.0.0.2","-","apache",1549573861,"GET /api/user HTTP/1.0",200,1234
+`
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Second,
+		AlertThresholdPerSecond: 2,
+	}
+	assert.Equal(t, "2019-02-07 21:11:01 +0000 UTC: Alert RED, ~3.00 hits per second which is higher than 2 (3 total) "+
+		"in the last 1s, top clients 10.0.0.2 (2 hits), 10.0.0.3 (1 hits), by bytes 10.0.0.3 (5000 bytes), 10.0.0.2 (2468 bytes), "+
+		"top users apache (2 hits), frank (1 hits)\n",
+		runProcessor(&logProcessor))
+
+	logProcessor = Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Second,
+		AlertThresholdPerSecond: 2,
+		JSON:                    true,
+	}
+	assert.Equal(t, `{"time":"2019-02-07T21:11:01Z","type":"alert","alert":"hits","state":"RED","per_second":3,"threshold":2,"total":3,`+
+		`"window":"1s","top_clients":[{"key":"10.0.0.2","count":2},{"key":"10.0.0.3","count":1}],`+
+		`"top_clients_by_bytes":[{"key":"10.0.0.3","count":5000},{"key":"10.0.0.2","count":2468}],`+
+		`"top_users":[{"key":"apache","count":2},{"key":"frank","count":1}]}`+"\n",
+		runProcessor(&logProcessor))
+}
+
+func TestLabelsAndAlertFilter(t *testing.T) {
+	log := `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.1.5","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.1.5","-","apache",1549573871,"GET /api/user HTTP/1.0",200,1234
+`
+	labels, err := NewLabels(strings.NewReader("10.0.0.0/24 office\n"))
+	assert.NoError(t, err)
+	filter, err := NewAlertFilter([]string{"label!=office"})
+	assert.NoError(t, err)
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Second,
+		AlertThresholdPerSecond: 1,
+		TopTalkers:              1,
+		Labels:                  labels,
+		AlertFilter:             filter,
+	}
+	// office hits are not counted by the alert, so the threshold is not crossed
+	assert.Equal(t, "2019-02-07 21:11:00 +0000 UTC: 4 hits from 2 users with 4936 bytes transferred, top /api with 1 hits\n"+
+		"2019-02-07 21:11:00 +0000 UTC: top clients 10.0.0.2 (3 hits), by bytes 10.0.0.2 (3702 bytes), "+
+		"top users apache (4 hits), top networks office (3 hits)\n",
+		runProcessor(&logProcessor))
+}
+
+func TestAlertTopClientsFiltered(t *testing.T) {
+	log := `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.1.5","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.1.6","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+`
+	labels, err := NewLabels(strings.NewReader("10.0.0.0/24 office\n"))
+	assert.NoError(t, err)
+	filter, err := NewAlertFilter([]string{"label!=office"})
+	assert.NoError(t, err)
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Second,
+		AlertThresholdPerSecond: 1,
+		Labels:                  labels,
+		AlertFilter:             filter,
+	}
+	// office client has the most hits, but they are not counted by the alert
+	out := runProcessor(&logProcessor)
+	assert.Contains(t, out, "Alert RED, ~2.00 hits per second")
+	assert.Contains(t, out, "top clients 10.0.1.5 (1 hits), 10.0.1.6 (1 hits), by bytes 10.0.1.5 (1234 bytes), 10.0.1.6 (1234 bytes), "+
+		"top users apache (2 hits)\n")
+	assert.NotContains(t, out, "10.0.0.2")
+}
+
+func TestBotsExcludedFromAlert(t *testing.T) {
+	log := `"remotehost","rfc931","authuser","date","request","status","bytes","useragent"
+"66.249.66.1","-","-",1549573860,"GET /api/user HTTP/1.0",200,1234,"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+"66.249.66.1","-","-",1549573860,"GET /api/user HTTP/1.0",200,1234,"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+"66.249.66.1","-","-",1549573860,"GET /api/user HTTP/1.0",200,1234,"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+"10.0.0.2","-","-",1549573860,"GET /report HTTP/1.0",200,1234,"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+"10.0.0.2","-","-",1549573871,"GET /report HTTP/1.0",200,1234,"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+`
+	filter, err := NewAlertFilter([]string{"bot=false"})
+	assert.NoError(t, err)
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Second,
+		AlertThresholdPerSecond: 1,
+		AlertFilter:             filter,
+		TopTalkers:              1,
+	}
+	assert.Equal(t, "2019-02-07 21:11:00 +0000 UTC: 4 hits from 2 users with 4936 bytes transferred, top /api and /report with 1 hits, "+
+		"1 human and 3 bot hits (75.00% bots)\n"+
+		"2019-02-07 21:11:00 +0000 UTC: top clients 66.249.66.1 (3 hits), by bytes 66.249.66.1 (3702 bytes), top users none, "+
+		"top browsers Chrome (1 hits), top OS Other (3 hits), top devices Bot (3 hits), top bots Googlebot (3 hits)\n",
+		runProcessor(&logProcessor))
+}
+
+// runProcessor processes the log and returns the output, log is expected to be processed in 100ms
+func runProcessor(l *Processor) string {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	output := new(strings.Builder)
+	printFunction = func(format string, a ...interface{}) (n int, err error) {
+		return fmt.Fprintf(output, format, a...)
+	}
+	defer func() { printFunction = fmt.Printf }()
+
+	done := make(chan struct{})
+	go func() {
+		// error is printed for the output to tell about it
+		if err := l.Start(ctx); err != nil {
+			fmt.Fprintln(output, err)
+		}
+		close(done)
+	}()
 	// hack to wait for log to be processed
-	time.Sleep(time.Second)
+	time.Sleep(time.Millisecond * 100)
 	cancel()
+	<-done
+	return output.String()
+}
+
+// aggregateLog feeds the records of the log to the processor one by one in the current goroutine, and returns
+// the output. Unlike runProcessor, it doesn't depend on how fast the log is processed, so it suits long logs.
+func aggregateLog(t *testing.T, l *Processor, log string) string {
+	output := new(strings.Builder)
+	printFunction = func(format string, a ...interface{}) (n int, err error) {
+		return fmt.Fprintf(output, format, a...)
+	}
+	defer func() { printFunction = fmt.Printf }()
+
+	opts, err := l.prepare()
+	require.NoError(t, err)
+	parser := newLineParser(opts)
+	for _, line := range strings.SplitAfter(log, "\n") {
+		var r record
+		if line != "" && parser.parse([]byte(line), &r) == reasonNone {
+			l.aggregate(&r)
+		}
+	}
+	return output.String()
+}
+
+// BenchmarkAggregate measures the throughput of history and alerts calculation on a generated log
+// with 1000 requests per second, run with -benchtime=5000000x to get a multi-million lines log
+func BenchmarkAggregate(b *testing.B) {
+	b.Run("1s", func(b *testing.B) { benchmarkAggregate(b, time.Second) })
+	b.Run("100ms", func(b *testing.B) { benchmarkAggregate(b, time.Millisecond*100) })
+}
+
+func benchmarkAggregate(b *testing.B, resolution time.Duration) {
+	const rps = 1000
+	records := make([]record, rps)
+	for i := range records {
+		records[i] = record{
+			remotehost: "10.0.0." + strconv.Itoa(i%250),
+			authuser:   "apache",
+			section:    "/section" + strconv.Itoa(i%50),
+			status:     200,
+			bytes:      1234,
+		}
+	}
+	printFunction = func(string, ...interface{}) (int, error) { return 0, nil }
+	defer func() { printFunction = fmt.Printf }()
+	l := Processor{AlertWindow: time.Minute * 2, AlertThresholdPerSecond: rps / 2, BucketResolution: resolution, OutputTZ: time.UTC}
+	l.history = newWindow(l.AlertWindow, resolution, historyOpts{})
+	start := time.Date(2019, 2, 7, 21, 11, 0, 0, time.UTC)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	began := time.Now()
+	for i := 0; i < b.N; i++ {
+		r := records[i%rps]
+		r.date = start.Add(time.Duration(i) * time.Second / rps)
+		l.aggregate(&r)
+	}
+	b.ReportMetric(float64(b.N)/time.Since(began).Seconds(), "lines/s")
+}
 
-	assert.Equal(t, sampleCsvOutput, output.String())
+func TestResponseSizes(t *testing.T) {
+	log := `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.3","-","-",1549573860,"GET /report HTTP/1.0",200,5000
+"10.0.0.2","-","apache",1549573861,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.4","-","frank",1549573861,"GET /api/user HTTP/1.0",200,10
+"10.0.0.2","-","apache",1549573871,"GET /api/user HTTP/1.0",200,1234
+`
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Minute * 2,
+		AlertThresholdPerSecond: 10,
+		SizeSections:            1,
+	}
+	// percentiles are within 1% of the true 1234 bytes, and maximum is exact
+	assert.Equal(t, "2019-02-07 21:11:01 +0000 UTC: 4 hits from 3 users with 7478 bytes transferred, top /api with 2 hits\n"+
+		"2019-02-07 21:11:01 +0000 UTC: response sizes all p50=1224 p90=1224 p99=1224 max=5000 bytes, "+
+		"/api p50=1224 p90=1224 p99=1224 max=1234 bytes\n",
+		runProcessor(&logProcessor))
+}
+
+func TestBandwidthAlert(t *testing.T) {
+	log := `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.3","-","-",1549573861,"GET /report HTTP/1.0",200,250000
+"10.0.0.2","-","apache",1549573862,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573990,"GET /api/user HTTP/1.0",200,1234
+`
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Minute * 2,
+		AlertThresholdPerSecond: 10,
+		AlertBandwidthPerSecond: 2000,
+	}
+	assert.Equal(t, "2019-02-07 21:11:01 +0000 UTC: Alert RED, ~2093.62 bytes per second which is higher than 2000 (251234 total) "+
+		"in the last 2m0s, top clients 10.0.0.2 (1 hits), 10.0.0.3 (1 hits), by bytes 10.0.0.3 (250000 bytes), 10.0.0.2 (1234 bytes), "+
+		"top users apache (1 hits)\n"+
+		"2019-02-07 21:11:02 +0000 UTC: 3 hits from 2 users with 252468 bytes transferred, top /api with 2 hits\n"+
+		"2019-02-07 21:13:10 +0000 UTC: Alert GREEN, ~10.28 bytes per second which is lower than 2000 (1234 total) in the last 2m0s\n",
+		runProcessor(&logProcessor))
 }
@@ -0,0 +1,655 @@
+package record
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is the parsed expression of the analyze command, like
+// `status >= 500 and section == "/api" | count by remotehost | top 10`.
+// It's a pipeline of filters, followed by a single aggregation and the limit of its results.
+type Query struct {
+	filters []queryExpr
+	agg     queryAggregation
+	top     int // number of rows with the highest value of the first aggregation in every bucket, all if zero
+}
+
+// queryAggregation computes functions of the values per group of records with the same fields, in every time bucket
+type queryAggregation struct {
+	funcs   []queryFunc
+	groupBy []queryField
+	every   time.Duration // length of time buckets, records are not bucketed if zero
+}
+
+// queryFunc is an aggregation function like count, sum(bytes) or p99(latency)
+type queryFunc struct {
+	name     string     // count, sum, avg, min, max or percentile
+	field    queryField // field of the values, not set for count
+	quantile float64    // between 0 and 1, for percentile only
+}
+
+// queryKind is the type of the value of the field
+type queryKind int
+
+const (
+	kindString queryKind = iota
+	kindNumber
+	kindDuration // values are in microseconds, same as in the latency distributions
+	kindBool
+)
+
+var kindNames = map[queryKind]string{kindString: "string", kindNumber: "number", kindDuration: "duration", kindBool: "bool"}
+
+// queryValue is the value of the field or the literal, numbers, durations and bools are kept in n
+type queryValue struct {
+	s string
+	n float64
+}
+
+// queryField is the field of the record the query could refer to
+type queryField struct {
+	name string
+	kind queryKind
+	// get returns the value of the field, false if the record doesn't have it, like latency of untimed record
+	get func(r *record) (queryValue, bool)
+}
+
+func stringField(get func(r *record) string) func(r *record) (queryValue, bool) {
+	return func(r *record) (queryValue, bool) { return queryValue{s: get(r)}, true }
+}
+
+// queryFields are fields of the record by their names
+var queryFields = map[string]queryField{
+	"remotehost": {kind: kindString, get: stringField(func(r *record) string { return r.remotehost })},
+	"rfc931":     {kind: kindString, get: stringField(func(r *record) string { return r.rfc931 })},
+	"authuser":   {kind: kindString, get: stringField(func(r *record) string { return r.authuser })},
+	"request":    {kind: kindString, get: stringField(func(r *record) string { return r.request })},
+	"section":    {kind: kindString, get: stringField(func(r *record) string { return r.section })},
+	"user_agent": {kind: kindString, get: stringField(func(r *record) string { return r.userAgent })},
+	"label":      {kind: kindString, get: stringField(func(r *record) string { return r.label })},
+	"country":    {kind: kindString, get: stringField(func(r *record) string { return r.country })},
+	"asn":        {kind: kindString, get: stringField(func(r *record) string { return r.asn })},
+	"browser":    {kind: kindString, get: stringField(func(r *record) string { return r.agent.Browser })},
+	"os":         {kind: kindString, get: stringField(func(r *record) string { return r.agent.OS })},
+	"device":     {kind: kindString, get: stringField(func(r *record) string { return r.agent.Device })},
+	"status": {kind: kindNumber, get: func(r *record) (queryValue, bool) {
+		return queryValue{n: float64(r.status)}, true
+	}},
+	"bytes": {kind: kindNumber, get: func(r *record) (queryValue, bool) {
+		return queryValue{n: float64(r.bytes)}, true
+	}},
+	"latency": {kind: kindDuration, get: func(r *record) (queryValue, bool) {
+		return queryValue{n: float64(r.latency) / float64(time.Microsecond)}, r.timed
+	}},
+	"bot": {kind: kindBool, get: func(r *record) (queryValue, bool) {
+		if r.agent.Bot {
+			return queryValue{n: 1}, true
+		}
+		return queryValue{}, true
+	}},
+}
+
+// queryFieldNames returns names of the fields in alphabetical order
+func queryFieldNames() []string {
+	names := make([]string, 0, len(queryFields))
+	for name := range queryFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseQuery parses the query of the analyze command, records are counted if it has no aggregation
+func ParseQuery(s string) (*Query, error) {
+	tokens, err := lexQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	q := &Query{}
+	aggregated := false
+	for p.peek().kind != tokenEOF {
+		switch t := p.peek(); {
+		case t.is("top"):
+			if !aggregated {
+				return nil, p.errorf(t, "top should follow an aggregation")
+			}
+			if q.top, err = p.parseTop(); err != nil {
+				return nil, err
+			}
+		case t.kind == tokenIdent && isAggregation(t.text):
+			if aggregated {
+				return nil, p.errorf(t, "only one aggregation is supported")
+			}
+			if q.agg, err = p.parseAggregation(); err != nil {
+				return nil, err
+			}
+			aggregated = true
+		default:
+			if aggregated {
+				return nil, p.errorf(t, "filters should come before the aggregation")
+			}
+			e, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			q.filters = append(q.filters, e)
+		}
+		switch t := p.next(); {
+		case t.kind == tokenPipe && p.peek().kind == tokenEOF:
+			return nil, p.errorf(p.peek(), "expected a stage after %q", "|")
+		case t.kind != tokenPipe && t.kind != tokenEOF:
+			return nil, p.errorf(t, "unexpected %s", t)
+		}
+	}
+	if !aggregated {
+		q.agg = queryAggregation{funcs: []queryFunc{{name: "count"}}}
+	}
+	return q, nil
+}
+
+// match returns true if the record passes all filters of the query
+func (q *Query) match(r *record) bool {
+	for _, f := range q.filters {
+		if !f.eval(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// columns returns names of the result columns: time if records are bucketed, fields to group by and aggregations
+func (q *Query) columns() []string {
+	var columns []string
+	if q.agg.every > 0 {
+		columns = append(columns, "time")
+	}
+	for _, f := range q.agg.groupBy {
+		columns = append(columns, f.name)
+	}
+	for _, f := range q.agg.funcs {
+		columns = append(columns, f.String())
+	}
+	return columns
+}
+
+// String returns the name of the function column, like p99(latency)
+func (f queryFunc) String() string {
+	switch f.name {
+	case "count":
+		return "count"
+	case "percentile":
+		return "p" + strconv.FormatFloat(f.quantile*100, 'f', -1, 64) + "(" + f.field.name + ")"
+	}
+	return f.name + "(" + f.field.name + ")"
+}
+
+// queryExpr is the boolean expression of the filter
+type queryExpr interface {
+	eval(r *record) bool
+}
+
+type andExpr struct{ left, right queryExpr }
+
+func (e andExpr) eval(r *record) bool { return e.left.eval(r) && e.right.eval(r) }
+
+type orExpr struct{ left, right queryExpr }
+
+func (e orExpr) eval(r *record) bool { return e.left.eval(r) || e.right.eval(r) }
+
+type notExpr struct{ expr queryExpr }
+
+func (e notExpr) eval(r *record) bool { return !e.expr.eval(r) }
+
+// compareExpr compares the field with the literal, it's false for records without the field
+type compareExpr struct {
+	field   queryField
+	op      string
+	literal queryValue
+	re      *regexp.Regexp // for =~ and !~
+}
+
+func (e compareExpr) eval(r *record) bool {
+	v, ok := e.field.get(r)
+	if !ok {
+		return false
+	}
+	switch e.op {
+	case "=~":
+		return e.re.MatchString(v.s)
+	case "!~":
+		return !e.re.MatchString(v.s)
+	}
+	if e.field.kind == kindString {
+		return (v.s == e.literal.s) == (e.op == "==")
+	}
+	switch e.op {
+	case "==":
+		return v.n == e.literal.n
+	case "!=":
+		return v.n != e.literal.n
+	case "<":
+		return v.n < e.literal.n
+	case "<=":
+		return v.n <= e.literal.n
+	case ">":
+		return v.n > e.literal.n
+	}
+	return v.n >= e.literal.n
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() queryToken { return p.tokens[p.pos] }
+
+func (p *queryParser) next() queryToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *queryParser) errorf(t queryToken, format string, args ...interface{}) error {
+	return fmt.Errorf("%s at column %d", fmt.Sprintf(format, args...), t.col)
+}
+
+// parseOr parses expr: and ("or" and)*
+func (p *queryParser) parseOr() (queryExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses and: not ("and" not)*
+func (p *queryParser) parseAnd() (queryExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseNot parses not: "not" not | "(" expr ")" | comparison
+func (p *queryParser) parseNot() (queryExpr, error) {
+	t := p.peek()
+	if t.is("not") {
+		p.next()
+		e, err := p.parseNot()
+		return notExpr{expr: e}, err
+	}
+	if t.kind == tokenLParen {
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, p.errorf(t, "expected %q instead of %s", ")", t)
+		}
+		return e, nil
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses comparison: field op literal | literal op field | bool field
+func (p *queryParser) parseComparison() (queryExpr, error) {
+	left := p.next()
+	op := p.peek()
+	if op.kind != tokenOp {
+		f, err := p.field(left)
+		if err != nil {
+			return nil, err
+		}
+		// bot is the same as bot == true
+		if f.kind == kindBool {
+			return compareExpr{field: f, op: "==", literal: queryValue{n: 1}}, nil
+		}
+		return nil, p.errorf(op, "expected a comparison instead of %s", op)
+	}
+	p.next()
+	right := p.next()
+	fieldToken, literal, operator := left, right, op.text
+	if left.kind != tokenIdent || left.is("true") || left.is("false") {
+		// literal on the left, like 500 <= status
+		fieldToken, literal, operator = right, left, flipOperator(op.text)
+	}
+	f, err := p.field(fieldToken)
+	if err != nil {
+		return nil, err
+	}
+	e := compareExpr{field: f, op: operator}
+	if operator == "=~" || operator == "!~" {
+		if f.kind != kindString || literal.kind != tokenString {
+			return nil, p.errorf(op, "%s should match a string field with a regular expression", operator)
+		}
+		re, err := regexp.Compile(literal.value)
+		if err != nil {
+			return nil, p.errorf(literal, "bad regular expression: %v", err)
+		}
+		e.re = re
+		return e, nil
+	}
+	if (f.kind == kindString || f.kind == kindBool) && operator != "==" && operator != "!=" {
+		return nil, p.errorf(op, "%s field %s can't be compared with %s", kindNames[f.kind], f.name, operator)
+	}
+	value, err := p.literal(literal, f)
+	if err != nil {
+		return nil, err
+	}
+	e.literal = value
+	return e, nil
+}
+
+// literal returns the value of the token compared with the field, checking that it has the same type
+func (p *queryParser) literal(t queryToken, f queryField) (queryValue, error) {
+	switch {
+	case f.kind == kindString && t.kind == tokenString:
+		return queryValue{s: t.value}, nil
+	case f.kind == kindNumber && t.kind == tokenNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return queryValue{}, p.errorf(t, "bad number %s", t)
+		}
+		return queryValue{n: n}, nil
+	case f.kind == kindDuration && t.kind == tokenDuration:
+		d, err := time.ParseDuration(t.text)
+		if err != nil {
+			return queryValue{}, p.errorf(t, "bad duration %s", t)
+		}
+		return queryValue{n: float64(d) / float64(time.Microsecond)}, nil
+	case f.kind == kindBool && (t.is("true") || t.is("false")):
+		if t.is("true") {
+			return queryValue{n: 1}, nil
+		}
+		return queryValue{}, nil
+	}
+	return queryValue{}, p.errorf(t, "%s field %s can't be compared with %s", kindNames[f.kind], f.name, t)
+}
+
+// field returns the field the token refers to
+func (p *queryParser) field(t queryToken) (queryField, error) {
+	f, ok := queryFields[t.text]
+	if t.kind != tokenIdent {
+		return f, p.errorf(t, "expected a field instead of %s", t)
+	}
+	if !ok {
+		return f, p.errorf(t, "unknown field %s, supported: %s", t, strings.Join(queryFieldNames(), ", "))
+	}
+	f.name = t.text
+	return f, nil
+}
+
+// flipOperator returns the operator with the operands swapped
+func flipOperator(op string) string {
+	switch op {
+	case "<":
+		return ">"
+	case "<=":
+		return ">="
+	case ">":
+		return "<"
+	case ">=":
+		return "<="
+	}
+	return op
+}
+
+// isAggregation returns true if the name is the aggregation function
+func isAggregation(name string) bool {
+	switch name {
+	case "count", "sum", "avg", "min", "max", "percentile":
+		return true
+	}
+	_, ok := parsePercentileName(name)
+	return ok
+}
+
+// parsePercentileName parses the shorthand of the percentile like p99 or p99.9
+func parsePercentileName(name string) (float64, bool) {
+	if !strings.HasPrefix(name, "p") {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(name[1:], 64)
+	if err != nil || n <= 0 || n > 100 || math.IsNaN(n) {
+		return 0, false
+	}
+	return n / 100, true
+}
+
+// parseAggregation parses aggregation: func ("," func)* ["by" field ("," field)*] ["every" duration]
+func (p *queryParser) parseAggregation() (queryAggregation, error) {
+	var agg queryAggregation
+	for {
+		f, err := p.parseFunc()
+		if err != nil {
+			return agg, err
+		}
+		agg.funcs = append(agg.funcs, f)
+		if p.peek().kind != tokenComma {
+			break
+		}
+		p.next()
+	}
+	if p.peek().is("by") {
+		p.next()
+		for {
+			f, err := p.field(p.next())
+			if err != nil {
+				return agg, err
+			}
+			agg.groupBy = append(agg.groupBy, f)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if p.peek().is("every") {
+		p.next()
+		t := p.next()
+		d, err := time.ParseDuration(t.text)
+		if t.kind != tokenDuration || err != nil || d <= 0 {
+			return agg, p.errorf(t, "expected a positive duration like 5m instead of %s", t)
+		}
+		agg.every = d
+	}
+	return agg, nil
+}
+
+// parseFunc parses func: "count" ["(" ")"] | name "(" field ")" | "percentile" "(" field "," number ")"
+func (p *queryParser) parseFunc() (queryFunc, error) {
+	t := p.next()
+	if t.kind != tokenIdent || !isAggregation(t.text) {
+		return queryFunc{}, p.errorf(t, "expected an aggregation like count or sum(bytes) instead of %s", t)
+	}
+	f, explicit := queryFunc{name: t.text}, t.text == "percentile"
+	if q, ok := parsePercentileName(t.text); ok {
+		f.name, f.quantile = "percentile", q
+	}
+	if f.name == "count" {
+		if p.peek().kind == tokenLParen {
+			p.next()
+			if t := p.next(); t.kind != tokenRParen {
+				return f, p.errorf(t, "count takes no arguments")
+			}
+		}
+		return f, nil
+	}
+	if t := p.next(); t.kind != tokenLParen {
+		return f, p.errorf(t, "expected %q after %s instead of %s", "(", f.name, t)
+	}
+	ft := p.next()
+	field, err := p.field(ft)
+	if err != nil {
+		return f, err
+	}
+	if field.kind != kindNumber && field.kind != kindDuration {
+		return f, p.errorf(ft, "%s can't be applied to %s field %s", f.name, kindNames[field.kind], field.name)
+	}
+	f.field = field
+	if explicit {
+		if t := p.next(); t.kind != tokenComma {
+			return f, p.errorf(t, "expected percentile like percentile(latency, 99) instead of %s", t)
+		}
+		n := p.next()
+		q, ok := parsePercentileName("p" + n.text)
+		if n.kind != tokenNumber || !ok {
+			return f, p.errorf(n, "expected percentile between 0 and 100 instead of %s", n)
+		}
+		f.quantile = q
+	}
+	if t := p.next(); t.kind != tokenRParen {
+		return f, p.errorf(t, "expected %q instead of %s", ")", t)
+	}
+	return f, nil
+}
+
+// parseTop parses top: "top" number
+func (p *queryParser) parseTop() (int, error) {
+	p.next()
+	t := p.next()
+	n, err := strconv.Atoi(t.text)
+	if t.kind != tokenNumber || err != nil || n <= 0 {
+		return 0, p.errorf(t, "expected a positive number of rows instead of %s", t)
+	}
+	return n, nil
+}
+
+// queryTokenKind is the kind of the lexeme of the query
+type queryTokenKind int
+
+const (
+	tokenEOF queryTokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenDuration
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+	tokenPipe
+)
+
+type queryToken struct {
+	kind  queryTokenKind
+	text  string // as written in the query
+	value string // unquoted value of the string
+	col   int    // position in the query starting with 1
+}
+
+// is returns true if the token is the keyword
+func (t queryToken) is(keyword string) bool {
+	return t.kind == tokenIdent && t.text == keyword
+}
+
+func (t queryToken) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of the query"
+	case tokenString:
+		return t.text
+	}
+	return strconv.Quote(t.text)
+}
+
+// queryOps are comparison operators, two-character ones go first so that they are matched before their prefixes
+var queryOps = []string{"==", "!=", "<=", ">=", "=~", "!~", "<", ">"}
+
+// lexQuery splits the query into tokens, the last one is always tokenEOF
+func lexQuery(s string) ([]queryToken, error) {
+	var tokens []queryToken
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c == '(' || c == ')' || c == ',' || c == '|':
+			kind := map[rune]queryTokenKind{'(': tokenLParen, ')': tokenRParen, ',': tokenComma, '|': tokenPipe}[c]
+			tokens = append(tokens, queryToken{kind: kind, text: string(c), col: i + 1})
+			i++
+			continue
+		case c == '"':
+			i++
+			for i < len(runes) && runes[i] != '"' {
+				if runes[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at column %d", start+1)
+			}
+			i++
+			text := string(runes[start:i])
+			value, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, fmt.Errorf("bad string %s at column %d", text, start+1)
+			}
+			tokens = append(tokens, queryToken{kind: tokenString, text: text, value: value, col: start + 1})
+			continue
+		case c >= '0' && c <= '9':
+			for i < len(runes) && (runes[i] >= '0' && runes[i] <= '9' || runes[i] == '.') {
+				i++
+			}
+			kind := tokenNumber
+			// durations like 500ms or 1h30m
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || runes[i] >= '0' && runes[i] <= '9' || runes[i] == '.') {
+				kind = tokenDuration
+				i++
+			}
+			tokens = append(tokens, queryToken{kind: kind, text: string(runes[start:i]), col: start + 1})
+			continue
+		case unicode.IsLetter(c) || c == '_':
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, queryToken{kind: tokenIdent, text: string(runes[start:i]), col: start + 1})
+			continue
+		}
+		matched := false
+		for _, op := range queryOps {
+			if strings.HasPrefix(string(runes[i:]), op) {
+				tokens = append(tokens, queryToken{kind: tokenOp, text: op, col: i + 1})
+				i += len(op)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("unexpected %q at column %d", string(c), i+1)
+		}
+	}
+	return append(tokens, queryToken{kind: tokenEOF, col: len(runes) + 1}), nil
+}
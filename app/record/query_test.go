@@ -0,0 +1,101 @@
+package record
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/paskal/datadog-parser/app/useragent"
+)
+
+func TestQueryFilter(t *testing.T) {
+	r := &record{remotehost: "10.0.0.1", section: "/api", status: 503, bytes: 1234, latency: 800 * time.Millisecond,
+		timed: true, agent: useragent.Agent{Browser: "Googlebot", Bot: true}}
+	var testData = []struct {
+		query string
+		match bool
+	}{
+		{query: "", match: true},
+		{query: `status >= 500 and section == "/api"`, match: true},
+		{query: `status >= 500 and section != "/api"`, match: false},
+		{query: `500 <= status`, match: true},
+		{query: `status < 500 or bytes > 1000`, match: true},
+		{query: `not (status < 500 or bytes > 1000)`, match: false},
+		{query: `status == 503 | remotehost =~ "^10\\."`, match: true},
+		{query: `remotehost !~ "^10\\."`, match: false},
+		{query: `latency > 500ms and latency <= 1s`, match: true},
+		{query: `bot and browser == "Googlebot"`, match: true},
+		{query: `not bot`, match: false},
+		{query: `bot == false or status == 200`, match: false},
+		{query: `true == bot`, match: true},
+	}
+	for _, x := range testData {
+		q, err := ParseQuery(x.query)
+		require.NoError(t, err, x.query)
+		assert.Equal(t, x.match, q.match(r), x.query)
+	}
+
+	// untimed records don't match latency comparisons either way
+	q, err := ParseQuery("latency < 1s or latency >= 1s")
+	require.NoError(t, err)
+	assert.False(t, q.match(&record{}))
+}
+
+func TestParseQuery(t *testing.T) {
+	var testData = []struct {
+		query   string
+		columns []string
+		top     int
+		every   time.Duration
+	}{
+		{query: `status >= 500`, columns: []string{"count"}},
+		{query: `status >= 500 and section == "/api" | count by remotehost | top 10`, columns: []string{"remotehost", "count"}, top: 10},
+		{query: `count(), sum(bytes), avg(bytes), min(latency), max(latency) by section, status`,
+			columns: []string{"section", "status", "count", "sum(bytes)", "avg(bytes)", "min(latency)", "max(latency)"}},
+		{query: `p99(latency), percentile(latency, 99.9), p50(bytes) every 5m`,
+			columns: []string{"time", "p99(latency)", "p99.9(latency)", "p50(bytes)"}, every: 5 * time.Minute},
+	}
+	for _, x := range testData {
+		q, err := ParseQuery(x.query)
+		require.NoError(t, err, x.query)
+		assert.Equal(t, x.columns, q.columns(), x.query)
+		assert.Equal(t, x.top, q.top, x.query)
+		assert.Equal(t, x.every, q.agg.every, x.query)
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for query, msg := range map[string]string{
+		`status >= 500 |`:               `expected a stage after "|" at column 16`,
+		`status >= 500 status`:          `unexpected "status" at column 15`,
+		`top 10`:                        "top should follow an aggregation at column 1",
+		`count | count`:                 "only one aggregation is supported at column 9",
+		`count | status >= 500`:         "filters should come before the aggregation at column 9",
+		`count | top ten`:               `expected a positive number of rows instead of "ten" at column 13`,
+		`host == "a"`:                   `unknown field "host", supported: asn, authuser, bot, browser, bytes, country, device, label, latency, os, remotehost, request, rfc931, section, status, user_agent at column 1`,
+		`status`:                        "expected a comparison instead of end of the query at column 7",
+		`status >= "500"`:               `number field status can't be compared with "500" at column 11`,
+		`section > "/api"`:              "string field section can't be compared with > at column 9",
+		`section == /api`:               `unexpected "/" at column 12`,
+		`section == "/api`:              "unterminated string at column 12",
+		`latency > 500`:                 `duration field latency can't be compared with "500" at column 11`,
+		`latency > 5xs`:                 `bad duration "5xs" at column 11`,
+		`status =~ "5.."`:               "=~ should match a string field with a regular expression at column 8",
+		`section =~ "("`:                "bad regular expression: error parsing regexp: missing closing ): `(` at column 12",
+		`(status == 500`:                `expected ")" instead of end of the query at column 15`,
+		`sum(section)`:                  "sum can't be applied to string field section at column 5",
+		`sum bytes`:                     `expected "(" after sum instead of "bytes" at column 5`,
+		`percentile(latency)`:           `expected percentile like percentile(latency, 99) instead of ")" at column 19`,
+		`percentile(latency, 101)`:      `expected percentile between 0 and 100 instead of "101" at column 21`,
+		`count by`:                      "expected a field instead of end of the query at column 9",
+		`count every 0s`:                `expected a positive duration like 5m instead of "0s" at column 13`,
+		`count(bytes)`:                  "count takes no arguments at column 7",
+		`status == 500 and # comment`:   `unexpected "#" at column 19`,
+		`avg(latency) by section every`: `expected a positive duration like 5m instead of end of the query at column 30`,
+	} {
+		_, err := ParseQuery(query)
+		assert.EqualError(t, err, msg, query)
+	}
+}
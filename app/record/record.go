@@ -2,8 +2,9 @@ package record
 
 import (
 	"strconv"
-	"strings"
 	"time"
+
+	"github.com/paskal/datadog-parser/app/useragent"
 )
 
 type record struct {
@@ -15,12 +16,24 @@ type record struct {
 	section    string
 	status     int
 	bytes      int
+	userAgent  string          // empty if the log has no user agent column
+	agent      useragent.Agent // parsed user agent, empty if the log has no user agent column
+	latency    time.Duration   // request duration, zero if it's unknown
+	timed      bool            // true if the request duration is known
+	label      string          // label of the client network, empty if labels are not set
+	country    string          // country of the client, empty if GeoIP database is not set
+	asn        string          // autonomous system of the client, empty if GeoIP database is not set
+	filtered   bool            // true if record is excluded from the alert by the filter
 }
 
-// parseRecord from slice of strings, return false in terms of errors
-func parseRecord(raw []string) *record {
-	if len(raw) != 7 {
-		return nil
+// parseRecord from slice of strings, returns nil and the reason in terms of errors
+func parseRecord(raw []string, opts parseOpts) (*record, rejectReason) {
+	if len(raw) > 0 && raw[0] == "remotehost" {
+		return nil, reasonHeader
+	}
+	layout := opts.layout()
+	if len(raw) != layout.count {
+		return nil, reasonFields
 	}
 	var err error
 	r := record{
@@ -30,24 +43,27 @@ func parseRecord(raw []string) *record {
 		request:    raw[4],
 	}
 	if r.bytes, err = strconv.Atoi(raw[6]); err != nil {
-		return nil
+		return nil, reasonBytes
 	}
 	if r.status, err = strconv.Atoi(raw[5]); err != nil {
-		return nil
+		return nil, reasonStatus
+	}
+	var ok bool
+	if r.date, ok = opts.times.parse([]byte(raw[3])); !ok {
+		return nil, reasonTimestamp
 	}
-	var timestamp int64
-	if timestamp, err = strconv.ParseInt(raw[3], 10, 64); err != nil {
-		return nil
+	section, ok := opts.sections.section(nil, []byte(r.request))
+	if !ok {
+		return nil, reasonRequest
 	}
-	r.date = time.Unix(timestamp, 0)
-	s := strings.Split(r.request, " ")
-	if len(s) < 2 {
-		return nil
+	r.section = string(section)
+	if layout.userAgent > 0 {
+		r.userAgent = raw[layout.userAgent]
 	}
-	url := strings.Split(s[1], "/")
-	if len(url) < 2 {
-		return nil
+	if layout.latency > 0 {
+		if r.latency, r.timed, ok = parseLatency([]byte(raw[layout.latency]), layout.latencyUnit); !ok {
+			return nil, reasonLatency
+		}
 	}
-	r.section = "/" + url[1]
-	return &r
+	return &r, reasonNone
 }
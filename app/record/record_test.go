@@ -12,14 +12,15 @@ func TestParseRecord(t *testing.T) {
 	var testData = []struct {
 		input  []string
 		output *record
+		reason rejectReason
 	}{
-		{input: []string{}, output: nil},
-		{input: []string{"remotehost", "rfc931", "authuser", "date", "request", "status", "bytes"}, output: nil},
-		{input: []string{"10.0.0.2", "-", "apache", "not_a_number", "GET /api/user HTTP/1.0", "200", "1234"}, output: nil},
-		{input: []string{"10.0.0.2", "-", "apache", "1549573860", "GET /api/user HTTP/1.0", "not_a_number", "1234"}, output: nil},
-		{input: []string{"10.0.0.2", "-", "apache", "1549573860", "GET /api/user HTTP/1.0", "200", "not_a_number"}, output: nil},
-		{input: []string{"10.0.0.2", "-", "apache", "1549573860", "wrong_line", "200", "1234"}, output: nil},
-		{input: []string{"10.0.0.2", "-", "apache", "1549573860", "GET /api/user HTTP/1.0", "200", "1234", "extra_field"}, output: nil},
+		{input: []string{}, output: nil, reason: reasonFields},
+		{input: []string{"remotehost", "rfc931", "authuser", "date", "request", "status", "bytes"}, output: nil, reason: reasonHeader},
+		{input: []string{"10.0.0.2", "-", "apache", "not_a_number", "GET /api/user HTTP/1.0", "200", "1234"}, output: nil, reason: reasonTimestamp},
+		{input: []string{"10.0.0.2", "-", "apache", "1549573860", "GET /api/user HTTP/1.0", "not_a_number", "1234"}, output: nil, reason: reasonStatus},
+		{input: []string{"10.0.0.2", "-", "apache", "1549573860", "GET /api/user HTTP/1.0", "200", "not_a_number"}, output: nil, reason: reasonBytes},
+		{input: []string{"10.0.0.2", "-", "apache", "1549573860", "wrong_line", "200", "1234"}, output: nil, reason: reasonRequest},
+		{input: []string{"10.0.0.2", "-", "apache", "1549573860", "GET /api/user HTTP/1.0", "200", "1234", "extra_field"}, output: nil, reason: reasonFields},
 		{
 			input: []string{"10.0.0.2", "-", "apache", "1549573860", "GET /api/user HTTP/1.0", "200", "1234"},
 			output: &record{
@@ -38,7 +39,9 @@ func TestParseRecord(t *testing.T) {
 	for i, x := range testData {
 		x := x
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
-			assert.Equal(t, x.output, parseRecord(x.input))
+			r, reason := parseRecord(x.input, defaultParseOpts)
+			assert.Equal(t, x.output, r)
+			assert.Equal(t, x.reason, reason)
 		})
 	}
 }
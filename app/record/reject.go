@@ -0,0 +1,127 @@
+package record
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rejectReason describes why the log line was not processed
+type rejectReason uint8
+
+const (
+	reasonNone rejectReason = iota
+	reasonHeader
+	reasonCSV
+	reasonFields
+	reasonBytes
+	reasonStatus
+	reasonTimestamp
+	reasonRequest
+	reasonLatency
+	reasonRead
+	reasonsCount
+)
+
+var reasonNames = [reasonsCount]string{
+	reasonNone:      "none",
+	reasonHeader:    "header",
+	reasonCSV:       "malformed csv",
+	reasonFields:    "wrong number of fields",
+	reasonBytes:     "bad bytes",
+	reasonStatus:    "bad status",
+	reasonTimestamp: "bad timestamp",
+	reasonRequest:   "bad request",
+	reasonLatency:   "bad latency",
+	reasonRead:      "read error",
+}
+
+func (r rejectReason) String() string {
+	return reasonNames[r]
+}
+
+// rejectStats counts rejected lines by reason
+type rejectStats struct {
+	lines   int // all lines, including accepted ones
+	reasons [reasonsCount]int
+}
+
+// add line with the provided reason, reasonNone for accepted lines
+func (s *rejectStats) add(reason rejectReason) {
+	s.lines++
+	s.reasons[reason]++
+}
+
+// malformed returns number of rejected lines, not counting the header which is expected in the log
+func (s *rejectStats) malformed() (n int) {
+	for r := reasonCSV; r < reasonsCount; r++ {
+		n += s.reasons[r]
+	}
+	return n
+}
+
+// byReason returns malformed lines count per reason name
+func (s *rejectStats) byReason() map[string]int {
+	reasons := make(map[string]int)
+	for r := reasonCSV; r < reasonsCount; r++ {
+		if s.reasons[r] > 0 {
+			reasons[r.String()] = s.reasons[r]
+		}
+	}
+	return reasons
+}
+
+// String returns malformed lines count per reason, like "2 bad timestamp, 1 malformed csv"
+func (s *rejectStats) String() string {
+	parts := []string{}
+	for r := reasonCSV; r < reasonsCount; r++ {
+		if s.reasons[r] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", s.reasons[r], r))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// reject accounts the rejected line and writes it to the rejects file if one is set
+func (l *Processor) reject(line []byte, lineNum int, reason rejectReason) {
+	l.rejected.add(reason)
+	l.intervalRejected.add(reason)
+	if l.rejectsWriter == nil || reason == reasonHeader {
+		return
+	}
+	raw := strings.TrimRight(string(line), "\r\n")
+	_ = l.rejectsWriter.Write([]string{strconv.Itoa(lineNum), reason.String(), raw}) // error is checked on flush
+}
+
+// flushRejects writes buffered rejected lines
+func (l *Processor) flushRejects() {
+	if l.rejectsWriter == nil {
+		return
+	}
+	l.rejectsWriter.Flush()
+	if err := l.rejectsWriter.Error(); err != nil {
+		log.Printf("Error writing rejected lines: %v", err)
+	}
+}
+
+// printRejectsWarning prints a warning if the share of malformed lines since the previous report is too high
+func (l *Processor) printRejectsWarning(lastEntry time.Time) {
+	s := &l.intervalRejected
+	if malformed := s.malformed(); malformed > 0 && float64(malformed)/float64(s.lines) > l.RejectsWarnRatio {
+		if l.JSON {
+			printJSON(jsonRejects{Time: lastEntry.In(l.OutputTZ), Type: "rejects", Rejected: malformed, Lines: s.lines, Reasons: s.byReason()})
+			l.intervalRejected = rejectStats{}
+			return
+		}
+		printFunction("%s: Warning, %d of %d lines (%.2f%%) rejected since the previous report: %s\n", //nolint:errcheck
+			lastEntry.In(l.OutputTZ),
+			malformed,
+			s.lines,
+			float64(malformed)/float64(s.lines)*100,
+			s,
+		)
+	}
+	l.intervalRejected = rejectStats{}
+}
@@ -0,0 +1,137 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRejects(t *testing.T) {
+	log := `"remotehost","rfc931","authuser","date","request","status","bytes"
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",not_a_number,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573861,"GET /api/user HTTP/1.0",200
+"10.0.0.2","-","apache",1549573862,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573871,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573872,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573882,"GET /api/user HTTP/1.0",200,1234
+`
+	rejects := new(bytes.Buffer)
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Minute * 2,
+		AlertThresholdPerSecond: 10,
+		Rejects:                 rejects,
+		RejectsWarnRatio:        0.1,
+	}
+	output := runProcessor(&logProcessor)
+
+	// header is not counted as a malformed line, and there are no rejected lines in the second interval
+	assert.Equal(t, `2019-02-07 21:11:02 +0000 UTC: 2 hits from 1 users with 2468 bytes transferred, top /api with 2 hits
+2019-02-07 21:11:02 +0000 UTC: Warning, 2 of 5 lines (40.00%) rejected since the previous report: 1 wrong number of fields, 1 bad timestamp
+2019-02-07 21:11:12 +0000 UTC: 3 hits from 1 users with 3702 bytes transferred, top /api with 3 hits
+`, output)
+	assert.Equal(t, `3,bad timestamp,"""10.0.0.2"",""-"",""apache"",not_a_number,""GET /api/user HTTP/1.0"",200,1234"
+4,wrong number of fields,"""10.0.0.2"",""-"",""apache"",1549573861,""GET /api/user HTTP/1.0"",200"
+`, rejects.String())
+	assert.Equal(t, 8, logProcessor.rejected.lines)
+	assert.Equal(t, 2, logProcessor.rejected.malformed())
+	assert.Equal(t, 1, logProcessor.rejected.reasons[reasonHeader])
+}
+
+func TestAllLinesRejected(t *testing.T) {
+	log := `"10.0.0.2","-","apache",not_a_number,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573861,"GET /api/user HTTP/1.0",200
+`
+	wallClock := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Minute * 2,
+		AlertThresholdPerSecond: 10,
+		now:                     func() time.Time { return wallClock },
+	}
+	// there are no reports, so the warning is printed by the wall clock at the end of the input
+	assert.Equal(t, "2021-01-01 00:00:00 +0000 UTC: Warning, 2 of 2 lines (100.00%) rejected since the previous report: "+
+		"1 wrong number of fields, 1 bad timestamp\n", runProcessor(&logProcessor))
+
+	// and on the idle tick, once more lines are rejected
+	var output strings.Builder
+	printFunction = func(format string, a ...interface{}) (n int, err error) {
+		return fmt.Fprintf(&output, format, a...)
+	}
+	defer func() { printFunction = fmt.Printf }()
+	logProcessor.now = func() time.Time { return wallClock.Add(time.Minute) }
+	logProcessor.idle()
+	logProcessor.reject([]byte("garbage"), 3, reasonFields)
+	logProcessor.idle()
+	logProcessor.idle()
+	assert.Equal(t, "2021-01-01 00:01:00 +0000 UTC: Warning, 1 of 1 lines (100.00%) rejected since the previous report: "+
+		"1 wrong number of fields\n", output.String())
+}
+
+// errReader returns an error on the first reads, and the log afterwards
+type errReader struct {
+	failures int // number of reads to fail
+	log      *strings.Reader
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	if e.failures > 0 {
+		e.failures--
+		return 0, errors.New("disk is on fire")
+	}
+	return e.log.Read(p)
+}
+
+func TestReadError(t *testing.T) {
+	r := &errReader{failures: 1, log: strings.NewReader(`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234` + "\n")}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := newPipeline(r, 1, 100, defaultParseOpts)
+	p.start(ctx)
+
+	b := p.next(ctx, nil)
+	require.NotNil(t, b)
+	assert.Equal(t, []rejectReason{reasonRead}, b.reasons)
+	assert.Equal(t, "disk is on fire\n", string(b.line(0)))
+	p.release(b)
+
+	b = p.next(ctx, nil)
+	require.NotNil(t, b)
+	assert.Equal(t, []rejectReason{reasonNone}, b.reasons)
+	p.release(b)
+}
+
+func TestPersistentReadError(t *testing.T) {
+	r := &errReader{failures: 5, log: strings.NewReader(`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234` + "\n")}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := newPipeline(r, 1, 100, defaultParseOpts)
+	var sleeps []time.Duration
+	p.sleep = func(ctx context.Context, d time.Duration) bool {
+		sleeps = append(sleeps, d)
+		return true
+	}
+	p.start(ctx)
+
+	// error is reported once, and retried with growing pauses till the read succeeds
+	b := p.next(ctx, nil)
+	require.NotNil(t, b)
+	assert.Equal(t, []rejectReason{reasonRead}, b.reasons)
+	p.release(b)
+
+	b = p.next(ctx, nil)
+	require.NotNil(t, b)
+	assert.Equal(t, []rejectReason{reasonNone}, b.reasons)
+	p.release(b)
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}, sleeps)
+
+	assert.Equal(t, 30*time.Second, nextErrorSleep(20*time.Second))
+}
@@ -0,0 +1,151 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"time"
+)
+
+// dateField is the position of the date column, which is the same in all log layouts
+const dateField = 3
+
+// Replayer writes the log with the same pauses between the lines as between their dates, sped up by Speed,
+// so that the log written long ago could be followed by Processor as if it was being written now
+type Replayer struct {
+	LogReader  io.Reader
+	Output     io.Writer
+	Timestamps *TimeParser // timestamps format is detected automatically if not set
+	Speed      float64     // pace of the replay, 2 is twice as fast as the log was written, no pauses if 0
+	Retime     bool        // replace dates with the time the lines are written at, in the same format
+
+	now   func() time.Time                                // time.Now if not set
+	sleep func(ctx context.Context, d time.Duration) bool // waits for the duration unless the context is cancelled
+}
+
+// Replay writes the log till its end or till the context is cancelled. Lines without a valid date,
+// like the header, are written right away, and so are the lines with dates before the previous ones.
+func (r *Replayer) Replay(ctx context.Context) error {
+	times := r.Timestamps
+	if times == nil {
+		times = defaultTimeParser
+	}
+	now, sleep := r.now, r.sleep
+	if now == nil {
+		now = time.Now
+	}
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	in := bufio.NewReaderSize(r.LogReader, readBufferSize)
+	out := bufio.NewWriter(r.Output)
+	var begin, first time.Time // time the first record was written at, and its date
+	var line []byte            // current line, could span multiple reads
+	var quotes int             // number of quotes in the current line, odd number means newline is quoted
+	for {
+		chunk, err := in.ReadSlice('\n')
+		line = append(line, chunk...)
+		quotes += bytes.Count(chunk, []byte{'"'})
+		if err == bufio.ErrBufferFull || (err == nil && quotes%2 == 1) {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			out.Flush() //nolint:errcheck // read error is more relevant
+			return err
+		}
+
+		start, end, ok := fieldBounds(line, dateField)
+		var date time.Time
+		if ok {
+			date, ok = times.parse(unquote(line[start:end]))
+		}
+		if !ok {
+			if _, werr := out.Write(line); werr != nil {
+				return werr
+			}
+		} else {
+			if first.IsZero() {
+				begin, first = now(), date
+			}
+			offset := date.Sub(first)
+			if r.Speed > 0 {
+				offset = time.Duration(float64(offset) / r.Speed)
+				if wait := begin.Add(offset).Sub(now()); wait > 0 {
+					if werr := out.Flush(); werr != nil {
+						return werr
+					}
+					if !sleep(ctx, wait) {
+						return out.Flush()
+					}
+				}
+			}
+			if werr := r.write(out, line, start, end, times, begin.Add(offset)); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || ctx.Err() != nil {
+			return out.Flush()
+		}
+		line, quotes = line[:0], 0
+	}
+}
+
+// write the line, replacing the date between start and end with the provided one if Retime is set
+func (r *Replayer) write(w *bufio.Writer, line []byte, start, end int, times *TimeParser, date time.Time) error {
+	if !r.Retime {
+		_, err := w.Write(line)
+		return err
+	}
+	field := line[start:end]
+	value := times.formatLike(date, unquote(field))
+	if len(field) != len(unquote(field)) {
+		value = `"` + value + `"`
+	}
+	w.Write(line[:start]) //nolint:errcheck // error is returned by the last write
+	w.WriteString(value)  //nolint:errcheck
+	_, err := w.Write(line[end:])
+	return err
+}
+
+// fieldBounds returns the bounds of the field with the index in the CSV line, including its quotes
+func fieldBounds(line []byte, index int) (start, end int, ok bool) {
+	field, quoted := 0, false
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			if field == index {
+				return start, i, true
+			}
+			field++
+			start = i + 1
+		}
+	}
+	if field == index {
+		return start, len(bytes.TrimRight(line, "\r\n")), true
+	}
+	return 0, 0, false
+}
+
+// unquote strips the quotes around the field
+func unquote(field []byte) []byte {
+	if len(field) >= 2 && field[0] == '"' && field[len(field)-1] == '"' {
+		return field[1 : len(field)-1]
+	}
+	return field
+}
+
+// sleepContext waits for the duration, returns false if the context was cancelled before that
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
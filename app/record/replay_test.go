@@ -0,0 +1,69 @@
+package record
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayer(t *testing.T) {
+	log := `"remotehost","rfc931","authuser","date","request","status","bytes"
+"10.0.0.1","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1000
+"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1000
+not a record
+"10.0.0.3","-","apache",1549573865,"GET ""/report
+"" HTTP/1.0",200,1000
+"10.0.0.1","-","apache",1549573862,"GET /api/user HTTP/1.0",200,1000
+"10.0.0.1","-","apache","1549573870","GET /api/user HTTP/1.0",200,1000`
+
+	replay := func(speed float64, retime bool) (out string, waits []time.Duration) {
+		clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		var w strings.Builder
+		r := Replayer{LogReader: strings.NewReader(log), Output: &w, Speed: speed, Retime: retime,
+			now: func() time.Time { return clock },
+			sleep: func(_ context.Context, d time.Duration) bool {
+				waits = append(waits, d)
+				clock = clock.Add(d)
+				return true
+			},
+		}
+		require.NoError(t, r.Replay(context.Background()))
+		return w.String(), waits
+	}
+
+	// lines are written as they are, with the pauses between their dates sped up,
+	// and the line with the date before the previous one is written right away
+	out, waits := replay(5, false)
+	assert.Equal(t, log, out)
+	assert.Equal(t, []time.Duration{time.Second, time.Second}, waits)
+
+	out, waits = replay(0, false)
+	assert.Equal(t, log, out)
+	assert.Empty(t, waits)
+
+	out, _ = replay(5, true)
+	assert.Equal(t, `"remotehost","rfc931","authuser","date","request","status","bytes"
+"10.0.0.1","-","apache",1704110400,"GET /api/user HTTP/1.0",200,1000
+"10.0.0.2","-","apache",1704110400,"GET /api/user HTTP/1.0",200,1000
+not a record
+"10.0.0.3","-","apache",1704110401,"GET ""/report
+"" HTTP/1.0",200,1000
+"10.0.0.1","-","apache",1704110400,"GET /api/user HTTP/1.0",200,1000
+"10.0.0.1","-","apache","1704110402","GET /api/user HTTP/1.0",200,1000`, out)
+
+	// the replay stops when the context is cancelled during the pause
+	ctx, cancel := context.WithCancel(context.Background())
+	var w strings.Builder
+	r := Replayer{LogReader: strings.NewReader(log), Output: &w, Speed: 1,
+		sleep: func(_ context.Context, d time.Duration) bool {
+			cancel()
+			return false
+		},
+	}
+	require.NoError(t, r.Replay(ctx))
+	assert.Equal(t, strings.Join(strings.Split(log, "\n")[:4], "\n")+"\n", w.String())
+}
@@ -0,0 +1,42 @@
+package record
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/paskal/datadog-parser/app/rollup"
+)
+
+// addRollup adds the record to the rollup of its interval. Rollups are written to the store once the log moves
+// one more interval past them, so that records which arrive a bit late are still counted in their interval.
+// Records of the interval which is written already are dropped by the store.
+func (l *Processor) addRollup(r *record) {
+	resolution := l.Rollups.Resolution()
+	start := r.date.Truncate(resolution)
+	ru, ok := l.rollups[start.Unix()]
+	if !ok {
+		ru = rollup.New(start)
+		l.rollups[start.Unix()] = ru
+	}
+	ru.Add(rollup.Group{Section: r.section, Status: r.status, Label: r.label}, r.bytes, r.remotehost)
+	l.writeRollups(r.date.Add(-resolution))
+}
+
+// writeRollups writes rollups of the intervals which end before the time to the store, in ascending order
+func (l *Processor) writeRollups(before time.Time) {
+	resolution := l.Rollups.Resolution()
+	var keys []int64
+	for key, ru := range l.rollups {
+		if !ru.Start.Add(resolution).After(before) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, key := range keys {
+		if err := l.Rollups.Write(l.rollups[key]); err != nil {
+			log.Printf("Error writing traffic rollup: %v", err)
+		}
+		delete(l.rollups, key)
+	}
+}
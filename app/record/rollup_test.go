@@ -0,0 +1,62 @@
+package record
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/paskal/datadog-parser/app/rollup"
+)
+
+func TestRollups(t *testing.T) {
+	tiers, err := rollup.ParseTiers([]string{"10s:1h", "1m:1d"})
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	// a record which arrives a bit late is counted in its own interval, and the one which arrives after
+	// its interval was written is dropped
+	log := routedLog() +
+		`"10.0.0.3","-","apache",1549573965,"GET /report HTTP/1.0",404,10,5` + "\n" +
+		`"10.0.0.3","-","apache",1549573865,"GET /report HTTP/1.0",404,10,5` + "\n"
+	start := time.Unix(1549573860, 0)
+	process := func() {
+		store, err := rollup.Open(dir, tiers)
+		require.NoError(t, err)
+		defer store.Close()
+		logProcessor := Processor{
+			LogReader:               strings.NewReader(log),
+			AlertWindow:             time.Second,
+			AlertThresholdPerSecond: 1000,
+			Rollups:                 store,
+		}
+		runProcessor(&logProcessor)
+
+		rollups, err := store.Read(10*time.Second, start, start.Add(2*time.Minute))
+		require.NoError(t, err)
+		require.Len(t, rollups, 12)
+		assert.Equal(t, 30, rollups[0].Hits)
+		assert.Equal(t, 1, rollups[0].UniqueUsers())
+		assert.Equal(t, 30, rollups[1].Hits)
+		assert.Equal(t, 11, rollups[10].Hits)
+		assert.Equal(t, 2, rollups[10].UniqueUsers())
+		assert.Equal(t, map[rollup.Group]rollup.Counts{
+			{Section: "/api", Status: 200}:    {Hits: 10, Bytes: 10 * 1234},
+			{Section: "/report", Status: 404}: {Hits: 1, Bytes: 10},
+		}, rollups[10].Groups)
+		assert.Equal(t, 10, rollups[11].Hits)
+
+		rollups, err = store.Read(time.Minute, start, start.Add(2*time.Minute))
+		require.NoError(t, err)
+		require.Len(t, rollups, 2)
+		assert.Equal(t, start.UTC(), rollups[0].Start.UTC())
+		assert.Equal(t, 180, rollups[0].Hits)
+		assert.Equal(t, 180*1234, rollups[0].Bytes)
+		assert.Equal(t, 61, rollups[1].Hits)
+	}
+	process()
+	// the same log is read from the start after restart, and its records are not counted again
+	process()
+}
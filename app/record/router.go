@@ -0,0 +1,277 @@
+package record
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// alertLabelNames are labels alerts could have, see alertLabels
+var alertLabelNames = map[string]bool{"alert": true, "section": true, "severity": true}
+
+// Routing groups alert state changes into notifications, like Alertmanager does: alerts with the same values of GroupBy
+// labels are notified together once GroupWait passes since the first change, still firing alerts are notified again
+// every RepeatInterval, and alerts matching the target of the inhibit rule are not notified while its source fires
+type Routing struct {
+	GroupBy        []string // all alerts are in the same group if empty
+	GroupWait      time.Duration
+	RepeatInterval time.Duration // firing alerts are not notified again if not set
+	Inhibit        []InhibitRule
+}
+
+// InhibitRule mutes target alerts while any source alert with the same values of Equal labels is firing
+type InhibitRule struct {
+	Source, Target map[string]string // labels the alert should have
+	Equal          []string
+}
+
+var inhibitRe = regexp.MustCompile(`^([^>]+)>(.+?)(?::([a-z_,]+))?$`)
+
+// NewRouting creates routing with inhibit rules from "source>target[:equal]" expressions, where source and target
+// are comma-separated label matchers and equal is comma-separated label names, like "alert=hits>alert=latency:section"
+func NewRouting(groupBy []string, groupWait, repeatInterval time.Duration, inhibit []string) (*Routing, error) {
+	for _, name := range groupBy {
+		if !alertLabelNames[name] {
+			return nil, fmt.Errorf("unknown alert label %q to group by", name)
+		}
+	}
+	if groupWait < 0 || repeatInterval < 0 {
+		return nil, fmt.Errorf("group wait and repeat interval should not be negative")
+	}
+	r := &Routing{GroupBy: groupBy, GroupWait: groupWait, RepeatInterval: repeatInterval}
+	for _, expr := range inhibit {
+		m := inhibitRe.FindStringSubmatch(expr)
+		if m == nil {
+			return nil, fmt.Errorf("inhibit rule %q should be in source>target[:equal] format, like alert=hits>alert=latency:section", expr)
+		}
+		rule := InhibitRule{}
+		var err error
+		if rule.Source, err = parseMatchers(m[1]); err != nil {
+			return nil, fmt.Errorf("inhibit rule %q: %w", expr, err)
+		}
+		if rule.Target, err = parseMatchers(m[2]); err != nil {
+			return nil, fmt.Errorf("inhibit rule %q: %w", expr, err)
+		}
+		if m[3] != "" {
+			rule.Equal = strings.Split(m[3], ",")
+		}
+		for _, name := range rule.Equal {
+			if !alertLabelNames[name] {
+				return nil, fmt.Errorf("inhibit rule %q has unknown alert label %q", expr, name)
+			}
+		}
+		r.Inhibit = append(r.Inhibit, rule)
+	}
+	return r, nil
+}
+
+// parseMatchers parses comma-separated "label=value" matchers
+func parseMatchers(s string) (map[string]string, error) {
+	matchers := map[string]string{}
+	for _, m := range strings.Split(s, ",") {
+		parts := strings.SplitN(m, "=", 2)
+		if len(parts) != 2 || !alertLabelNames[parts[0]] {
+			return nil, fmt.Errorf("bad matcher %q, should be label=value with alert, section or severity label", m)
+		}
+		matchers[parts[0]] = parts[1]
+	}
+	return matchers, nil
+}
+
+// matchLabels returns true if labels have all values of the matchers
+func matchLabels(matchers, labels map[string]string) bool {
+	for name, value := range matchers {
+		if labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// router keeps the latest state change of every alert which is firing or not notified yet, and notifies groups of them
+type router struct {
+	Routing
+	alerts map[alertRule]*routedAlert
+	groups map[string]*alertGroup
+	next   time.Time // earliest time any group should be notified at, zero if none should
+}
+
+type routedAlert struct {
+	alertEvent
+	group    *alertGroup
+	notified bool // false if the alert fired and wasn't notified yet
+}
+
+// alertGroup is the set of alerts with the same values of GroupBy labels
+type alertGroup struct {
+	key      string      // values of GroupBy labels, like "alert=hits section=/api"
+	alerts   []alertRule // in order of their first state change
+	pending  time.Time   // time the group should be notified at due to changes, zero if there were none
+	notified time.Time   // time of the last notification
+}
+
+func newRouter(r Routing) *router {
+	return &router{Routing: r, alerts: map[alertRule]*routedAlert{}, groups: map[string]*alertGroup{}}
+}
+
+// route the alert state change into its group
+func (r *router) route(e alertEvent) {
+	a, ok := r.alerts[e.rule]
+	if !ok {
+		if e.state != "RED" {
+			// alert which was never routed as firing, like the silenced one, recovers without notification
+			return
+		}
+		a = &routedAlert{group: r.group(e.labels)}
+		a.group.alerts = append(a.group.alerts, e.rule)
+		r.alerts[e.rule] = a
+	}
+	if e.state == "RED" {
+		a.notified = false
+	}
+	a.alertEvent = e
+	if a.group.pending.IsZero() {
+		a.group.pending = e.time.Add(r.GroupWait)
+	}
+	r.schedule()
+}
+
+// group returns the group for the alert labels, creating it if needed
+func (r *router) group(labels map[string]string) *alertGroup {
+	key := "all alerts"
+	if len(r.GroupBy) > 0 {
+		parts := make([]string, len(r.GroupBy))
+		for i, name := range r.GroupBy {
+			parts[i] = name + "=" + labels[name]
+		}
+		key = strings.Join(parts, " ")
+	}
+	g, ok := r.groups[key]
+	if !ok {
+		g = &alertGroup{key: key}
+		r.groups[key] = g
+	}
+	return g
+}
+
+// inhibited returns true if any source alert of the inhibit rules matching the alert is firing
+func (r *router) inhibited(rule alertRule, labels map[string]string) bool {
+	for _, inhibit := range r.Inhibit {
+		if !matchLabels(inhibit.Target, labels) {
+			continue
+		}
+		for source, a := range r.alerts {
+			if source != rule && a.state == "RED" && matchLabels(inhibit.Source, a.labels) && equalLabels(inhibit.Equal, labels, a.labels) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// equalLabels returns true if both labels have the same values of the provided names
+func equalLabels(names []string, a, b map[string]string) bool {
+	for _, name := range names {
+		if a[name] != b[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// schedule updates the time of the earliest notification
+func (r *router) schedule() {
+	r.next = time.Time{}
+	for _, g := range r.groups {
+		if t := r.due(g); !t.IsZero() && (r.next.IsZero() || t.Before(r.next)) {
+			r.next = t
+		}
+	}
+}
+
+// due returns the time the group should be notified at, zero if it shouldn't
+func (r *router) due(g *alertGroup) time.Time {
+	if !g.pending.IsZero() {
+		return g.pending
+	}
+	if r.RepeatInterval > 0 && len(g.alerts) > 0 {
+		return g.notified.Add(r.RepeatInterval)
+	}
+	return time.Time{}
+}
+
+// flush notifies the groups which are due at the current time
+func (r *router) flush(l *Processor, currentTime time.Time) {
+	if r.next.IsZero() || currentTime.Before(r.next) {
+		return
+	}
+	keys := make([]string, 0, len(r.groups))
+	for key, g := range r.groups {
+		if t := r.due(g); !t.IsZero() && !currentTime.Before(t) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		r.notifyGroup(l, r.groups[key], currentTime)
+	}
+	r.schedule()
+}
+
+// notifyGroup prints the notification with firing alerts of the group which are neither inhibited nor silenced
+// at the current time, and alerts resolved since the previous notification, and forgets the resolved ones
+func (r *router) notifyGroup(l *Processor, g *alertGroup, currentTime time.Time) {
+	repeat := g.pending.IsZero()
+	var firing, resolved []*routedAlert
+	alerts := g.alerts[:0]
+	for _, rule := range g.alerts {
+		a := r.alerts[rule]
+		if a.state == "RED" {
+			alerts = append(alerts, rule)
+			if !r.inhibited(rule, a.labels) && !l.silenced(a.labels, currentTime) {
+				firing = append(firing, a)
+			}
+			continue
+		}
+		delete(r.alerts, rule)
+		if a.notified {
+			resolved = append(resolved, a)
+		}
+	}
+	g.alerts = alerts
+	g.pending, g.notified = time.Time{}, currentTime
+	if len(firing)+len(resolved) > 0 {
+		l.printNotification(g, firing, resolved, repeat, currentTime)
+	}
+	for _, a := range firing {
+		a.notified = true
+	}
+	if len(g.alerts) == 0 {
+		delete(r.groups, g.key)
+	}
+}
+
+func (l *Processor) printNotification(g *alertGroup, firing, resolved []*routedAlert, repeat bool, currentTime time.Time) {
+	alerts := make([]*routedAlert, 0, len(firing)+len(resolved))
+	alerts = append(append(alerts, firing...), resolved...)
+	if l.JSON {
+		n := jsonNotification{Time: currentTime.In(l.OutputTZ), Type: "notification", Group: g.key, Repeat: repeat,
+			Firing: len(firing), Resolved: len(resolved), Alerts: make([]jsonAlertEvent, len(alerts))}
+		for i, a := range alerts {
+			n.Alerts[i] = a.json
+		}
+		printJSON(n)
+		return
+	}
+	var repeated string
+	if repeat {
+		repeated = ", repeated"
+	}
+	printFunction("%s: Notification for %s, %d firing and %d resolved%s\n", //nolint:errcheck
+		currentTime.In(l.OutputTZ), g.key, len(firing), len(resolved), repeated)
+	for _, a := range alerts {
+		printFunction("%s:   %s\n", a.time.In(l.OutputTZ), a.text) //nolint:errcheck
+	}
+}
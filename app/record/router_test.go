@@ -0,0 +1,163 @@
+package record
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/paskal/datadog-parser/app/silence"
+)
+
+func TestNewRouting(t *testing.T) {
+	r, err := NewRouting([]string{"section"}, 30*time.Second, time.Hour,
+		[]string{"alert=slo,severity=fast>alert=slo,severity=slow:section", "alert=hits>alert=latency"})
+	require.NoError(t, err)
+	assert.Equal(t, &Routing{GroupBy: []string{"section"}, GroupWait: 30 * time.Second, RepeatInterval: time.Hour,
+		Inhibit: []InhibitRule{
+			{Source: map[string]string{"alert": "slo", "severity": "fast"}, Target: map[string]string{"alert": "slo", "severity": "slow"},
+				Equal: []string{"section"}},
+			{Source: map[string]string{"alert": "hits"}, Target: map[string]string{"alert": "latency"}},
+		}}, r)
+
+	_, err = NewRouting([]string{"client"}, 0, 0, nil)
+	assert.EqualError(t, err, `unknown alert label "client" to group by`)
+	_, err = NewRouting(nil, -time.Second, 0, nil)
+	assert.EqualError(t, err, "group wait and repeat interval should not be negative")
+	_, err = NewRouting(nil, 0, 0, []string{"alert=hits"})
+	assert.EqualError(t, err, `inhibit rule "alert=hits" should be in source>target[:equal] format, like alert=hits>alert=latency:section`)
+	_, err = NewRouting(nil, 0, 0, []string{"client=10.0.0.1>alert=hits"})
+	assert.EqualError(t, err, `inhibit rule "client=10.0.0.1>alert=hits": bad matcher "client=10.0.0.1", should be label=value with alert, section or severity label`)
+	_, err = NewRouting(nil, 0, 0, []string{"alert=hits>alert=latency:client"})
+	assert.EqualError(t, err, `inhibit rule "alert=hits>alert=latency:client" has unknown alert label "client"`)
+}
+
+// routedLog returns a log with three slow hits of /api per second for a minute, and then a fast one per second for a minute
+func routedLog() string {
+	var log strings.Builder
+	log.WriteString("remotehost,rfc931,authuser,date,request,status,bytes,latency\n")
+	start := int64(1549573860)
+	for second := int64(0); second < 2*60; second++ {
+		hits, latency := 3, 2000
+		if second >= 60 {
+			hits, latency = 1, 10
+		}
+		for i := 0; i < hits; i++ {
+			fmt.Fprintf(&log, "\"10.0.0.2\",\"-\",\"apache\",%d,\"GET /api/user HTTP/1.0\",200,1234,%d\n", start+second, latency)
+		}
+	}
+	return log.String()
+}
+
+func TestRouter(t *testing.T) {
+	latencyRules, err := NewLatencyRules([]string{"/api:p50>1s", "p90>1s"})
+	require.NoError(t, err)
+	routing, err := NewRouting(nil, 10*time.Second, 30*time.Second, nil)
+	require.NoError(t, err)
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(routedLog()),
+		AlertWindow:             time.Second,
+		AlertThresholdPerSecond: 2,
+		LatencyAlerts:           latencyRules,
+		Routing:                 routing,
+	}
+	// alerts are listed in order of their first change, and latency ones fire on the first record before the hits one
+	assert.Equal(t, []string{
+		"2019-02-07 21:11:10 +0000 UTC: Notification for all alerts, 3 firing and 0 resolved",
+		"2019-02-07 21:11:00 +0000 UTC:   Alert RED, p50 latency of /api ~2s is higher than 1s in the last 1s",
+		"2019-02-07 21:11:00 +0000 UTC:   Alert RED, p90 latency ~2s is higher than 1s in the last 1s",
+		"2019-02-07 21:11:00 +0000 UTC:   Alert RED, ~3.00 hits per second which is higher than 2 (3 total) in the last 1s, top clients 10.0.0.2 (3 hits), " +
+			"by bytes 10.0.0.2 (3702 bytes), top users apache (3 hits)",
+		"2019-02-07 21:11:40 +0000 UTC: Notification for all alerts, 3 firing and 0 resolved, repeated",
+		"2019-02-07 21:11:00 +0000 UTC:   Alert RED, p50 latency of /api ~2s is higher than 1s in the last 1s",
+		"2019-02-07 21:11:00 +0000 UTC:   Alert RED, p90 latency ~2s is higher than 1s in the last 1s",
+		"2019-02-07 21:11:00 +0000 UTC:   Alert RED, ~3.00 hits per second which is higher than 2 (3 total) in the last 1s, top clients 10.0.0.2 (3 hits), " +
+			"by bytes 10.0.0.2 (3702 bytes), top users apache (3 hits)",
+		"2019-02-07 21:12:11 +0000 UTC: Notification for all alerts, 0 firing and 3 resolved",
+		"2019-02-07 21:12:01 +0000 UTC:   Alert GREEN, p50 latency of /api ~10ms is lower than 1s in the last 1s",
+		"2019-02-07 21:12:01 +0000 UTC:   Alert GREEN, p90 latency ~10ms is lower than 1s in the last 1s",
+		"2019-02-07 21:12:01 +0000 UTC:   Alert GREEN, ~2.00 hits per second which is lower than 2 (2 total) in the last 1s",
+	}, notificationLines(runProcessor(&logProcessor)))
+}
+
+func TestRouterInhibit(t *testing.T) {
+	latencyRules, err := NewLatencyRules([]string{"/api:p50>1s"})
+	require.NoError(t, err)
+	routing, err := NewRouting(nil, 5*time.Second, 0, []string{"alert=hits>alert=latency"})
+	require.NoError(t, err)
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(routedLog()),
+		AlertWindow:             time.Second,
+		AlertThresholdPerSecond: 2,
+		LatencyAlerts:           latencyRules,
+		Routing:                 routing,
+		JSON:                    true,
+	}
+	// latency alert fires on the first record before the hits one, so its change isn't marked as inhibited, but it's
+	// left out of the notification sent after the group wait as hits alert is firing by then, and it's not notified
+	// as resolved along with the hits one since it was never notified as firing
+	assert.Equal(t, []string{
+		`{"time":"2019-02-07T21:11:00Z","type":"alert","alert":"latency","state":"RED","section":"/api","percentile":50,"latency_ms":2000,"threshold_ms":1000,"window":"1s"}`,
+		`{"time":"2019-02-07T21:11:00Z","type":"alert","alert":"hits","state":"RED","per_second":3,"threshold":2,"total":3,"window":"1s","top_clients":[{"key":"10.0.0.2","count":3}],"top_clients_by_bytes":[{"key":"10.0.0.2","count":3702}],"top_users":[{"key":"apache","count":3}]}`,
+		`{"time":"2019-02-07T21:11:05Z","type":"notification","group":"all alerts","firing":1,"resolved":0,"alerts":[{"time":"2019-02-07T21:11:00Z","type":"alert","alert":"hits","state":"RED","per_second":3,"threshold":2,"total":3,"window":"1s","top_clients":[{"key":"10.0.0.2","count":3}],"top_clients_by_bytes":[{"key":"10.0.0.2","count":3702}],"top_users":[{"key":"apache","count":3}]}]}`,
+		`{"time":"2019-02-07T21:12:01Z","type":"alert","alert":"hits","state":"GREEN","per_second":2,"threshold":2,"total":2,"window":"1s"}`,
+		`{"time":"2019-02-07T21:12:01Z","type":"alert","alert":"latency","state":"GREEN","section":"/api","percentile":50,"latency_ms":10,"threshold_ms":1000,"window":"1s"}`,
+		`{"time":"2019-02-07T21:12:06Z","type":"notification","group":"all alerts","firing":0,"resolved":1,"alerts":[{"time":"2019-02-07T21:12:01Z","type":"alert","alert":"hits","state":"GREEN","per_second":2,"threshold":2,"total":2,"window":"1s"}]}`,
+	}, notificationLines(runProcessor(&logProcessor)))
+}
+
+func TestRouterSilenced(t *testing.T) {
+	routing, err := NewRouting(nil, 10*time.Second, 30*time.Second, nil)
+	require.NoError(t, err)
+	// silence starts after the hits alert was notified as firing, so it's left out of the repeated notification
+	silences := silence.NewStore(time.UTC)
+	start := time.Unix(1549573880, 0)
+	end := start.Add(time.Hour)
+	_, err = silences.Add(silence.Silence{Matchers: map[string]string{"alert": "hits"}, Start: &start, End: &end})
+	require.NoError(t, err)
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(routedLog()),
+		AlertWindow:             time.Second,
+		AlertThresholdPerSecond: 2,
+		Routing:                 routing,
+		Silences:                silences,
+	}
+	assert.Equal(t, []string{
+		"2019-02-07 21:11:10 +0000 UTC: Notification for all alerts, 1 firing and 0 resolved",
+		"2019-02-07 21:11:00 +0000 UTC:   Alert RED, ~3.00 hits per second which is higher than 2 (3 total) in the last 1s, top clients 10.0.0.2 (3 hits), " +
+			"by bytes 10.0.0.2 (3702 bytes), top users apache (3 hits)",
+		"2019-02-07 21:12:11 +0000 UTC: Notification for all alerts, 0 firing and 1 resolved",
+		"2019-02-07 21:12:01 +0000 UTC:   Alert GREEN, ~2.00 hits per second which is lower than 2 (2 total) in the last 1s",
+	}, notificationLines(runProcessor(&logProcessor)))
+}
+
+func TestRouterInhibited(t *testing.T) {
+	routing, err := NewRouting(nil, 0, 0, []string{"alert=slo,severity=fast>alert=slo,severity=slow:section"})
+	require.NoError(t, err)
+	r := newRouter(*routing)
+	fast, slow := &burnRateAlert{}, &burnRateAlert{}
+	fastLabels := map[string]string{"alert": "slo", "section": "/api", "severity": "fast"}
+	slowLabels := map[string]string{"alert": "slo", "section": "/api", "severity": "slow"}
+	r.route(alertEvent{rule: fast, state: "RED", labels: fastLabels})
+	assert.True(t, r.inhibited(slow, slowLabels))
+	assert.False(t, r.inhibited(slow, map[string]string{"alert": "slo", "section": "/report", "severity": "slow"}),
+		"other section is not inhibited")
+	assert.False(t, r.inhibited(fast, fastLabels), "source doesn't inhibit itself")
+	r.route(alertEvent{rule: fast, state: "GREEN", labels: fastLabels})
+	assert.False(t, r.inhibited(slow, slowLabels), "resolved source doesn't inhibit")
+}
+
+// notificationLines returns alerts and notifications from the processor output
+func notificationLines(output string) []string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, "Alert") || strings.Contains(line, "Notification") || strings.Contains(line, `"type":"alert"`) ||
+			strings.Contains(line, `"type":"notification"`) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
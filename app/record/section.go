@@ -0,0 +1,107 @@
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// builtin normalization rules, enabled with SectionRules.Normalize
+var defaultNormalization = []string{
+	"{uuid}=[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}",
+	"{id}=[0-9]+",
+}
+
+// SectionRules define how the section is extracted from the request URL:
+// query string and fragment are stripped, the path is cut to Depth segments
+// and every segment matching one of normalization rules is replaced with its placeholder
+type SectionRules struct {
+	depth int
+	rules []normalization
+}
+
+// normalization replaces path segment fully matching the pattern with the placeholder
+type normalization struct {
+	pattern     *regexp.Regexp
+	placeholder []byte
+}
+
+// NewSectionRules creates SectionRules with the provided depth, which is at least 1.
+// Every rule is a "placeholder=regexp" string, and the segment is replaced with the placeholder
+// of the first rule matching it. Builtin rules for numeric IDs and UUIDs are added after the
+// provided ones if normalize is set.
+func NewSectionRules(depth int, normalize bool, rules []string) (*SectionRules, error) {
+	if depth < 1 {
+		return nil, fmt.Errorf("section depth should be at least 1, got %d", depth)
+	}
+	s := &SectionRules{depth: depth}
+	if normalize {
+		rules = append(rules[:len(rules):len(rules)], defaultNormalization...)
+	}
+	for _, rule := range rules {
+		eq := strings.Index(rule, "=")
+		if eq < 1 {
+			return nil, fmt.Errorf("section rule %q should be in placeholder=regexp format", rule)
+		}
+		pattern, err := regexp.Compile("^(?:" + rule[eq+1:] + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("section rule %q: %w", rule, err)
+		}
+		s.rules = append(s.rules, normalization{pattern: pattern, placeholder: []byte(rule[:eq])})
+	}
+	return s, nil
+}
+
+// defaultSectionRules use the first path segment as the section
+var defaultSectionRules = &SectionRules{depth: 1}
+
+// section appends the section of the request line like "GET /api/user HTTP/1.0" to dst,
+// returns false if there is no URL in the request
+func (s *SectionRules) section(dst, request []byte) ([]byte, bool) {
+	space := bytes.IndexByte(request, ' ')
+	if space < 0 {
+		return dst, false
+	}
+	url := request[space+1:]
+	if end := bytes.IndexAny(url, " ?#"); end >= 0 {
+		url = url[:end]
+	}
+	slash := bytes.IndexByte(url, '/')
+	if slash < 0 {
+		return dst, false
+	}
+	path := url[slash+1:]
+	for n := 0; n < s.depth; n++ {
+		segment := path
+		if end := bytes.IndexByte(path, '/'); end >= 0 {
+			segment, path = path[:end], path[end+1:]
+		} else {
+			path = nil
+		}
+		// first segment is always there, even if empty, so that "/" is a section too
+		if n > 0 && len(segment) == 0 {
+			if path == nil {
+				break
+			}
+			n--
+			continue
+		}
+		dst = append(dst, '/')
+		dst = append(dst, s.normalize(segment)...)
+		if path == nil {
+			break
+		}
+	}
+	return dst, true
+}
+
+// normalize returns the placeholder of the first rule matching the segment, or the segment itself
+func (s *SectionRules) normalize(segment []byte) []byte {
+	for _, r := range s.rules {
+		if r.pattern.Match(segment) {
+			return r.placeholder
+		}
+	}
+	return segment
+}
@@ -0,0 +1,69 @@
+package record
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSectionRules(t *testing.T) {
+	var testData = []struct {
+		description string
+		depth       int
+		normalize   bool
+		rules       []string
+		request     string
+		section     string
+	}{
+		{description: "default", depth: 1, request: "GET /api/user HTTP/1.0", section: "/api"},
+		{description: "root", depth: 1, request: "GET / HTTP/1.0", section: "/"},
+		{description: "root with depth", depth: 3, request: "GET / HTTP/1.0", section: "/"},
+		{description: "no URL", depth: 1, request: "GET", section: ""},
+		{description: "no slash", depth: 1, request: "GET api HTTP/1.0", section: ""},
+		{description: "query is stripped", depth: 1, request: "GET /api?user=1 HTTP/1.0", section: "/api"},
+		{description: "fragment is stripped", depth: 2, request: "GET /api/user#top HTTP/1.0", section: "/api/user"},
+		{description: "depth", depth: 2, request: "GET /api/user/1/profile HTTP/1.0", section: "/api/user"},
+		{description: "shorter than depth", depth: 3, request: "GET /api/user HTTP/1.0", section: "/api/user"},
+		{description: "empty segments", depth: 3, request: "GET /api//user/ HTTP/1.0", section: "/api/user"},
+		{description: "normalize ID", depth: 3, normalize: true, request: "GET /api/user/123/profile HTTP/1.0", section: "/api/user/{id}"},
+		{description: "normalize UUID", depth: 3, normalize: true,
+			request: "GET /api/user/0e2b4f0c-5bd3-4bc2-8a0b-6dc9f6c02d1e HTTP/1.0", section: "/api/user/{uuid}"},
+		{description: "partial match is not replaced", depth: 3, normalize: true, request: "GET /api/v2/user HTTP/1.0", section: "/api/v2/user"},
+		{description: "custom rule goes first", depth: 3, normalize: true, rules: []string{"{year}=20[0-9]{2}"},
+			request: "GET /report/2019/07 HTTP/1.0", section: "/report/{year}/{id}"},
+	}
+
+	for _, x := range testData {
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			rules, err := NewSectionRules(x.depth, x.normalize, x.rules)
+			require.NoError(t, err)
+			section, ok := rules.section(nil, []byte(x.request))
+			assert.Equal(t, x.section != "", ok)
+			assert.Equal(t, x.section, string(section))
+		})
+	}
+}
+
+func TestNewSectionRulesErrors(t *testing.T) {
+	_, err := NewSectionRules(0, false, nil)
+	assert.EqualError(t, err, "section depth should be at least 1, got 0")
+	_, err = NewSectionRules(1, false, []string{"[0-9]+"})
+	assert.EqualError(t, err, `section rule "[0-9]+" should be in placeholder=regexp format`)
+	_, err = NewSectionRules(1, false, []string{"{id}=[0-9"})
+	assert.Error(t, err)
+}
+
+func TestSectionRulesInParser(t *testing.T) {
+	rules, err := NewSectionRules(2, true, nil)
+	require.NoError(t, err)
+	p := newLineParser(parseOpts{sections: rules, times: defaultTimeParser})
+	r := record{}
+	assert.Equal(t, reasonNone, p.parse([]byte(`"10.0.0.2","-","apache",1549573860,"GET /api/42?x=y HTTP/1.0",200,1234`+"\n"), &r))
+	assert.Equal(t, "/api/{id}", r.section)
+	raw := []string{"10.0.0.2", "-", "apache", "1549573860", "GET /api/42?x=y HTTP/1.0", "200", "1234"}
+	slow, reason := parseRecord(raw, parseOpts{sections: rules, times: defaultTimeParser})
+	assert.Equal(t, reasonNone, reason)
+	assert.Equal(t, &r, slow)
+}
@@ -0,0 +1,237 @@
+package record
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sloResolution is the precision of SLO windows, which are much longer than the alert window
+const sloResolution = time.Minute
+
+// SLO is the service level objective: share of requests of the section, all of them if the section is empty,
+// which should not fail with 5xx status over the period
+type SLO struct {
+	Section   string
+	Objective float64 // percent of good requests, like 99.9
+	Period    time.Duration
+}
+
+// burnRateWindows are multi-window burn rate alerts from the Google SRE workbook: alert fires when both long and short
+// windows consume the error budget fast enough to spend the provided share of it over the long window
+var burnRateWindows = []struct {
+	severity    string
+	long, short time.Duration
+	budget      float64
+}{
+	{"fast", time.Hour, 5 * time.Minute, 0.02},
+	{"slow", 6 * time.Hour, 30 * time.Minute, 0.05},
+}
+
+var sloRe = regexp.MustCompile(`^(?:(.+):)?(\d+(?:\.\d+)?)%?$`)
+
+// NewSLOs creates objectives from "[section:]percent" expressions like "/api:99.9%" over the provided period
+func NewSLOs(exprs []string, period time.Duration) ([]SLO, error) {
+	if len(exprs) > 0 && period < burnRateWindows[len(burnRateWindows)-1].long {
+		return nil, fmt.Errorf("SLO period should be at least %s, got %s", burnRateWindows[len(burnRateWindows)-1].long, period)
+	}
+	slos := make([]SLO, 0, len(exprs))
+	for _, expr := range exprs {
+		m := sloRe.FindStringSubmatch(expr)
+		if m == nil {
+			return nil, fmt.Errorf("SLO %q should be in [section:]percent format, like /api:99.9%%", expr)
+		}
+		objective, err := strconv.ParseFloat(m[2], 64)
+		if err != nil || objective <= 0 || objective >= 100 {
+			return nil, fmt.Errorf("SLO %q should have objective between 0 and 100 percent", expr)
+		}
+		slos = append(slos, SLO{Section: m[1], Objective: objective, Period: period})
+	}
+	return slos, nil
+}
+
+// name returns the objective description like "/api 99.9%"
+func (s SLO) name() string {
+	if s.Section == "" {
+		return fmt.Sprintf("%g%%", s.Objective)
+	}
+	return fmt.Sprintf("%s %g%%", s.Section, s.Objective)
+}
+
+// budget returns the allowed share of failed requests
+func (s SLO) budget() float64 {
+	return 1 - s.Objective/100
+}
+
+// sloTracker counts requests of the objective in a ring of sloResolution-long buckets covering its period
+type sloTracker struct {
+	SLO
+	buckets []sloBucket
+	head    int64 // key of the newest bucket
+	started bool  // false until the first record is added
+}
+
+type sloBucket struct {
+	key            int64
+	requests, errs int
+}
+
+func newSLOTracker(slo SLO) *sloTracker {
+	return &sloTracker{SLO: slo, buckets: make([]sloBucket, int64(slo.Period/sloResolution))}
+}
+
+// add the record if it belongs to the objective
+func (t *sloTracker) add(r *record) {
+	if r.filtered || (t.Section != "" && t.Section != r.section) {
+		return
+	}
+	key := sloKey(r.date)
+	if !t.started || key > t.head {
+		t.head, t.started = key, true
+	}
+	if key <= t.head-int64(len(t.buckets)) {
+		return
+	}
+	b := &t.buckets[floorMod(key, int64(len(t.buckets)))]
+	if b.key != key {
+		*b = sloBucket{key: key}
+	}
+	b.requests++
+	if r.status >= 500 {
+		b.errs++
+	}
+}
+
+// sloKey returns the key of the SLO bucket for the provided time
+func sloKey(t time.Time) int64 {
+	return floorDiv(t.Unix(), int64(sloResolution/time.Second))
+}
+
+// count returns the number of requests and failed ones in d up to the bucket with the provided key
+func (t *sloTracker) count(to int64, d time.Duration) (requests, errs int) {
+	n := int64(d / sloResolution)
+	if n > int64(len(t.buckets)) {
+		n = int64(len(t.buckets))
+	}
+	for key := to - n + 1; key <= to; key++ {
+		if b := &t.buckets[floorMod(key, int64(len(t.buckets)))]; b.key == key {
+			requests += b.requests
+			errs += b.errs
+		}
+	}
+	return requests, errs
+}
+
+// burnRate returns how many times faster than allowed the error budget was spent in d up to the bucket with the provided key
+func (t *sloTracker) burnRate(to int64, d time.Duration) float64 {
+	requests, errs := t.count(to, d)
+	if requests == 0 {
+		return 0
+	}
+	return float64(errs) / float64(requests) / t.budget()
+}
+
+// sloStatus is the state of the objective over its period
+type sloStatus struct {
+	slo             SLO
+	requests, errs  int
+	budgetRemaining float64 // share of the error budget left, negative once it's exceeded
+}
+
+// status returns the state of the objective over the period up to the provided time
+func (t *sloTracker) status(now time.Time) sloStatus {
+	s := sloStatus{slo: t.SLO, budgetRemaining: 1}
+	s.requests, s.errs = t.count(sloKey(now), t.Period)
+	if s.requests > 0 {
+		s.budgetRemaining = 1 - float64(s.errs)/float64(s.requests)/t.budget()
+	}
+	return s
+}
+
+// good returns the share of good requests, one if there were none
+func (s sloStatus) good() float64 {
+	if s.requests == 0 {
+		return 1
+	}
+	return 1 - float64(s.errs)/float64(s.requests)
+}
+
+// formatSLOs returns objectives state for the text output,
+// like "SLO /api 99.9% 99.950% good of 2000 requests, 50.00% of error budget remaining"
+func formatSLOs(statuses []sloStatus) string {
+	parts := make([]string, len(statuses))
+	for i, s := range statuses {
+		parts[i] = fmt.Sprintf("SLO %s %.3f%% good of %d requests, %.2f%% of error budget remaining",
+			s.slo.name(), s.good()*100, s.requests, s.budgetRemaining*100)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// burnRateAlert fires when the error budget of the objective is spent too fast both in long and short windows.
+// It's checked once per SLO bucket rather than on every record, including the records of other sections, so it
+// recovers even if the requests of the section stop.
+type burnRateAlert struct {
+	tracker     *sloTracker
+	severity    string
+	long, short time.Duration
+	threshold   float64 // burn rate
+	checked     int64   // key of the bucket when the alert was checked
+	started     bool    // false until the first check
+	burnRate    float64 // lower of the long and short window burn rates on the last check
+	firing      bool
+}
+
+// burnRateAlerts returns alerts for every burn rate window of the objective
+func burnRateAlerts(t *sloTracker) []alertRule {
+	alerts := make([]alertRule, 0, len(burnRateWindows))
+	for _, w := range burnRateWindows {
+		alerts = append(alerts, &burnRateAlert{tracker: t, severity: w.severity, long: w.long, short: w.short,
+			threshold: w.budget * float64(t.Period) / float64(w.long)})
+	}
+	return alerts
+}
+
+func (a *burnRateAlert) check(l *Processor, currentTime time.Time) {
+	key := sloKey(currentTime)
+	if a.started && a.checked == key {
+		return
+	}
+	a.checked, a.started = key, true
+	long, short := a.tracker.burnRate(key, a.long), a.tracker.burnRate(key, a.short)
+	a.burnRate = math.Min(long, short)
+
+	if long > a.threshold && short > a.threshold {
+		if !a.firing {
+			a.firing = true
+			l.printBurnRateAlert(a, "RED", long, short, currentTime)
+		}
+		return
+	}
+	if a.firing {
+		a.firing = false
+		l.printBurnRateAlert(a, "GREEN", long, short, currentTime)
+	}
+}
+
+func (a *burnRateAlert) measure() measurement {
+	return measurement{value: a.burnRate, unit: "x burn rate"}
+}
+
+func (l *Processor) printBurnRateAlert(a *burnRateAlert, state string, long, short float64, currentTime time.Time) {
+	comparison := "higher"
+	if state == "GREEN" {
+		comparison = "no longer higher"
+	}
+	labels := alertLabels("slo", a.tracker.Section)
+	labels["severity"] = a.severity
+	l.notify(alertEvent{rule: a, id: ruleID("slo", a.tracker.Section, fmt.Sprintf("%g%%:%s", a.tracker.Objective, a.severity)),
+		name: fmt.Sprintf("SLO %s %s burn", a.tracker.name(), a.severity), time: currentTime, state: state, labels: labels,
+		text: fmt.Sprintf("Alert %s, SLO %s %s burn, error budget is spent %.2fx as fast as allowed in the last %s and %.2fx in the last %s, %s than %.2fx in both",
+			state, a.tracker.name(), a.severity, long, a.long, short, a.short, comparison, a.threshold),
+		json: &jsonBurnRateAlert{Section: a.tracker.Section, Objective: a.tracker.Objective, Severity: a.severity,
+			LongWindow: a.long.String(), LongBurnRate: long, ShortWindow: a.short.String(), ShortBurnRate: short, Threshold: a.threshold},
+	})
+}
@@ -0,0 +1,103 @@
+package record
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSLOs(t *testing.T) {
+	slos, err := NewSLOs([]string{"/api:99.9%", "99"}, 720*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, []SLO{{Section: "/api", Objective: 99.9, Period: 720 * time.Hour}, {Objective: 99, Period: 720 * time.Hour}}, slos)
+	assert.Equal(t, "/api 99.9%", slos[0].name())
+	assert.Equal(t, "99%", slos[1].name())
+	assert.InDelta(t, 0.001, slos[0].budget(), 1e-9)
+
+	_, err = NewSLOs([]string{"/api:high"}, 720*time.Hour)
+	assert.EqualError(t, err, `SLO "/api:high" should be in [section:]percent format, like /api:99.9%`)
+	_, err = NewSLOs([]string{"/api:100%"}, 720*time.Hour)
+	assert.EqualError(t, err, `SLO "/api:100%" should have objective between 0 and 100 percent`)
+	_, err = NewSLOs([]string{"/api:99%"}, time.Hour)
+	assert.EqualError(t, err, "SLO period should be at least 6h0m0s, got 1h0m0s")
+}
+
+func TestSLOTracker(t *testing.T) {
+	tracker := newSLOTracker(SLO{Section: "/api", Objective: 99, Period: 24 * time.Hour})
+	start := time.Unix(1549573860, 0)
+	for i := 0; i < 100; i++ {
+		r := record{section: "/api", status: 200, date: start.Add(time.Duration(i) * time.Minute)}
+		if i%10 == 0 {
+			r.status = 503
+		}
+		tracker.add(&r)
+		tracker.add(&record{section: "/report", status: 500, date: r.date})
+		tracker.add(&record{section: "/api", status: 500, date: r.date, filtered: true})
+	}
+	last := sloKey(start.Add(99 * time.Minute))
+	requests, errs := tracker.count(last, time.Hour)
+	assert.Equal(t, 60, requests)
+	assert.Equal(t, 6, errs)
+	assert.InDelta(t, 10, tracker.burnRate(last, time.Hour), 1e-9, "10% of errors is 10 times more than 1% budget")
+	assert.InDelta(t, 0, tracker.burnRate(last, 5*time.Minute), 1e-9)
+
+	status := tracker.status(start.Add(99 * time.Minute))
+	assert.Equal(t, 100, status.requests)
+	assert.Equal(t, 10, status.errs)
+	assert.InDelta(t, -9, status.budgetRemaining, 1e-9)
+	assert.Equal(t, "SLO /api 99% 90.000% good of 100 requests, -900.00% of error budget remaining", formatSLOs([]sloStatus{status}))
+
+	// old buckets are not counted once the ring moves over them
+	tracker.add(&record{section: "/api", status: 200, date: start.Add(48 * time.Hour)})
+	status = tracker.status(start.Add(48 * time.Hour))
+	assert.Equal(t, 1, status.requests)
+	assert.InDelta(t, 1, status.budgetRemaining, 1e-9)
+}
+
+func TestBurnRateAlerts(t *testing.T) {
+	// ten requests every minute for three hours, with half of them failing for 20 minutes after the first hour
+	var log strings.Builder
+	start := int64(1549573860)
+	for minute := int64(0); minute < 180; minute++ {
+		for i := int64(0); i < 10; i++ {
+			status := 200
+			if minute >= 60 && minute < 80 && i%2 == 0 {
+				status = 503
+			}
+			fmt.Fprintf(&log, "\"10.0.0.2\",\"-\",\"apache\",%d,\"GET /api/user HTTP/1.0\",%d,1234\n", start+minute*60+i, status)
+		}
+	}
+	slos, err := NewSLOs([]string{"/api:99.9%"}, 720*time.Hour)
+	require.NoError(t, err)
+	logProcessor := Processor{
+		AlertWindow:             time.Minute * 2,
+		AlertThresholdPerSecond: 10,
+		SLOs:                    slos,
+	}
+	var alerts []string
+	var lastSLO string
+	for _, line := range strings.Split(aggregateLog(t, &logProcessor, log.String()), "\n") {
+		if strings.Contains(line, "Alert") {
+			alerts = append(alerts, line)
+		}
+		if strings.Contains(line, ": SLO ") {
+			lastSLO = line
+		}
+	}
+	// slow burn fires first and fast one requires more errors in the last hour, both recover after the short window
+	assert.Equal(t, []string{
+		"2019-02-07 22:12:00 +0000 UTC: Alert RED, SLO /api 99.9% slow burn, error budget is spent 9.82x as fast as allowed " +
+			"in the last 6h0m0s and 20.62x in the last 30m0s, higher than 6.00x in both",
+		"2019-02-07 22:13:00 +0000 UTC: Alert RED, SLO /api 99.9% fast burn, error budget is spent 18.61x as fast as allowed " +
+			"in the last 1h0m0s and 268.29x in the last 5m0s, higher than 14.40x in both",
+		"2019-02-07 22:35:00 +0000 UTC: Alert GREEN, SLO /api 99.9% fast burn, error budget is spent 169.20x as fast as allowed " +
+			"in the last 1h0m0s and 0.00x in the last 5m0s, no longer higher than 14.40x in both",
+		"2019-02-07 23:00:00 +0000 UTC: Alert GREEN, SLO /api 99.9% slow burn, error budget is spent 91.66x as fast as allowed " +
+			"in the last 6h0m0s and 0.00x in the last 30m0s, no longer higher than 6.00x in both",
+	}, alerts)
+	assert.Equal(t, "2019-02-08 00:09:09 +0000 UTC: SLO /api 99.9% 94.413% good of 1790 requests, -5486.59% of error budget remaining", lastSLO)
+}
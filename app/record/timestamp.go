@@ -0,0 +1,229 @@
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// supported time formats, anything with % in it is treated as strftime-style pattern
+const (
+	TimeAuto    = "auto"    // unix time in seconds, milliseconds, microseconds or nanoseconds, or RFC3339
+	TimeUnix    = "unix"    // unix time in seconds, with optional fraction
+	TimeUnixMs  = "unix_ms" // unix time in milliseconds
+	TimeUnixUs  = "unix_us" // unix time in microseconds
+	TimeUnixNs  = "unix_ns" // unix time in nanoseconds
+	TimeRFC3339 = "rfc3339" // RFC3339 with optional fraction of a second
+)
+
+// strftime directives and their Go layout equivalents
+var strftimeDirectives = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2", 'H': "15", 'I': "03", 'M': "04", 'S': "05",
+	'f': "000000", 'p': "PM", 'b': "Jan", 'h': "Jan", 'B': "January", 'a': "Mon", 'A': "Monday",
+	'z': "-0700", 'Z': "MST", 'T': "15:04:05", 'F': "2006-01-02", 'D': "01/02/06", '%': "%",
+}
+
+// literalCheckTime differs from the Go reference time in every date element, so that the literal
+// text of the strftime-style pattern is formatted as is only if there are no layout elements in it
+var literalCheckTime = time.Date(1, time.November, 28, 8, 59, 58, 123456789, time.UTC)
+
+// TimeParser parses record timestamps in the configured format
+type TimeParser struct {
+	format   string
+	layout   string         // Go layout for strftime-style format
+	location *time.Location // location for timestamps without the timezone
+}
+
+// defaultTimeParser detects the format of every timestamp
+var defaultTimeParser = &TimeParser{format: TimeAuto, location: time.Local}
+
+// NewTimeParser creates parser for the provided format, which is one of Time* constants
+// or strftime-style pattern like "%d/%b/%Y:%H:%M:%S %z". Timestamps without timezone
+// are considered to be in the provided location.
+func NewTimeParser(format string, location *time.Location) (*TimeParser, error) {
+	p := &TimeParser{format: format, location: location}
+	switch format {
+	case TimeAuto, TimeUnix, TimeUnixMs, TimeUnixUs, TimeUnixNs, TimeRFC3339:
+		return p, nil
+	}
+	if !strings.Contains(format, "%") {
+		return nil, fmt.Errorf("unknown time format %q", format)
+	}
+	var layout, literal strings.Builder
+	// literal text is copied to the Go layout as is, so it can't have anything Go would read as a date element
+	flushLiteral := func() error {
+		if literal.Len() > 0 && literalCheckTime.Format(literal.String()) != literal.String() {
+			return fmt.Errorf("literal %q in time format %q would be read as a date element, like Jan, Mon, PM or 2006",
+				literal.String(), format)
+		}
+		layout.WriteString(literal.String())
+		literal.Reset()
+		return nil
+	}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			literal.WriteByte(format[i])
+			continue
+		}
+		if err := flushLiteral(); err != nil {
+			return nil, err
+		}
+		if i+1 == len(format) {
+			return nil, fmt.Errorf("time format %q ends with %%", format)
+		}
+		i++
+		directive, ok := strftimeDirectives[format[i]]
+		if !ok {
+			return nil, fmt.Errorf("unsupported directive %%%c in time format %q", format[i], format)
+		}
+		// Go reads the fraction of a second only after the separator
+		if format[i] == 'f' && !strings.HasSuffix(layout.String(), ".") && !strings.HasSuffix(layout.String(), ",") {
+			return nil, fmt.Errorf("directive %%f in time format %q should follow . or ,", format)
+		}
+		layout.WriteString(directive)
+	}
+	if err := flushLiteral(); err != nil {
+		return nil, err
+	}
+	p.layout = layout.String()
+	return p, nil
+}
+
+// parse the timestamp
+func (p *TimeParser) parse(b []byte) (time.Time, bool) {
+	switch p.format {
+	case TimeAuto:
+		if ts, ok := p.parseNumber(b, 0); ok {
+			return ts, true
+		}
+		return p.parseLayout(b, time.RFC3339Nano)
+	case TimeUnix:
+		return p.parseNumber(b, time.Second)
+	case TimeUnixMs:
+		return p.parseNumber(b, time.Millisecond)
+	case TimeUnixUs:
+		return p.parseNumber(b, time.Microsecond)
+	case TimeUnixNs:
+		return p.parseNumber(b, time.Nanosecond)
+	case TimeRFC3339:
+		return p.parseLayout(b, time.RFC3339Nano)
+	}
+	return p.parseLayout(b, p.layout)
+}
+
+// parseNumber parses unix time in provided units with optional fraction,
+// units are detected by the number of digits if zero
+func (p *TimeParser) parseNumber(b []byte, unit time.Duration) (time.Time, bool) {
+	var fraction []byte
+	if dot := bytes.IndexByte(b, '.'); dot >= 0 {
+		b, fraction = b[:dot], b[dot+1:]
+		if len(fraction) == 0 {
+			return time.Time{}, false
+		}
+	}
+	n, ok := parseInt(b)
+	if !ok {
+		return time.Time{}, false
+	}
+	if unit == 0 {
+		unit = detectUnit(b)
+	}
+
+	// fraction of the unit, in nanoseconds
+	var nsec int64
+	scale := int64(unit)
+	for _, c := range fraction {
+		if c < '0' || c > '9' {
+			return time.Time{}, false
+		}
+		if scale >= 10 {
+			scale /= 10
+			nsec += int64(c-'0') * scale
+		}
+	}
+	if n < 0 || (n == 0 && len(b) > 0 && b[0] == '-') {
+		nsec = -nsec
+	}
+	perSecond := int64(time.Second / unit)
+	return time.Unix(n/perSecond, n%perSecond*int64(unit)+nsec), true
+}
+
+// detectUnit of the unix time by the number of digits in it: seconds have up to 11 digits,
+// which is enough till the year 5000, and every next unit adds three more
+func detectUnit(b []byte) time.Duration {
+	digits := len(b)
+	if digits > 0 && (b[0] == '-' || b[0] == '+') {
+		digits--
+	}
+	switch {
+	case digits <= 11:
+		return time.Second
+	case digits <= 14:
+		return time.Millisecond
+	case digits <= 17:
+		return time.Microsecond
+	}
+	return time.Nanosecond
+}
+
+// parseLayout parses the timestamp with Go layout in the parser location
+func (p *TimeParser) parseLayout(b []byte, layout string) (time.Time, bool) {
+	ts, err := time.ParseInLocation(layout, string(b), p.location)
+	return ts, err == nil
+}
+
+// formatLike formats the time the same way as the original timestamp: unix time in the same unit, with the fraction
+// if the original has one, and layouts in the timezone of the original
+func (p *TimeParser) formatLike(t time.Time, original []byte) string {
+	switch p.format {
+	case TimeAuto:
+		if _, ok := p.parseNumber(original, 0); ok {
+			integer := original
+			if dot := bytes.IndexByte(original, '.'); dot >= 0 {
+				integer = original[:dot]
+			}
+			return formatNumber(t, detectUnit(integer), original)
+		}
+		return p.formatLayout(t, original, time.RFC3339Nano)
+	case TimeUnix:
+		return formatNumber(t, time.Second, original)
+	case TimeUnixMs:
+		return formatNumber(t, time.Millisecond, original)
+	case TimeUnixUs:
+		return formatNumber(t, time.Microsecond, original)
+	case TimeUnixNs:
+		return formatNumber(t, time.Nanosecond, original)
+	case TimeRFC3339:
+		return p.formatLayout(t, original, time.RFC3339Nano)
+	}
+	return p.formatLayout(t, original, p.layout)
+}
+
+// formatNumber formats unix time in the unit, with the fraction of the unit if the original has one
+func formatNumber(t time.Time, unit time.Duration, original []byte) string {
+	nsec := t.UnixNano()
+	s := strconv.FormatInt(nsec/int64(unit), 10)
+	if bytes.IndexByte(original, '.') < 0 || unit == time.Nanosecond {
+		return s
+	}
+	digits := len(strconv.FormatInt(int64(unit), 10)) - 1
+	fraction := strings.TrimRight(fmt.Sprintf("%0*d", digits, nsec%int64(unit)), "0")
+	if fraction == "" {
+		fraction = "0"
+	}
+	return s + "." + fraction
+}
+
+// formatLayout formats the time with Go layout in the timezone of the original timestamp,
+// RFC3339 without the fraction of a second if the original has none
+func (p *TimeParser) formatLayout(t time.Time, original []byte, layout string) string {
+	if ts, ok := p.parseLayout(original, layout); ok {
+		t = t.In(ts.Location())
+	}
+	if layout == time.RFC3339Nano && bytes.IndexByte(original, '.') < 0 {
+		layout = time.RFC3339
+	}
+	return t.Format(layout)
+}
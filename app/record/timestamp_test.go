@@ -0,0 +1,119 @@
+package record
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeParser(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	sec := time.Date(2019, 2, 7, 21, 11, 0, 0, time.UTC)
+	var testData = []struct {
+		format string
+		input  string
+		output time.Time
+	}{
+		{format: TimeAuto, input: "1549573860", output: sec},
+		{format: TimeAuto, input: "1549573860.25", output: sec.Add(time.Millisecond * 250)},
+		{format: TimeAuto, input: "1549573860123", output: sec.Add(time.Millisecond * 123)},
+		{format: TimeAuto, input: "1549573860123456", output: sec.Add(time.Microsecond * 123456)},
+		{format: TimeAuto, input: "1549573860123456789", output: sec.Add(time.Nanosecond * 123456789)},
+		{format: TimeAuto, input: "2019-02-07T22:11:00.5+01:00", output: sec.Add(time.Millisecond * 500)},
+		{format: TimeAuto, input: "2019-02-07T21:11:00Z", output: sec},
+		{format: TimeAuto, input: "-1", output: time.Unix(-1, 0)},
+		{format: TimeAuto, input: "-1.5", output: time.Unix(-1, -5e8)},
+		{format: TimeAuto, input: "not_a_number"},
+		{format: TimeAuto, input: "1549573860."},
+		{format: TimeAuto, input: "1549573860.5x"},
+		{format: TimeUnix, input: "1549573860", output: sec},
+		{format: TimeUnix, input: "1549573860123", output: time.Unix(1549573860123, 0)},
+		{format: TimeUnix, input: "2019-02-07T21:11:00Z"},
+		{format: TimeUnixMs, input: "1549573860123", output: sec.Add(time.Millisecond * 123)},
+		{format: TimeUnixMs, input: "1549573860123.5", output: sec.Add(time.Microsecond * 123500)},
+		{format: TimeUnixUs, input: "1549573860000001", output: sec.Add(time.Microsecond)},
+		{format: TimeUnixNs, input: "1549573860000000001", output: sec.Add(time.Nanosecond)},
+		{format: TimeRFC3339, input: "2019-02-07T21:11:00Z", output: sec},
+		{format: TimeRFC3339, input: "1549573860"},
+		{format: "%d/%b/%Y:%H:%M:%S %z", input: "07/Feb/2019:22:11:00 +0100", output: sec},
+		{format: "%Y-%m-%d %H:%M:%S", input: "2019-02-07 22:11:00", output: sec},
+		{format: "%F %T.%f", input: "2019-02-07 22:11:00.000001", output: sec.Add(time.Microsecond)},
+		{format: "%F %T,%f", input: "2019-02-07 22:11:00,000001", output: sec.Add(time.Microsecond)},
+		{format: "%F %T", input: "07/Feb/2019:22:11:00"},
+	}
+
+	for _, x := range testData {
+		x := x
+		t.Run(x.format+" "+x.input, func(t *testing.T) {
+			p, err := NewTimeParser(x.format, berlin)
+			require.NoError(t, err)
+			ts, ok := p.parse([]byte(x.input))
+			assert.Equal(t, !x.output.IsZero(), ok)
+			if ok {
+				assert.True(t, x.output.Equal(ts), "expected %s, got %s", x.output, ts)
+			}
+		})
+	}
+}
+
+func TestNewTimeParserErrors(t *testing.T) {
+	_, err := NewTimeParser("unix_s", time.UTC)
+	assert.EqualError(t, err, `unknown time format "unix_s"`)
+	_, err = NewTimeParser("%Y-%m-%d %", time.UTC)
+	assert.EqualError(t, err, `time format "%Y-%m-%d %" ends with %`)
+	_, err = NewTimeParser("%Y-%j", time.UTC)
+	assert.EqualError(t, err, `unsupported directive %j in time format "%Y-%j"`)
+	_, err = NewTimeParser("%d Jan %Y", time.UTC)
+	assert.EqualError(t, err, `literal " Jan " in time format "%d Jan %Y" would be read as a date element, like Jan, Mon, PM or 2006`)
+	_, err = NewTimeParser("%Y-%m-%d 2006", time.UTC)
+	assert.EqualError(t, err, `literal " 2006" in time format "%Y-%m-%d 2006" would be read as a date element, like Jan, Mon, PM or 2006`)
+	_, err = NewTimeParser("Mon %d/%m/%Y", time.UTC)
+	assert.EqualError(t, err, `literal "Mon " in time format "Mon %d/%m/%Y" would be read as a date element, like Jan, Mon, PM or 2006`)
+	_, err = NewTimeParser("%H:%M PM", time.UTC)
+	assert.EqualError(t, err, `literal " PM" in time format "%H:%M PM" would be read as a date element, like Jan, Mon, PM or 2006`)
+	_, err = NewTimeParser("%H:%M:%S%f", time.UTC)
+	assert.EqualError(t, err, `directive %f in time format "%H:%M:%S%f" should follow . or ,`)
+	_, err = NewTimeParser("%f %H:%M:%S", time.UTC)
+	assert.EqualError(t, err, `directive %f in time format "%f %H:%M:%S" should follow . or ,`)
+
+	// literals without layout elements, and the fraction after either separator are fine
+	for _, format := range []string{"%Y-%m-%dT%H:%M:%S.%fZ", "%Y-%m-%d %H:%M:%S,%f", "[%d/%b/%Y:%H:%M:%S %z]", "%H:%M:%S %%"} {
+		_, err = NewTimeParser(format, time.UTC)
+		assert.NoError(t, err, format)
+	}
+}
+
+func TestTimeParserFormatLike(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 250e6, time.UTC)
+	var testData = []struct {
+		format   string
+		original string
+		output   string
+	}{
+		{format: TimeAuto, original: "1549573860", output: "1704110400"},
+		{format: TimeAuto, original: "1549573860.5", output: "1704110400.25"},
+		{format: TimeAuto, original: "1549573860123", output: "1704110400250"},
+		{format: TimeAuto, original: "1549573860123456789", output: "1704110400250000000"},
+		{format: TimeAuto, original: "2019-02-07T22:11:00+01:00", output: "2024-01-01T13:00:00+01:00"},
+		{format: TimeAuto, original: "2019-02-07T21:11:00.5Z", output: "2024-01-01T12:00:00.25Z"},
+		{format: TimeUnix, original: "1549573860.0", output: "1704110400.25"},
+		{format: TimeUnixMs, original: "1549573860000.5", output: "1704110400250.0"},
+		{format: TimeUnixUs, original: "1549573860000000", output: "1704110400250000"},
+		{format: TimeRFC3339, original: "2019-02-07T21:11:00Z", output: "2024-01-01T12:00:00Z"},
+		{format: "%d/%b/%Y:%H:%M:%S %z", original: "07/Feb/2019:22:11:00 +0200", output: "01/Jan/2024:14:00:00 +0200"},
+		{format: "%Y-%m-%d %H:%M:%S", original: "2019-02-07 22:11:00", output: "2024-01-01 13:00:00"},
+	}
+	for _, x := range testData {
+		p, err := NewTimeParser(x.format, berlin)
+		require.NoError(t, err)
+		assert.Equal(t, x.output, p.formatLike(ts, []byte(x.original)), x.format+" "+x.original)
+		parsed, ok := p.parse([]byte(x.output))
+		assert.True(t, ok, x.output)
+		assert.True(t, parsed.Equal(ts.Truncate(time.Second)) || parsed.Equal(ts), x.output)
+	}
+}
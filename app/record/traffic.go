@@ -0,0 +1,203 @@
+package record
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// maxLowTrafficDuration limits memory of the low traffic alert, which counts hits per second
+const maxLowTrafficDuration = 24 * time.Hour
+
+// LowTrafficRule fires the alert when the rate of hits of the section is lower than the threshold
+// over the duration, which is unrelated to the alert window
+type LowTrafficRule struct {
+	Section   string  // all records are counted if empty
+	Threshold float64 // hits per second
+	Duration  time.Duration
+}
+
+var lowTrafficRe = regexp.MustCompile(`^(?:(.+):)?(\d+(?:\.\d+)?)/s@(.+)$`)
+
+// NewLowTrafficRules creates rules from "[section:]rate/s@duration" expressions like "/api:1/s@5m"
+func NewLowTrafficRules(exprs []string) ([]LowTrafficRule, error) {
+	rules := make([]LowTrafficRule, 0, len(exprs))
+	for _, expr := range exprs {
+		m := lowTrafficRe.FindStringSubmatch(expr)
+		if m == nil {
+			return nil, fmt.Errorf("low traffic alert %q should be in [section:]rate/s@duration format, like /api:1/s@5m", expr)
+		}
+		threshold, err := strconv.ParseFloat(m[2], 64)
+		if err != nil || threshold <= 0 {
+			return nil, fmt.Errorf("low traffic alert %q should have positive rate", expr)
+		}
+		duration, err := time.ParseDuration(m[3])
+		if err != nil || duration < time.Second || duration > maxLowTrafficDuration {
+			return nil, fmt.Errorf("low traffic alert %q should have duration between 1s and %s", expr, maxLowTrafficDuration)
+		}
+		rules = append(rules, LowTrafficRule{Section: m[1], Threshold: threshold, Duration: duration.Truncate(time.Second)})
+	}
+	return rules, nil
+}
+
+// name returns the rule description like "hits of /api"
+func (r LowTrafficRule) name() string {
+	if r.Section == "" {
+		return "hits"
+	}
+	return "hits of " + r.Section
+}
+
+// lowTrafficAlert counts hits of the rule per second in a ring covering its duration, and it's not checked
+// until the whole duration passes since the first record or check, so that the start of the log doesn't count as a drop
+type lowTrafficAlert struct {
+	LowTrafficRule
+	counts  []int   // hits per second, second with the key k lives at index k mod len(counts)
+	total   int     // running total of counts
+	head    int64   // unix second of the newest count
+	first   int64   // unix second of the first record or check
+	started bool    // false until the first record or check
+	rate    float64 // rate on the last check
+	firing  bool
+}
+
+func newLowTrafficAlert(rule LowTrafficRule) *lowTrafficAlert {
+	return &lowTrafficAlert{LowTrafficRule: rule, counts: make([]int, int64(rule.Duration/time.Second))}
+}
+
+// add the record if it's counted by the alert and belongs to the section of the rule
+func (a *lowTrafficAlert) add(r *record) {
+	if r.filtered || (a.Section != "" && a.Section != r.section) {
+		return
+	}
+	key := r.date.Unix()
+	a.advance(key)
+	if key <= a.head-int64(len(a.counts)) {
+		return
+	}
+	a.counts[floorMod(key, int64(len(a.counts)))]++
+	a.total++
+}
+
+// advance moves the head to the key, resetting counts which fall out of the duration
+func (a *lowTrafficAlert) advance(key int64) {
+	if !a.started {
+		a.head, a.first, a.started = key, key, true
+		return
+	}
+	if key <= a.head {
+		return
+	}
+	n := int64(len(a.counts))
+	from := a.head + 1
+	if key-a.head > n {
+		from = key - n + 1
+	}
+	for k := from; k <= key; k++ {
+		i := floorMod(k, n)
+		a.total -= a.counts[i]
+		a.counts[i] = 0
+	}
+	a.head = key
+}
+
+func (a *lowTrafficAlert) check(l *Processor, currentTime time.Time) {
+	key := currentTime.Unix()
+	a.advance(key)
+	if key-a.first < int64(len(a.counts)) {
+		return
+	}
+	rate := float64(a.total) / a.Duration.Seconds()
+	a.rate = rate
+
+	if rate < a.Threshold {
+		if !a.firing {
+			a.firing = true
+			l.printLowTrafficAlert(a, "RED", rate, currentTime)
+		}
+		return
+	}
+	if a.firing {
+		a.firing = false
+		l.printLowTrafficAlert(a, "GREEN", rate, currentTime)
+	}
+}
+
+func (a *lowTrafficAlert) measure() measurement {
+	return measurement{value: a.rate, unit: "hits/s", low: true, totalUnit: "hits"}
+}
+
+func (l *Processor) printLowTrafficAlert(a *lowTrafficAlert, state string, rate float64, currentTime time.Time) {
+	comparison := "lower"
+	if state == "GREEN" {
+		comparison = "no longer lower"
+	}
+	l.notify(alertEvent{rule: a, id: ruleID("low_traffic", a.Section, fmt.Sprintf("%g/s@%s", a.Threshold, a.Duration)),
+		name: "low " + a.name(), time: currentTime, state: state, labels: alertLabels("low_traffic", a.Section),
+		text: fmt.Sprintf("Alert %s, ~%.2f %s per second which is %s than %g (%d total) in the last %s",
+			state, rate, a.name(), comparison, a.Threshold, a.total, a.Duration),
+		json: &jsonLowTrafficAlert{Section: a.Section, PerSecond: rate, Threshold: a.Threshold, Total: a.total,
+			Window: a.Duration.String()},
+	})
+}
+
+// noDataAlert fires when no records arrive for the duration. Gaps between dates of the records are noticed when
+// the record after the gap arrives, and silence of the log itself is noticed by the wall clock while it's idle,
+// including the silence since the start when no records arrived yet. That one is fired and resolved by the wall
+// clock, as dates of the log could be long before it, and the date of the first record is noted separately.
+type noDataAlert struct {
+	after  time.Duration
+	seen   time.Time // date of the newest record at the last check
+	firing bool
+}
+
+func (a *noDataAlert) check(l *Processor, currentTime time.Time) {
+	if l.lastEntry.IsZero() {
+		if silence := currentTime.Sub(l.started); !a.firing && silence >= a.after {
+			a.firing = true
+			l.printNoDataAlert(a, "RED", silence, time.Time{}, currentTime)
+		}
+		return
+	}
+	if a.seen.IsZero() && a.firing {
+		// the first record resolves the alert fired by the wall clock since the start, at the time it arrived
+		l.printNoDataAlert(a, "GREEN", l.lastArrival.Sub(l.started), l.lastEntry, l.lastArrival)
+		a.firing = false
+	}
+	if l.lastEntry.After(a.seen) {
+		// record after the gap in the log fires the alert and resolves it at once
+		if !a.seen.IsZero() && !a.firing && l.lastEntry.Sub(a.seen) >= a.after {
+			l.printNoDataAlert(a, "RED", a.after, time.Time{}, a.seen.Add(a.after))
+			a.firing = true
+		}
+		if a.firing {
+			l.printNoDataAlert(a, "GREEN", l.lastEntry.Sub(a.seen), time.Time{}, currentTime)
+			a.firing = false
+		}
+		a.seen = l.lastEntry
+		return
+	}
+	if !a.firing && currentTime.Sub(a.seen) >= a.after {
+		a.firing = true
+		l.printNoDataAlert(a, "RED", currentTime.Sub(a.seen), time.Time{}, currentTime)
+	}
+}
+
+// printNoDataAlert prints the change of the alert state, along with the date of the first record if it's set
+func (l *Processor) printNoDataAlert(a *noDataAlert, state string, silence time.Duration, first, currentTime time.Time) {
+	silence = silence.Truncate(time.Second)
+	text := fmt.Sprintf("Alert RED, no records in the last %s", silence)
+	if state == "GREEN" {
+		text = fmt.Sprintf("Alert GREEN, records arrived after %s without them", silence)
+	}
+	j := &jsonNoDataAlert{Silence: silence.String(), Threshold: a.after.String()}
+	if !first.IsZero() {
+		text += fmt.Sprintf(", the first one dated %s", first.In(l.OutputTZ))
+		first = first.In(l.OutputTZ)
+		j.FirstRecord = &first
+	}
+	l.notify(alertEvent{rule: a, id: ruleID("no_data", "", ""), name: "no data", time: currentTime, state: state,
+		labels: alertLabels("no_data", ""), text: text, json: j,
+	})
+}
@@ -0,0 +1,195 @@
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/paskal/datadog-parser/app/history"
+)
+
+func TestNewLowTrafficRules(t *testing.T) {
+	rules, err := NewLowTrafficRules([]string{"/api:1/s@5m", "0.5/s@1h"})
+	require.NoError(t, err)
+	assert.Equal(t, []LowTrafficRule{{Section: "/api", Threshold: 1, Duration: 5 * time.Minute},
+		{Threshold: 0.5, Duration: time.Hour}}, rules)
+	assert.Equal(t, "hits of /api", rules[0].name())
+	assert.Equal(t, "hits", rules[1].name())
+
+	_, err = NewLowTrafficRules([]string{"/api:1/s"})
+	assert.EqualError(t, err, `low traffic alert "/api:1/s" should be in [section:]rate/s@duration format, like /api:1/s@5m`)
+	_, err = NewLowTrafficRules([]string{"0/s@5m"})
+	assert.EqualError(t, err, `low traffic alert "0/s@5m" should have positive rate`)
+	_, err = NewLowTrafficRules([]string{"1/s@48h"})
+	assert.EqualError(t, err, `low traffic alert "1/s@48h" should have duration between 1s and 24h0m0s`)
+}
+
+func TestLowTrafficAlert(t *testing.T) {
+	// two hits of /api per second for 6 minutes, a hit every other second for 6 minutes, then two per second again;
+	// /report hits don't count
+	var log strings.Builder
+	start := int64(1549573860)
+	for second := int64(0); second < 18*60; second++ {
+		if second < 6*60 || second >= 12*60 {
+			fmt.Fprintf(&log, "\"10.0.0.2\",\"-\",\"apache\",%d,\"GET /api/user HTTP/1.0\",200,1234\n", start+second)
+		}
+		if second < 6*60 || second >= 12*60 || second%2 == 0 {
+			fmt.Fprintf(&log, "\"10.0.0.2\",\"-\",\"apache\",%d,\"GET /api/user HTTP/1.0\",200,1234\n", start+second)
+		}
+		fmt.Fprintf(&log, "\"10.0.0.2\",\"-\",\"apache\",%d,\"GET /report HTTP/1.0\",200,1234\n", start+second)
+	}
+	rules, err := NewLowTrafficRules([]string{"/api:1/s@5m"})
+	require.NoError(t, err)
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log.String()),
+		AlertWindow:             time.Minute,
+		AlertThresholdPerSecond: 100,
+		LowTrafficAlerts:        rules,
+	}
+	assert.Equal(t, []string{
+		"2019-02-07 21:20:20 +0000 UTC: Alert RED, ~1.00 hits of /api per second which is lower than 1 (299 total) in the last 5m0s",
+		"2019-02-07 21:24:39 +0000 UTC: Alert GREEN, ~1.00 hits of /api per second which is no longer lower than 1 (300 total) in the last 5m0s",
+	}, alertLines(runProcessor(&logProcessor)))
+}
+
+func TestNoDataAlert(t *testing.T) {
+	// ten minutes gap in the log between the second and the third records
+	log := `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549573861,"GET /api/user HTTP/1.0",200,1234
+"10.0.0.2","-","apache",1549574461,"GET /api/user HTTP/1.0",200,1234
+`
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log),
+		AlertWindow:             time.Minute,
+		AlertThresholdPerSecond: 100,
+		NoDataAlert:             5 * time.Minute,
+	}
+	assert.Equal(t, []string{
+		"2019-02-07 21:16:01 +0000 UTC: Alert RED, no records in the last 5m0s",
+		"2019-02-07 21:21:01 +0000 UTC: Alert GREEN, records arrived after 10m0s without them",
+	}, alertLines(runProcessor(&logProcessor)))
+}
+
+func TestNoDataAlertWithoutRecords(t *testing.T) {
+	wallClock := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	logProcessor := Processor{
+		LogReader:   strings.NewReader(""),
+		AlertWindow: time.Minute,
+		NoDataAlert: 5 * time.Minute,
+		now:         func() time.Time { return wallClock },
+	}
+	assert.Empty(t, runProcessor(&logProcessor))
+
+	// absence of records is measured from the start by the wall clock
+	var output strings.Builder
+	printFunction = func(format string, a ...interface{}) (n int, err error) {
+		return fmt.Fprintf(&output, format, a...)
+	}
+	defer func() { printFunction = fmt.Printf }()
+	for _, elapsed := range []time.Duration{time.Minute, 5 * time.Minute, 6 * time.Minute} {
+		logProcessor.now = func() time.Time { return wallClock.Add(elapsed) }
+		logProcessor.idle()
+	}
+	assert.Equal(t, "2021-01-01 00:05:00 +0000 UTC: Alert RED, no records in the last 5m0s\n", output.String())
+}
+
+func TestNoDataAlertBeforePastRecords(t *testing.T) {
+	wallClock := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	var alertHistory bytes.Buffer
+	logProcessor := Processor{
+		AlertWindow:             time.Minute,
+		AlertThresholdPerSecond: 100,
+		NoDataAlert:             5 * time.Minute,
+		AlertHistory:            &alertHistory,
+		now:                     func() time.Time { return wallClock },
+	}
+	var output strings.Builder
+	printFunction = func(format string, a ...interface{}) (n int, err error) {
+		return fmt.Fprintf(&output, format, a...)
+	}
+	defer func() { printFunction = fmt.Printf }()
+
+	// the log written two years before arrives after six minutes without records
+	opts, err := logProcessor.prepare()
+	require.NoError(t, err)
+	parser := newLineParser(opts)
+	logProcessor.now = func() time.Time { return wallClock.Add(6 * time.Minute) }
+	logProcessor.idle()
+	logProcessor.now = func() time.Time { return wallClock.Add(7 * time.Minute) }
+	logProcessor.lastArrival = logProcessor.now()
+	for _, line := range []string{
+		`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234`,
+		`"10.0.0.2","-","apache",1549573861,"GET /api/user HTTP/1.0",200,1234`,
+	} {
+		var r record
+		require.Equal(t, reasonNone, parser.parse([]byte(line), &r))
+		logProcessor.aggregate(&r)
+	}
+
+	// both transitions are dated by the wall clock, so the alert doesn't recover before it fired
+	assert.Equal(t, "2021-01-01 00:06:00 +0000 UTC: Alert RED, no records in the last 6m0s\n"+
+		"2021-01-01 00:07:00 +0000 UTC: Alert GREEN, records arrived after 7m0s without them, "+
+		"the first one dated 2019-02-07 21:11:00 +0000 UTC\n", output.String())
+	entries, err := history.Read(&alertHistory)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, wallClock.Add(6*time.Minute), entries[1].Start)
+	assert.Equal(t, wallClock.Add(7*time.Minute), *entries[1].End)
+}
+
+func TestIdleAlerts(t *testing.T) {
+	// two hits per second for two minutes, then the log stops being written
+	var log strings.Builder
+	start := int64(1549573860)
+	for second := int64(0); second < 2*60; second++ {
+		for i := 0; i < 2; i++ {
+			fmt.Fprintf(&log, "\"10.0.0.2\",\"-\",\"apache\",%d,\"GET /api/user HTTP/1.0\",200,1234\n", start+second)
+		}
+	}
+	rules, err := NewLowTrafficRules([]string{"1/s@1m"})
+	require.NoError(t, err)
+	wallClock := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	logProcessor := Processor{
+		LogReader:               strings.NewReader(log.String()),
+		AlertWindow:             time.Minute,
+		AlertThresholdPerSecond: 1,
+		LowTrafficAlerts:        rules,
+		NoDataAlert:             5 * time.Minute,
+		JSON:                    true,
+		now:                     func() time.Time { return wallClock },
+	}
+	assert.Equal(t, []string{
+		`{"time":"2019-02-07T21:11:30Z","type":"alert","alert":"hits","state":"RED","per_second":1.0166666666666666,"threshold":1,"total":61,"window":"1m0s","top_clients":[{"key":"10.0.0.2","count":61}],"top_clients_by_bytes":[{"key":"10.0.0.2","count":75274}],"top_users":[{"key":"apache","count":61}]}`,
+	}, alertLines(runProcessor(&logProcessor)))
+
+	// log time goes on along with the wall clock, and alerts notice the absence of records
+	var output strings.Builder
+	printFunction = func(format string, a ...interface{}) (n int, err error) {
+		return fmt.Fprintf(&output, format, a...)
+	}
+	defer func() { printFunction = fmt.Printf }()
+	for _, elapsed := range []time.Duration{time.Millisecond * 500, 30 * time.Second, time.Minute, 5 * time.Minute} {
+		logProcessor.now = func() time.Time { return wallClock.Add(elapsed) }
+		logProcessor.idle()
+	}
+	assert.Equal(t, `{"time":"2019-02-07T21:13:59Z","type":"alert","alert":"hits","state":"GREEN","per_second":0.03333333333333333,"threshold":1,"total":2,"window":"1m0s"}
+{"time":"2019-02-07T21:13:59Z","type":"alert","alert":"low_traffic","state":"RED","per_second":0,"threshold":1,"total":0,"window":"1m0s"}
+{"time":"2019-02-07T21:17:59Z","type":"alert","alert":"no_data","state":"RED","silence":"5m0s","threshold":"5m0s"}
+`, output.String())
+}
+
+// alertLines returns alerts from the processor output
+func alertLines(output string) []string {
+	var alerts []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, "Alert") || strings.Contains(line, `"type":"alert"`) {
+			alerts = append(alerts, line)
+		}
+	}
+	return alerts
+}
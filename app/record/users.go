@@ -0,0 +1,59 @@
+package record
+
+import (
+	"github.com/paskal/datadog-parser/app/sketch"
+)
+
+// userCounter counts unique users
+type userCounter interface {
+	add(user string)
+	merge(other userCounter)
+	count() int
+	reset()
+	// stdError is the relative standard error of the count, zero for the exact one
+	stdError() float64
+}
+
+// newUserCounter creates exact counter if precision is zero, and HyperLogLog-based otherwise
+func newUserCounter(precision uint8) userCounter {
+	if precision == 0 {
+		return exactUsers{}
+	}
+	return estimatedUsers{sketch.NewHLL(precision)}
+}
+
+// exactUsers keeps every user in the map
+type exactUsers map[string]struct{}
+
+func (e exactUsers) add(user string) { e[user] = struct{}{} }
+
+func (e exactUsers) merge(other userCounter) {
+	for u := range other.(exactUsers) {
+		e[u] = struct{}{}
+	}
+}
+
+func (e exactUsers) count() int { return len(e) }
+
+func (e exactUsers) reset() {
+	for u := range e {
+		delete(e, u)
+	}
+}
+
+func (e exactUsers) stdError() float64 { return 0 }
+
+// estimatedUsers counts users approximately in bounded memory
+type estimatedUsers struct {
+	hll *sketch.HLL
+}
+
+func (e estimatedUsers) add(user string) { e.hll.Add(user) }
+
+func (e estimatedUsers) merge(other userCounter) { e.hll.Merge(other.(estimatedUsers).hll) }
+
+func (e estimatedUsers) count() int { return int(e.hll.Count()) }
+
+func (e estimatedUsers) reset() { e.hll.Reset() }
+
+func (e estimatedUsers) stdError() float64 { return e.hll.StdError() }
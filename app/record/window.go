@@ -0,0 +1,345 @@
+package record
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/paskal/datadog-parser/app/useragent"
+)
+
+// maxBuckets limits the memory used by the window with fine resolution
+const maxBuckets = 100000
+
+// maxUsersMemory limits the memory HyperLogLog unique user counters of all buckets of the window could take
+const maxUsersMemory = 1 << 30
+
+// window is a fixed-size ring of history buckets covering the alert window, each bucket
+// holds records of a resolution-long period. Bucket with the key k lives at index k mod len(buckets),
+// so adding a record and moving the window forward cost O(1) amortised regardless of the window size.
+type window struct {
+	buckets    []bucket
+	resolution time.Duration
+	opts       historyOpts
+	head       int64 // key of the newest bucket in the window
+	started    bool  // false until the first record is added
+	hits       int   // running total of hits across all buckets in the window
+	alertHits  int   // running total of hits counted by the alert
+	alertBytes int   // running total of bytes counted by the alert
+}
+
+// bucket is a single resolution-long period of history
+type bucket struct {
+	key  int64 // number of resolution-long periods since unix epoch
+	used bool  // false for buckets which were never filled or were reset
+	historyRecord
+}
+
+// CheckResolution returns error if the window can't be split into buckets of the provided resolution
+func CheckResolution(size, resolution time.Duration) error {
+	if resolution <= 0 {
+		return fmt.Errorf("bucket resolution should be positive, got %s", resolution)
+	}
+	if size/resolution >= maxBuckets {
+		return fmt.Errorf("window of %s with %s resolution needs more than %d buckets", size, resolution, maxBuckets)
+	}
+	return nil
+}
+
+// CheckUsersPrecision returns error if HyperLogLog unique user counters of the given precision could take
+// more than maxUsersMemory in all buckets of the window, as every one of them takes up to 2^precision bytes
+func CheckUsersPrecision(size, resolution time.Duration, precision uint8) error {
+	if memory := (int64(size/resolution) + 1) << precision; memory > maxUsersMemory {
+		return fmt.Errorf("window of %s with %s resolution and unique users precision %d could take %d MB, more than %d MB",
+			size, resolution, precision, memory>>20, maxUsersMemory>>20)
+	}
+	return nil
+}
+
+// newWindow creates window keeping all records not older than size from the newest one,
+// in buckets of the provided resolution
+func newWindow(size, resolution time.Duration, opts historyOpts) *window {
+	return &window{buckets: make([]bucket, int64(size/resolution)+1), resolution: resolution, opts: opts}
+}
+
+// key of the bucket for the provided time
+func (w *window) key(t time.Time) int64 {
+	return bucketKey(t, w.resolution)
+}
+
+// bucketKey returns the number of resolution-long periods since unix epoch till the provided time
+func bucketKey(t time.Time, resolution time.Duration) int64 {
+	// whole seconds resolution doesn't need nanoseconds, which overflow for distant dates
+	if resolution%time.Second == 0 {
+		return floorDiv(t.Unix(), int64(resolution/time.Second))
+	}
+	return floorDiv(t.UnixNano(), int64(resolution))
+}
+
+// start time of the bucket with the provided key
+func (w *window) start(key int64) time.Time {
+	if w.resolution%time.Second == 0 {
+		return time.Unix(key*int64(w.resolution/time.Second), 0)
+	}
+	return time.Unix(0, key*int64(w.resolution))
+}
+
+// add record to the window, returns false if record is too old to fit into it
+func (w *window) add(r *record) bool {
+	key := w.key(r.date)
+	if !w.started || key > w.head {
+		w.advance(key)
+	}
+	if key <= w.head-int64(len(w.buckets)) {
+		return false
+	}
+	b := &w.buckets[w.index(key)]
+	if !b.used {
+		b.key, b.used = key, true
+		if b.sections == nil {
+			b.historyRecord = newHistoryRecord(w.opts)
+		}
+	}
+	b.add(r, w.opts)
+	w.hits++
+	if !r.filtered {
+		w.alertHits++
+		w.alertBytes += r.bytes
+	}
+	return true
+}
+
+// advance moves the head of the window to the key, resetting buckets which fall out of it
+func (w *window) advance(key int64) {
+	n := int64(len(w.buckets))
+	from := w.head + 1
+	if !w.started || key-w.head > n {
+		// everything in the window is outdated, no need to walk over the same bucket twice
+		from = key - n + 1
+	}
+	for k := from; k <= key; k++ {
+		w.reset(&w.buckets[w.index(k)])
+	}
+	w.head, w.started = key, true
+}
+
+// reset empties the bucket, keeping the allocated maps for reuse
+func (w *window) reset(b *bucket) {
+	if !b.used {
+		return
+	}
+	w.hits -= b.hits
+	w.alertHits -= b.alertHits
+	w.alertBytes -= b.alertBytes
+	b.historyRecord.reset()
+	b.used = false
+}
+
+// latestBefore returns the key of the newest bucket present in the window which is before the provided one
+func (w *window) latestBefore(key int64) (latest int64, ok bool) {
+	for i := range w.buckets {
+		b := &w.buckets[i]
+		if b.used && b.key < key && (!ok || b.key > latest) {
+			latest, ok = b.key, true
+		}
+	}
+	return latest, ok
+}
+
+// collect merges buckets with keys in [from, to] range into single historyRecord
+func (w *window) collect(from, to int64) historyRecord {
+	stats := newHistoryRecord(w.opts)
+	// only keys still present in the window could have data
+	if oldest := w.head - int64(len(w.buckets)) + 1; from < oldest {
+		from = oldest
+	}
+	if to > w.head {
+		to = w.head
+	}
+	for k := from; k <= to; k++ {
+		if b := &w.buckets[w.index(k)]; b.used && b.key == k {
+			stats.append(b.historyRecord)
+		}
+	}
+	return stats
+}
+
+// total merges all buckets in the window into single historyRecord
+func (w *window) total() historyRecord {
+	return w.collect(w.head-int64(len(w.buckets))+1, w.head)
+}
+
+// index of the bucket for the provided key
+func (w *window) index(key int64) int {
+	return int(floorMod(key, int64(len(w.buckets))))
+}
+
+// floorDiv is a division rounding towards negative infinity
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// floorMod is a modulo with the sign of the divisor
+func floorMod(a, b int64) int64 {
+	return (a%b + b) % b
+}
+
+// historyOpts define how history is collected
+type historyOpts struct {
+	usersPrecision uint8 // HyperLogLog precision for unique users, exact count if zero
+	heavyHitters   int   // number of keys tracked per bucket for every breakdown, exact count if zero
+	sizes          bool  // collect response size distributions
+}
+
+type historyRecord struct {
+	bytesTransferred int           // used for stats
+	hits             int           // used for stats
+	alertHits        int           // hits counted by the alert
+	alertBytes       int           // bytes counted by the alert
+	sections         hitCounter    // hit stats per section, buckets with hits of the section once merged
+	networks         hitCounter    // hit stats per client network label
+	countries        hitCounter    // hit stats per client country
+	asns             hitCounter    // hit stats per client autonomous system
+	agentHits        int           // hits with known user agent
+	botHits          int           // hits from bots
+	browsers         hitCounter    // hit stats per browser of humans
+	systems          hitCounter    // hit stats per operating system
+	devices          hitCounter    // hit stats per device family
+	bots             hitCounter    // hit stats per bot
+	clients          hitCounter    // hit stats per client address
+	clientBytes      hitCounter    // bytes transferred per client address
+	alertClients     hitCounter    // hits counted by the alert per client address
+	alertClientBytes hitCounter    // bytes counted by the alert per client address
+	alertAuthUsers   hitCounter    // hits counted by the alert per authenticated user
+	authUsers        hitCounter    // hit stats per authenticated user
+	uniqueUsers      userCounter   // unique user counter
+	sizes            distributions // response size distributions
+	latencies        distributions // request duration distributions in microseconds
+}
+
+func newHistoryRecord(opts historyOpts) historyRecord {
+	h := historyRecord{
+		sections:         newHitCounter(opts.heavyHitters),
+		networks:         newHitCounter(opts.heavyHitters),
+		countries:        newHitCounter(opts.heavyHitters),
+		asns:             newHitCounter(opts.heavyHitters),
+		browsers:         newHitCounter(opts.heavyHitters),
+		systems:          newHitCounter(opts.heavyHitters),
+		devices:          newHitCounter(opts.heavyHitters),
+		bots:             newHitCounter(opts.heavyHitters),
+		clients:          newHitCounter(opts.heavyHitters),
+		clientBytes:      newHitCounter(opts.heavyHitters),
+		alertClients:     newHitCounter(opts.heavyHitters),
+		alertClientBytes: newHitCounter(opts.heavyHitters),
+		alertAuthUsers:   newHitCounter(opts.heavyHitters),
+		authUsers:        newHitCounter(opts.heavyHitters),
+		uniqueUsers:      newUserCounter(opts.usersPrecision),
+		sizes:            newDistributions(opts.sizes, opts.heavyHitters),
+		latencies:        newDistributions(true, opts.heavyHitters),
+	}
+	return h
+}
+
+func (h *historyRecord) add(r *record, opts historyOpts) {
+	h.bytesTransferred += r.bytes
+	h.sections.add(r.section, 1)
+	if r.label != "" {
+		h.networks.add(r.label, 1)
+	}
+	if r.country != "" {
+		h.countries.add(r.country, 1)
+		h.asns.add(r.asn, 1)
+	}
+	if r.agent.Device != "" {
+		h.addAgent(r.agent)
+	}
+	h.clients.add(r.remotehost, 1)
+	h.clientBytes.add(r.remotehost, r.bytes)
+	// "-" means the user is not authenticated
+	authenticated := r.authuser != "-" && r.authuser != ""
+	if authenticated {
+		h.authUsers.add(r.authuser, 1)
+	}
+	h.uniqueUsers.add(r.remotehost)
+	h.sizes.add(r.section, float64(r.bytes))
+	if r.timed {
+		h.latencies.add(r.section, float64(r.latency)/float64(time.Microsecond))
+	}
+	h.hits++
+	if !r.filtered {
+		h.alertHits++
+		h.alertBytes += r.bytes
+		h.alertClients.add(r.remotehost, 1)
+		h.alertClientBytes.add(r.remotehost, r.bytes)
+		if authenticated {
+			h.alertAuthUsers.add(r.authuser, 1)
+		}
+	}
+}
+
+func (h *historyRecord) addAgent(agent useragent.Agent) {
+	h.agentHits++
+	h.systems.add(agent.OS, 1)
+	h.devices.add(agent.Device, 1)
+	if agent.Bot {
+		h.botHits++
+		h.bots.add(agent.Browser, 1)
+		return
+	}
+	h.browsers.add(agent.Browser, 1)
+}
+
+func (h *historyRecord) append(new historyRecord) {
+	h.bytesTransferred += new.bytesTransferred
+	h.hits += new.hits
+	h.alertHits += new.alertHits
+	h.alertBytes += new.alertBytes
+	// sections are ranked by the number of buckets they have hits in rather than by their hits,
+	// which keeps the top section of the report the one requested most steadily
+	for _, c := range new.sections.top(0) {
+		h.sections.add(c.key, 1)
+	}
+	h.networks.merge(new.networks)
+	h.countries.merge(new.countries)
+	h.asns.merge(new.asns)
+	h.agentHits += new.agentHits
+	h.botHits += new.botHits
+	h.browsers.merge(new.browsers)
+	h.systems.merge(new.systems)
+	h.devices.merge(new.devices)
+	h.bots.merge(new.bots)
+	h.clients.merge(new.clients)
+	h.clientBytes.merge(new.clientBytes)
+	h.alertClients.merge(new.alertClients)
+	h.alertClientBytes.merge(new.alertClientBytes)
+	h.alertAuthUsers.merge(new.alertAuthUsers)
+	h.authUsers.merge(new.authUsers)
+	h.uniqueUsers.merge(new.uniqueUsers)
+	h.sizes.merge(new.sizes)
+	h.latencies.merge(new.latencies)
+}
+
+func (h *historyRecord) reset() {
+	h.bytesTransferred, h.hits, h.alertHits, h.alertBytes = 0, 0, 0, 0
+	h.sections.reset()
+	h.networks.reset()
+	h.countries.reset()
+	h.asns.reset()
+	h.agentHits, h.botHits = 0, 0
+	h.browsers.reset()
+	h.systems.reset()
+	h.devices.reset()
+	h.bots.reset()
+	h.clients.reset()
+	h.clientBytes.reset()
+	h.alertClients.reset()
+	h.alertClientBytes.reset()
+	h.alertAuthUsers.reset()
+	h.authUsers.reset()
+	h.uniqueUsers.reset()
+	h.sizes.reset()
+	h.latencies.reset()
+}
@@ -0,0 +1,94 @@
+package record
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindow(t *testing.T) {
+	w := newWindow(time.Second*3, time.Second, historyOpts{})
+	assert.Equal(t, 4, len(w.buckets))
+	newRecord := func(ts int64, host string) *record {
+		return &record{date: time.Unix(ts, 0), remotehost: host, section: "/api", bytes: 10}
+	}
+
+	assert.True(t, w.add(newRecord(100, "a")))
+	assert.True(t, w.add(newRecord(101, "b")))
+	assert.True(t, w.add(newRecord(101, "a")))
+	assert.True(t, w.add(newRecord(100, "c")), "late record within the window")
+	assert.Equal(t, 4, w.hits)
+
+	stats := w.collect(100, 101)
+	assert.Equal(t, 4, stats.hits)
+	assert.Equal(t, 40, stats.bytesTransferred)
+	assert.Equal(t, 3, stats.uniqueUsers.count())
+
+	latest, ok := w.latestBefore(101)
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), latest)
+	_, ok = w.latestBefore(100)
+	assert.False(t, ok)
+
+	// 100 falls out of the window when 104 arrives
+	assert.True(t, w.add(newRecord(104, "d")))
+	assert.Equal(t, 3, w.hits)
+	assert.Equal(t, 0, w.collect(100, 100).hits)
+	assert.False(t, w.add(newRecord(100, "e")), "record older than the window is dropped")
+	assert.Equal(t, 3, w.hits)
+
+	// jump far ahead resets everything
+	assert.True(t, w.add(newRecord(1000, "f")))
+	assert.Equal(t, 1, w.hits)
+	assert.Equal(t, 1, w.collect(0, 2000).hits)
+	latest, ok = w.latestBefore(2000)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1000), latest)
+}
+
+func TestWindowResolution(t *testing.T) {
+	w := newWindow(time.Second, time.Millisecond*100, historyOpts{})
+	assert.Equal(t, 11, len(w.buckets))
+	start := time.Date(2019, 2, 7, 21, 11, 0, 0, time.UTC)
+	newRecord := func(d time.Duration) *record {
+		return &record{date: start.Add(d), remotehost: "a", section: "/api"}
+	}
+
+	assert.True(t, w.add(newRecord(time.Millisecond*50)))
+	assert.True(t, w.add(newRecord(time.Millisecond*99)))
+	assert.True(t, w.add(newRecord(time.Millisecond*100)))
+	assert.Equal(t, 2, w.collect(w.key(start), w.key(start)).hits)
+	assert.True(t, start.Add(time.Millisecond*100).Equal(w.start(w.key(start.Add(time.Millisecond*199)))))
+
+	// first bucket is dropped once the window moves one second forward from it
+	assert.True(t, w.add(newRecord(time.Millisecond*1050)))
+	assert.Equal(t, 4, w.hits)
+	assert.True(t, w.add(newRecord(time.Millisecond*1100)))
+	assert.Equal(t, 3, w.hits)
+}
+
+func TestWindowKey(t *testing.T) {
+	w := newWindow(time.Minute, time.Second*10, historyOpts{})
+	assert.Equal(t, int64(0), w.key(time.Unix(9, 999)))
+	assert.Equal(t, int64(-1), w.key(time.Unix(-1, 0)))
+	assert.True(t, time.Unix(-10, 0).Equal(w.start(-1)))
+	w = newWindow(time.Minute, time.Millisecond*250, historyOpts{})
+	assert.Equal(t, int64(-1), w.key(time.Unix(0, -1)))
+	assert.Equal(t, int64(5), w.key(time.Unix(1, 300000000)))
+	assert.True(t, time.Unix(1, 250000000).Equal(w.start(5)))
+}
+
+func TestCheckResolution(t *testing.T) {
+	assert.NoError(t, CheckResolution(time.Minute*2, time.Millisecond*100))
+	assert.EqualError(t, CheckResolution(time.Minute, 0), "bucket resolution should be positive, got 0s")
+	assert.EqualError(t, CheckResolution(time.Hour, time.Millisecond),
+		"window of 1h0m0s with 1ms resolution needs more than 100000 buckets")
+}
+
+func TestCheckUsersPrecision(t *testing.T) {
+	assert.NoError(t, CheckUsersPrecision(time.Minute*2, time.Second, 18))
+	assert.NoError(t, CheckUsersPrecision(time.Hour, time.Millisecond*100, 14))
+	assert.EqualError(t, CheckUsersPrecision(time.Hour, time.Millisecond*100, 18),
+		"window of 1h0m0s with 100ms resolution and unique users precision 18 could take 9000 MB, more than 1024 MB")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/paskal/datadog-parser/app/record"
+)
+
+// replayCommand writes the log with the pauses between its records, to be piped to tail command
+type replayCommand struct {
+	Speed  float64 `long:"speed" default:"1" description:"pace of the replay, 10 is ten times faster than the log was written, no pauses if 0"`
+	Retime bool    `long:"retime" description:"replace dates with the time the lines are written at"`
+}
+
+// runReplay writes the log till its end or the interrupt signal, and returns the exit code
+func runReplay(w io.Writer, opts opts, stdin io.Reader) int {
+	if opts.Replay.Speed < 0 {
+		log.Print("Replay speed must not be negative")
+		return 2
+	}
+	in, code := openInput(opts, stdin)
+	if code != 0 {
+		return code
+	}
+	defer in.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	replayer := record.Replayer{
+		LogReader:  in.reader,
+		Output:     w,
+		Timestamps: in.timestamps,
+		Speed:      opts.Replay.Speed,
+		Retime:     opts.Replay.Retime,
+	}
+	if err := replayer.Replay(ctx); err != nil {
+		log.Printf("Error replaying the log: %v", err)
+		return 3
+	}
+	return 0
+}
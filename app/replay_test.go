@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunReplay(t *testing.T) {
+	log := `"remotehost","rfc931","authuser","date","request","status","bytes"
+"10.0.0.1","-","apache",2019-02-07T21:11:00Z,"GET /api/user HTTP/1.0",200,1000
+"10.0.0.2","-","apache",2019-02-07T21:11:00Z,"GET /api/user HTTP/1.0",500,200
+`
+	replay := func(args ...string) (string, int) {
+		o, command := parseArgs(t, append([]string{"replay"}, args...)...)
+		require.Equal(t, "replay", command)
+		var out strings.Builder
+		code := runReplay(&out, o, strings.NewReader(log))
+		return out.String(), code
+	}
+
+	out, code := replay("--speed=0")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, log, out)
+
+	// dates are replaced with the current time, in the format of the log
+	out, code = replay("--retime", "--time_format=rfc3339")
+	assert.Equal(t, 0, code)
+	assert.NotContains(t, out, "2019-02-07T21:11:00Z")
+	assert.Regexp(t, `^"remotehost".*\n("10\.0\.0\.\d","-","apache",\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ,.*\n){2}$`, out)
+
+	out, code = replay("--speed=-1")
+	assert.Equal(t, 2, code)
+	assert.Empty(t, out)
+	_, code = replay("--filepath=/nonexistent/access.csv")
+	assert.Equal(t, 3, code)
+}
@@ -0,0 +1,204 @@
+package rollup
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paskal/datadog-parser/app/sketch"
+)
+
+// Dimensions rollups could be grouped by
+var Dimensions = []string{"section", "status", "status_class", "label"}
+
+// Metrics of the query
+var Metrics = []string{"hits", "bytes", "users"}
+
+// Query sums rollups within the range into steps, for every group of the requests with the same dimensions
+type Query struct {
+	From, To    time.Time
+	Step        time.Duration // the whole range is one step if not set
+	GroupBy     []string      // dimensions, like section or status_class
+	Metrics     []string      // hits, bytes or users
+	Section     string        // prefix of the section of the counted requests, all of them are counted if empty
+	StatusClass int           // first digit of the status of the counted requests, like 5 for 5xx, all of them if zero
+	Label       string        // client network label of the counted requests, "-" for clients without one
+}
+
+// Row is the values of the metrics of the group in the step
+type Row struct {
+	Time   time.Time // start of the step
+	Group  []string  // values of the dimensions in the order of Query.GroupBy
+	Values []int     // values of the metrics in the order of Query.Metrics
+}
+
+// validate the query, unique users could be counted only for all requests as rollups don't keep them per group
+func (q Query) validate() error {
+	if !q.From.Before(q.To) {
+		return fmt.Errorf("start of the range should be before its end")
+	}
+	if q.Step < 0 {
+		return fmt.Errorf("step should not be negative")
+	}
+	for _, d := range q.GroupBy {
+		if !contains(Dimensions, d) {
+			return fmt.Errorf("unknown dimension %q, should be one of %s", d, strings.Join(Dimensions, ", "))
+		}
+	}
+	if len(q.Metrics) == 0 {
+		return fmt.Errorf("at least one metric should be set")
+	}
+	for _, m := range q.Metrics {
+		if !contains(Metrics, m) {
+			return fmt.Errorf("unknown metric %q, should be one of %s", m, strings.Join(Metrics, ", "))
+		}
+		if m == "users" && (len(q.GroupBy) > 0 || q.filtered()) {
+			return fmt.Errorf("users metric can't be grouped or filtered, as unique users are kept for all requests only")
+		}
+	}
+	if q.StatusClass < 0 || q.StatusClass > 5 {
+		return fmt.Errorf("status class should be between 1xx and 5xx")
+	}
+	return nil
+}
+
+func (q Query) filtered() bool {
+	return q.Section != "" || q.StatusClass != 0 || q.Label != ""
+}
+
+// match returns true if the requests of the group are counted by the query
+func (q Query) match(g Group) bool {
+	return strings.HasPrefix(g.Section, q.Section) &&
+		(q.StatusClass == 0 || g.Status/100 == q.StatusClass) &&
+		(q.Label == "" || q.Label == dimension(g, "label"))
+}
+
+// dimension returns the value of the dimension of the group
+func dimension(g Group, name string) string {
+	switch name {
+	case "section":
+		return g.Section
+	case "status":
+		return strconv.Itoa(g.Status)
+	case "status_class":
+		return strconv.Itoa(g.Status/100) + "xx"
+	case "label":
+		if g.Label == "" {
+			return "-"
+		}
+		return g.Label
+	}
+	return ""
+}
+
+// Query returns rows of the query sorted by time and group. Steps without requests are left out.
+// Rollups are read from the coarsest tier which has the resolution fitting the step and the range,
+// and still has rollups of the start of the range.
+func (s *Store) Query(q Query) ([]Row, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+	t, err := s.queryTier(q)
+	if err != nil {
+		return nil, err
+	}
+	rollups, err := s.Read(t.Resolution, q.From, q.To)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		step  int64
+		group string
+	}
+	rows := map[key]*Row{}
+	users := map[int64]*sketch.HLL{}
+	for _, r := range rollups {
+		step := q.From
+		if q.Step > 0 {
+			step = q.From.Add(r.Start.Sub(q.From) / q.Step * q.Step)
+		}
+		for g, c := range r.Groups {
+			if !q.match(g) {
+				continue
+			}
+			values := make([]string, len(q.GroupBy))
+			for i, d := range q.GroupBy {
+				values[i] = dimension(g, d)
+			}
+			k := key{step: step.Unix(), group: strings.Join(values, "\x00")}
+			row, ok := rows[k]
+			if !ok {
+				row = &Row{Time: step, Group: values, Values: make([]int, len(q.Metrics))}
+				rows[k] = row
+			}
+			for i, m := range q.Metrics {
+				switch m {
+				case "hits":
+					row.Values[i] += c.Hits
+				case "bytes":
+					row.Values[i] += c.Bytes
+				}
+			}
+		}
+		if contains(q.Metrics, "users") {
+			if _, ok := users[step.Unix()]; !ok {
+				users[step.Unix()] = sketch.NewHLL(usersPrecision)
+			}
+			users[step.Unix()].Merge(r.Users)
+		}
+	}
+
+	result := make([]Row, 0, len(rows))
+	for k, row := range rows {
+		for i, m := range q.Metrics {
+			if m == "users" {
+				row.Values[i] = int(users[k.step].Count())
+			}
+		}
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Time.Equal(result[j].Time) {
+			return result[i].Time.Before(result[j].Time)
+		}
+		return strings.Join(result[i].Group, "\x00") < strings.Join(result[j].Group, "\x00")
+	})
+	return result, nil
+}
+
+// queryTier returns the coarsest tier with the resolution which the step and the bounds of the range are aligned to,
+// which still keeps the start of the range, or the one which keeps the longest history if none of them does
+func (s *Store) queryTier(q Query) (*tier, error) {
+	var fit, longest *tier
+	for _, t := range s.tiers {
+		aligned := q.From.Truncate(t.Resolution).Equal(q.From) && q.To.Truncate(t.Resolution).Equal(q.To)
+		if !aligned || q.Step%t.Resolution != 0 {
+			continue
+		}
+		if t.newest.IsZero() || !q.From.Before(t.newest.Add(-t.Retention)) {
+			fit = t
+		}
+		if longest == nil || t.Retention > longest.Retention {
+			longest = t
+		}
+	}
+	if fit != nil {
+		return fit, nil
+	}
+	if longest != nil {
+		return longest, nil
+	}
+	return nil, fmt.Errorf("step and the range should be aligned to %s resolution of rollups", formatDuration(s.tiers[0].Resolution))
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
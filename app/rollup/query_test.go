@@ -0,0 +1,76 @@
+package rollup
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuery(t *testing.T) {
+	tiers, err := ParseTiers([]string{"10s:1h", "1m:1d", "1h:7d"})
+	require.NoError(t, err)
+	store, err := Open(t.TempDir(), tiers)
+	require.NoError(t, err)
+
+	// three minutes of hits every 10 seconds, the internal client requesting /api/user, failing every other time
+	start := time.Date(2019, 2, 7, 21, 10, 0, 0, time.UTC)
+	for i := 0; i < 18; i++ {
+		r := New(start.Add(time.Duration(i) * 10 * time.Second))
+		r.Add(Group{Section: "/api", Status: 200 + i%2*300}, 100, fmt.Sprintf("10.0.0.%d", i%3))
+		r.Add(Group{Section: "/api/user", Status: 200 + i%2*3, Label: "internal"}, 10, "10.0.0.1")
+		r.Add(Group{Section: "/report", Status: 200}, 1000, "10.0.0.4")
+		require.NoError(t, store.Write(r))
+	}
+
+	query := func(q Query) []string {
+		rows, err := store.Query(q)
+		require.NoError(t, err)
+		var result []string
+		for _, r := range rows {
+			result = append(result, fmt.Sprintf("%s %v %v", r.Time.Format("15:04:05"), r.Group, r.Values))
+		}
+		return result
+	}
+
+	// the whole range is summed from hourly rollups which are still pending
+	assert.Equal(t, []string{
+		"21:10:00 [/api 2xx] [9 900]",
+		"21:10:00 [/api 5xx] [9 900]",
+		"21:10:00 [/api/user 2xx] [18 180]",
+		"21:10:00 [/report 2xx] [18 18000]",
+	}, query(Query{From: start, To: start.Add(time.Hour), GroupBy: []string{"section", "status_class"},
+		Metrics: []string{"hits", "bytes"}}))
+
+	assert.Equal(t, []string{
+		"21:10:00 [] [27 4]",
+		"21:11:30 [] [27 4]",
+	}, query(Query{From: start, To: start.Add(5 * time.Minute), Step: 90 * time.Second, Metrics: []string{"hits", "users"}}))
+
+	assert.Equal(t, []string{
+		"21:10:00 [200 internal] [6]",
+		"21:10:00 [203 internal] [6]",
+		"21:12:00 [200 internal] [3]",
+		"21:12:00 [203 internal] [3]",
+	}, query(Query{From: start, To: start.Add(time.Hour), Step: 2 * time.Minute, GroupBy: []string{"status", "label"},
+		Metrics: []string{"hits"}, Section: "/api", StatusClass: 2, Label: "internal"}))
+
+	assert.Equal(t, []string{
+		"21:10:00 [-] [12]",
+	}, query(Query{From: start, To: start.Add(time.Minute), GroupBy: []string{"label"}, Metrics: []string{"hits"}, Label: "-"}))
+
+	for q, msg := range map[*Query]string{
+		{From: start, To: start, Metrics: []string{"hits"}}:                                           "start of the range should be before its end",
+		{From: start, To: start.Add(time.Hour), Metrics: []string{"hits"}, GroupBy: []string{"path"}}: `unknown dimension "path", should be one of section, status, status_class, label`,
+		{From: start, To: start.Add(time.Hour)}:                                                       "at least one metric should be set",
+		{From: start, To: start.Add(time.Hour), Metrics: []string{"latency"}}:                         `unknown metric "latency", should be one of hits, bytes, users`,
+		{From: start, To: start.Add(time.Hour), Metrics: []string{"users"}, Section: "/api"}:          "users metric can't be grouped or filtered, as unique users are kept for all requests only",
+		{From: start, To: start.Add(time.Hour), Metrics: []string{"hits"}, Step: 15 * time.Second}:    "step and the range should be aligned to 10s resolution of rollups",
+		{From: start.Add(time.Second), To: start.Add(time.Hour), Metrics: []string{"hits"}}:           "step and the range should be aligned to 10s resolution of rollups",
+	} {
+		_, err = store.Query(*q)
+		assert.EqualError(t, err, msg)
+	}
+}
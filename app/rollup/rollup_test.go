@@ -0,0 +1,41 @@
+package rollup
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollup(t *testing.T) {
+	start := time.Date(2019, 2, 7, 21, 11, 0, 0, time.UTC)
+	r := New(start)
+	r.Add(Group{Section: "/api", Status: 200}, 1234, "10.0.0.1")
+	r.Add(Group{Section: "/api", Status: 500, Label: "office"}, 100, "10.0.0.2")
+	other := New(start.Add(10 * time.Second))
+	other.Add(Group{Section: "/report", Status: 200}, 10, "10.0.0.1")
+	other.Add(Group{Section: "/api", Status: 200}, 1000, "10.0.0.1")
+	r.Merge(other)
+	assert.Equal(t, start, r.Start)
+	assert.Equal(t, 4, r.Hits)
+	assert.Equal(t, 2344, r.Bytes)
+	assert.Equal(t, 2, r.UniqueUsers())
+	assert.Equal(t, map[Group]Counts{
+		{Section: "/api", Status: 200}:                  {Hits: 2, Bytes: 2234},
+		{Section: "/api", Status: 500, Label: "office"}: {Hits: 1, Bytes: 100},
+		{Section: "/report", Status: 200}:               {Hits: 1, Bytes: 10},
+	}, r.Groups)
+
+	data, err := json.Marshal(r)
+	require.NoError(t, err)
+	assert.Equal(t, `{"start":"2019-02-07T21:11:00Z","hits":4,"bytes":2344,"users":"DAAAAAqrBAAADIcB",`+
+		`"groups":[{"section":"/api","status":200,"hits":2,"bytes":2234},{"section":"/api","status":500,"label":"office","hits":1,"bytes":100},`+
+		`{"section":"/report","status":200,"hits":1,"bytes":10}]}`, string(data))
+	decoded := &Rollup{}
+	require.NoError(t, json.Unmarshal(data, decoded))
+	assert.Equal(t, r, decoded)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"start":"2019-02-07T21:11:00Z","users":"AA=="}`), decoded))
+}
@@ -0,0 +1,434 @@
+package rollup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// segmentRollups is the number of rollups in a segment file of the tier, retention removes whole segments
+const segmentRollups = 360
+
+// segmentFormat is the name of the segment file, which is the start of its first rollup
+const segmentFormat = "20060102T150405Z.jsonl"
+
+// Tier keeps rollups of the resolution for the retention period
+type Tier struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+var tierRe = regexp.MustCompile(`^(\w+):(\w+)$`)
+
+// ParseTiers creates tiers from "resolution:retention" expressions like "10s:24h", from the finest to the coarsest one
+func ParseTiers(exprs []string) ([]Tier, error) {
+	tiers := make([]Tier, 0, len(exprs))
+	for i, expr := range exprs {
+		m := tierRe.FindStringSubmatch(expr)
+		if m == nil {
+			return nil, fmt.Errorf("rollup tier %q should be in resolution:retention format, like 10s:24h", expr)
+		}
+		resolution, err := parseDuration(m[1])
+		if err != nil || resolution < time.Second || resolution%time.Second != 0 {
+			return nil, fmt.Errorf("rollup tier %q should have resolution of whole seconds", expr)
+		}
+		retention, err := parseDuration(m[2])
+		if err != nil || retention < resolution {
+			return nil, fmt.Errorf("rollup tier %q should have retention not shorter than its resolution", expr)
+		}
+		if i > 0 {
+			prev := tiers[i-1]
+			if resolution <= prev.Resolution || resolution%prev.Resolution != 0 {
+				return nil, fmt.Errorf("rollup tier %q should have resolution which is a multiple of the previous one", expr)
+			}
+			// rollup of the tier which is not complete yet is restored from the previous tier after restart
+			if prev.Retention < resolution {
+				return nil, fmt.Errorf("rollup tier %q should have resolution not longer than retention of the previous one", expr)
+			}
+		}
+		tiers = append(tiers, Tier{Resolution: resolution, Retention: retention})
+	}
+	if len(tiers) == 0 {
+		return nil, fmt.Errorf("at least one rollup tier should be set")
+	}
+	return tiers, nil
+}
+
+// parseDuration parses the duration which could have days, like "1d" or "90d"
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// String returns the tier like "1m:7d"
+func (t Tier) String() string {
+	return formatDuration(t.Resolution) + ":" + formatDuration(t.Retention)
+}
+
+// formatDuration returns the duration in the largest whole unit, like "10s", "1m" or "90d"
+func formatDuration(d time.Duration) string {
+	for _, unit := range []struct {
+		d    time.Duration
+		name string
+	}{{24 * time.Hour, "d"}, {time.Hour, "h"}, {time.Minute, "m"}} {
+		if d%unit.d == 0 {
+			return strconv.FormatInt(int64(d/unit.d), 10) + unit.name
+		}
+	}
+	return d.String()
+}
+
+// Store keeps rollups of every tier in its own directory, in append-only segment files of JSON lines.
+// Rollups written to the first tier are merged into the rollup of the next tier, which is written once
+// the rollup of the next interval arrives, and so on. Start of the newest rollup written to every tier is
+// its high-water mark, which is read back from its last segment on open, and rollups at or before it are
+// skipped, so that processing the same log again after restart doesn't count its records twice.
+// The store is not thread-safe.
+type Store struct {
+	tiers    []*tier
+	readOnly bool
+}
+
+type tier struct {
+	Tier
+	dir     string
+	pending *Rollup   // rollups of the previous tier which are not written to this one yet
+	newest  time.Time // start of the newest written rollup, rollups at or before it are not written again
+	file    *os.File  // segment being written
+	segment time.Time // start of that segment
+}
+
+// Open opens the store in the directory, creating it if needed, and restores rollups which are not complete yet
+func Open(dir string, tiers []Tier) (*Store, error) {
+	return open(dir, tiers, false)
+}
+
+// OpenReadOnly opens the store to read it while another program could be writing it
+func OpenReadOnly(dir string, tiers []Tier) (*Store, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	return open(dir, tiers, true)
+}
+
+func open(dir string, tiers []Tier, readOnly bool) (*Store, error) {
+	s := &Store{readOnly: readOnly}
+	for i, t := range tiers {
+		st := &tier{Tier: t, dir: filepath.Join(dir, formatDuration(t.Resolution))}
+		if !readOnly {
+			if err := os.MkdirAll(st.dir, 0o700); err != nil {
+				return nil, err
+			}
+		}
+		segments, err := st.segments()
+		if err != nil {
+			return nil, err
+		}
+		if len(segments) > 0 {
+			last := segments[len(segments)-1]
+			if !readOnly {
+				if err := st.repair(last); err != nil {
+					return nil, err
+				}
+			}
+			rollups, err := st.readSegment(last)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range rollups {
+				if r.Start.After(st.newest) {
+					st.newest = r.Start
+				}
+			}
+		}
+		s.tiers = append(s.tiers, st)
+		if i == 0 {
+			continue
+		}
+		if err := s.restore(st, s.tiers[i-1]); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// repair removes the incomplete line the segment could be left with if the program crashed while writing it,
+// so that the next rollups are not appended to it
+func (t *tier) repair(segment time.Time) error {
+	name := filepath.Join(t.dir, segment.UTC().Format(segmentFormat))
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return nil
+	}
+	return os.Truncate(name, int64(bytes.LastIndexByte(data, '\n')+1))
+}
+
+// restore the pending rollup of the tier from rollups of the previous one which it doesn't have yet
+func (s *Store) restore(t, prev *tier) error {
+	if prev.newest.IsZero() {
+		return nil
+	}
+	start := prev.newest.Truncate(t.Resolution)
+	if !start.After(t.newest) {
+		return nil
+	}
+	rollups, err := prev.read(start, start.Add(t.Resolution))
+	if err != nil {
+		return err
+	}
+	if len(rollups) == 0 {
+		return nil
+	}
+	t.pending = New(start)
+	for _, r := range rollups {
+		t.pending.Merge(r)
+	}
+	return nil
+}
+
+// Tiers returns tiers of the store, rollups of the resolution of the first one should be written to it
+func (s *Store) Tiers() []Tier {
+	tiers := make([]Tier, len(s.tiers))
+	for i, t := range s.tiers {
+		tiers[i] = t.Tier
+	}
+	return tiers
+}
+
+// Resolution returns the resolution of the first tier
+func (s *Store) Resolution() time.Duration {
+	return s.tiers[0].Resolution
+}
+
+// Write the rollup of the first tier, which start should be aligned to its resolution. Rollup which
+// is not newer than the last written one is skipped.
+func (s *Store) Write(r *Rollup) error {
+	if s.readOnly {
+		return errors.New("rollups are opened read-only")
+	}
+	return s.write(0, r)
+}
+
+func (s *Store) write(i int, r *Rollup) error {
+	t := s.tiers[i]
+	if !t.newest.IsZero() && !r.Start.After(t.newest) {
+		// the interval is written already, like when the same log is processed again after restart
+		return nil
+	}
+	if i+1 < len(s.tiers) {
+		// rollup of the next tier is written before this one, so that it's never left behind after restart
+		next := s.tiers[i+1]
+		start := r.Start.Truncate(next.Resolution)
+		if next.pending != nil && !next.pending.Start.Equal(start) {
+			if err := s.write(i+1, next.pending); err != nil {
+				return err
+			}
+			next.pending = nil
+		}
+		if next.pending == nil {
+			next.pending = New(start)
+		}
+		next.pending.Merge(r)
+	}
+	return t.append(r)
+}
+
+// append the rollup to its segment, removing segments beyond the retention when the new one is started
+func (t *tier) append(r *Rollup) error {
+	segment := r.Start.Truncate(t.Resolution * segmentRollups)
+	if t.file == nil || !segment.Equal(t.segment) {
+		if t.file != nil {
+			if err := t.file.Close(); err != nil {
+				return err
+			}
+			t.file = nil
+		}
+		f, err := os.OpenFile(filepath.Join(t.dir, segment.UTC().Format(segmentFormat)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		t.file, t.segment = f, segment
+		if r.Start.After(t.newest) {
+			t.newest = r.Start
+		}
+		if err := t.expire(); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err = t.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if r.Start.After(t.newest) {
+		t.newest = r.Start
+	}
+	return nil
+}
+
+// expire removes segments which end before the retention
+func (t *tier) expire() error {
+	segments, err := t.segments()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment.Add(t.Resolution * segmentRollups).After(t.newest.Add(-t.Retention)) {
+			break
+		}
+		if err := os.Remove(filepath.Join(t.dir, segment.UTC().Format(segmentFormat))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// segments returns starts of the segment files of the tier in ascending order
+func (t *tier) segments() ([]time.Time, error) {
+	files, err := ioutil.ReadDir(t.dir)
+	if os.IsNotExist(err) {
+		// the tier which was never written in the read-only store
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var segments []time.Time
+	for _, f := range files {
+		if start, err := time.Parse(segmentFormat, f.Name()); err == nil {
+			segments = append(segments, start)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Before(segments[j]) })
+	return segments, nil
+}
+
+// Read returns rollups of the tier with the resolution which start between from and to, in ascending order.
+// The last ones include rollups of the finer tiers which are not written to the tier yet.
+func (s *Store) Read(resolution time.Duration, from, to time.Time) ([]*Rollup, error) {
+	for i, t := range s.tiers {
+		if t.Resolution != resolution {
+			continue
+		}
+		rollups, err := t.read(from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, finer := range s.tiers[:i+1] {
+			if p := finer.pending; p != nil {
+				pending := New(p.Start.Truncate(resolution))
+				pending.Merge(p)
+				if !pending.Start.Before(from) && pending.Start.Before(to) {
+					rollups = append(rollups, pending)
+				}
+			}
+		}
+		return mergeRollups(rollups), nil
+	}
+	return nil, fmt.Errorf("no rollup tier with %s resolution", formatDuration(resolution))
+}
+
+// read returns written rollups which start between from and to, in ascending order
+func (t *tier) read(from, to time.Time) ([]*Rollup, error) {
+	segments, err := t.segments()
+	if err != nil {
+		return nil, err
+	}
+	var rollups []*Rollup
+	for _, segment := range segments {
+		if !segment.Before(to) || !segment.Add(t.Resolution*segmentRollups).After(from) {
+			continue
+		}
+		list, err := t.readSegment(segment)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range list {
+			if !r.Start.Before(from) && r.Start.Before(to) {
+				rollups = append(rollups, r)
+			}
+		}
+	}
+	return mergeRollups(rollups), nil
+}
+
+// readSegment returns all rollups of the segment. The last line which is not complete is skipped,
+// as the segment could be written at the same time.
+func (t *tier) readSegment(segment time.Time) ([]*Rollup, error) {
+	name := filepath.Join(t.dir, segment.UTC().Format(segmentFormat))
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rollups []*Rollup
+	reader := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return rollups, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+		r := &Rollup{}
+		if err := json.Unmarshal(data, r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		rollups = append(rollups, r)
+	}
+}
+
+// mergeRollups merges rollups with the same start, returning them in ascending order
+func mergeRollups(rollups []*Rollup) []*Rollup {
+	sort.SliceStable(rollups, func(i, j int) bool { return rollups[i].Start.Before(rollups[j].Start) })
+	var merged []*Rollup
+	for _, r := range rollups {
+		if n := len(merged); n > 0 && merged[n-1].Start.Equal(r.Start) {
+			merged[n-1].Merge(r)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// Close closes the segment being written. Rollups which are not complete yet are not written,
+// and they are restored from the previous tiers when the store is opened again.
+func (s *Store) Close() error {
+	for _, t := range s.tiers {
+		if t.file == nil {
+			continue
+		}
+		if err := t.file.Close(); err != nil {
+			return err
+		}
+		t.file = nil
+	}
+	return nil
+}
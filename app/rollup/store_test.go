@@ -0,0 +1,159 @@
+package rollup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTiers(t *testing.T) {
+	tiers, err := ParseTiers([]string{"10s:1d", "1m:7d", "1h:90d", "1d:730d"})
+	require.NoError(t, err)
+	assert.Equal(t, []Tier{
+		{Resolution: 10 * time.Second, Retention: 24 * time.Hour},
+		{Resolution: time.Minute, Retention: 7 * 24 * time.Hour},
+		{Resolution: time.Hour, Retention: 90 * 24 * time.Hour},
+		{Resolution: 24 * time.Hour, Retention: 730 * 24 * time.Hour},
+	}, tiers)
+	assert.Equal(t, "1m:7d", tiers[1].String())
+
+	for expr, msg := range map[string]string{
+		"10s":        `rollup tier "10s" should be in resolution:retention format, like 10s:24h`,
+		"500ms:1h":   `rollup tier "500ms:1h" should have resolution of whole seconds`,
+		"1m:30s":     `rollup tier "1m:30s" should have retention not shorter than its resolution`,
+		"15s:1d":     `rollup tier "15s:1d" should have resolution which is a multiple of the previous one`,
+		"1d:730d":    `rollup tier "1d:730d" should have resolution not longer than retention of the previous one`,
+		"1m:xd":      `rollup tier "1m:xd" should have retention not shorter than its resolution`,
+		"1m:1h1x":    `rollup tier "1m:1h1x" should have retention not shorter than its resolution`,
+		"10s:1h:1d2": `rollup tier "10s:1h:1d2" should be in resolution:retention format, like 10s:24h`,
+	} {
+		_, err = ParseTiers([]string{"10s:1h", expr})
+		assert.EqualError(t, err, msg)
+	}
+	_, err = ParseTiers(nil)
+	assert.EqualError(t, err, "at least one rollup tier should be set")
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	tiers, err := ParseTiers([]string{"10s:1h", "1m:1d", "1h:7d"})
+	require.NoError(t, err)
+	store, err := Open(dir, tiers)
+	require.NoError(t, err)
+	assert.Equal(t, tiers, store.Tiers())
+	assert.Equal(t, 10*time.Second, store.Resolution())
+
+	// a hit every 10 seconds for three minutes, by one of three users
+	start := time.Date(2019, 2, 7, 21, 10, 0, 0, time.UTC)
+	for i := 0; i < 18; i++ {
+		r := New(start.Add(time.Duration(i) * 10 * time.Second))
+		r.Add(Group{Section: "/api", Status: 200 + i%2*300}, 100, fmt.Sprintf("10.0.0.%d", i%3))
+		require.NoError(t, store.Write(r))
+	}
+	check := func(resolution time.Duration, expected []string) {
+		rollups, err := store.Read(resolution, start.Add(-time.Hour), start.Add(time.Hour))
+		require.NoError(t, err)
+		var actual []string
+		for _, r := range rollups {
+			sections, statuses := map[string]int{}, map[int]int{}
+			for g, c := range r.Groups {
+				sections[g.Section] += c.Hits
+				statuses[g.Status] += c.Hits
+			}
+			actual = append(actual, fmt.Sprintf("%s: %d hits, %d bytes, %d users, %v, %v",
+				r.Start.Format("15:04:05"), r.Hits, r.Bytes, r.UniqueUsers(), sections, statuses))
+		}
+		assert.Equal(t, expected, actual, "%s resolution", resolution)
+	}
+	minutes := []string{
+		"21:10:00: 6 hits, 600 bytes, 3 users, map[/api:6], map[200:3 500:3]",
+		"21:11:00: 6 hits, 600 bytes, 3 users, map[/api:6], map[200:3 500:3]",
+		"21:12:00: 6 hits, 600 bytes, 3 users, map[/api:6], map[200:3 500:3]",
+	}
+	hours := []string{"21:00:00: 18 hits, 1800 bytes, 3 users, map[/api:18], map[200:9 500:9]"}
+	check(time.Minute, minutes)
+	check(time.Hour, hours)
+	rollups, err := store.Read(10*time.Second, start, start.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, rollups, 6)
+	_, err = store.Read(time.Second, start, start.Add(time.Minute))
+	assert.EqualError(t, err, "no rollup tier with 1s resolution")
+
+	// rollups which are not complete yet are restored after restart, and writing the same rollups again
+	// doesn't change the counts, while the newer ones are still added
+	require.NoError(t, store.Close())
+	store, err = Open(dir, tiers)
+	require.NoError(t, err)
+	check(time.Minute, minutes)
+	check(time.Hour, hours)
+	for i := 0; i < 18; i++ {
+		r := New(start.Add(time.Duration(i) * 10 * time.Second))
+		r.Add(Group{Section: "/api", Status: 200 + i%2*300}, 100, fmt.Sprintf("10.0.0.%d", i%3))
+		require.NoError(t, store.Write(r))
+	}
+	check(time.Minute, minutes)
+	check(time.Hour, hours)
+	r := New(start.Add(180 * time.Second))
+	r.Add(Group{Section: "/report", Status: 404}, 10, "10.0.0.3")
+	require.NoError(t, store.Write(r))
+	check(time.Minute, append(minutes[:3:3], "21:13:00: 1 hits, 10 bytes, 1 users, map[/report:1], map[404:1]"))
+	check(time.Hour, []string{"21:00:00: 19 hits, 1810 bytes, 4 users, map[/api:18 /report:1], map[200:9 404:1 500:9]"})
+	require.NoError(t, store.Close())
+}
+
+func TestStoreRetention(t *testing.T) {
+	dir := t.TempDir()
+	tiers, err := ParseTiers([]string{"10s:1h", "1m:1d"})
+	require.NoError(t, err)
+	store, err := Open(dir, tiers)
+	require.NoError(t, err)
+
+	start := time.Date(2019, 2, 7, 21, 0, 0, 0, time.UTC)
+	for _, offset := range []time.Duration{0, 150 * time.Minute, 0} {
+		r := New(start.Add(offset))
+		r.Add(Group{Section: "/api", Status: 200}, 100, "10.0.0.1")
+		require.NoError(t, store.Write(r))
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, "10s"))
+	require.NoError(t, err)
+	require.Len(t, files, 1, "segment of the first hour is removed")
+	assert.Equal(t, "20190207T230000Z.jsonl", files[0].Name())
+
+	// the last rollup is older than the newest written one, so it's dropped
+	rollups, err := store.Read(10*time.Second, start, start.Add(24*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, rollups, 1)
+	assert.Equal(t, start.Add(150*time.Minute), rollups[0].Start)
+	rollups, err = store.Read(time.Minute, start, start.Add(24*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, rollups, 2)
+	assert.Equal(t, 1, rollups[0].Hits)
+
+	// incomplete line which is being written is skipped
+	f, err := os.OpenFile(filepath.Join(dir, "10s", files[0].Name()), os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"start":"2019-02-07T23:30:10Z","hi`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	rollups, err = store.Read(10*time.Second, start, start.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, rollups, 1)
+
+	// and it's removed after restart, as the program crashed while writing it
+	require.NoError(t, store.Close())
+	store, err = Open(dir, tiers)
+	require.NoError(t, err)
+	r := New(start.Add(150*time.Minute + 10*time.Second))
+	r.Add(Group{Section: "/api", Status: 200}, 100, "10.0.0.1")
+	require.NoError(t, store.Write(r))
+	rollups, err = store.Read(10*time.Second, start, start.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, rollups, 2)
+	require.NoError(t, store.Close())
+}
@@ -0,0 +1,62 @@
+package silence
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	s := NewStore(time.UTC)
+	ts := httptest.NewServer(Handler(s))
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/silences", "application/json",
+		strings.NewReader(`{"matchers": {"alert": "hits"}, "cron": "0 2 * * *", "duration": "30m"}`))
+	require.NoError(t, err)
+	var created Silence
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.NotEmpty(t, created.ID)
+	assert.Equal(t, Duration(30*time.Minute), created.Duration)
+
+	resp, err = http.Post(ts.URL+"/silences", "application/json", strings.NewReader(`{"matchers": {"alert": "hits"}}`))
+	require.NoError(t, err)
+	var failed map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&failed))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, failed["error"], "should have either end time, or cron schedule and duration")
+
+	resp, err = http.Get(ts.URL + "/silences")
+	require.NoError(t, err)
+	var list []Silence
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	resp.Body.Close()
+	require.Len(t, list, 1)
+	assert.Equal(t, created.ID, list[0].ID)
+
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+"/silences/"+created.ID, nil)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Empty(t, s.List())
+
+	resp, err = http.Get(ts.URL + "/silences/" + created.ID)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
@@ -0,0 +1,150 @@
+package silence
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is the standard five-field cron schedule: minute, hour, day of month, month and day of week,
+// each of them "*", a number, a range like "1-5", a list like "1,15" or any of them with a step like "*/15".
+// Day of week is 0 to 7, both 0 and 7 being Sunday. As in cron, if both day of month and day of week are
+// restricted, the schedule matches the day which satisfies either of them.
+type Schedule struct {
+	minutes, hours, days, months, weekdays uint64 // bit n is set if the value n matches
+	anyDay, anyWeekday                     bool   // day of month or day of week field matches all of its values
+}
+
+const (
+	allDays     = 1<<32 - 2 // bits of days of month 1 to 31
+	allWeekdays = 1<<7 - 1  // bits of days of week 0 to 6
+)
+
+// cronFields are the bounds of the schedule fields
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// ParseSchedule parses the five-field cron expression like "30 2 * * 1-5"
+func ParseSchedule(expr string) (*Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron %q should have %d fields: minute, hour, day of month, month and day of week", expr, len(cronFields))
+	}
+	values := make([]uint64, len(fields))
+	for i, field := range fields {
+		b, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("cron %q has bad %s: %w", expr, cronFields[i].name, err)
+		}
+		values[i] = b
+	}
+	// Sunday is both 0 and 7
+	if values[4]&(1<<7) != 0 {
+		values[4] |= 1
+	}
+	// day field isn't a restriction if it matches every day, however it's written, like "*", "1-31" or "*/1"
+	return &Schedule{minutes: values[0], hours: values[1], days: values[2], months: values[3], weekdays: values[4],
+		anyDay: values[2]&allDays == allDays, anyWeekday: values[4]&allWeekdays == allWeekdays}, nil
+}
+
+// parseCronField returns bits of values matching the comma-separated list of ranges
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			step, part = s, part[:i]
+		}
+		from, to := min, max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("bad value %q", part)
+				}
+			}
+			if from < min || to > max || from > to {
+				return 0, fmt.Errorf("%q is out of %d-%d range", part, min, max)
+			}
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Matches returns true if the schedule fires at the minute of t
+func (s *Schedule) Matches(t time.Time) bool {
+	return s.minutes&(1<<uint(t.Minute())) != 0 && s.hours&(1<<uint(t.Hour())) != 0 && s.matchesDay(t)
+}
+
+// matchesDay returns true if the schedule fires at any minute of the day of t
+func (s *Schedule) matchesDay(t time.Time) bool {
+	if s.months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	day, weekday := s.days&(1<<uint(t.Day())) != 0, s.weekdays&(1<<uint(t.Weekday())) != 0
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// Active returns true if t is within the duration after any time the schedule fires at, in the provided location.
+// The last time it fired at is found walking back by days, and then by the hours and minutes it fires at,
+// so only the days the duration spans are checked.
+func (s *Schedule) Active(t time.Time, d time.Duration, loc *time.Location) bool {
+	t = t.In(loc)
+	from := t.Add(-d)
+	lastHour, lastMinute := t.Hour(), t.Minute()
+	for day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc); day.AddDate(0, 0, 1).After(from); day = day.AddDate(0, 0, -1) {
+		if s.matchesDay(day) {
+			for h := lastBit(s.hours, lastHour); h >= 0; h = lastBit(s.hours, h-1) {
+				maxMinute := 59
+				if h == lastHour {
+					maxMinute = lastMinute
+				}
+				m := lastBit(s.minutes, maxMinute)
+				if m < 0 {
+					continue
+				}
+				// the time skipped by the daylight saving change is normalized, possibly to the one after t
+				fired := time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, loc)
+				if fired.After(t) {
+					continue
+				}
+				return fired.After(from)
+			}
+		}
+		lastHour, lastMinute = 23, 59
+	}
+	return false
+}
+
+// lastBit returns the highest bit set in the values which is not higher than max, -1 if there is none
+func lastBit(values uint64, max int) int {
+	if max < 0 {
+		return -1
+	}
+	return bits.Len64(values&(1<<uint(max+1)-1)) - 1
+}
@@ -0,0 +1,101 @@
+package silence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchedule(t *testing.T) {
+	s, err := ParseSchedule("*/15 2,14 * * 1-5")
+	require.NoError(t, err)
+	// Thursday
+	assert.True(t, s.Matches(time.Date(2019, 2, 7, 2, 30, 0, 0, time.UTC)))
+	assert.True(t, s.Matches(time.Date(2019, 2, 7, 14, 45, 59, 0, time.UTC)))
+	assert.False(t, s.Matches(time.Date(2019, 2, 7, 2, 31, 0, 0, time.UTC)))
+	assert.False(t, s.Matches(time.Date(2019, 2, 7, 3, 30, 0, 0, time.UTC)))
+	// Saturday
+	assert.False(t, s.Matches(time.Date(2019, 2, 9, 2, 30, 0, 0, time.UTC)))
+
+	// Sunday is both 0 and 7
+	s, err = ParseSchedule("0 0 * * 7")
+	require.NoError(t, err)
+	assert.True(t, s.Matches(time.Date(2019, 2, 10, 0, 0, 0, 0, time.UTC)))
+
+	// restricted day of month and day of week match either of them
+	s, err = ParseSchedule("0 0 1 * 1")
+	require.NoError(t, err)
+	assert.True(t, s.Matches(time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)))  // Friday, 1st
+	assert.True(t, s.Matches(time.Date(2019, 2, 4, 0, 0, 0, 0, time.UTC)))  // Monday
+	assert.False(t, s.Matches(time.Date(2019, 2, 5, 0, 0, 0, 0, time.UTC))) // Tuesday
+
+	// day field matching every day is not a restriction, however it's written
+	s, err = ParseSchedule("0 0 1-31 * 1")
+	require.NoError(t, err)
+	assert.True(t, s.Matches(time.Date(2019, 2, 4, 0, 0, 0, 0, time.UTC)))  // Monday
+	assert.False(t, s.Matches(time.Date(2019, 2, 5, 0, 0, 0, 0, time.UTC))) // Tuesday
+	s, err = ParseSchedule("0 0 */2 * 0-7")
+	require.NoError(t, err)
+	assert.True(t, s.Matches(time.Date(2019, 2, 5, 0, 0, 0, 0, time.UTC)))  // 5th
+	assert.False(t, s.Matches(time.Date(2019, 2, 4, 0, 0, 0, 0, time.UTC))) // Monday, 4th
+	// while the step over a part of the days is
+	s, err = ParseSchedule("0 0 1 * */2")
+	require.NoError(t, err)
+	assert.True(t, s.Matches(time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)))  // Friday, 1st
+	assert.True(t, s.Matches(time.Date(2019, 2, 5, 0, 0, 0, 0, time.UTC)))  // Tuesday
+	assert.False(t, s.Matches(time.Date(2019, 2, 4, 0, 0, 0, 0, time.UTC))) // Monday
+
+	_, err = ParseSchedule("0 2 * *")
+	assert.EqualError(t, err, `cron "0 2 * *" should have 5 fields: minute, hour, day of month, month and day of week`)
+	_, err = ParseSchedule("0 24 * * *")
+	assert.EqualError(t, err, `cron "0 24 * * *" has bad hour: "24" is out of 0-23 range`)
+	_, err = ParseSchedule("*/0 * * * *")
+	assert.EqualError(t, err, `cron "*/0 * * * *" has bad minute: bad step in "*/0"`)
+	_, err = ParseSchedule("0 * * jan *")
+	assert.EqualError(t, err, `cron "0 * * jan *" has bad month: bad value "jan"`)
+}
+
+func TestScheduleActive(t *testing.T) {
+	s, err := ParseSchedule("30 23 * * *")
+	require.NoError(t, err)
+	start := time.Date(2019, 2, 7, 23, 30, 0, 0, time.UTC)
+	assert.False(t, s.Active(start.Add(-time.Second), time.Hour, time.UTC))
+	assert.True(t, s.Active(start, time.Hour, time.UTC))
+	assert.True(t, s.Active(start.Add(59*time.Minute+59*time.Second), time.Hour, time.UTC))
+	assert.False(t, s.Active(start.Add(time.Hour), time.Hour, time.UTC))
+
+	// schedule is evaluated in the provided location
+	tz := time.FixedZone("UTC+1", 3600)
+	assert.False(t, s.Active(start, time.Hour, tz))
+	assert.True(t, s.Active(start.Add(-time.Hour), time.Hour, tz))
+
+	// weekly window spans the days before the one it's checked on
+	s, err = ParseSchedule("0 22 * * 5")
+	require.NoError(t, err)
+	friday := time.Date(2019, 2, 8, 22, 0, 0, 0, time.UTC)
+	assert.True(t, s.Active(friday.Add(7*24*time.Hour-time.Second), 7*24*time.Hour, time.UTC))
+	assert.True(t, s.Active(friday.Add(7*24*time.Hour), 7*24*time.Hour, time.UTC), "fires again")
+	assert.False(t, s.Active(friday.Add(6*24*time.Hour), 2*24*time.Hour, time.UTC))
+}
+
+func TestScheduleActiveMatches(t *testing.T) {
+	// time is active if the schedule matched any minute within the duration before it
+	for _, expr := range []string{"*/7 * * * *", "15 3,9 * * *", "0 0 1,15 * *", "0 0 1 * 1", "30 12 29 2 *", "59 23 * * 0"} {
+		s, err := ParseSchedule(expr)
+		require.NoError(t, err)
+		for _, d := range []time.Duration{time.Minute, 90 * time.Minute, 36 * time.Hour, 7 * 24 * time.Hour} {
+			for at := time.Date(2020, 2, 27, 0, 0, 30, 0, time.UTC); at.Before(time.Date(2020, 3, 3, 0, 0, 0, 0, time.UTC)); at = at.Add(53 * time.Minute) {
+				var matched bool
+				for m := at.Truncate(time.Minute); at.Sub(m) < d; m = m.Add(-time.Minute) {
+					if s.Matches(m) {
+						matched = true
+						break
+					}
+				}
+				assert.Equal(t, matched, s.Active(at, d, time.UTC), "%s at %s for %s", expr, at, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Package silence mutes alerts matching the labels for a period of time or during recurring maintenance windows,
+// silences are loaded from a file and could be managed at runtime through the HTTP API
+package silence
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxCronDuration limits the length of a recurring maintenance window
+const maxCronDuration = 7 * 24 * time.Hour
+
+// Silence mutes alerts which have all labels of the matchers, either between start and end,
+// or for the duration after every time the cron schedule fires at while within them
+type Silence struct {
+	ID       string            `json:"id"`
+	Matchers map[string]string `json:"matchers"`           // label values, like {"alert": "hits", "section": "/api"}
+	Start    *time.Time        `json:"start,omitempty"`    // silence is active since ever if not set
+	End      *time.Time        `json:"end,omitempty"`      // silence is active forever if not set, only allowed with cron
+	Cron     string            `json:"cron,omitempty"`     // recurring maintenance window schedule, like "0 2 * * 1-5"
+	Duration Duration          `json:"duration,omitempty"` // length of the recurring maintenance window
+	Comment  string            `json:"comment,omitempty"`
+
+	schedule *Schedule
+}
+
+// Duration is time.Duration which is written to JSON as a string like "30m"
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads the duration from a string like "30m"
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration should be a string like \"30m\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// validate checks the silence and parses its schedule
+func (s *Silence) validate() error {
+	if len(s.Matchers) == 0 {
+		return fmt.Errorf("silence %s should have at least one matcher", s.ID)
+	}
+	if s.Cron == "" {
+		if s.End == nil || s.Duration != 0 {
+			return fmt.Errorf("silence %s should have either end time, or cron schedule and duration", s.ID)
+		}
+		if s.Start != nil && !s.End.After(*s.Start) {
+			return fmt.Errorf("silence %s should end after it starts", s.ID)
+		}
+		return nil
+	}
+	if s.Duration <= 0 || time.Duration(s.Duration) > maxCronDuration {
+		return fmt.Errorf("silence %s should have duration between 0 and %s with cron schedule", s.ID, maxCronDuration)
+	}
+	schedule, err := ParseSchedule(s.Cron)
+	if err != nil {
+		return fmt.Errorf("silence %s: %w", s.ID, err)
+	}
+	s.schedule = schedule
+	return nil
+}
+
+// Active returns true if the silence mutes the alert with the provided labels at time t,
+// cron schedule is evaluated in the provided location
+func (s *Silence) Active(labels map[string]string, t time.Time, loc *time.Location) bool {
+	for name, value := range s.Matchers {
+		if labels[name] != value {
+			return false
+		}
+	}
+	if (s.Start != nil && t.Before(*s.Start)) || (s.End != nil && !t.Before(*s.End)) {
+		return false
+	}
+	return s.schedule == nil || s.schedule.Active(t, time.Duration(s.Duration), loc)
+}
+
+// Store is the thread-safe set of silences, saved to the file on every change if it's loaded from one
+type Store struct {
+	loc      *time.Location
+	path     string
+	mu       sync.RWMutex
+	silences map[string]*Silence
+}
+
+// NewStore creates empty store evaluating cron schedules in the provided location
+func NewStore(loc *time.Location) *Store {
+	return &Store{loc: loc, silences: map[string]*Silence{}}
+}
+
+// Load silences from the JSON file with the list of them, the file is created on the first change if it doesn't exist
+func Load(path string, loc *time.Location) (*Store, error) {
+	s := NewStore(loc)
+	s.path = path
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var silences []*Silence
+	if err = json.Unmarshal(data, &silences); err != nil {
+		return nil, fmt.Errorf("can't decode silences: %w", err)
+	}
+	for _, silence := range silences {
+		if silence.ID == "" {
+			silence.ID = newID()
+		}
+		if _, ok := s.silences[silence.ID]; ok {
+			return nil, fmt.Errorf("duplicate silence %s", silence.ID)
+		}
+		if err = silence.validate(); err != nil {
+			return nil, err
+		}
+		s.silences[silence.ID] = silence
+	}
+	return s, nil
+}
+
+// Add the silence, generating its ID if not set, and replacing the one with the same ID
+func (s *Store) Add(silence Silence) (Silence, error) {
+	if silence.ID == "" {
+		silence.ID = newID()
+	}
+	if err := silence.validate(); err != nil {
+		return Silence{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.silences[silence.ID] = &silence
+	return silence, s.save()
+}
+
+// Delete the silence, returns false if there was none with the provided ID
+func (s *Store) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.silences[id]; !ok {
+		return false, nil
+	}
+	delete(s.silences, id)
+	return true, s.save()
+}
+
+// List returns all silences ordered by ID
+func (s *Store) List() []Silence {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]Silence, 0, len(s.silences))
+	for _, silence := range s.silences {
+		result = append(result, *silence)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// Silenced returns true if any of the silences mutes the alert with the provided labels at time t
+func (s *Store) Silenced(labels map[string]string, t time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, silence := range s.silences {
+		if silence.Active(labels, t, s.loc) {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes silences to the temporary file first, so the saved ones are never left half-written;
+// should be called with the lock held
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	silences := make([]*Silence, 0, len(s.silences))
+	for _, silence := range s.silences {
+		silences = append(silences, silence)
+	}
+	sort.Slice(silences, func(i, j int) bool { return silences[i].ID < silences[j].ID })
+	data, err := json.MarshalIndent(silences, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// newID returns random silence ID
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,76 @@
+package silence
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "silence")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "silences.json")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`[
+		{"id": "deploy", "matchers": {"alert": "hits"}, "start": "2019-02-07T21:00:00Z", "end": "2019-02-07T22:00:00Z"},
+		{"id": "nightly", "matchers": {"section": "/api"}, "cron": "0 2 * * *", "duration": "30m", "comment": "backups"}
+	]`), 0o600))
+	s, err := Load(path, time.UTC)
+	require.NoError(t, err)
+	require.Len(t, s.List(), 2)
+
+	deploy := time.Date(2019, 2, 7, 21, 30, 0, 0, time.UTC)
+	assert.True(t, s.Silenced(map[string]string{"alert": "hits"}, deploy))
+	assert.False(t, s.Silenced(map[string]string{"alert": "hits"}, deploy.Add(time.Hour)))
+	assert.False(t, s.Silenced(map[string]string{"alert": "latency"}, deploy))
+
+	nightly := time.Date(2019, 2, 8, 2, 10, 0, 0, time.UTC)
+	assert.True(t, s.Silenced(map[string]string{"alert": "latency", "section": "/api"}, nightly))
+	assert.False(t, s.Silenced(map[string]string{"alert": "latency", "section": "/report"}, nightly))
+	assert.False(t, s.Silenced(map[string]string{"alert": "latency", "section": "/api"}, nightly.Add(time.Hour)))
+
+	// changes are saved to the file
+	end := deploy.Add(time.Hour)
+	added, err := s.Add(Silence{Matchers: map[string]string{"alert": "slo"}, End: &end})
+	require.NoError(t, err)
+	assert.NotEmpty(t, added.ID)
+	found, err := s.Delete("deploy")
+	require.NoError(t, err)
+	assert.True(t, found)
+	found, err = s.Delete("deploy")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	loaded, err := Load(path, time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, len(s.List()), len(loaded.List()))
+	assert.True(t, loaded.Silenced(map[string]string{"alert": "slo"}, deploy))
+	assert.False(t, loaded.Silenced(map[string]string{"alert": "hits"}, deploy))
+
+	// missing file is created on the first change
+	s, err = Load(filepath.Join(dir, "new.json"), time.UTC)
+	require.NoError(t, err)
+	assert.Empty(t, s.List())
+}
+
+func TestSilenceValidate(t *testing.T) {
+	s := NewStore(time.UTC)
+	start := time.Date(2019, 2, 7, 21, 0, 0, 0, time.UTC)
+	_, err := s.Add(Silence{ID: "x", End: &start})
+	assert.EqualError(t, err, "silence x should have at least one matcher")
+	_, err = s.Add(Silence{ID: "x", Matchers: map[string]string{"alert": "hits"}})
+	assert.EqualError(t, err, "silence x should have either end time, or cron schedule and duration")
+	_, err = s.Add(Silence{ID: "x", Matchers: map[string]string{"alert": "hits"}, Start: &start, End: &start})
+	assert.EqualError(t, err, "silence x should end after it starts")
+	_, err = s.Add(Silence{ID: "x", Matchers: map[string]string{"alert": "hits"}, Cron: "0 2 * * *"})
+	assert.EqualError(t, err, "silence x should have duration between 0 and 168h0m0s with cron schedule")
+	_, err = s.Add(Silence{ID: "x", Matchers: map[string]string{"alert": "hits"}, Cron: "0 2 * *", Duration: Duration(time.Hour)})
+	assert.EqualError(t, err, `silence x: cron "0 2 * *" should have 5 fields: minute, hour, day of month, month and day of week`)
+	assert.Empty(t, s.List())
+}
@@ -0,0 +1,174 @@
+package sketch
+
+import (
+	"fmt"
+	"math"
+)
+
+// DefaultAccuracy of DDSketch, quantiles are estimated within 1% of their true value
+const DefaultAccuracy = 0.01
+
+// DDSketch estimates quantiles of non-negative values with relative accuracy: the estimate
+// is within accuracy*value of the true quantile value. Values are counted in logarithmically
+// sized bins, so sketches with the same accuracy could be merged without losing precision.
+// Once there are more than maxBins bins, the lowest ones are collapsed together, which keeps
+// the memory bounded while preserving the accuracy of the high quantiles.
+type DDSketch struct {
+	gamma    float64
+	logGamma float64
+	maxBins  int
+	bins     []uint64 // counts of values in bins with keys from offset to offset+len(bins)-1
+	offset   int
+	zeros    uint64 // values too small to be put into bins
+	count    uint64
+	min, max float64
+}
+
+// minIndexable is the smallest value put into bins, the ones below it are counted as zeros
+const minIndexable = 1e-9
+
+// CheckAccuracy returns error if DDSketch can't be created with provided accuracy
+func CheckAccuracy(accuracy float64) error {
+	if accuracy <= 0 || accuracy >= 1 {
+		return fmt.Errorf("DDSketch accuracy should be between 0 and 1, got %g", accuracy)
+	}
+	return nil
+}
+
+// NewDDSketch creates DDSketch with relative accuracy which should pass CheckAccuracy,
+// keeping at most maxBins bins or unlimited number of them if maxBins is not positive
+func NewDDSketch(accuracy float64, maxBins int) *DDSketch {
+	gamma := (1 + accuracy) / (1 - accuracy)
+	return &DDSketch{gamma: gamma, logGamma: math.Log(gamma), maxBins: maxBins}
+}
+
+// Add the value, negative ones are counted as zeros
+func (s *DDSketch) Add(value float64) {
+	if s.count == 0 || value < s.min {
+		s.min = value
+	}
+	if s.count == 0 || value > s.max {
+		s.max = value
+	}
+	s.count++
+	if value < minIndexable {
+		s.zeros++
+		return
+	}
+	key := int(math.Ceil(math.Log(value) / s.logGamma))
+	s.grow(key, key)
+	s.bins[s.bin(key)]++
+}
+
+// bin returns position in bins for the key, which collapses into the lowest bin if it's below it
+func (s *DDSketch) bin(key int) int {
+	if key < s.offset {
+		return 0
+	}
+	return key - s.offset
+}
+
+// grow bins to cover keys from low to high, collapsing the lowest ones if there are more than maxBins
+func (s *DDSketch) grow(low, high int) {
+	if len(s.bins) == 0 {
+		s.offset = low
+		s.bins = append(s.bins[:0], make([]uint64, high-low+1)...)
+		s.collapse()
+		return
+	}
+	if low < s.offset {
+		if s.maxBins > 0 && s.offset+len(s.bins)-low > s.maxBins {
+			// values below the collapsed bins end up in the lowest one
+			low = s.offset + len(s.bins) - s.maxBins
+		}
+		if low < s.offset {
+			s.bins = append(make([]uint64, s.offset-low), s.bins...)
+			s.offset = low
+		}
+	}
+	if last := s.offset + len(s.bins) - 1; high > last {
+		s.bins = append(s.bins, make([]uint64, high-last)...)
+	}
+	s.collapse()
+}
+
+// collapse the lowest bins into one so there are no more than maxBins of them
+func (s *DDSketch) collapse() {
+	if s.maxBins <= 0 || len(s.bins) <= s.maxBins {
+		return
+	}
+	extra := len(s.bins) - s.maxBins
+	for _, c := range s.bins[:extra] {
+		s.bins[extra] += c
+	}
+	s.bins = append(s.bins[:0], s.bins[extra:]...)
+	s.offset += extra
+}
+
+// Merge other DDSketch with the same accuracy into this one
+func (s *DDSketch) Merge(other *DDSketch) {
+	if other.count == 0 {
+		return
+	}
+	if s.count == 0 || other.min < s.min {
+		s.min = other.min
+	}
+	if s.count == 0 || other.max > s.max {
+		s.max = other.max
+	}
+	s.count += other.count
+	s.zeros += other.zeros
+	if len(other.bins) == 0 {
+		return
+	}
+	s.grow(other.offset, other.offset+len(other.bins)-1)
+	for i, c := range other.bins {
+		s.bins[s.bin(other.offset+i)] += c
+	}
+}
+
+// Quantile returns the estimated value at quantile q between 0 and 1, zero if there are no values
+func (s *DDSketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	switch {
+	case q <= 0:
+		return s.min
+	case q >= 1:
+		return s.max
+	}
+	rank := uint64(q * float64(s.count-1))
+	seen := s.zeros
+	if seen > rank {
+		return s.clamp(0)
+	}
+	for i, c := range s.bins {
+		seen += c
+		if seen > rank {
+			return s.clamp(2 * math.Pow(s.gamma, float64(s.offset+i)) / (s.gamma + 1))
+		}
+	}
+	return s.max
+}
+
+// clamp the estimate to the range of added values, which are known exactly
+func (s *DDSketch) clamp(v float64) float64 {
+	return math.Max(s.min, math.Min(s.max, v))
+}
+
+// Count returns the number of added values
+func (s *DDSketch) Count() uint64 {
+	return s.count
+}
+
+// Max returns the largest added value, zero if there are none
+func (s *DDSketch) Max() float64 {
+	return s.max
+}
+
+// Reset removes all values, keeping the allocated memory
+func (s *DDSketch) Reset() {
+	s.bins = s.bins[:0]
+	s.offset, s.zeros, s.count, s.min, s.max = 0, 0, 0, 0, 0
+}
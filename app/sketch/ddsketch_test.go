@@ -0,0 +1,97 @@
+package sketch
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDDSketch(t *testing.T) {
+	s := NewDDSketch(DefaultAccuracy, 0)
+	assert.Equal(t, 0.0, s.Quantile(0.5), "empty sketch")
+
+	for _, v := range []float64{0, 100, 200, 300, 400, 1000} {
+		s.Add(v)
+	}
+	assert.Equal(t, uint64(6), s.Count())
+	assert.Equal(t, 1000.0, s.Max())
+	assert.Equal(t, 0.0, s.Quantile(0))
+	assert.Equal(t, 0.0, s.Quantile(0.1))
+	assert.InEpsilon(t, 200, s.Quantile(0.5), DefaultAccuracy)
+	assert.InEpsilon(t, 400, s.Quantile(0.9), DefaultAccuracy)
+	assert.Equal(t, 1000.0, s.Quantile(1))
+
+	s.Reset()
+	assert.Equal(t, uint64(0), s.Count())
+	s.Add(5)
+	assert.Equal(t, 5.0, s.Quantile(0.99), "single value is known exactly")
+}
+
+// TestDDSketchAccuracy checks that merged sketches estimate quantiles within the relative accuracy
+func TestDDSketchAccuracy(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	parts := []*DDSketch{NewDDSketch(DefaultAccuracy, 0), NewDDSketch(DefaultAccuracy, 0), NewDDSketch(DefaultAccuracy, 0)}
+	values := make([]float64, 0, 30000)
+	for i := 0; i < 30000; i++ {
+		// response sizes are roughly log-normal
+		v := math.Round(math.Exp(rnd.NormFloat64()*1.5 + 8))
+		values = append(values, v)
+		parts[i%len(parts)].Add(v)
+	}
+	merged := NewDDSketch(DefaultAccuracy, 0)
+	for _, p := range parts {
+		merged.Merge(p)
+	}
+	sort.Float64s(values)
+	assert.Equal(t, uint64(len(values)), merged.Count())
+	assert.Equal(t, values[len(values)-1], merged.Max())
+	for _, q := range []float64{0.01, 0.25, 0.5, 0.9, 0.99, 0.999} {
+		exact := values[int(q*float64(len(values)-1))]
+		assert.InEpsilon(t, exact, merged.Quantile(q), DefaultAccuracy, "quantile %v", q)
+	}
+}
+
+func TestDDSketchMaxBins(t *testing.T) {
+	s := NewDDSketch(DefaultAccuracy, 100)
+	var values []float64
+	for v := 1.0; v < 1e9; v *= 1.01 {
+		values = append(values, v)
+		s.Add(v)
+	}
+	assert.Len(t, s.bins, 100)
+	// high quantiles keep the accuracy, the low ones are collapsed
+	assert.InEpsilon(t, values[int(0.999*float64(len(values)-1))], s.Quantile(0.999), DefaultAccuracy)
+	assert.Equal(t, values[len(values)-1], s.Max())
+	assert.Greater(t, s.Quantile(0.01), values[len(values)/2], "collapsed values are overestimated by the lowest bin")
+
+	other := NewDDSketch(DefaultAccuracy, 100)
+	other.Add(0.5)
+	other.Add(1e10)
+	s.Merge(other)
+	assert.Len(t, s.bins, 100)
+	assert.Equal(t, 1e10, s.Quantile(1))
+	assert.Equal(t, 0.5, s.Quantile(0))
+}
+
+func TestCheckAccuracy(t *testing.T) {
+	assert.NoError(t, CheckAccuracy(DefaultAccuracy))
+	assert.EqualError(t, CheckAccuracy(0), "DDSketch accuracy should be between 0 and 1, got 0")
+	assert.Error(t, CheckAccuracy(1))
+}
+
+func BenchmarkDDSketchAdd(b *testing.B) {
+	s := NewDDSketch(DefaultAccuracy, 0)
+	rnd := rand.New(rand.NewSource(42))
+	values := make([]float64, 1024)
+	for i := range values {
+		values[i] = math.Round(math.Exp(rnd.NormFloat64()*1.5 + 8))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Add(values[i%len(values)])
+	}
+}
@@ -0,0 +1,221 @@
+// Package sketch implements probabilistic data structures which allow to count
+// huge amounts of distinct values in bounded memory and merge the results
+package sketch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// HLL precision limits, 4 gives 26% error with 16 bytes of memory, 18 gives 0.2% with 256KB
+const (
+	MinPrecision = 4
+	MaxPrecision = 18
+)
+
+// HLL is a HyperLogLog cardinality estimator. It starts with the sparse representation
+// which takes memory proportional to the number of distinct values and switches
+// to dense 2^precision registers once that becomes cheaper.
+type HLL struct {
+	precision uint8
+	sparse    map[uint32]uint8 // register index to its value, nil in dense mode
+	dense     []uint8
+}
+
+// CheckPrecision returns error if HLL can't be created with provided precision
+func CheckPrecision(precision uint8) error {
+	if precision < MinPrecision || precision > MaxPrecision {
+		return fmt.Errorf("HyperLogLog precision should be between %d and %d, got %d", MinPrecision, MaxPrecision, precision)
+	}
+	return nil
+}
+
+// NewHLL creates HyperLogLog with 2^precision registers, precision should pass CheckPrecision
+func NewHLL(precision uint8) *HLL {
+	return &HLL{precision: precision, sparse: make(map[uint32]uint8)}
+}
+
+// Add the value to the set
+func (h *HLL) Add(value string) {
+	h.AddHash(Hash(value))
+}
+
+// AddHash adds the value with provided 64-bit hash to the set
+func (h *HLL) AddHash(hash uint64) {
+	idx := uint32(hash >> (64 - h.precision))
+	// rank is the position of the first set bit in the rest of the hash, capped at 64-precision+1
+	rank := uint8(bits.LeadingZeros64(hash<<h.precision|1<<(h.precision-1)) + 1)
+	h.set(idx, rank)
+}
+
+// set the register to the rank if it's higher than the current value
+func (h *HLL) set(idx uint32, rank uint8) {
+	if h.sparse == nil {
+		if rank > h.dense[idx] {
+			h.dense[idx] = rank
+		}
+		return
+	}
+	if rank > h.sparse[idx] {
+		h.sparse[idx] = rank
+		// map entry takes roughly eight times more than a dense register
+		if len(h.sparse) > (1<<h.precision)/8 {
+			h.toDense()
+		}
+	}
+}
+
+// toDense switches to the dense representation
+func (h *HLL) toDense() {
+	h.dense = make([]uint8, 1<<h.precision)
+	for idx, rank := range h.sparse {
+		h.dense[idx] = rank
+	}
+	h.sparse = nil
+}
+
+// Merge other HLL of the same precision into this one, so that it estimates the union of both sets
+func (h *HLL) Merge(other *HLL) {
+	if other.sparse != nil {
+		for idx, rank := range other.sparse {
+			h.set(idx, rank)
+		}
+		return
+	}
+	if h.sparse != nil {
+		h.toDense()
+	}
+	for idx, rank := range other.dense {
+		if rank > h.dense[idx] {
+			h.dense[idx] = rank
+		}
+	}
+}
+
+// MarshalBinary encodes the HLL as the precision followed by sorted index and rank pairs of the sparse registers,
+// or by all registers of the dense ones, so that sets of a few values take a few bytes
+func (h *HLL) MarshalBinary() ([]byte, error) {
+	if h.sparse == nil {
+		return append([]byte{h.precision, 1}, h.dense...), nil
+	}
+	indexes := make([]uint32, 0, len(h.sparse))
+	for idx := range h.sparse {
+		indexes = append(indexes, idx)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	data := make([]byte, 2, 2+5*len(indexes))
+	data[0] = h.precision
+	for _, idx := range indexes {
+		data = append(data, byte(idx>>24), byte(idx>>16), byte(idx>>8), byte(idx), h.sparse[idx])
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes the HLL written by MarshalBinary
+func (h *HLL) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 || CheckPrecision(data[0]) != nil || data[1] > 1 {
+		return errors.New("bad HyperLogLog header")
+	}
+	precision, dense, data := data[0], data[1] == 1, data[2:]
+	if dense {
+		if len(data) != 1<<precision {
+			return errors.New("bad HyperLogLog dense registers length")
+		}
+		*h = HLL{precision: precision, dense: append([]uint8(nil), data...)}
+		return nil
+	}
+	if len(data)%5 != 0 {
+		return errors.New("bad HyperLogLog sparse registers length")
+	}
+	*h = HLL{precision: precision, sparse: make(map[uint32]uint8, len(data)/5)}
+	for ; len(data) > 0; data = data[5:] {
+		idx := binary.BigEndian.Uint32(data)
+		if idx >= 1<<precision {
+			return errors.New("bad HyperLogLog register index")
+		}
+		h.set(idx, data[4])
+	}
+	return nil
+}
+
+// Reset empties the set, keeping the allocated memory
+func (h *HLL) Reset() {
+	if h.sparse != nil {
+		for idx := range h.sparse {
+			delete(h.sparse, idx)
+		}
+		return
+	}
+	for idx := range h.dense {
+		h.dense[idx] = 0
+	}
+}
+
+// Count returns the estimated number of distinct values in the set
+func (h *HLL) Count() uint64 {
+	m := float64(uint64(1) << h.precision)
+	var sum float64
+	var zeros int
+	if h.sparse != nil {
+		zeros = (1 << h.precision) - len(h.sparse)
+		sum = float64(zeros)
+		for _, rank := range h.sparse {
+			sum += math.Ldexp(1, -int(rank))
+		}
+	} else {
+		for _, rank := range h.dense {
+			if rank == 0 {
+				zeros++
+			}
+			sum += math.Ldexp(1, -int(rank))
+		}
+	}
+	estimate := alpha(m) * m * m / sum
+	// linear counting is more precise for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// StdError returns the relative standard error of the estimation
+func (h *HLL) StdError() float64 {
+	return 1.04 / math.Sqrt(float64(uint64(1)<<h.precision))
+}
+
+// alpha is a bias correction constant for m registers
+func alpha(m float64) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/m)
+}
+
+// Hash returns 64-bit hash of the value: FNV-1a followed by murmur3 finalizer,
+// as FNV alone doesn't spread short similar strings like IP addresses well enough
+func Hash(value string) uint64 {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+	h := uint64(offset)
+	for i := 0; i < len(value); i++ {
+		h ^= uint64(value[i])
+		h *= prime
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
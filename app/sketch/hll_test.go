@@ -0,0 +1,92 @@
+package sketch
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHLL(t *testing.T) {
+	for _, precision := range []uint8{MinPrecision, 10, 14, MaxPrecision} {
+		for _, n := range []int{0, 1, 10, 1000, 100000} {
+			h := NewHLL(precision)
+			for i := 0; i < n; i++ {
+				h.Add("10.0." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256))
+				h.Add("10.0." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256)) // duplicates don't count
+			}
+			// 4 standard errors are very unlikely to be exceeded
+			assert.InDelta(t, n, h.Count(), math.Max(1, 4*h.StdError()*float64(n)), "precision %d, n %d", precision, n)
+		}
+	}
+}
+
+func TestHLLMerge(t *testing.T) {
+	a, b, sparse := NewHLL(12), NewHLL(12), NewHLL(12)
+	for i := 0; i < 50000; i++ {
+		a.Add("a" + strconv.Itoa(i))
+		b.Add("b" + strconv.Itoa(i))
+	}
+	sparse.Add("c")
+	assert.Nil(t, a.sparse, "switched to dense")
+	assert.NotNil(t, sparse.sparse)
+
+	a.Merge(b)
+	a.Merge(sparse)
+	assert.InDelta(t, 100001, a.Count(), 4*a.StdError()*100001)
+
+	// sparse merged with dense becomes dense
+	sparse.Merge(b)
+	assert.Nil(t, sparse.sparse)
+	assert.InDelta(t, 50001, sparse.Count(), 4*sparse.StdError()*50001)
+
+	a.Reset()
+	assert.Equal(t, uint64(0), a.Count())
+	sparse = NewHLL(12)
+	sparse.Add("c")
+	sparse.Reset()
+	assert.Equal(t, uint64(0), sparse.Count())
+}
+
+func TestCheckPrecision(t *testing.T) {
+	assert.NoError(t, CheckPrecision(14))
+	assert.EqualError(t, CheckPrecision(3), "HyperLogLog precision should be between 4 and 18, got 3")
+	assert.EqualError(t, CheckPrecision(19), "HyperLogLog precision should be between 4 and 18, got 19")
+}
+
+func BenchmarkHLLAdd(b *testing.B) {
+	h := NewHLL(14)
+	values := make([]string, 1000)
+	for i := range values {
+		values[i] = "10.0." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.Add(values[i%len(values)])
+	}
+}
+
+func TestHLLMarshal(t *testing.T) {
+	for _, n := range []int{0, 10, 10000} {
+		h := NewHLL(10)
+		for i := 0; i < n; i++ {
+			h.Add(strconv.Itoa(i))
+		}
+		data, err := h.MarshalBinary()
+		require.NoError(t, err)
+		decoded := &HLL{}
+		require.NoError(t, decoded.UnmarshalBinary(data))
+		assert.Equal(t, h, decoded, "%d values", n)
+	}
+	data, err := NewHLL(10).MarshalBinary()
+	require.NoError(t, err)
+	assert.Len(t, data, 2, "empty set takes only the header")
+
+	assert.EqualError(t, (&HLL{}).UnmarshalBinary([]byte{2, 0}), "bad HyperLogLog header")
+	assert.EqualError(t, (&HLL{}).UnmarshalBinary([]byte{4, 1, 0}), "bad HyperLogLog dense registers length")
+	assert.EqualError(t, (&HLL{}).UnmarshalBinary([]byte{4, 0, 0, 0}), "bad HyperLogLog sparse registers length")
+	assert.EqualError(t, (&HLL{}).UnmarshalBinary([]byte{4, 0, 0, 0, 0, 16, 1}), "bad HyperLogLog register index")
+}
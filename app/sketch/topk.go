@@ -0,0 +1,160 @@
+package sketch
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// TopK tracks the most frequent keys in the stream using Space-Saving algorithm,
+// keeping at most capacity counters. Every counter overestimates the true count
+// of its key by no more than its Err, which in turn is no more than total/capacity.
+type TopK struct {
+	capacity int
+	counters counterHeap    // min-heap by count
+	index    map[string]int // key to its position in the heap
+	total    uint64
+}
+
+// Counter is the count of the key, true count is between Count-Err and Count
+type Counter struct {
+	Key   string
+	Count uint64
+	Err   uint64
+}
+
+// NewTopK creates TopK keeping at most capacity counters
+func NewTopK(capacity int) *TopK {
+	t := &TopK{capacity: capacity, index: make(map[string]int, capacity)}
+	t.counters.index = t.index
+	return t
+}
+
+// Add weight to the key count
+func (t *TopK) Add(key string, weight uint64) {
+	t.total += weight
+	if i, ok := t.index[key]; ok {
+		t.counters.items[i].Count += weight
+		heap.Fix(&t.counters, i)
+		return
+	}
+	if len(t.counters.items) < t.capacity {
+		heap.Push(&t.counters, Counter{Key: key, Count: weight})
+		return
+	}
+	// the key replaces the least frequent one and inherits its count as possible error
+	least := &t.counters.items[0]
+	delete(t.index, least.Key)
+	t.index[key] = 0
+	least.Key, least.Err = key, least.Count
+	least.Count += weight
+	heap.Fix(&t.counters, 0)
+}
+
+// Merge other TopK into this one. Key missing in one of them is considered to have there up to
+// the minimal count of that TopK if it's full, which keeps the error bounds guaranteed.
+func (t *TopK) Merge(other *TopK) {
+	ownMin, otherMin := t.min(), other.min()
+	merged := make(map[string]Counter, len(t.counters.items)+len(other.counters.items))
+	for _, c := range t.counters.items {
+		c.Count += otherMin
+		c.Err += otherMin
+		merged[c.Key] = c
+	}
+	for _, c := range other.counters.items {
+		if own, ok := merged[c.Key]; ok {
+			// own counter already has otherMin added, which is replaced with the real count
+			c.Count += own.Count - otherMin
+			c.Err += own.Err - otherMin
+		} else {
+			c.Count += ownMin
+			c.Err += ownMin
+		}
+		merged[c.Key] = c
+	}
+
+	counters := make([]Counter, 0, len(merged))
+	for _, c := range merged {
+		counters = append(counters, c)
+	}
+	sortCounters(counters)
+	if len(counters) > t.capacity {
+		counters = counters[:t.capacity]
+	}
+	total := t.total + other.total
+	t.Reset()
+	t.total = total
+	for _, c := range counters {
+		heap.Push(&t.counters, c)
+	}
+}
+
+// min returns the minimal count if TopK is full, zero otherwise
+func (t *TopK) min() uint64 {
+	if len(t.counters.items) < t.capacity {
+		return 0
+	}
+	return t.counters.items[0].Count
+}
+
+// Top returns up to n most frequent keys sorted by count, all of them if n is not positive
+func (t *TopK) Top(n int) []Counter {
+	counters := make([]Counter, len(t.counters.items))
+	copy(counters, t.counters.items)
+	sortCounters(counters)
+	if n > 0 && len(counters) > n {
+		counters = counters[:n]
+	}
+	return counters
+}
+
+// Total returns the sum of all added weights
+func (t *TopK) Total() uint64 {
+	return t.total
+}
+
+// Reset removes all counters, keeping the allocated memory
+func (t *TopK) Reset() {
+	for k := range t.index {
+		delete(t.index, k)
+	}
+	t.counters.items = t.counters.items[:0]
+	t.total = 0
+}
+
+// sortCounters by count descending, then by key
+func sortCounters(counters []Counter) {
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].Count != counters[j].Count {
+			return counters[i].Count > counters[j].Count
+		}
+		return counters[i].Key < counters[j].Key
+	})
+}
+
+// counterHeap is a min-heap of counters which keeps the index of keys up to date
+type counterHeap struct {
+	items []Counter
+	index map[string]int
+}
+
+func (h *counterHeap) Len() int           { return len(h.items) }
+func (h *counterHeap) Less(i, j int) bool { return h.items[i].Count < h.items[j].Count }
+
+func (h *counterHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[h.items[i].Key] = i
+	h.index[h.items[j].Key] = j
+}
+
+func (h *counterHeap) Push(x interface{}) {
+	c := x.(Counter)
+	h.index[c.Key] = len(h.items)
+	h.items = append(h.items, c)
+}
+
+func (h *counterHeap) Pop() interface{} {
+	c := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.index, c.Key)
+	return c
+}
@@ -0,0 +1,74 @@
+package sketch
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopK(t *testing.T) {
+	tk := NewTopK(3)
+	for _, k := range []string{"a", "b", "a", "c", "a", "b"} {
+		tk.Add(k, 1)
+	}
+	assert.Equal(t, []Counter{{Key: "a", Count: 3}, {Key: "b", Count: 2}, {Key: "c", Count: 1}}, tk.Top(0))
+	assert.Equal(t, []Counter{{Key: "a", Count: 3}}, tk.Top(1))
+
+	// d replaces c, the least frequent one
+	tk.Add("d", 5)
+	assert.Equal(t, []Counter{{Key: "d", Count: 6, Err: 1}, {Key: "a", Count: 3}, {Key: "b", Count: 2}}, tk.Top(0))
+	assert.Equal(t, uint64(11), tk.Total())
+
+	tk.Reset()
+	assert.Empty(t, tk.Top(0))
+	assert.Equal(t, uint64(0), tk.Total())
+}
+
+// TestTopKBounds checks that true counts are always within the reported bounds on a skewed stream
+func TestTopKBounds(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	zipf := rand.NewZipf(rnd, 1.2, 1, 10000)
+	const capacity = 50
+	exact := map[string]uint64{}
+	parts := []*TopK{NewTopK(capacity), NewTopK(capacity), NewTopK(capacity)}
+	var total uint64
+	for i := 0; i < 30000; i++ {
+		key := strconv.FormatUint(zipf.Uint64(), 10)
+		weight := uint64(rnd.Intn(3) + 1)
+		exact[key] += weight
+		total += weight
+		parts[i%len(parts)].Add(key, weight)
+	}
+
+	merged := NewTopK(capacity)
+	for _, p := range parts {
+		merged.Merge(p)
+	}
+	assert.Equal(t, total, merged.Total())
+	top := merged.Top(10)
+	assert.Equal(t, "0", top[0].Key)
+	for _, c := range merged.Top(0) {
+		assert.True(t, c.Count >= exact[c.Key], "%s: %d < %d", c.Key, c.Count, exact[c.Key])
+		assert.True(t, c.Count-c.Err <= exact[c.Key], "%s: %d-%d > %d", c.Key, c.Count, c.Err, exact[c.Key])
+	}
+	// error of the merged summary is bounded by the sum of errors of parts
+	for _, c := range top {
+		assert.True(t, c.Err <= total/capacity, "%s: error %d", c.Key, c.Err)
+	}
+}
+
+func BenchmarkTopKAdd(b *testing.B) {
+	tk := NewTopK(100)
+	zipf := rand.NewZipf(rand.New(rand.NewSource(42)), 1.1, 1, 100000)
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = strconv.FormatUint(zipf.Uint64(), 10)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tk.Add(keys[i%len(keys)], 1)
+	}
+}
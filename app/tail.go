@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/paskal/datadog-parser/app/anomaly"
+	"github.com/paskal/datadog-parser/app/record"
+	"github.com/paskal/datadog-parser/app/rollup"
+	"github.com/paskal/datadog-parser/app/silence"
+	"github.com/paskal/datadog-parser/app/sketch"
+)
+
+// tailCommand follows the log, printing reports and alerts on it, it's the default command
+type tailCommand struct {
+	tailOptions
+}
+
+// tailOptions are the options of the log processing, which are checked by validate command as well
+type tailOptions struct {
+	AlertWindow             time.Duration `long:"alert_window" env:"ALERT_WINDOW" default:"2m" description:"alert windows"`
+	AlertThresholdPerSecond int           `long:"alert_threshold_per_sec" env:"ALERT_THRESHOLD_PER_SEC" default:"10" description:"threshold for alert, requests per second"`
+	AlertBandwidthPerSecond int           `long:"alert_bandwidth_per_sec" env:"ALERT_BANDWIDTH_PER_SEC" default:"0" description:"threshold for bandwidth alert, bytes per second, disabled if 0"`
+	AlertMode               string        `long:"alert_mode" env:"ALERT_MODE" default:"threshold" choice:"threshold" choice:"ewma" choice:"holt_winters" description:"hits alert mode: static threshold, or anomaly from learned baseline without or with daily seasonality"`
+	AnomalySigma            float64       `long:"anomaly_sigma" env:"ANOMALY_SIGMA" default:"3" description:"standard deviations from the learned baseline to fire the anomaly alert on"`
+	AnomalyBaseline         string        `long:"anomaly_baseline" env:"ANOMALY_BASELINE" default:"" description:"file to load the learned baseline from on start and save it to every minute and on exit"`
+	BucketResolution        time.Duration `long:"bucket_resolution" env:"BUCKET_RESOLUTION" default:"1s" description:"precision of the alert window"`
+	UsersPrecision          uint8         `long:"users_hll_precision" env:"USERS_HLL_PRECISION" default:"0" description:"estimate unique users using HyperLogLog with 2^precision registers, 4 to 18, exact count if 0"`
+	HeavyHitters            int           `long:"heavy_hitters" env:"HEAVY_HITTERS" default:"0" description:"number of top sections, clients and users tracked per bucket, exact count if 0"`
+	TopTalkers              int           `long:"top_talkers" env:"TOP_TALKERS" default:"0" description:"number of top clients and users printed with every report, none if 0"`
+	SizeSections            int           `long:"size_sections" env:"SIZE_SECTIONS" default:"0" description:"number of top sections with response size percentiles printed with every report, none if 0"`
+	LatencySections         int           `long:"latency_sections" env:"LATENCY_SECTIONS" default:"3" description:"number of top sections with latency percentiles printed with every report if the log has latency column"`
+	LatencyAlerts           []string      `long:"latency_alert" env:"LATENCY_ALERTS" env-delim:"," description:"[section:]pNN>duration[@window] latency alert like /api:p99>800ms@10m, in the alert window if not set, could be repeated"`
+	SLOs                    []string      `long:"slo" env:"SLOS" env-delim:"," description:"[section:]percent objective of non-5xx requests like /api:99.9%, could be repeated"`
+	SLOPeriod               time.Duration `long:"slo_period" env:"SLO_PERIOD" default:"720h" description:"period of objectives, 30 days by default"`
+	LowTrafficAlerts        []string      `long:"low_traffic_alert" env:"LOW_TRAFFIC_ALERTS" env-delim:"," description:"[section:]rate/s@duration alert on hits rate lower than the threshold like /api:1/s@5m, could be repeated"`
+	NoDataAlert             time.Duration `long:"no_data_alert" env:"NO_DATA_ALERT" default:"0" description:"time without records to fire the alert on, disabled if 0"`
+	SilencesPath            string        `long:"silences" env:"SILENCES" default:"" description:"JSON file with alert silences, saved on changes through the API"`
+	SilencesAPI             string        `long:"silences_api" env:"SILENCES_API" default:"" description:"address to serve silences HTTP API on, like localhost:8080, disabled if not set"`
+	GroupBy                 []string      `long:"group_by" env:"GROUP_BY" env-delim:"," description:"alert label to group notifications by: alert, section or severity, could be repeated"`
+	GroupWait               time.Duration `long:"group_wait" env:"GROUP_WAIT" default:"0" description:"time to wait for other changes in the group before the notification"`
+	RepeatInterval          time.Duration `long:"repeat_interval" env:"REPEAT_INTERVAL" default:"0" description:"interval to notify still firing alerts again at, disabled if 0"`
+	InhibitRules            []string      `long:"inhibit" env:"INHIBIT_RULES" env-delim:";" description:"source>target[:equal] rule to mute target alerts while the source fires, like alert=hits>alert=latency:section, could be repeated"`
+	AlertFilters            []string      `long:"alert_filter" env:"ALERT_FILTERS" env-delim:"," description:"dimension=value or dimension!=value filter of records counted by the alert, could be repeated"`
+	JSON                    bool          `long:"json" env:"JSON" description:"print reports and alerts as JSON lines"`
+	Workers                 int           `long:"workers" env:"WORKERS" default:"0" description:"number of parser workers, number of CPUs if not set"`
+	BatchSize               int           `long:"batch_size" env:"BATCH_SIZE" default:"1024" description:"number of lines parsed by a worker at once"`
+	RejectsPath             string        `long:"rejects" env:"REJECTS" default:"" description:"file to append rejected lines to, with line number and reason"`
+	RejectsWarnRatio        float64       `long:"rejects_warn_ratio" env:"REJECTS_WARN_RATIO" default:"0.01" description:"share of rejected lines between reports to print a warning on"`
+}
+
+// tailRules are the parsed options of the log processing
+type tailRules struct {
+	alertFilter      *record.AlertFilter
+	latencyAlerts    []record.LatencyRule
+	lowTrafficAlerts []record.LowTrafficRule
+	slos             []record.SLO
+	routing          *record.Routing // nil if none of the routing options is set
+	rollupTiers      []rollup.Tier
+	outputTZ         *time.Location
+	baseline         anomaly.Baseline // nil for the static threshold
+	silences         *silence.Store
+}
+
+// parseRules checks the options and parses the rules of the log processing, reading the baseline
+// and silences files but not opening the files written by it. Errors are logged, and the exit code
+// is returned along with them.
+func (t tailOptions) parseRules(opts opts) (*tailRules, int) {
+	if t.AlertWindow == 0 {
+		log.Print("Alert window must be non-zero")
+		return nil, 2
+	}
+
+	if t.AlertMode == "threshold" && t.AlertThresholdPerSecond == 0 {
+		log.Print("Alert threshold must be non-zero")
+		return nil, 2
+	}
+
+	if err := record.CheckResolution(t.AlertWindow, t.BucketResolution); err != nil {
+		log.Printf("Bad bucket resolution: %v", err)
+		return nil, 2
+	}
+
+	if t.UsersPrecision != 0 {
+		if err := sketch.CheckPrecision(t.UsersPrecision); err != nil {
+			log.Printf("Bad unique users precision: %v", err)
+			return nil, 2
+		}
+		if err := record.CheckUsersPrecision(t.AlertWindow, t.BucketResolution, t.UsersPrecision); err != nil {
+			log.Printf("Bad unique users precision: %v", err)
+			return nil, 2
+		}
+	}
+
+	if t.HeavyHitters < 0 || t.TopTalkers < 0 || t.SizeSections < 0 || t.LatencySections < 0 {
+		log.Print("Heavy hitters, top talkers, size and latency sections numbers must not be negative")
+		return nil, 2
+	}
+
+	if t.AlertBandwidthPerSecond < 0 {
+		log.Print("Bandwidth alert threshold must not be negative")
+		return nil, 2
+	}
+
+	if t.NoDataAlert < 0 {
+		log.Print("No data alert duration must not be negative")
+		return nil, 2
+	}
+
+	if t.AnomalySigma <= 0 {
+		log.Print("Anomaly sigma must be positive")
+		return nil, 2
+	}
+
+	if t.Workers < 0 || t.BatchSize < 0 {
+		log.Print("Workers number and batch size must not be negative")
+		return nil, 2
+	}
+
+	rules := &tailRules{}
+	var err error
+	if rules.alertFilter, err = record.NewAlertFilter(t.AlertFilters); err != nil {
+		log.Printf("Bad alert filter: %v", err)
+		return nil, 2
+	}
+
+	if rules.latencyAlerts, err = record.NewLatencyRules(t.LatencyAlerts); err != nil {
+		log.Printf("Bad latency alert: %v", err)
+		return nil, 2
+	}
+	for _, rule := range rules.latencyAlerts {
+		if err = record.CheckResolution(rule.Window, t.BucketResolution); err != nil {
+			log.Printf("Bad latency alert window: %v", err)
+			return nil, 2
+		}
+	}
+
+	if rules.lowTrafficAlerts, err = record.NewLowTrafficRules(t.LowTrafficAlerts); err != nil {
+		log.Printf("Bad low traffic alert: %v", err)
+		return nil, 2
+	}
+
+	if rules.slos, err = record.NewSLOs(t.SLOs, t.SLOPeriod); err != nil {
+		log.Printf("Bad SLO: %v", err)
+		return nil, 2
+	}
+
+	if rules.rollupTiers, err = rollup.ParseTiers(opts.RollupTiers); err != nil {
+		log.Printf("Bad rollup tiers: %v", err)
+		return nil, 2
+	}
+
+	// alert state changes are routed only if any of the routing options is set
+	if len(t.GroupBy) > 0 || t.GroupWait != 0 || t.RepeatInterval != 0 || len(t.InhibitRules) > 0 {
+		if rules.routing, err = record.NewRouting(t.GroupBy, t.GroupWait, t.RepeatInterval, t.InhibitRules); err != nil {
+			log.Printf("Bad alert routing: %v", err)
+			return nil, 2
+		}
+	}
+
+	if rules.outputTZ, err = time.LoadLocation(opts.OutputTZ); err != nil {
+		log.Printf("Bad output timezone: %v", err)
+		return nil, 2
+	}
+
+	if rules.baseline, err = loadBaseline(t.AlertMode, t.AnomalyBaseline); err != nil {
+		log.Printf("Bad anomaly baseline: %v", err)
+		return nil, 2
+	}
+
+	rules.silences = silence.NewStore(rules.outputTZ)
+	if t.SilencesPath != "" {
+		if rules.silences, err = silence.Load(t.SilencesPath, rules.outputTZ); err != nil {
+			log.Printf("Bad silences file: %v", err)
+			return nil, 2
+		}
+	}
+	return rules, 0
+}
+
+// runTail follows the log till the interrupt signal, and returns the exit code
+func runTail(opts opts, stdin io.Reader) int {
+	t := opts.Tail.tailOptions
+	rules, code := t.parseRules(opts)
+	if code != 0 {
+		return code
+	}
+	in, code := openInput(opts, stdin)
+	if code != 0 {
+		return code
+	}
+	defer in.close()
+
+	var rejects io.Writer
+	if t.RejectsPath != "" {
+		f, err := os.OpenFile(t.RejectsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			log.Printf("Error opening rejects file: %v", err)
+			return 3
+		}
+		defer f.Close()
+		rejects = f
+	}
+
+	var alertHistory io.Writer
+	if opts.AlertHistoryPath != "" {
+		f, err := os.OpenFile(opts.AlertHistoryPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			log.Printf("Error opening alert history file: %v", err)
+			return 3
+		}
+		defer f.Close()
+		alertHistory = f
+	}
+
+	var rollups *rollup.Store
+	if opts.RollupsPath != "" {
+		var err error
+		if rollups, err = rollup.Open(opts.RollupsPath, rules.rollupTiers); err != nil {
+			log.Printf("Error opening rollups: %v", err)
+			return 3
+		}
+		defer rollups.Close()
+	}
+
+	// catch TERM signal and invoke graceful termination
+	// otherwise it's impossible to test main()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		cancel()
+	}()
+
+	if t.SilencesAPI != "" {
+		server := &http.Server{Addr: t.SilencesAPI, Handler: silence.Handler(rules.silences), ReadHeaderTimeout: 5 * time.Second}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Error serving silences API: %v", err)
+			}
+		}()
+		defer server.Close()
+	}
+
+	logProcessor := t.newProcessor(rules, in)
+	logProcessor.Rejects = rejects
+	logProcessor.AlertHistory = alertHistory
+	logProcessor.Rollups = rollups
+	if rules.baseline != nil && t.AnomalyBaseline != "" {
+		logProcessor.AnomalySave = func(b anomaly.Baseline) error { return saveBaseline(b, t.AnomalyBaseline) }
+	}
+	if err := logProcessor.Start(ctx); err != nil {
+		log.Printf("Bad processing options: %v", err)
+		return 2
+	}
+
+	if rules.baseline != nil && t.AnomalyBaseline != "" {
+		if err := saveBaseline(rules.baseline, t.AnomalyBaseline); err != nil {
+			log.Printf("Error saving anomaly baseline: %v", err)
+		}
+	}
+	return 0
+}
+
+// newProcessor returns the processor of the log with the parsed rules, without the files written by it
+func (t tailOptions) newProcessor(rules *tailRules, in *input) *record.Processor {
+	return &record.Processor{
+		LogReader:               in.reader,
+		AlertWindow:             t.AlertWindow,
+		AlertThresholdPerSecond: t.AlertThresholdPerSecond,
+		AlertBandwidthPerSecond: t.AlertBandwidthPerSecond,
+		Workers:                 t.Workers,
+		BatchSize:               t.BatchSize,
+		RejectsWarnRatio:        t.RejectsWarnRatio,
+		Sections:                in.sections,
+		Timestamps:              in.timestamps,
+		OutputTZ:                rules.outputTZ,
+		BucketResolution:        t.BucketResolution,
+		UsersPrecision:          t.UsersPrecision,
+		HeavyHitters:            t.HeavyHitters,
+		TopTalkers:              t.TopTalkers,
+		SizeSections:            t.SizeSections,
+		LatencySections:         t.LatencySections,
+		LatencyAlerts:           rules.latencyAlerts,
+		SLOs:                    rules.slos,
+		LowTrafficAlerts:        rules.lowTrafficAlerts,
+		NoDataAlert:             t.NoDataAlert,
+		Silences:                rules.silences,
+		Routing:                 rules.routing,
+		Anomaly:                 rules.baseline,
+		AnomalySigma:            t.AnomalySigma,
+		Labels:                  in.labels,
+		AlertFilter:             rules.alertFilter,
+		GeoIP:                   in.geo,
+		Agents:                  in.agents,
+		JSON:                    t.JSON,
+	}
+}
+
+// loadBaseline returns the baseline for the alert mode, nil for the static threshold. Saved one is loaded
+// from the file if it exists, and a new one is created otherwise.
+func loadBaseline(mode, path string) (anomaly.Baseline, error) {
+	if mode == "threshold" {
+		return nil, nil
+	}
+	if path != "" {
+		f, err := os.Open(path)
+		if err == nil {
+			defer f.Close()
+			b, err := anomaly.Load(f)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if _, seasonal := b.(*anomaly.HoltWinters); seasonal != (mode == "holt_winters") {
+				return nil, fmt.Errorf("%s baseline doesn't match %s alert mode", path, mode)
+			}
+			return b, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	// baseline is sampled every minute, and forgets half of the weight of a sample after an hour
+	if mode == "holt_winters" {
+		return anomaly.NewHoltWinters(time.Minute, 24*time.Hour, 60), nil
+	}
+	return anomaly.NewEWMA(60), nil
+}
+
+// saveBaseline writes the baseline to the temporary file first, so the saved one is never left half-written
+func saveBaseline(b anomaly.Baseline, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if err = anomaly.Save(f, b); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/paskal/datadog-parser/app/anomaly"
+)
+
+func TestBaseline(t *testing.T) {
+	b, err := loadBaseline("threshold", "")
+	assert.NoError(t, err)
+	assert.Nil(t, b)
+
+	dir, err := ioutil.TempDir("", "datadog-parser")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "baseline.json")
+
+	// new baseline is created when the file doesn't exist yet
+	b, err = loadBaseline("holt_winters", path)
+	require.NoError(t, err)
+	require.IsType(t, &anomaly.HoltWinters{}, b)
+	b.Observe(time.Unix(1549573860, 0), 5)
+	require.NoError(t, saveBaseline(b, path))
+
+	loaded, err := loadBaseline("holt_winters", path)
+	require.NoError(t, err)
+	assert.Equal(t, b, loaded)
+
+	_, err = loadBaseline("ewma", path)
+	assert.EqualError(t, err, path+" baseline doesn't match ewma alert mode")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("bad"), 0o600))
+	_, err = loadBaseline("ewma", path)
+	assert.Error(t, err)
+}
+
+func TestParseRules(t *testing.T) {
+	o, _ := parseArgs(t, "--slo=/api:99.9%", "--group_by=section", "--output_tz=Europe/Berlin")
+	rules, code := o.Tail.parseRules(o)
+	require.Equal(t, 0, code)
+	assert.Len(t, rules.slos, 1)
+	assert.NotNil(t, rules.routing)
+	assert.Nil(t, rules.baseline)
+	assert.Equal(t, "Europe/Berlin", rules.outputTZ.String())
+	assert.Len(t, rules.rollupTiers, 4)
+
+	for _, args := range [][]string{
+		{"--alert_window=0"},
+		{"--bucket_resolution=0"},
+		{"--slo=/api:200%"},
+		{"--inhibit=bad"},
+		{"--output_tz=Mars/Olympus"},
+		{"--rollup_tier=1m"},
+		{"--latency_alert=p99>1s@100h"},
+		{"--alert_window=1h", "--bucket_resolution=100ms", "--users_hll_precision=18"},
+	} {
+		o, _ = parseArgs(t, args...)
+		_, code = o.Tail.parseRules(o)
+		assert.Equal(t, 2, code, args)
+	}
+}
@@ -0,0 +1,149 @@
+// Package useragent parses user agent strings into browser, OS and device families,
+// and tells crawlers and bots apart from humans using the bundled list of patterns
+package useragent
+
+import (
+	"bufio"
+	_ "embed" // bundled bot patterns
+	"io"
+	"strings"
+)
+
+//go:embed bots.txt
+var bundledBots string
+
+// Other is the family of the browser, OS or device which wasn't recognised
+const Other = "Other"
+
+// device families
+const (
+	Desktop = "Desktop"
+	Mobile  = "Mobile"
+	Tablet  = "Tablet"
+	Bot     = "Bot"
+)
+
+// Agent is the parsed user agent
+type Agent struct {
+	Browser string // browser family like "Chrome", or the bot name for bots
+	OS      string // operating system family like "Windows"
+	Device  string // device family: Desktop, Mobile, Tablet or Bot
+	Bot     bool   // true for crawlers, bots and tools like curl
+}
+
+// family is a token identifying the browser or OS family, tokens are checked in order
+type family struct {
+	token string
+	name  string
+}
+
+// browsers are checked in order, as most of them mention the ones they are based on
+var browsers = []family{
+	{"Edg/", "Edge"}, {"Edge/", "Edge"}, {"EdgA/", "Edge"}, {"EdgiOS/", "Edge"},
+	{"OPR/", "Opera"}, {"Opera", "Opera"},
+	{"YaBrowser/", "Yandex Browser"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"UCBrowser/", "UC Browser"},
+	{"Vivaldi/", "Vivaldi"},
+	{"Firefox/", "Firefox"}, {"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"}, {"Chromium/", "Chromium"}, {"Chrome/", "Chrome"},
+	{"MSIE ", "IE"}, {"Trident/", "IE"},
+	{"Version/", "Safari"},
+}
+
+// operating systems are checked in order, as Android and ChromeOS mention Linux
+var operatingSystems = []family{
+	{"Windows", "Windows"},
+	{"iPhone", "iOS"}, {"iPad", "iOS"}, {"iPod", "iOS"},
+	{"Android", "Android"},
+	{"CrOS", "ChromeOS"},
+	{"Mac OS X", "macOS"}, {"Macintosh", "macOS"},
+	{"Linux", "Linux"},
+	{"FreeBSD", "FreeBSD"},
+}
+
+// Default is the parser with the bundled bot patterns only
+var Default = func() *Parser {
+	p := &Parser{}
+	if err := p.addBots(strings.NewReader(bundledBots)); err != nil {
+		panic(err) // bundled patterns are always readable
+	}
+	return p
+}()
+
+// Parser parses user agents, it's safe for concurrent use
+type Parser struct {
+	bots []bot
+}
+
+// bot is the pattern of the bot user agent
+type bot struct {
+	name    string
+	pattern string // lowercase substring of the user agent
+}
+
+// NewParser creates parser with the bundled bot patterns, and patterns from extra reader if it's not nil.
+// Every pattern is a case-insensitive substring of the user agent on its own line, blank lines and lines
+// starting with # are ignored. Extra patterns are checked before the bundled ones.
+func NewParser(extra io.Reader) (*Parser, error) {
+	p := &Parser{}
+	if extra != nil {
+		if err := p.addBots(extra); err != nil {
+			return nil, err
+		}
+	}
+	p.bots = append(p.bots, Default.bots...)
+	return p, nil
+}
+
+func (p *Parser) addBots(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.bots = append(p.bots, bot{name: line, pattern: strings.ToLower(line)})
+	}
+	return scanner.Err()
+}
+
+// Parse the user agent
+func (p *Parser) Parse(ua string) Agent {
+	agent := Agent{Browser: Other, OS: osFamily(ua), Device: Desktop}
+	lower := strings.ToLower(ua)
+	for _, b := range p.bots {
+		if strings.Contains(lower, b.pattern) {
+			agent.Browser, agent.Device, agent.Bot = b.name, Bot, true
+			return agent
+		}
+	}
+	if ua == "" || ua == "-" {
+		agent.Device = Other
+		return agent
+	}
+	for _, b := range browsers {
+		if strings.Contains(ua, b.token) {
+			agent.Browser = b.name
+			break
+		}
+	}
+	switch {
+	case strings.Contains(ua, "iPad") || strings.Contains(ua, "Tablet") ||
+		(agent.OS == "Android" && !strings.Contains(ua, "Mobile")):
+		agent.Device = Tablet
+	case strings.Contains(ua, "Mobi") || strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPod"):
+		agent.Device = Mobile
+	}
+	return agent
+}
+
+// osFamily returns the operating system family of the user agent
+func osFamily(ua string) string {
+	for _, o := range operatingSystems {
+		if strings.Contains(ua, o.token) {
+			return o.name
+		}
+	}
+	return Other
+}
@@ -0,0 +1,69 @@
+package useragent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	p, err := NewParser(nil)
+	require.NoError(t, err)
+	var testData = []struct {
+		ua    string
+		agent Agent
+	}{
+		{ua: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			agent: Agent{Browser: "Chrome", OS: "Windows", Device: Desktop}},
+		{ua: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0",
+			agent: Agent{Browser: "Edge", OS: "Windows", Device: Desktop}},
+		{ua: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+			agent: Agent{Browser: "Safari", OS: "macOS", Device: Desktop}},
+		{ua: "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			agent: Agent{Browser: "Firefox", OS: "Linux", Device: Desktop}},
+		{ua: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			agent: Agent{Browser: "Safari", OS: "iOS", Device: Mobile}},
+		{ua: "Mozilla/5.0 (iPad; CPU OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1",
+			agent: Agent{Browser: "Chrome", OS: "iOS", Device: Tablet}},
+		{ua: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+			agent: Agent{Browser: "Chrome", OS: "Android", Device: Mobile}},
+		{ua: "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Safari/537.36",
+			agent: Agent{Browser: "Samsung Internet", OS: "Android", Device: Tablet}},
+		{ua: "Mozilla/5.0 (Windows NT 6.1; Trident/7.0; rv:11.0) like Gecko",
+			agent: Agent{Browser: "IE", OS: "Windows", Device: Desktop}},
+		{ua: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			agent: Agent{Browser: "Googlebot", OS: Other, Device: Bot, Bot: true}},
+		{ua: "Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			agent: Agent{Browser: "Googlebot", OS: "Android", Device: Bot, Bot: true}},
+		{ua: "curl/8.4.0", agent: Agent{Browser: "curl/", OS: Other, Device: Bot, Bot: true}},
+		{ua: "SomeNewCrawler/1.0", agent: Agent{Browser: "crawl", OS: Other, Device: Bot, Bot: true}},
+		{ua: "-", agent: Agent{Browser: Other, OS: Other, Device: Other}},
+		{ua: "", agent: Agent{Browser: Other, OS: Other, Device: Other}},
+		{ua: "Lynx/2.8.9rel.1 libwww-FM/2.14", agent: Agent{Browser: Other, OS: Other, Device: Desktop}},
+	}
+	for _, x := range testData {
+		assert.Equal(t, x.agent, p.Parse(x.ua), x.ua)
+	}
+}
+
+func TestExtraBots(t *testing.T) {
+	p, err := NewParser(strings.NewReader("# our monitoring\nInternalProbe\n\nChrome/99.0.1234\n"))
+	require.NoError(t, err)
+	assert.Equal(t, Agent{Browser: "InternalProbe", OS: Other, Device: Bot, Bot: true}, p.Parse("internalprobe/1.2"))
+	assert.Equal(t, Agent{Browser: "Chrome/99.0.1234", OS: "Linux", Device: Bot, Bot: true},
+		p.Parse("Mozilla/5.0 (X11; Linux x86_64) Chrome/99.0.1234 Safari/537.36"))
+	assert.Equal(t, Agent{Browser: "Chrome", OS: "Linux", Device: Desktop},
+		p.Parse("Mozilla/5.0 (X11; Linux x86_64) Chrome/100.0.1234 Safari/537.36"))
+}
+
+func BenchmarkParse(b *testing.B) {
+	p, err := NewParser(nil)
+	require.NoError(b, err)
+	ua := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.Parse(ua)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/paskal/datadog-parser/app/record"
+)
+
+// validateCommand checks the options of tail command, and the log unless ConfigOnly is set
+type validateCommand struct {
+	tailOptions
+	ConfigOnly bool `long:"config_only" description:"check the options only, without reading the log"`
+}
+
+// runValidate prints the summary of the log, and returns the exit code, which is 1 if the log has rejected lines
+func runValidate(w io.Writer, opts opts, stdin io.Reader) int {
+	rules, code := opts.Validate.parseRules(opts)
+	if code != 0 {
+		return code
+	}
+	if opts.Validate.ConfigOnly {
+		if _, code = loadInput(opts, stdin); code != 0 {
+			return code
+		}
+		fmt.Fprintln(w, "Options are valid") //nolint:errcheck
+		return 0
+	}
+	in, code := openInput(opts, stdin)
+	if code != 0 {
+		return code
+	}
+	defer in.close()
+
+	// the empty query only counts the records
+	query, err := record.ParseQuery("")
+	if err != nil {
+		log.Printf("Bad query: %v", err)
+		return 2
+	}
+	analyzer := record.Analyzer{
+		LogReader:  in.reader,
+		Query:      query,
+		Sections:   in.sections,
+		Timestamps: in.timestamps,
+		Labels:     in.labels,
+		GeoIP:      in.geo,
+		Agents:     in.agents,
+	}
+	result, err := analyzer.Run()
+	if err != nil {
+		log.Printf("Error reading the log: %v", err)
+		return 3
+	}
+
+	fmt.Fprintln(w, "Options are valid") //nolint:errcheck
+	if result.Records == 0 {
+		fmt.Fprintln(w, "No valid records") //nolint:errcheck
+	} else {
+		fmt.Fprintf(w, "%d valid records from %s to %s\n", result.Records, //nolint:errcheck
+			result.From.In(rules.outputTZ), result.To.In(rules.outputTZ))
+	}
+	if result.Rejected == 0 {
+		return 0
+	}
+	fmt.Fprintf(w, "%d of %d lines rejected: %s\n", result.Rejected, result.Records+result.Rejected, //nolint:errcheck
+		formatReasons(result.Reasons))
+	return 1
+}
+
+// formatReasons returns rejected lines count per reason, like "2 bad timestamp, 1 malformed csv",
+// with the most frequent reasons first
+func formatReasons(reasons map[string]int) string {
+	names := make([]string, 0, len(reasons))
+	for name := range reasons {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if reasons[names[i]] != reasons[names[j]] {
+			return reasons[names[i]] > reasons[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%d %s", reasons[name], name)
+	}
+	return strings.Join(parts, ", ")
+}
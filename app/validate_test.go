@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunValidate(t *testing.T) {
+	log := `"remotehost","rfc931","authuser","date","request","status","bytes"
+"10.0.0.1","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1000
+"10.0.0.2","-","apache",1549573921,"GET /api/user HTTP/1.0",500,200
+`
+	validate := func(input string, args ...string) (string, int) {
+		o, command := parseArgs(t, append([]string{"validate", "--input_tz=UTC"}, args...)...)
+		require.Equal(t, "validate", command)
+		var out strings.Builder
+		code := runValidate(&out, o, strings.NewReader(input))
+		return out.String(), code
+	}
+
+	out, code := validate(log)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, `Options are valid
+2 valid records from 2019-02-07 21:11:00 +0000 UTC to 2019-02-07 21:12:01 +0000 UTC
+`, out)
+
+	out, code = validate(log+`"10.0.0.2","-","apache",yesterday,"GET /api/user HTTP/1.0",500,200
+"10.0.0.2","-","apache",1549573921,"GET /api/user HTTP/1.0",500,2k
+"10.0.0.2","-","apache",1549573921,"GET /api/user HTTP/1.0",500
+"10.0.0.2","-","apache",1549573921,"GET /api/user HTTP/1.0",500,200,extra
+`, "--output_tz=Europe/Berlin")
+	assert.Equal(t, 1, code)
+	assert.Equal(t, `Options are valid
+2 valid records from 2019-02-07 22:11:00 +0100 CET to 2019-02-07 22:12:01 +0100 CET
+4 of 6 lines rejected: 2 wrong number of fields, 1 bad bytes, 1 bad timestamp
+`, out)
+
+	out, code = validate("")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "Options are valid\nNo valid records\n", out)
+
+	// the log isn't read with the options only checked
+	path := filepath.Join(t.TempDir(), "access.csv")
+	require.NoError(t, ioutil.WriteFile(path, []byte("not a log\n"), 0o600))
+	out, code = validate("", "--config_only", "--filepath="+path+".missing")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "Options are valid\n", out)
+	_, code = validate("", "--filepath="+path+".missing")
+	assert.Equal(t, 3, code)
+	out, code = validate("", "--filepath="+path)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "Options are valid\nNo valid records\n1 of 1 lines rejected: 1 wrong number of fields\n", out)
+
+	for _, args := range [][]string{{"--slo=/api:200%"}, {"--time_format=%Q"}, {"--config_only", "--section_rule=bad"}} {
+		out, code = validate(log, args...)
+		assert.Equal(t, 2, code, args)
+		assert.Empty(t, out, args)
+	}
+}